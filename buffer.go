@@ -40,3 +40,11 @@ func (rb *readbuf) ReadTokenBytes() []byte {
 	}
 	return val.Bytes()
 }
+
+// firstToken returns the bytes in data up to the first space
+func firstToken(data []byte) []byte {
+	if i := bytes.IndexByte(data, ' '); i >= 0 {
+		return data[:i]
+	}
+	return data
+}
@@ -1,56 +1,170 @@
 package client
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
 	"net"
-)
+	"strings"
+	"sync"
+	"time"
 
-type Command interface {
-	Key() string
-}
+	"github.com/lestrrat-go/memdproto"
+)
 
 // Client represents a memcached client.
 //
 // Please note that this is NOT a full fledged client. It is only meant to be
 // a sample on how one could use the memdproto package to implement a tool that
 // is capable of handling memcached protocol.
+//
+// A Client is safe for concurrent use. It keeps a pool of connections to
+// each server, see SetMaxIdleConns, SetMaxOpenConns and SetIdleTimeout.
 type Client struct {
-	servers     []string
-	selector    ServerSelector
-	activeConns map[string]net.Conn
+	servers      []string
+	selector     ServerSelector
+	tlsConfig    *tls.Config
+	maxIdleConns int
+	maxOpenConns int
+	idleTimeout  time.Duration
+	udpTimeout   time.Duration
+
+	mu     sync.Mutex
+	pools  map[string]*pool
+	closed bool
 }
 
+// New creates a client for the given servers. Servers are addressed as
+// "host:port", as "udp://host:port" to send commands over UDP, or as
+// "unix:///path/to/socket" to connect to a unix domain socket.
 func New(servers ...string) *Client {
 	return &Client{
-		servers:     servers,
-		selector:    &ModulusSelector{},
-		activeConns: make(map[string]net.Conn),
+		servers:      servers,
+		selector:     &ModulusSelector{},
+		maxIdleConns: DefaultMaxIdleConns,
+		idleTimeout:  DefaultIdleTimeout,
+		udpTimeout:   DefaultUDPTimeout,
+		pools:        make(map[string]*pool),
 	}
 }
 
+// ServerSelector chooses the server that a command should be sent to.
 type ServerSelector interface {
-	Select(*Client, Command) (string, error)
+	Select(*Client, memdproto.Cmd) (string, error)
+}
+
+// SetTLSConfig makes the client connect to the servers over TLS, using
+// cfg. Set cfg.RootCAs to trust servers whose certificates are not
+// issued by the system roots, and cfg.Certificates to present a client
+// certificate. Unless cfg.ServerName is set, the host name of each
+// server is used for SNI and to verify its certificate. Servers that are
+// reached over UDP or unix domain sockets do not use TLS.
+func (c *Client) SetTLSConfig(cfg *tls.Config) *Client {
+	c.tlsConfig = cfg
+	return c
+}
+
+// SetMaxIdleConns sets the maximum number of idle connections that are
+// kept for each server, DefaultMaxIdleConns by default. Zero or less
+// means that connections are closed as soon as they are done with.
+func (c *Client) SetMaxIdleConns(n int) *Client {
+	c.maxIdleConns = n
+	return c
+}
+
+// SetMaxOpenConns sets the maximum number of connections that are open
+// to each server at the same time. Once it is reached, commands wait for
+// a connection to be released, or for their context to be done. Zero,
+// the default, means no limit.
+func (c *Client) SetMaxOpenConns(n int) *Client {
+	c.maxOpenConns = n
+	return c
+}
+
+// SetIdleTimeout sets the time after which idle connections are closed
+// instead of being reused, DefaultIdleTimeout by default. Zero means
+// that idle connections are kept forever.
+func (c *Client) SetIdleTimeout(d time.Duration) *Client {
+	c.idleTimeout = d
+	return c
+}
+
+// SetUDPTimeout sets how long the reply to a command sent over UDP is
+// waited for, DefaultUDPTimeout by default. As datagrams may be lost,
+// commands fail once it has elapsed, even if their context has no
+// deadline. Zero means that replies are waited for until the deadline
+// of the context, if any.
+func (c *Client) SetUDPTimeout(d time.Duration) *Client {
+	c.udpTimeout = d
+	return c
 }
 
 func (c *Client) Servers() []string {
 	return c.servers
 }
 
+// Close closes the idle connections, and the other ones as soon as
+// they are released. Commands can not be sent after Close is called.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	pools := c.pools
+	c.pools = make(map[string]*pool)
+	c.mu.Unlock()
+
+	for _, p := range pools {
+		p.close()
+	}
+	return nil
+}
+
 // getConn is responsible for choosing the server to connect, and
-// to actually make the connection.
-func (c *Client) getConn(cmd Command) (net.Conn, error) {
+// to check out a connection to it from its pool. The connection must
+// be released or discarded once done with. Its deadline is set to that
+// of ctx, if any.
+func (c *Client) getConn(ctx context.Context, cmd memdproto.Cmd) (*conn, error) {
 	addr, err := c.selector.Select(c, cmd)
 	if err != nil {
 		return nil, fmt.Errorf(`client.getConn: failed to select server: %w`, err)
 	}
-	if conn, ok := c.activeConns[addr]; ok {
-		return conn, nil
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, fmt.Errorf(`client.getConn: %w`, errPoolClosed)
 	}
+	p, ok := c.pools[addr]
+	if !ok {
+		p = &pool{client: c, addr: addr}
+		c.pools[addr] = p
+	}
+	c.mu.Unlock()
 
-	conn, err := net.Dial("tcp", addr)
+	cn, err := p.get(ctx)
 	if err != nil {
 		return nil, fmt.Errorf(`client.getConn: failed to connect to %s: %w`, addr, err)
 	}
-	c.activeConns[addr] = conn
-	return conn, nil
+	deadline, _ := ctx.Deadline()
+	cn.Conn.SetDeadline(deadline)
+	return cn, nil
+}
+
+// dial connects to the server at addr, over TLS if it is configured
+func (c *Client) dial(ctx context.Context, addr string) (net.Conn, error) {
+	var d net.Dialer
+	if hostport, ok := strings.CutPrefix(addr, "udp://"); ok {
+		conn, err := d.DialContext(ctx, "udp", hostport)
+		if err != nil {
+			return nil, err
+		}
+		return newUDPConn(conn, c.udpTimeout), nil
+	}
+	if path, ok := strings.CutPrefix(addr, "unix://"); ok {
+		return d.DialContext(ctx, "unix", path)
+	}
+	if c.tlsConfig != nil {
+		td := tls.Dialer{Config: c.tlsConfig}
+		return td.DialContext(ctx, "tcp", addr)
+	}
+	return d.DialContext(ctx, "tcp", addr)
 }
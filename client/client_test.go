@@ -1,17 +1,25 @@
 package client_test
 
 import (
+	"bytes"
 	"context"
 	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"net"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strconv"
 	"testing"
 	"time"
 
+	"github.com/lestrrat-go/memdproto"
 	"github.com/lestrrat-go/memdproto/client"
+	"github.com/lestrrat-go/memdproto/memdtest"
+	"github.com/lestrrat-go/memdproto/server"
+	"github.com/lestrrat-go/memdproto/store"
 	"github.com/stretchr/testify/require"
 )
 
@@ -72,6 +80,14 @@ func testMain(m *testing.M) (int, error) {
 		}
 	}
 
+	if MemcachedAddr == "" {
+		// memcached is not installed: run the tests against
+		// an in-process server instead
+		srv := memdtest.NewServer()
+		defer srv.Close()
+		MemcachedAddr = srv.Addr()
+	}
+
 	return m.Run(), nil
 }
 
@@ -106,3 +122,144 @@ func TestClient(t *testing.T) {
 	require.NoError(t, err, `client.MetaGet.Do should succeed`)
 	require.True(t, mgres.Hit(), `client.MetaGet.Do should result in a hit`)
 }
+
+func TestClientTLS(t *testing.T) {
+	srv := memdtest.NewTLSServer()
+	defer srv.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+
+	cl := client.New(srv.Addr()).
+		SetTLSConfig(&tls.Config{RootCAs: roots, ServerName: "localhost"})
+
+	msres, err := cl.MetaSet("foo", []byte("bar")).
+		Do(ctx)
+	require.NoError(t, err, `client.MetaSet.Do should succeed`)
+	require.True(t, msres.Stored(), `client.MetaSet.Do should result in stored`)
+
+	mgres, err := cl.MetaGet("foo").
+		Do(ctx)
+	require.NoError(t, err, `client.MetaGet.Do should succeed`)
+	require.Equal(t, []byte("bar"), mgres.Value(), `client.MetaGet.Do should return the value`)
+
+	_, err = client.New(srv.Addr()).
+		SetTLSConfig(&tls.Config{}).
+		MetaGet("foo").
+		Do(ctx)
+	require.Error(t, err, `client.MetaGet.Do should fail without the CA`)
+}
+
+func TestClientUDP(t *testing.T) {
+	srv := server.New(store.New())
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err, `net.Listen should succeed`)
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err, `net.ListenPacket should succeed`)
+	go srv.Serve(ln)
+	go srv.ServeUDP(pc)
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cl := client.New("udp://" + pc.LocalAddr().String())
+	msres, err := cl.MetaSet("foo", []byte("bar")).
+		Do(ctx)
+	require.NoError(t, err, `client.MetaSet.Do should succeed`)
+	require.True(t, msres.Stored(), `client.MetaSet.Do should result in stored`)
+
+	mgres, err := cl.MetaGet("foo").
+		Do(ctx)
+	require.NoError(t, err, `client.MetaGet.Do should succeed`)
+	require.Equal(t, []byte("bar"), mgres.Value(), `client.MetaGet.Do should return the value`)
+
+	mgres, err = cl.MetaGet("bar").
+		Do(ctx)
+	require.NoError(t, err, `client.MetaGet.Do should succeed`)
+	require.True(t, mgres.Miss(), `client.MetaGet.Do should result in a miss`)
+
+	// requests must fit in a datagram, but replies may span several
+	value := bytes.Repeat([]byte("x"), 4000)
+	_, err = cl.MetaSet("big", value).
+		Do(ctx)
+	require.Error(t, err, `client.MetaSet.Do should fail on large values`)
+
+	msres, err = client.New(ln.Addr().String()).
+		MetaSet("big", value).
+		Do(ctx)
+	require.NoError(t, err, `client.MetaSet.Do should succeed over TCP`)
+	require.True(t, msres.Stored(), `client.MetaSet.Do should result in stored`)
+
+	mgres, err = cl.MetaGet("big").
+		Do(ctx)
+	require.NoError(t, err, `client.MetaGet.Do should succeed`)
+	require.Equal(t, value, mgres.Value(), `client.MetaGet.Do should return the value`)
+}
+
+func TestClientUDPLostReply(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err, `net.ListenPacket should succeed`)
+	defer pc.Close()
+
+	// drops the requests for "mg drop", and sends a reply without
+	// any payload to the other ones
+	go func() {
+		buf := make([]byte, memdproto.UDPMaxDatagramSize)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			h, payload, err := memdproto.ParseUDPDatagram(buf[:n])
+			if err != nil || bytes.HasPrefix(payload, []byte("mg drop")) {
+				continue
+			}
+			reply := memdproto.UDPHeader{RequestID: h.RequestID, Total: 1}
+			pc.WriteTo(reply.AppendTo(nil), addr)
+		}
+	}()
+
+	cl := client.New("udp://" + pc.LocalAddr().String()).
+		SetUDPTimeout(100 * time.Millisecond)
+
+	start := time.Now()
+	_, err = cl.MetaGet("drop").
+		Do(context.Background())
+	require.ErrorIs(t, err, os.ErrDeadlineExceeded, `client.MetaGet.Do should time out`)
+	require.Less(t, time.Since(start), 5*time.Second, `client.MetaGet.Do should not wait past the UDP timeout`)
+
+	_, err = cl.MetaGet("empty").
+		Do(context.Background())
+	require.Error(t, err, `client.MetaGet.Do should fail on an empty reply`)
+	require.NotErrorIs(t, err, os.ErrDeadlineExceeded, `client.MetaGet.Do should not wait for more datagrams`)
+}
+
+func TestClientUnix(t *testing.T) {
+	dir, err := os.MkdirTemp("", "memd")
+	require.NoError(t, err, `os.MkdirTemp should succeed`)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "memd.sock")
+
+	ln, err := net.Listen("unix", path)
+	require.NoError(t, err, `net.Listen should succeed`)
+	srv := server.New(store.New())
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cl := client.New("unix://" + path)
+	msres, err := cl.MetaSet("foo", []byte("bar")).
+		Do(ctx)
+	require.NoError(t, err, `client.MetaSet.Do should succeed`)
+	require.True(t, msres.Stored(), `client.MetaSet.Do should result in stored`)
+
+	mgres, err := cl.MetaGet("foo").
+		Do(ctx)
+	require.NoError(t, err, `client.MetaGet.Do should succeed`)
+	require.Equal(t, []byte("bar"), mgres.Value(), `client.MetaGet.Do should return the value`)
+}
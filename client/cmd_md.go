@@ -23,20 +23,23 @@ func (cmd *MetaDeleteCmd) Key() string {
 }
 
 func (cmd *MetaDeleteCmd) Do(ctx context.Context) (*MetaDeleteResult, error) {
-	conn, err := cmd.client.getConn(cmd)
+	conn, err := cmd.client.getConn(ctx, cmd.proto)
 	if err != nil {
 		return nil, err
 	}
 
 	if _, err := cmd.proto.WriteTo(conn); err != nil {
+		conn.discard()
 		return nil, err
 	}
 
 	var result MetaDeleteResult
-	if _, err := result.proto.ReadFrom(conn); err != nil {
+	if _, err := result.proto.ReadFrom(conn.rdr); err != nil {
+		conn.discard()
 		return nil, err
 	}
 
+	conn.release()
 	return &result, nil
 }
 
@@ -36,20 +36,23 @@ func (cmd *MetaGetCmd) Opaque(v []byte) *MetaGetCmd {
 }
 
 func (cmd *MetaGetCmd) Do(ctx context.Context) (*MetaGetResult, error) {
-	conn, err := cmd.client.getConn(cmd)
+	conn, err := cmd.client.getConn(ctx, cmd.proto)
 	if err != nil {
 		return nil, fmt.Errorf(`client.MetaGetCmd.Do: failed to connect: %w`, err)
 	}
 
 	if _, err := cmd.proto.WriteTo(conn); err != nil {
+		conn.discard()
 		return nil, fmt.Errorf(`client.MetaGetCmd.Do: failed to send command: %w`, err)
 	}
 
 	var reply memdproto.MetaGetReply
-	if _, err := reply.ReadFrom(conn); err != nil {
+	if _, err := reply.ReadFrom(conn.rdr); err != nil {
+		conn.discard()
 		return nil, fmt.Errorf(`client.MetaGetCmd.Do: failed to read response: %w`, err)
 	}
 
+	conn.release()
 	return &MetaGetResult{proto: &reply}, nil
 }
 
@@ -24,20 +24,23 @@ func (cmd *MetaSetCmd) Key() string {
 }
 
 func (cmd *MetaSetCmd) Do(ctx context.Context) (*MetaSetResult, error) {
-	conn, err := cmd.client.getConn(cmd)
+	conn, err := cmd.client.getConn(ctx, cmd.proto)
 	if err != nil {
 		return nil, err
 	}
 
 	if _, err := cmd.proto.WriteTo(conn); err != nil {
+		conn.discard()
 		return nil, err
 	}
 
 	var reply memdproto.MetaSetReply
-	if _, err := reply.ReadFrom(conn); err != nil {
+	if _, err := reply.ReadFrom(conn.rdr); err != nil {
+		conn.discard()
 		return nil, fmt.Errorf(`client.MetaSetCmd.Do: failed to read response: %w`, err)
 	}
 
+	conn.release()
 	return &MetaSetResult{proto: &reply}, nil
 }
 
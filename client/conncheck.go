@@ -0,0 +1,53 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+
+package client
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"syscall"
+)
+
+var errUnexpectedRead = errors.New(`client: unexpected data on idle connection`)
+
+// connCheck peeks at the socket of conn without blocking, and returns an
+// error if the server has closed the connection, or has sent data that
+// nobody asked for. Over TLS, data is allowed, as the server may send
+// messages of its own, such as session tickets. Connections that do not
+// expose their socket are assumed to be fine.
+func connCheck(conn net.Conn) error {
+	strict := true
+	if tc, ok := conn.(*tls.Conn); ok {
+		conn, strict = tc.NetConn(), false
+	}
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return nil
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var checkErr error
+	err = rc.Read(func(fd uintptr) bool {
+		var buf [1]byte
+		n, _, err := syscall.Recvfrom(int(fd), buf[:], syscall.MSG_PEEK)
+		switch {
+		case n == 0 && err == nil:
+			checkErr = io.EOF
+		case n > 0 && strict:
+			checkErr = errUnexpectedRead
+		case err != nil && !errors.Is(err, syscall.EAGAIN) && !errors.Is(err, syscall.EWOULDBLOCK):
+			checkErr = err
+		}
+		// never wait for the socket to become readable
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	return checkErr
+}
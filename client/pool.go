@@ -0,0 +1,205 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// Defaults of the connection pools, see SetMaxIdleConns and SetIdleTimeout
+const (
+	DefaultMaxIdleConns = 2
+	DefaultIdleTimeout  = time.Minute
+)
+
+// errPoolClosed is returned when a connection is requested after the
+// client has been closed
+var errPoolClosed = errors.New(`client: client is closed`)
+
+// conn is a connection checked out of a pool. Replies must be read
+// from rdr, which may hold data that was read ahead.
+type conn struct {
+	net.Conn
+	rdr      *bufio.Reader
+	pool     *pool
+	lastUsed time.Time
+}
+
+// release puts the connection back in its pool. It must only be called
+// once the reply to the last command has been read entirely.
+func (cn *conn) release() {
+	cn.pool.put(cn)
+}
+
+// discard closes the connection, which must not be used anymore, for
+// example because an error left it out of sync
+func (cn *conn) discard() {
+	cn.Conn.Close()
+	cn.pool.slotFreed()
+}
+
+// healthy returns false if the server has closed the connection, or
+// has sent data that nobody asked for, which happens when a connection
+// is put back in the pool without reading the whole reply
+func (cn *conn) healthy() bool {
+	return cn.rdr.Buffered() == 0 && connCheck(cn.Conn) == nil
+}
+
+// poolGrant is handed to a waiter: either a connection, the permission
+// to open a new one when conn is nil, or an error
+type poolGrant struct {
+	conn *conn
+	err  error
+}
+
+type poolWaiter chan poolGrant
+
+// pool holds the connections to a single server
+type pool struct {
+	client *Client
+	addr   string
+
+	mu      sync.Mutex
+	idle    []*conn // the most recently used last
+	open    int
+	waiters []poolWaiter
+	closed  bool
+}
+
+// get returns an idle connection, or opens a new one. If the maximum
+// number of open connections has been reached, get waits for one to be
+// released, or for ctx to be done.
+func (p *pool) get(ctx context.Context) (*conn, error) {
+	for {
+		cn, err := p.checkout(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if cn == nil {
+			return p.dial(ctx)
+		}
+		expired := p.client.idleTimeout > 0 && time.Since(cn.lastUsed) >= p.client.idleTimeout
+		if !expired && cn.healthy() {
+			return cn, nil
+		}
+		cn.discard()
+	}
+}
+
+// checkout takes an idle connection, or the permission to open a new
+// one, in which case it returns nil
+func (p *pool) checkout(ctx context.Context) (*conn, error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, errPoolClosed
+	}
+	if n := len(p.idle); n > 0 {
+		cn := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.mu.Unlock()
+		return cn, nil
+	}
+	if limit := p.client.maxOpenConns; limit <= 0 || p.open < limit {
+		p.open++
+		p.mu.Unlock()
+		return nil, nil
+	}
+
+	w := make(poolWaiter, 1)
+	p.waiters = append(p.waiters, w)
+	p.mu.Unlock()
+
+	select {
+	case g := <-w:
+		return g.conn, g.err
+	case <-ctx.Done():
+		p.mu.Lock()
+		for i, other := range p.waiters {
+			if other == w {
+				p.waiters = append(p.waiters[:i], p.waiters[i+1:]...)
+				p.mu.Unlock()
+				return nil, ctx.Err()
+			}
+		}
+		p.mu.Unlock()
+
+		// something was handed to us in the meantime: pass it on
+		switch g := <-w; {
+		case g.err != nil:
+		case g.conn != nil:
+			p.put(g.conn)
+		default:
+			p.slotFreed()
+		}
+		return nil, ctx.Err()
+	}
+}
+
+func (p *pool) dial(ctx context.Context) (*conn, error) {
+	nc, err := p.client.dial(ctx, p.addr)
+	if err != nil {
+		p.slotFreed()
+		return nil, err
+	}
+	return &conn{Conn: nc, rdr: bufio.NewReader(nc), pool: p}, nil
+}
+
+// put hands cn to the first waiter, or makes it idle
+func (p *pool) put(cn *conn) {
+	cn.Conn.SetDeadline(time.Time{})
+	cn.lastUsed = time.Now()
+
+	p.mu.Lock()
+	if len(p.waiters) > 0 {
+		w := p.waiters[0]
+		p.waiters = p.waiters[1:]
+		p.mu.Unlock()
+		w <- poolGrant{conn: cn}
+		return
+	}
+	if p.closed || len(p.idle) >= p.client.maxIdleConns {
+		p.open--
+		p.mu.Unlock()
+		cn.Conn.Close()
+		return
+	}
+	p.idle = append(p.idle, cn)
+	p.mu.Unlock()
+}
+
+// slotFreed is called when a connection has been closed, or could not
+// be opened. The first waiter, if any, may then open a new one.
+func (p *pool) slotFreed() {
+	p.mu.Lock()
+	if len(p.waiters) > 0 && !p.closed {
+		w := p.waiters[0]
+		p.waiters = p.waiters[1:]
+		p.mu.Unlock()
+		w <- poolGrant{}
+		return
+	}
+	p.open--
+	p.mu.Unlock()
+}
+
+// close closes the idle connections, and fails the waiters. Connections
+// that are in use are closed when they are released.
+func (p *pool) close() {
+	p.mu.Lock()
+	idle, waiters := p.idle, p.waiters
+	p.idle, p.waiters = nil, nil
+	p.open -= len(idle)
+	p.closed = true
+	p.mu.Unlock()
+
+	for _, cn := range idle {
+		cn.Conn.Close()
+	}
+	for _, w := range waiters {
+		w <- poolGrant{err: errPoolClosed}
+	}
+}
@@ -0,0 +1,181 @@
+package client_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/memdproto"
+	"github.com/lestrrat-go/memdproto/client"
+	"github.com/lestrrat-go/memdproto/server"
+	"github.com/lestrrat-go/memdproto/store"
+	"github.com/stretchr/testify/require"
+)
+
+// countingListener counts the connections that it accepts
+type countingListener struct {
+	net.Listener
+	accepted atomic.Int64
+}
+
+func (ln *countingListener) Accept() (net.Conn, error) {
+	conn, err := ln.Listener.Accept()
+	if err == nil {
+		ln.accepted.Add(1)
+	}
+	return conn, err
+}
+
+// startPoolServer serves h on a loopback port
+func startPoolServer(t *testing.T, srv *server.Server) *countingListener {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err, `net.Listen should succeed`)
+	cl := &countingListener{Listener: ln}
+	go srv.Serve(cl)
+	t.Cleanup(func() { srv.Close() })
+	return cl
+}
+
+func TestPool(t *testing.T) {
+	t.Run("concurrent use", func(t *testing.T) {
+		ln := startPoolServer(t, server.New(store.New()))
+		cl := client.New(ln.Addr().String()).SetMaxOpenConns(4)
+		defer cl.Close()
+		ctx := context.Background()
+
+		var wg sync.WaitGroup
+		for i := 0; i < 16; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < 20; j++ {
+					key := fmt.Sprintf("key-%d-%d", i, j)
+					value := []byte(fmt.Sprintf("value-%d-%d", i, j))
+					msres, err := cl.MetaSet(key, value).Do(ctx)
+					if !assertNoError(t, err, `client.MetaSet.Do should succeed`) || !msres.Stored() {
+						return
+					}
+					mgres, err := cl.MetaGet(key).Do(ctx)
+					if !assertNoError(t, err, `client.MetaGet.Do should succeed`) {
+						return
+					}
+					if string(mgres.Value()) != string(value) {
+						t.Errorf("value of %s should match: expected %q, got %q", key, value, mgres.Value())
+						return
+					}
+				}
+			}(i)
+		}
+		wg.Wait()
+		require.LessOrEqual(t, ln.accepted.Load(), int64(4), `no more than 4 connections should be opened`)
+	})
+
+	t.Run("reuse", func(t *testing.T) {
+		ln := startPoolServer(t, server.New(store.New()))
+		cl := client.New(ln.Addr().String())
+		defer cl.Close()
+		for i := 0; i < 5; i++ {
+			_, err := cl.MetaGet("foo").Do(context.Background())
+			require.NoError(t, err, `client.MetaGet.Do should succeed`)
+		}
+		require.Equal(t, int64(1), ln.accepted.Load(), `the connection should be reused`)
+
+		noIdle := client.New(ln.Addr().String()).SetMaxIdleConns(0)
+		defer noIdle.Close()
+		for i := 0; i < 3; i++ {
+			_, err := noIdle.MetaGet("foo").Do(context.Background())
+			require.NoError(t, err, `client.MetaGet.Do should succeed`)
+		}
+		require.Equal(t, int64(4), ln.accepted.Load(), `connections should not be kept idle`)
+	})
+
+	t.Run("wait for a connection", func(t *testing.T) {
+		block := make(chan struct{})
+		started := make(chan struct{}, 1)
+		h := server.HandlerFunc(func(_ context.Context, cmd memdproto.Cmd) (memdproto.Reply, error) {
+			if cmd.Keys()[0] == "slow" {
+				started <- struct{}{}
+				<-block
+			}
+			return memdproto.NewMetaGetReply().SetMiss(true), nil
+		})
+		ln := startPoolServer(t, server.New(h))
+		cl := client.New(ln.Addr().String()).SetMaxOpenConns(1)
+		defer cl.Close()
+
+		done := make(chan error, 1)
+		go func() {
+			_, err := cl.MetaGet("slow").Do(context.Background())
+			done <- err
+		}()
+		<-started
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, err := cl.MetaGet("foo").Do(ctx)
+		require.ErrorIs(t, err, context.DeadlineExceeded, `client.MetaGet.Do should give up waiting for a connection`)
+
+		waited := make(chan error, 1)
+		go func() {
+			_, err := cl.MetaGet("foo").Do(context.Background())
+			waited <- err
+		}()
+		close(block)
+		require.NoError(t, <-done, `client.MetaGet.Do should succeed`)
+		require.NoError(t, <-waited, `client.MetaGet.Do should succeed once the connection is released`)
+		require.Equal(t, int64(1), ln.accepted.Load(), `only one connection should be opened`)
+	})
+
+	t.Run("idle timeout", func(t *testing.T) {
+		ln := startPoolServer(t, server.New(store.New()))
+		cl := client.New(ln.Addr().String()).SetIdleTimeout(20 * time.Millisecond)
+		defer cl.Close()
+
+		_, err := cl.MetaGet("foo").Do(context.Background())
+		require.NoError(t, err, `client.MetaGet.Do should succeed`)
+		time.Sleep(50 * time.Millisecond)
+		_, err = cl.MetaGet("foo").Do(context.Background())
+		require.NoError(t, err, `client.MetaGet.Do should succeed`)
+		require.Equal(t, int64(2), ln.accepted.Load(), `expired connections should not be reused`)
+	})
+
+	t.Run("health check", func(t *testing.T) {
+		// the server hangs up on idle clients
+		ln := startPoolServer(t, server.New(store.New()).SetIdleTimeout(20*time.Millisecond))
+		cl := client.New(ln.Addr().String())
+		defer cl.Close()
+
+		_, err := cl.MetaGet("foo").Do(context.Background())
+		require.NoError(t, err, `client.MetaGet.Do should succeed`)
+		time.Sleep(100 * time.Millisecond)
+		_, err = cl.MetaGet("foo").Do(context.Background())
+		require.NoError(t, err, `client.MetaGet.Do should succeed on a new connection`)
+		require.Equal(t, int64(2), ln.accepted.Load(), `closed connections should not be reused`)
+	})
+
+	t.Run("close", func(t *testing.T) {
+		ln := startPoolServer(t, server.New(store.New()))
+		cl := client.New(ln.Addr().String())
+		_, err := cl.MetaGet("foo").Do(context.Background())
+		require.NoError(t, err, `client.MetaGet.Do should succeed`)
+
+		require.NoError(t, cl.Close(), `client.Close should succeed`)
+		_, err = cl.MetaGet("foo").Do(context.Background())
+		require.Error(t, err, `client.MetaGet.Do should fail after Close`)
+	})
+}
+
+// assertNoError reports err from a goroutine other than the test's
+func assertNoError(t *testing.T, err error, msg string) bool {
+	t.Helper()
+	if err != nil {
+		t.Errorf("%s: %s", msg, err)
+		return false
+	}
+	return true
+}
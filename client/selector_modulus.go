@@ -1,13 +1,27 @@
 package client
 
-import "hash/fnv"
+import (
+	"fmt"
+	"hash/fnv"
+
+	"github.com/lestrrat-go/memdproto"
+)
 
 type ModulusSelector struct {
 }
 
-func (s *ModulusSelector) Select(c *Client, cmd Command) (string, error) {
+func (s *ModulusSelector) Select(c *Client, cmd memdproto.Cmd) (string, error) {
 	l := len(c.servers)
+	if l == 0 {
+		return "", fmt.Errorf(`client.ModulusSelector: no servers available`)
+	}
+
+	keys := cmd.Keys()
+	if len(keys) == 0 {
+		return "", fmt.Errorf(`client.ModulusSelector: command %q has no keys`, cmd.Verb())
+	}
+
 	h := fnv.New64a()
-	h.Write([]byte(cmd.Key()))
+	h.Write([]byte(keys[0]))
 	return c.servers[h.Sum64()%uint64(l)], nil
 }
@@ -0,0 +1,128 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/lestrrat-go/memdproto"
+)
+
+// DefaultUDPTimeout is how long the reply to a command sent over UDP
+// is waited for by default, see SetUDPTimeout
+const DefaultUDPTimeout = time.Second
+
+// udpConn speaks the UDP protocol over a connected UDP socket, so that
+// it can be used like a stream. Commands that are written are buffered
+// until the reply is read: they are then sent as a request, in a single
+// datagram with a new request ID, and Read returns the reply once all of
+// its datagrams have been received. Datagrams that belong to other
+// requests are dropped. Once the reply has been read in full, Read
+// returns io.EOF until the next request is sent.
+//
+// As datagrams may be lost, the reply is only waited for until timeout
+// has elapsed, or until the deadline set by the caller if it is earlier.
+type udpConn struct {
+	net.Conn
+	asm       *memdproto.UDPAssembler
+	timeout   time.Duration
+	deadline  time.Time
+	requestID uint16
+	request   []byte
+	reply     []byte
+	done      bool
+	buf       []byte
+}
+
+func newUDPConn(conn net.Conn, timeout time.Duration) *udpConn {
+	return &udpConn{
+		Conn:    conn,
+		asm:     memdproto.NewUDPAssembler(),
+		timeout: timeout,
+		buf:     make([]byte, 64*1024),
+	}
+}
+
+func (c *udpConn) SetDeadline(t time.Time) error {
+	c.deadline = t
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *udpConn) SetReadDeadline(t time.Time) error {
+	c.deadline = t
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *udpConn) Write(p []byte) (int, error) {
+	if c.request == nil {
+		h := memdproto.UDPHeader{RequestID: c.requestID + 1, Total: 1}
+		c.request = h.AppendTo(nil)
+	}
+	if len(c.request)+len(p) > memdproto.UDPMaxDatagramSize {
+		c.request = nil
+		return 0, fmt.Errorf(`client.udpConn.Write: request does not fit in a datagram`)
+	}
+	c.request = append(c.request, p...)
+	return len(p), nil
+}
+
+// send sends the buffered request
+func (c *udpConn) send() error {
+	c.asm.Discard(c.requestID)
+	c.requestID++
+	c.reply = nil
+	c.done = false
+	request := c.request
+	c.request = nil
+
+	if c.timeout > 0 {
+		deadline := time.Now().Add(c.timeout)
+		if !c.deadline.IsZero() && c.deadline.Before(deadline) {
+			deadline = c.deadline
+		}
+		if err := c.Conn.SetReadDeadline(deadline); err != nil {
+			return err
+		}
+	}
+	_, err := c.Conn.Write(request)
+	return err
+}
+
+func (c *udpConn) Read(p []byte) (int, error) {
+	if c.request != nil {
+		if err := c.send(); err != nil {
+			return 0, err
+		}
+	}
+
+	for len(c.reply) == 0 {
+		if c.done {
+			return 0, io.EOF
+		}
+		n, err := c.Conn.Read(c.buf)
+		if err != nil {
+			var nerr net.Error
+			if errors.As(err, &nerr) && nerr.Timeout() {
+				return 0, fmt.Errorf(`client.udpConn.Read: no reply to request %d: %w`, c.requestID, err)
+			}
+			return 0, err
+		}
+		h, message, ok, err := c.asm.Add(c.buf[:n])
+		if err != nil {
+			continue
+		}
+		if h.RequestID != c.requestID {
+			c.asm.Discard(h.RequestID)
+			continue
+		}
+		if ok {
+			c.reply = message
+			c.done = true
+		}
+	}
+	n := copy(p, c.reply)
+	c.reply = c.reply[n:]
+	return n, nil
+}
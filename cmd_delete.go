@@ -1,6 +1,7 @@
 package memdproto
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 )
@@ -10,6 +11,34 @@ type DeleteCmd struct {
 	noreply bool
 }
 
+var _ Cmd = (*DeleteCmd)(nil)
+
+func NewDeleteCmd(key string) *DeleteCmd {
+	return &DeleteCmd{
+		key: key,
+	}
+}
+
+func (cmd *DeleteCmd) Key() string {
+	return cmd.key
+}
+
+func (cmd *DeleteCmd) Verb() string {
+	return "delete"
+}
+
+func (cmd *DeleteCmd) Keys() []string {
+	return []string{cmd.key}
+}
+
+func (cmd *DeleteCmd) NoReply() bool {
+	return cmd.noreply
+}
+
+func (cmd *DeleteCmd) NewReply() Reply {
+	return NewDeleteCmdReply()
+}
+
 func (cmd *DeleteCmd) SetNoReply(noreply bool) *DeleteCmd {
 	cmd.noreply = noreply
 	return cmd
@@ -36,3 +65,124 @@ func (cmd *DeleteCmd) WriteTo(dst io.Writer) (int64, error) {
 	written += int64(n)
 	return written, err
 }
+
+var deleteCmdName = []byte("delete")
+
+func (cmd *DeleteCmd) UnmarshalText(data []byte) error {
+	*cmd = DeleteCmd{}
+
+	ldata := len(data)
+	if !bytes.HasPrefix(data, deleteCmdName) {
+		return parseErrorf(``, 0, string(firstToken(data)), `invalid delete command`)
+	}
+	data = data[len(deleteCmdName):]
+
+	if !bytes.HasSuffix(data, crlf) {
+		return parseErrorf(`delete`, ldata, ``, `expected CRLF`)
+	}
+	data = data[:len(data)-2]
+
+	if len(data) == 0 || data[0] != ' ' {
+		return parseErrorf(`delete`, len(deleteCmdName), ``, `missing space after command`)
+	}
+
+	fields := bytes.Split(data[1:], space)
+	switch {
+	case len(fields) == 1:
+	case len(fields) == 2 && bytes.Equal(fields[1], noreplyToken):
+		cmd.noreply = true
+	default:
+		return parseErrorf(`delete`, len(deleteCmdName)+1+len(fields[0])+1, string(fields[1]), `expected noreply`)
+	}
+
+	key, count, err := readBytes(fields[0], 250)
+	if err == nil && count != len(fields[0]) {
+		err = fmt.Errorf(`unexpected character %q`, fields[0][count])
+	}
+	if err != nil {
+		return parseError(`delete`, len(deleteCmdName)+1, string(fields[0]), fmt.Errorf(`invalid key: %w`, err))
+	}
+	cmd.key = string(key)
+	return nil
+}
+
+type DeleteCmdReplyType uint8
+
+const (
+	DeleteCmdReplyInvalid DeleteCmdReplyType = iota
+	DeleteCmdReplyDeleted
+	DeleteCmdReplyNotFound
+	DeleteCmdReplyTypeMax
+)
+
+var deleteCmdReplyDeleted = []byte("DELETED")
+var deleteCmdReplyNotFound = []byte("NOT_FOUND")
+
+// DeleteCmdReply represents the reply to a classic delete command
+type DeleteCmdReply struct {
+	status DeleteCmdReplyType
+}
+
+var _ Reply = (*DeleteCmdReply)(nil)
+
+func NewDeleteCmdReply() *DeleteCmdReply {
+	return &DeleteCmdReply{}
+}
+
+func (reply *DeleteCmdReply) Status() DeleteCmdReplyType {
+	return reply.status
+}
+
+func (reply *DeleteCmdReply) SetStatus(status DeleteCmdReplyType) *DeleteCmdReply {
+	reply.status = status
+	return reply
+}
+
+func (reply *DeleteCmdReply) WriteTo(dst io.Writer) (int64, error) {
+	var status []byte
+	switch reply.status {
+	case DeleteCmdReplyDeleted:
+		status = deleteCmdReplyDeleted
+	case DeleteCmdReplyNotFound:
+		status = deleteCmdReplyNotFound
+	default:
+		return 0, fmt.Errorf("invalid delete command reply")
+	}
+
+	var written int64
+	n, err := dst.Write(status)
+	written += int64(n)
+	if err != nil {
+		return written, err
+	}
+
+	n, err = dst.Write(crlf)
+	written += int64(n)
+	return written, err
+}
+
+func (reply *DeleteCmdReply) ReadFrom(src io.Reader) (int64, error) {
+	line, err := bufferedReader(src).ReadBytes('\n')
+	if err != nil {
+		return int64(len(line)), parseError(string(firstToken(line)), len(line), ``, err)
+	}
+	return int64(len(line)), reply.UnmarshalText(line)
+}
+
+func (reply *DeleteCmdReply) UnmarshalText(data []byte) error {
+	ldata := len(data)
+	if ldata < 2 || !bytes.Equal(data[ldata-2:], crlf) {
+		return parseErrorf(string(firstToken(data)), ldata, ``, `expected CRLF`)
+	}
+	data = data[:ldata-2]
+
+	switch {
+	case bytes.Equal(data, deleteCmdReplyDeleted):
+		reply.status = DeleteCmdReplyDeleted
+	case bytes.Equal(data, deleteCmdReplyNotFound):
+		reply.status = DeleteCmdReplyNotFound
+	default:
+		return parseErrorf(``, 0, string(firstToken(data)), `invalid delete command reply`)
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package memdproto
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// GatCmd represents the classic gat and gats ("get and touch") commands,
+// which fetch items while updating their expiration time.
+type GatCmd struct {
+	expires int64
+	keys    []string
+	cas     bool
+}
+
+var _ Cmd = (*GatCmd)(nil)
+
+// NewGatCmd creates a new GatCmd that sets the expiration time of the
+// items specified by keys to expires. Use SetRetrieveCas to issue a gats
+// command instead.
+func NewGatCmd(expires int64, keys ...string) *GatCmd {
+	return &GatCmd{
+		expires: expires,
+		keys:    keys,
+	}
+}
+
+// Verb returns "gats" if the command is set to retrieve CAS values,
+// and "gat" otherwise.
+func (cmd *GatCmd) Verb() string {
+	if cmd.cas {
+		return "gats"
+	}
+	return "gat"
+}
+
+func (cmd *GatCmd) Keys() []string {
+	return cmd.keys
+}
+
+// NoReply always returns false, as retrieval commands can not be quieted.
+func (cmd *GatCmd) NoReply() bool {
+	return false
+}
+
+// NewReply returns an empty GetReply
+func (cmd *GatCmd) NewReply() Reply {
+	return NewGetReply()
+}
+
+// Expires returns the new expiration time of the items, as sent on the wire
+func (cmd *GatCmd) Expires() int64 {
+	return cmd.expires
+}
+
+func (cmd *GatCmd) SetExpires(expires int64) *GatCmd {
+	cmd.expires = expires
+	return cmd
+}
+
+func (cmd *GatCmd) AddKeys(keys ...string) *GatCmd {
+	cmd.keys = append(cmd.keys, keys...)
+	return cmd
+}
+
+// SetRetrieveCas sets whether or not the gats command should be used.
+func (cmd *GatCmd) SetRetrieveCas(b bool) *GatCmd {
+	cmd.cas = b
+	return cmd
+}
+
+func (cmd *GatCmd) WriteTo(dst io.Writer) (int64, error) {
+	if len(cmd.keys) == 0 {
+		return 0, fmt.Errorf("memdproto.GatCmd: no keys specified")
+	}
+
+	var written int64
+	n, err := fmt.Fprintf(dst, "%s %d", cmd.Verb(), cmd.expires)
+	written += int64(n)
+	if err != nil {
+		return written, err
+	}
+
+	for _, key := range cmd.keys {
+		n, err := fmt.Fprintf(dst, " %s", key)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+
+	n, err = dst.Write(crlf)
+	written += int64(n)
+	return written, err
+}
+
+func (cmd *GatCmd) UnmarshalText(data []byte) error {
+	*cmd = GatCmd{}
+
+	ldata := len(data)
+	verb := string(firstToken(data))
+	switch verb {
+	case "gat":
+	case "gats":
+		cmd.cas = true
+	default:
+		return parseErrorf(``, 0, verb, `invalid gat command`)
+	}
+
+	if !bytes.HasSuffix(data, crlf) {
+		return parseErrorf(verb, ldata, ``, `expected CRLF`)
+	}
+	data = data[len(verb) : ldata-2]
+
+	if len(data) == 0 || data[0] != ' ' {
+		return parseErrorf(verb, len(verb), ``, `missing space after command`)
+	}
+
+	fields := bytes.Split(data[1:], space)
+	if len(fields) < 2 {
+		return parseError(verb, ldata-2, ``, fmt.Errorf(`missing keys: %w`, ErrUnexpectedEOL))
+	}
+
+	expires, err := strconv.ParseInt(string(fields[0]), 10, 64)
+	if err != nil {
+		return parseError(verb, len(verb)+1, string(fields[0]), fmt.Errorf(`invalid exptime: %w`, err))
+	}
+	cmd.expires = expires
+
+	offset := len(verb) + 1 + len(fields[0]) + 1
+	for _, field := range fields[1:] {
+		key, count, err := readBytes(field, 250)
+		if err == nil && count != len(field) {
+			err = fmt.Errorf(`unexpected character %q`, field[count])
+		}
+		if err != nil {
+			return parseError(verb, offset, string(field), fmt.Errorf(`invalid key: %w`, err))
+		}
+		cmd.keys = append(cmd.keys, string(key))
+		offset += len(field) + 1
+	}
+	return nil
+}
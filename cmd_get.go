@@ -2,9 +2,9 @@ package memdproto
 
 import (
 	"bytes"
-	"errors"
 	"fmt"
 	"io"
+	"strconv"
 	"sync"
 	"unicode"
 )
@@ -37,6 +37,29 @@ func (cmd *GetCmd) Keys() []string {
 	return cmd.keys
 }
 
+// Verb returns "gets" if the command is set to retrieve CAS values,
+// and "get" otherwise.
+//
+// It is safe to call this method concurrently with other methods on this object.
+func (cmd *GetCmd) Verb() string {
+	cmd.mu.RLock()
+	defer cmd.mu.RUnlock()
+	if cmd.cas {
+		return "gets"
+	}
+	return "get"
+}
+
+// NoReply always returns false, as retrieval commands can not be quieted.
+func (cmd *GetCmd) NoReply() bool {
+	return false
+}
+
+// NewReply returns an empty GetReply
+func (cmd *GetCmd) NewReply() Reply {
+	return NewGetReply()
+}
+
 // AddKeys adds the specified keys to the command.
 //
 // It is safe to call this method concurrently with other methods on this object.
@@ -120,22 +143,31 @@ func (cmd *GetCmd) UnmarshalText(data []byte) error {
 
 	cmd.resetNL()
 
-	if len(data) < 5 {
-		return fmt.Errorf("memdproto.GetCmd: UnmarshalText: invalid get(s) command length")
+	ldata := len(data)
+	// offset returns the position of the current head of data
+	// relative to the beginning of the original input
+	offset := func() int {
+		return ldata - len(data)
+	}
+
+	if ldata < 5 {
+		return parseErrorf(`get`, 0, ``, `invalid get(s) command length`)
 	}
 
 	if !bytes.Equal(data[:len(getcmd)], getcmd) {
-		return fmt.Errorf("memdproto.GetCmd: UnmarshalText: invalid get command")
+		return parseErrorf(``, 0, string(firstToken(data)), `invalid get command`)
 	}
+	verb := `get`
 	data = data[len(getcmd):]
 
 	if data[0] == 's' {
 		cmd.cas = true
+		verb = `gets`
 		data = data[1:]
 	}
 
 	if data[0] != ' ' {
-		return fmt.Errorf("memdproto.GetCmd: UnmarshalText: invalid get command: expected space after command")
+		return parseErrorf(verb, offset(), ``, `expected space after command`)
 	}
 	data = data[1:]
 
@@ -167,18 +199,18 @@ func (cmd *GetCmd) UnmarshalText(data []byte) error {
 	}
 
 	if len(data) != 0 {
-		return fmt.Errorf("memdproto.GetCmd: UnmarshalText: invalid get command: trailing data")
+		return parseErrorf(verb, offset(), ``, `trailing data`)
 	}
 	// check that we have at least one key
 	if len(cmd.keys) == 0 {
-		return fmt.Errorf("memdproto.GetCmd: UnmarshalText: invalid get command: missing keys")
+		return parseErrorf(verb, offset(), ``, `missing keys`)
 	}
 	return nil
 }
 
 type GetReplyItem struct {
 	key   string
-	flags uint16
+	flags uint32
 	cas   *uint64
 	value []byte
 }
@@ -190,13 +222,34 @@ func NewGetReplyItem(key string, value []byte) *GetReplyItem {
 	}
 }
 
+func (item *GetReplyItem) Key() string {
+	return item.key
+}
+
+func (item *GetReplyItem) Value() []byte {
+	return item.value
+}
+
+func (item *GetReplyItem) Flags() uint32 {
+	return item.flags
+}
+
+// Cas returns the CAS unique value of the item, which is only
+// present in replies to gets/gats commands
+func (item *GetReplyItem) Cas() (uint64, bool) {
+	if item.cas == nil {
+		return 0, false
+	}
+	return *item.cas, true
+}
+
 func (item *GetReplyItem) SetCas(cas uint64) *GetReplyItem {
 	var v = cas
 	item.cas = &v
 	return item
 }
 
-func (item *GetReplyItem) SetFlags(flags uint16) *GetReplyItem {
+func (item *GetReplyItem) SetFlags(flags uint32) *GetReplyItem {
 	item.flags = flags
 	return item
 }
@@ -212,6 +265,15 @@ func NewGetReply() *GetReply {
 	return &GetReply{}
 }
 
+// Items returns the items in the reply
+//
+// It is safe to call this method concurrently with other methods on this object.
+func (reply *GetReply) Items() []*GetReplyItem {
+	reply.mu.RLock()
+	defer reply.mu.RUnlock()
+	return reply.items
+}
+
 func (reply *GetReply) AddItems(items ...*GetReplyItem) *GetReply {
 	reply.mu.Lock()
 	defer reply.mu.Unlock()
@@ -272,68 +334,123 @@ func (reply *GetReply) WriteTo(dst io.Writer) (int64, error) {
 	return written, err
 }
 
-/*
-func (reply *GetReply) UnmarshalText(data []byte) error {
-	// VALUE <key> <flags> <size> [<cas unique>]
-	rdr := bufio.NewReader(bytes.NewReader(data)
-	cmd, err := rdr.ReadString(' ')
-	if err != nil {
-		return fmt.Errorf("memdproto.GetReply: expected VALUE %w", err)
-	}
-	if err != nil {
-		return fmt.Errorf("memdproto.GetReply: expected key %w", err)
-	}
-	key = key[:len(key)-1]
+var valueReplyPrefix = []byte("VALUE ")
 
-	flagStr, err := rdr.ReadString(' ')
-	if err != nil {
-		return fmt.Errorf("memdproto.GetReply: expected flags %w", err)
-	}
+// ReadFrom reads a complete reply to a get(s) command, i.e. zero
+// or more VALUE items followed by END.
+//
+// It is safe to call this method concurrently with other methods on this object.
+func (reply *GetReply) ReadFrom(src io.Reader) (int64, error) {
+	brdr := bufferedReader(src)
 
-	flagStr = flagStr[:len(flags)-1]
-	flags, err := strconv.ParseUint(flagStr, 10, 16)
-	if err != nil {
-		return fmt.Errorf("memdproto.GetReply: expected numeric flags: %w", err)
-	}
+	// collect the entire reply, and let UnmarshalText do the parsing
+	var buf bytes.Buffer
+	for {
+		line, err := brdr.ReadBytes('\n')
+		buf.Write(line)
+		if err != nil {
+			return int64(buf.Len()), parseError(string(firstToken(line)), buf.Len(), ``, err)
+		}
 
-	// next token is everything from the size to possible CAS value.
-	sizeStr, err := rdr.ReadString('\r')
-	if err != nil {
-		return fmt.Errorf("memdproto.GetReply: expected size %w", err)
-	}
-	sizeStr = sizeStr[:len(sizeStr)-1]
+		if !bytes.HasPrefix(line, valueReplyPrefix) {
+			// either END, or something that UnmarshalText will complain about
+			break
+		}
 
-	// next ReadRune should be '\n'
-	r, _, err := rdr.ReadRune()
-	if err != nil {
-		return fmt.Errorf("memdproto.GetReply: expected newline after size %w", err)
-	}
-	if r != '\n' {
-		return errors.New("memdproto.GetReply: expected newline after size")
-	}
+		// VALUE <key> <flags> <bytes> [<cas unique>]
+		fields := bytes.Split(bytes.TrimRight(line, "\r\n"), space)
+		if len(fields) < 4 {
+			break
+		}
+		size, err := strconv.ParseUint(string(fields[3]), 10, 31)
+		if err != nil {
+			break
+		}
 
-	var casStr string
-	if i := strings.IndexRune(sizeStr, ' '); i > 0 {
-		casStr = sizeStr[i+1:]
-		sizeStr = sizeStr[:i]
+		// data block, followed by CRLF
+		n, err := io.CopyN(&buf, brdr, int64(size)+2)
+		if err != nil {
+			return int64(buf.Len()), parseError(`VALUE`, buf.Len(), ``, fmt.Errorf(`failed to read data block (read %d bytes): %w`, n, err))
+		}
 	}
 
-	size, err := strconv.ParseUint(sizeStr, 10, 64)
-	if err != nil {
-		return fmt.Errorf("memdproto.GetReply: expected numeric size: %w", err)
+	return int64(buf.Len()), reply.UnmarshalText(buf.Bytes())
+}
+
+// UnmarshalText parses a complete reply to a get(s) command, i.e. zero
+// or more VALUE items followed by END.
+//
+// It is safe to call this method concurrently with other methods on this object.
+func (reply *GetReply) UnmarshalText(data []byte) error {
+	reply.mu.Lock()
+	defer reply.mu.Unlock()
+
+	reply.items = nil
+
+	ldata := len(data)
+	// offset returns the position of the current head of data
+	// relative to the beginning of the original input
+	offset := func() int {
+		return ldata - len(data)
 	}
 
-	if casStr != "" {
-		cas, err := strconv.ParseUint(casStr, 10, 64)
+	for {
+		i := bytes.Index(data, crlf)
+		if i < 0 {
+			return parseError(`VALUE`, offset(), ``, ErrUnexpectedEOL)
+		}
+		line := data[:i]
+
+		if bytes.Equal(line, end) {
+			data = data[i+2:]
+			break
+		}
+
+		if !bytes.HasPrefix(line, valueReplyPrefix) {
+			return parseErrorf(``, offset(), string(firstToken(line)), `expected VALUE or END`)
+		}
+
+		// VALUE <key> <flags> <bytes> [<cas unique>]
+		fields := bytes.Split(line[len(valueReplyPrefix):], space)
+		if len(fields) != 3 && len(fields) != 4 {
+			return parseErrorf(`VALUE`, offset(), ``, `expected 3 or 4 fields, got %d`, len(fields))
+		}
+
+		flags, err := strconv.ParseUint(string(fields[1]), 10, 32)
 		if err != nil {
-			return fmt.Errorf("memdproto.GetReply: expected numeric cas: %w", err)
+			return parseError(`VALUE`, offset(), string(fields[1]), fmt.Errorf(`expected numeric flags: %w`, err))
 		}
-	}
 
+		size, err := strconv.ParseUint(string(fields[2]), 10, 31)
+		if err != nil {
+			return parseError(`VALUE`, offset(), string(fields[2]), fmt.Errorf(`expected numeric size: %w`, err))
+		}
 
-}
-*/
+		item := NewGetReplyItem(string(fields[0]), nil).SetFlags(uint32(flags))
+		if len(fields) == 4 {
+			cas, err := strconv.ParseUint(string(fields[3]), 10, 64)
+			if err != nil {
+				return parseError(`VALUE`, offset(), string(fields[3]), fmt.Errorf(`expected numeric cas: %w`, err))
+			}
+			item.SetCas(cas)
+		}
+		data = data[i+2:]
 
-func (reply *GetReply) UnmarshalText(data []byte) error {
-	return errors.New("not implemented")
+		if uint64(len(data)) < size+2 {
+			return parseError(`VALUE`, offset(), ``, fmt.Errorf(`expected %d bytes of data: %w`, size, ErrUnexpectedEOL))
+		}
+		item.value = data[:size]
+		data = data[size:]
+		if !bytes.HasPrefix(data, crlf) {
+			return parseErrorf(`VALUE`, offset(), ``, `expected CRLF after data block`)
+		}
+		data = data[2:]
+
+		reply.items = append(reply.items, item)
+	}
+
+	if len(data) != 0 {
+		return parseErrorf(`END`, offset(), ``, `trailing data`)
+	}
+	return nil
 }
@@ -0,0 +1,251 @@
+package memdproto
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// arithmeticCmd is the common implementation of the classic
+// incr and decr commands
+type arithmeticCmd struct {
+	cmdName string
+	key     string
+	delta   uint64
+	noreply bool
+}
+
+func (cmd *arithmeticCmd) Key() string {
+	return cmd.key
+}
+
+func (cmd *arithmeticCmd) Verb() string {
+	return cmd.cmdName
+}
+
+func (cmd *arithmeticCmd) Keys() []string {
+	return []string{cmd.key}
+}
+
+func (cmd *arithmeticCmd) NoReply() bool {
+	return cmd.noreply
+}
+
+func (cmd *arithmeticCmd) NewReply() Reply {
+	return NewArithmeticCmdReply()
+}
+
+// Delta returns the amount to increment or decrement by
+func (cmd *arithmeticCmd) Delta() uint64 {
+	return cmd.delta
+}
+
+func (cmd *arithmeticCmd) SetDelta(delta uint64) *arithmeticCmd {
+	cmd.delta = delta
+	return cmd
+}
+
+func (cmd *arithmeticCmd) SetNoReply(noreply bool) *arithmeticCmd {
+	cmd.noreply = noreply
+	return cmd
+}
+
+func (cmd *arithmeticCmd) WriteTo(dst io.Writer) (int64, error) {
+	var written int64
+
+	n, err := fmt.Fprintf(dst, "%s %s %d", cmd.cmdName, cmd.key, cmd.delta)
+	written += int64(n)
+	if err != nil {
+		return written, err
+	}
+
+	if cmd.noreply {
+		n, err := fmt.Fprintf(dst, " noreply")
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+
+	n, err = dst.Write(crlf)
+	written += int64(n)
+	return written, err
+}
+
+func (cmd *arithmeticCmd) UnmarshalText(data []byte) error {
+	ldata := len(data)
+	verb := string(firstToken(data))
+	switch verb {
+	case "incr", "decr":
+		if cmd.cmdName != "" && cmd.cmdName != verb {
+			return parseErrorf(``, 0, verb, `expected %s command`, cmd.cmdName)
+		}
+	default:
+		return parseErrorf(``, 0, verb, `invalid arithmetic command`)
+	}
+
+	if !bytes.HasSuffix(data, crlf) {
+		return parseErrorf(verb, ldata, ``, `expected CRLF`)
+	}
+	data = data[len(verb) : ldata-2]
+
+	if len(data) == 0 || data[0] != ' ' {
+		return parseErrorf(verb, len(verb), ``, `missing space after command`)
+	}
+
+	var noreply bool
+	fields := bytes.Split(data[1:], space)
+	switch {
+	case len(fields) < 2:
+		return parseError(verb, ldata-2, ``, fmt.Errorf(`missing delta: %w`, ErrUnexpectedEOL))
+	case len(fields) == 2:
+	case len(fields) == 3 && bytes.Equal(fields[2], noreplyToken):
+		noreply = true
+	default:
+		return parseErrorf(verb, len(verb)+1+len(fields[0])+1+len(fields[1])+1, string(fields[2]), `expected noreply`)
+	}
+
+	key, count, err := readBytes(fields[0], 250)
+	if err == nil && count != len(fields[0]) {
+		err = fmt.Errorf(`unexpected character %q`, fields[0][count])
+	}
+	if err != nil {
+		return parseError(verb, len(verb)+1, string(fields[0]), fmt.Errorf(`invalid key: %w`, err))
+	}
+
+	delta, err := strconv.ParseUint(string(fields[1]), 10, 64)
+	if err != nil {
+		return parseError(verb, len(verb)+1+len(fields[0])+1, string(fields[1]), fmt.Errorf(`invalid delta: %w`, err))
+	}
+
+	*cmd = arithmeticCmd{
+		cmdName: verb,
+		key:     string(key),
+		delta:   delta,
+		noreply: noreply,
+	}
+	return nil
+}
+
+// IncrCmd represents the classic incr command
+type IncrCmd struct {
+	arithmeticCmd
+}
+
+var _ Cmd = (*IncrCmd)(nil)
+
+func NewIncrCmd(key string, delta uint64) *IncrCmd {
+	return &IncrCmd{
+		arithmeticCmd: arithmeticCmd{
+			cmdName: "incr",
+			key:     key,
+			delta:   delta,
+		},
+	}
+}
+
+// DecrCmd represents the classic decr command
+type DecrCmd struct {
+	arithmeticCmd
+}
+
+var _ Cmd = (*DecrCmd)(nil)
+
+func NewDecrCmd(key string, delta uint64) *DecrCmd {
+	return &DecrCmd{
+		arithmeticCmd: arithmeticCmd{
+			cmdName: "decr",
+			key:     key,
+			delta:   delta,
+		},
+	}
+}
+
+var arithmeticCmdReplyNotFound = []byte("NOT_FOUND")
+
+// ArithmeticCmdReply represents the reply to a classic incr or decr
+// command, which is either the new value of the item, or NOT_FOUND
+type ArithmeticCmdReply struct {
+	found bool
+	value uint64
+}
+
+var _ Reply = (*ArithmeticCmdReply)(nil)
+
+func NewArithmeticCmdReply() *ArithmeticCmdReply {
+	return &ArithmeticCmdReply{}
+}
+
+// Found returns false if the reply was NOT_FOUND
+func (reply *ArithmeticCmdReply) Found() bool {
+	return reply.found
+}
+
+// Value returns the value of the item after the operation
+func (reply *ArithmeticCmdReply) Value() uint64 {
+	return reply.value
+}
+
+// SetValue sets the value of the item, and marks it as found
+func (reply *ArithmeticCmdReply) SetValue(v uint64) *ArithmeticCmdReply {
+	reply.found = true
+	reply.value = v
+	return reply
+}
+
+// SetNotFound makes the reply NOT_FOUND
+func (reply *ArithmeticCmdReply) SetNotFound() *ArithmeticCmdReply {
+	reply.found = false
+	reply.value = 0
+	return reply
+}
+
+func (reply *ArithmeticCmdReply) WriteTo(dst io.Writer) (int64, error) {
+	var written int64
+	if reply.found {
+		n, err := fmt.Fprintf(dst, "%d", reply.value)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	} else {
+		n, err := dst.Write(arithmeticCmdReplyNotFound)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+
+	n, err := dst.Write(crlf)
+	written += int64(n)
+	return written, err
+}
+
+func (reply *ArithmeticCmdReply) ReadFrom(src io.Reader) (int64, error) {
+	line, err := bufferedReader(src).ReadBytes('\n')
+	if err != nil {
+		return int64(len(line)), parseError(string(firstToken(line)), len(line), ``, err)
+	}
+	return int64(len(line)), reply.UnmarshalText(line)
+}
+
+func (reply *ArithmeticCmdReply) UnmarshalText(data []byte) error {
+	ldata := len(data)
+	if ldata < 2 || !bytes.Equal(data[ldata-2:], crlf) {
+		return parseErrorf(string(firstToken(data)), ldata, ``, `expected CRLF`)
+	}
+	data = data[:ldata-2]
+
+	if bytes.Equal(data, arithmeticCmdReplyNotFound) {
+		reply.SetNotFound()
+		return nil
+	}
+
+	v, err := strconv.ParseUint(string(data), 10, 64)
+	if err != nil {
+		return parseError(``, 0, string(firstToken(data)), fmt.Errorf(`invalid arithmetic command reply: %w`, err))
+	}
+	reply.SetValue(v)
+	return nil
+}
@@ -0,0 +1,684 @@
+package memdproto
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// MetaArithmeticCmd represents the memcached meta arithmetic command.
+type MetaArithmeticCmd struct {
+	key          string
+	b64          *FlagKeyAsBase64
+	cas          *FlagRetrieveCas
+	ccas         *FlagCompareCas
+	ncas         *FlagNewCas
+	vivify       *FlagVivifyOnMiss
+	initial      *FlagInitialValue
+	delta        *FlagDelta
+	updateTTL    *FlagUpdateTTL
+	mode         *MetaArithmeticMode
+	opaque       FlagOpaque
+	noreply      *FlagNoReply
+	remainingTTL *FlagRetrieveRemainingTTL
+	value        *FlagRetrieveValue
+	rkey         *FlagRetrieveKey
+}
+
+var _ Cmd = (*MetaArithmeticCmd)(nil)
+
+func NewMetaArithmeticCmd(key string) *MetaArithmeticCmd {
+	return &MetaArithmeticCmd{
+		key: key,
+	}
+}
+
+func (cmd *MetaArithmeticCmd) Key() string {
+	return cmd.key
+}
+
+func (cmd *MetaArithmeticCmd) Verb() string {
+	return "ma"
+}
+
+func (cmd *MetaArithmeticCmd) Keys() []string {
+	return []string{cmd.key}
+}
+
+func (cmd *MetaArithmeticCmd) NoReply() bool {
+	return cmd.noreply != nil
+}
+
+func (cmd *MetaArithmeticCmd) NewReply() Reply {
+	return NewMetaArithmeticReply()
+}
+
+func (cmd *MetaArithmeticCmd) SetKeyAsBase64(b bool) *MetaArithmeticCmd {
+	if b {
+		cmd.b64 = &FlagKeyAsBase64{}
+	} else {
+		cmd.b64 = nil
+	}
+	return cmd
+}
+
+func (cmd *MetaArithmeticCmd) SetRetrieveCas(b bool) *MetaArithmeticCmd {
+	if b {
+		cmd.cas = new(FlagRetrieveCas)
+	} else {
+		cmd.cas = nil
+	}
+	return cmd
+}
+
+func (cmd *MetaArithmeticCmd) SetCompareCas(cas uint64) *MetaArithmeticCmd {
+	v := FlagCompareCas(cas)
+	cmd.ccas = &v
+	return cmd
+}
+
+// SetNewCas makes the server use cas as the new CAS value of the item,
+// if the item is modified ("E" flag)
+func (cmd *MetaArithmeticCmd) SetNewCas(cas uint64) *MetaArithmeticCmd {
+	v := FlagNewCas(cas)
+	cmd.ncas = &v
+	return cmd
+}
+
+func (cmd *MetaArithmeticCmd) SetVivifyOnMiss(ttl uint64) *MetaArithmeticCmd {
+	v := FlagVivifyOnMiss(ttl)
+	cmd.vivify = &v
+	return cmd
+}
+
+func (cmd *MetaArithmeticCmd) SetInitialValue(initial uint64) *MetaArithmeticCmd {
+	v := FlagInitialValue(initial)
+	cmd.initial = &v
+	return cmd
+}
+
+func (cmd *MetaArithmeticCmd) SetDelta(delta uint64) *MetaArithmeticCmd {
+	v := FlagDelta(delta)
+	cmd.delta = &v
+	return cmd
+}
+
+func (cmd *MetaArithmeticCmd) SetUpdateTTL(ttl int64) *MetaArithmeticCmd {
+	v := FlagUpdateTTL(ttl)
+	cmd.updateTTL = &v
+	return cmd
+}
+
+func (cmd *MetaArithmeticCmd) SetMode(mode MetaArithmeticMode) *MetaArithmeticCmd {
+	cmd.mode = &mode
+	return cmd
+}
+
+// RetrieveKey returns true if the command requests the key to be
+// returned in the reply ("k" flag)
+func (cmd *MetaArithmeticCmd) RetrieveKey() bool {
+	return cmd.rkey != nil
+}
+
+// Opaque returns the opaque value ("O" flag) associated with the command
+func (cmd *MetaArithmeticCmd) Opaque() []byte {
+	return cmd.opaque
+}
+
+func (cmd *MetaArithmeticCmd) SetOpaque(o []byte) *MetaArithmeticCmd {
+	cmd.opaque = FlagOpaque(o)
+	return cmd
+}
+
+func (cmd *MetaArithmeticCmd) SetNoReply(b bool) *MetaArithmeticCmd {
+	if b {
+		cmd.noreply = new(FlagNoReply)
+	} else {
+		cmd.noreply = nil
+	}
+	return cmd
+}
+
+func (cmd *MetaArithmeticCmd) SetRetrieveRemainingTTL(b bool) *MetaArithmeticCmd {
+	if b {
+		cmd.remainingTTL = new(FlagRetrieveRemainingTTL)
+	} else {
+		cmd.remainingTTL = nil
+	}
+	return cmd
+}
+
+func (cmd *MetaArithmeticCmd) SetRetrieveValue(b bool) *MetaArithmeticCmd {
+	if b {
+		cmd.value = new(FlagRetrieveValue)
+	} else {
+		cmd.value = nil
+	}
+	return cmd
+}
+
+func (cmd *MetaArithmeticCmd) SetRetrieveKey(b bool) *MetaArithmeticCmd {
+	if b {
+		cmd.rkey = new(FlagRetrieveKey)
+	} else {
+		cmd.rkey = nil
+	}
+	return cmd
+}
+
+// KeyAsBase64 returns true if the key is sent base64 encoded ("b" flag)
+func (cmd *MetaArithmeticCmd) KeyAsBase64() bool {
+	return cmd.b64 != nil
+}
+
+// RetrieveCas returns true if the CAS value is requested ("c" flag)
+func (cmd *MetaArithmeticCmd) RetrieveCas() bool {
+	return cmd.cas != nil
+}
+
+// CompareCas returns the CAS value to compare against ("C" flag), if any
+func (cmd *MetaArithmeticCmd) CompareCas() (uint64, bool) {
+	if cmd.ccas == nil {
+		return 0, false
+	}
+	return uint64(*cmd.ccas), true
+}
+
+// NewCas returns the CAS value to be used if the item is modified
+// ("E" flag), if any
+func (cmd *MetaArithmeticCmd) NewCas() (uint64, bool) {
+	if cmd.ncas == nil {
+		return 0, false
+	}
+	return uint64(*cmd.ncas), true
+}
+
+// VivifyOnMiss returns the TTL of the item to be created on a miss
+// ("N" flag), if any
+func (cmd *MetaArithmeticCmd) VivifyOnMiss() (uint64, bool) {
+	if cmd.vivify == nil {
+		return 0, false
+	}
+	return uint64(*cmd.vivify), true
+}
+
+// InitialValue returns the value of the item to be created on a miss
+// ("J" flag). It defaults to 0.
+func (cmd *MetaArithmeticCmd) InitialValue() uint64 {
+	if cmd.initial == nil {
+		return 0
+	}
+	return uint64(*cmd.initial)
+}
+
+// Delta returns the amount to add or subtract ("D" flag). It defaults to 1.
+func (cmd *MetaArithmeticCmd) Delta() uint64 {
+	if cmd.delta == nil {
+		return 1
+	}
+	return uint64(*cmd.delta)
+}
+
+// UpdateTTL returns the new TTL of the item ("T" flag), if any
+func (cmd *MetaArithmeticCmd) UpdateTTL() (int64, bool) {
+	if cmd.updateTTL == nil {
+		return 0, false
+	}
+	return int64(*cmd.updateTTL), true
+}
+
+// Mode returns the mode of the command ("M" flag). MetaArithmeticModeIncr
+// is returned if the mode was not specified.
+func (cmd *MetaArithmeticCmd) Mode() MetaArithmeticMode {
+	if cmd.mode == nil {
+		return MetaArithmeticModeIncr
+	}
+	return *cmd.mode
+}
+
+// RetrieveRemainingTTL returns true if the remaining TTL of the item
+// is requested ("t" flag)
+func (cmd *MetaArithmeticCmd) RetrieveRemainingTTL() bool {
+	return cmd.remainingTTL != nil
+}
+
+// RetrieveValue returns true if the new value of the item is
+// requested ("v" flag)
+func (cmd *MetaArithmeticCmd) RetrieveValue() bool {
+	return cmd.value != nil
+}
+
+func (cmd *MetaArithmeticCmd) WriteTo(dst io.Writer) (int64, error) {
+	var written int64
+
+	var key string
+	if cmd.b64 != nil {
+		key = base64.StdEncoding.EncodeToString([]byte(cmd.key))
+	} else {
+		key = cmd.key
+	}
+	n, err := fmt.Fprintf(dst, "ma %s", key)
+	written += int64(n)
+	if err != nil {
+		return written, err
+	}
+
+	n64, err := writeFlags(dst, cmd.b64, cmd.cas, cmd.ccas, cmd.delta, cmd.ncas, cmd.initial, cmd.rkey, cmd.mode, cmd.vivify, cmd.opaque, cmd.noreply, cmd.remainingTTL, cmd.updateTTL, cmd.value)
+	written += n64
+	if err != nil {
+		return written, err
+	}
+
+	n, err = dst.Write(crlf)
+	written += int64(n)
+	return written, err
+}
+
+var metaarithmeticCmd = []byte{'m', 'a'}
+
+func (cmd *MetaArithmeticCmd) UnmarshalText(data []byte) error {
+	*cmd = MetaArithmeticCmd{}
+
+	ldata := len(data)
+	// offset returns the position of the current head of data
+	// relative to the beginning of the original input
+	offset := func() int {
+		return ldata - len(data)
+	}
+
+	if ldata < 2 || !bytes.Equal(data[:2], metaarithmeticCmd) {
+		return parseErrorf(`ma`, 0, ``, `invalid ma command`)
+	}
+
+	data = data[2:]
+	if len(data) == 0 || data[0] != ' ' {
+		return parseErrorf(`ma`, offset(), ``, `expected space after ma command`)
+	}
+	data = data[1:]
+
+	keyb, count, err := readBytes(data, 250)
+	if err != nil {
+		return parseError(`ma`, offset(), string(keyb), fmt.Errorf(`invalid key: %w`, err))
+	}
+	data = data[count:]
+	cmd.key = string(keyb)
+
+	for len(data) > 0 {
+		if data[0] == ' ' {
+			data = data[1:]
+			continue
+		}
+		if bytes.Equal(data, crlf) {
+			break
+		}
+
+		flag := data[0]
+		switch flag {
+		case 'b', 'c', 'k', 'q', 't', 'v':
+			if !isSuffixedWithSpaceOrEOL(data) {
+				return parseErrorf(`ma`, offset(), string(flag), `extra characters following ma flag %c`, flag)
+			}
+			data = data[1:]
+		}
+
+		switch flag {
+		case 'b':
+			cmd.b64 = &FlagKeyAsBase64{}
+		case 'c':
+			cmd.cas = new(FlagRetrieveCas)
+		case 'k':
+			cmd.rkey = new(FlagRetrieveKey)
+		case 'q':
+			cmd.noreply = new(FlagNoReply)
+		case 't':
+			cmd.remainingTTL = new(FlagRetrieveRemainingTTL)
+		case 'v':
+			cmd.value = new(FlagRetrieveValue)
+		case 'C', 'E', 'N', 'J', 'D':
+			data = data[1:]
+			u64, count, err := readU64(data)
+			if err != nil {
+				return parseError(`ma`, offset(), string(flag), err)
+			}
+			data = data[count:]
+			switch flag {
+			case 'C':
+				v := FlagCompareCas(u64)
+				cmd.ccas = &v
+			case 'E':
+				v := FlagNewCas(u64)
+				cmd.ncas = &v
+			case 'N':
+				v := FlagVivifyOnMiss(u64)
+				cmd.vivify = &v
+			case 'J':
+				v := FlagInitialValue(u64)
+				cmd.initial = &v
+			case 'D':
+				v := FlagDelta(u64)
+				cmd.delta = &v
+			}
+		case 'T':
+			data = data[1:]
+			i64, count, err := readI64(data)
+			if err != nil {
+				return parseError(`ma`, offset(), `T`, err)
+			}
+			data = data[count:]
+			v := FlagUpdateTTL(i64)
+			cmd.updateTTL = &v
+		case 'M':
+			data = data[1:]
+			mode, count, err := readBytes(data, 4)
+			if err != nil {
+				return parseError(`ma`, offset(), `M`, err)
+			}
+			var m MetaArithmeticMode
+			switch string(mode) {
+			case "I", "i", "+", "incr":
+				m = MetaArithmeticModeIncr
+			case "D", "d", "-", "decr":
+				m = MetaArithmeticModeDecr
+			default:
+				return parseErrorf(`ma`, offset(), `M`, `invalid mode %q`, mode)
+			}
+			cmd.mode = &m
+			data = data[count:]
+		case 'O':
+			data = data[1:]
+			b, count, err := readBytes(data, 32)
+			if err != nil {
+				return parseError(`ma`, offset(), `O`, err)
+			}
+			data = data[count:]
+			cmd.opaque = FlagOpaque(b)
+		default:
+			return parseError(`ma`, offset(), string(flag), ErrUnknownFlag)
+		}
+	}
+
+	if cmd.b64 != nil {
+		decoded, err := base64.StdEncoding.DecodeString(cmd.key)
+		if err != nil {
+			return parseError(`ma`, 3, cmd.key, fmt.Errorf(`failed to decode base64 key: %w`, err))
+		}
+		cmd.key = string(decoded)
+	}
+	return nil
+}
+
+type MetaArithmeticCmdStatus uint8
+
+const (
+	MetaArithmeticCmdStatusInvalid MetaArithmeticCmdStatus = iota
+	// Operation was successful (command=HD, or VA if the value was requested)
+	MetaArithmeticCmdStatusSuccess
+	// Item was not found (command=NF)
+	MetaArithmeticCmdStatusNotFound
+	// Item was not stored, e.g. because it was not a number (command=NS)
+	MetaArithmeticCmdStatusNotStored
+	// Under CAS semantics, item has been modified since your
+	// last fetch (command=EX)
+	MetaArithmeticCmdStatusExists
+)
+
+type MetaArithmeticReply struct {
+	status       MetaArithmeticCmdStatus
+	value        []byte
+	b64          *FlagKeyAsBase64
+	cas          *FlagRetrieveCas
+	rkey         *FlagRetrieveKey
+	opaque       FlagOpaque
+	remainingTTL *FlagRetrieveRemainingTTL
+}
+
+var _ Reply = (*MetaArithmeticReply)(nil)
+
+func NewMetaArithmeticReply() *MetaArithmeticReply {
+	return &MetaArithmeticReply{}
+}
+
+func (reply *MetaArithmeticReply) Status() MetaArithmeticCmdStatus {
+	return reply.status
+}
+
+func (reply *MetaArithmeticReply) SetStatus(status MetaArithmeticCmdStatus) *MetaArithmeticReply {
+	reply.status = status
+	return reply
+}
+
+// Value returns the value of the item after the operation, if the
+// value was requested using the "v" flag. It is returned in its
+// textual form, e.g. "123"
+func (reply *MetaArithmeticReply) Value() []byte {
+	return reply.value
+}
+
+func (reply *MetaArithmeticReply) SetValue(v []byte) *MetaArithmeticReply {
+	reply.value = v
+	return reply
+}
+
+// Number returns the value returned by the server as a number.
+func (reply *MetaArithmeticReply) Number() (uint64, error) {
+	return strconv.ParseUint(string(reply.value), 10, 64)
+}
+
+func (reply *MetaArithmeticReply) SetCas(v uint64) *MetaArithmeticReply {
+	f := FlagRetrieveCas(v)
+	reply.cas = &f
+	return reply
+}
+
+// Key returns the value associated with the key flag ("k") in the response.
+//
+// If the base64 flag is toggled, the key is base64 decoded before being returned.
+func (reply *MetaArithmeticReply) Key() string {
+	return replyKey(reply.rkey, reply.b64)
+}
+
+// SetKey sets the key to be returned with the response using the key flag ("k").
+// If b64 is true, the key is base64 encoded and the base64 flag ("b") is set.
+func (reply *MetaArithmeticReply) SetKey(s string, b64 bool) *MetaArithmeticReply {
+	reply.rkey, reply.b64 = replyKeyFlags(s, b64)
+	return reply
+}
+
+// Opaque returns the opaque value ("O" flag) echoed back in the reply
+func (reply *MetaArithmeticReply) Opaque() []byte {
+	return reply.opaque
+}
+
+func (reply *MetaArithmeticReply) SetOpaque(o []byte) *MetaArithmeticReply {
+	reply.opaque = FlagOpaque(o)
+	return reply
+}
+
+func (reply *MetaArithmeticReply) SetRemainingTTL(v int64) *MetaArithmeticReply {
+	reply.remainingTTL = &FlagRetrieveRemainingTTL{value: &v}
+	return reply
+}
+
+func (reply *MetaArithmeticReply) WriteTo(dst io.Writer) (int64, error) {
+	var written int64
+
+	withValue := reply.status == MetaArithmeticCmdStatusSuccess && reply.value != nil
+	switch reply.status {
+	case MetaArithmeticCmdStatusSuccess:
+		if withValue {
+			n, err := fmt.Fprintf(dst, "VA %d", len(reply.value))
+			written += int64(n)
+			if err != nil {
+				return written, err
+			}
+		} else {
+			n, err := dst.Write([]byte("HD"))
+			written += int64(n)
+			if err != nil {
+				return written, err
+			}
+		}
+	case MetaArithmeticCmdStatusNotFound:
+		n, err := dst.Write([]byte("NF"))
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	case MetaArithmeticCmdStatusNotStored:
+		n, err := dst.Write([]byte("NS"))
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	case MetaArithmeticCmdStatusExists:
+		n, err := dst.Write([]byte("EX"))
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	default:
+		return 0, fmt.Errorf(`invalid ma reply status %d`, reply.status)
+	}
+
+	n64, err := writeFlags(dst, reply.b64, replyNumberFlag('c', reply.cas), reply.rkey, reply.opaque, reply.remainingTTL)
+	written += n64
+	if err != nil {
+		return written, err
+	}
+
+	n, err := dst.Write(crlf)
+	written += int64(n)
+	if err != nil {
+		return written, err
+	}
+
+	if withValue {
+		n, err := dst.Write(reply.value)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+		n, err = dst.Write(crlf)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+func (reply *MetaArithmeticReply) ReadFrom(src io.Reader) (int64, error) {
+	brdr := bufferedReader(src)
+	line, err := brdr.ReadBytes('\n')
+	lline := len(line)
+	nread := int64(lline)
+	if err != nil {
+		return nread, parseError(`ma`, lline, ``, fmt.Errorf(`failed to read reply: %w`, err))
+	}
+
+	if lline < 4 || line[lline-2] != '\r' {
+		return nread, parseErrorf(`ma`, lline, ``, `expected CRLF at end of line`)
+	}
+	line = line[:lline-2]
+
+	code := string(line[:2])
+	rest := line[2:]
+	base := 2
+	switch code {
+	case "HD":
+		reply.status = MetaArithmeticCmdStatusSuccess
+	case "NF":
+		reply.status = MetaArithmeticCmdStatusNotFound
+	case "NS":
+		reply.status = MetaArithmeticCmdStatusNotStored
+	case "EX":
+		reply.status = MetaArithmeticCmdStatusExists
+	case "VA":
+		reply.status = MetaArithmeticCmdStatusSuccess
+		if len(rest) < 2 || rest[0] != ' ' {
+			return nread, parseErrorf(`VA`, 2, ``, `expected size`)
+		}
+		rb := readbuf{data: rest[1:]}
+		size := rb.ReadToken()
+		sz, err := strconv.ParseUint(size, 10, 31)
+		if err != nil {
+			return nread, parseError(`VA`, 3, size, fmt.Errorf(`failed to parse size: %w`, err))
+		}
+		rest = rb.data
+		base = 3 + rb.NRead()
+
+		buf, n, err := readValue(brdr, int64(sz)+2)
+		nread += int64(n)
+		if err != nil {
+			return nread, parseError(`VA`, int(nread), ``, fmt.Errorf(`failed to read value: %w`, err))
+		}
+		if !bytes.Equal(buf[sz:], crlf) {
+			return nread, parseErrorf(`VA`, int(nread)-2, ``, `expected CRLF after value`)
+		}
+		reply.value = buf[:sz]
+	default:
+		if bytes.HasPrefix(line, []byte("CLIENT_ERROR ")) {
+			return nread, parseErrorf(`CLIENT_ERROR`, 13, ``, `client error: %s`, line[13:])
+		}
+		return nread, parseErrorf(``, 0, string(firstToken(line)), `unexpected response for ma command`)
+	}
+
+	if err := reply.readFlags(code, rest, base); err != nil {
+		return nread, err
+	}
+	return nread, nil
+}
+
+func (reply *MetaArithmeticReply) readFlags(verb string, data []byte, base int) error {
+	rb := readbuf{data: data}
+	for rb.Len() > 0 {
+		if rb.data[0] == ' ' {
+			rb.Advance()
+			continue
+		}
+
+		pos := base + rb.NRead()
+		flag := rb.data[0]
+		rb.Advance()
+		switch flag {
+		case 'b':
+			reply.b64 = &FlagKeyAsBase64{}
+		case 'c':
+			s := rb.ReadToken()
+			u64, err := strconv.ParseUint(s, 10, 64)
+			if err != nil {
+				return parseError(verb, pos, `c`, fmt.Errorf(`failed to parse cas: %w`, err))
+			}
+			reply.SetCas(u64)
+		case 'k':
+			key := rb.ReadToken()
+			if key == "" {
+				return parseErrorf(verb, pos, `k`, `expected value after k flag`)
+			}
+			reply.rkey = &FlagRetrieveKey{key: &key}
+		case 'O':
+			val := rb.ReadTokenBytes()
+			if len(val) == 0 {
+				return parseErrorf(verb, pos, `O`, `expected value after O flag`)
+			}
+			reply.opaque = FlagOpaque(val)
+		case 't':
+			s := rb.ReadToken()
+			i64, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				return parseError(verb, pos, `t`, fmt.Errorf(`failed to parse remaining ttl: %w`, err))
+			}
+			reply.SetRemainingTTL(i64)
+		default:
+			return parseError(verb, pos, string(flag), ErrUnknownFlag)
+		}
+	}
+	return nil
+}
+
+func (reply *MetaArithmeticReply) UnmarshalText(data []byte) error {
+	*reply = MetaArithmeticReply{}
+	return unmarshalReply(`ma`, reply, data)
+}
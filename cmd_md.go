@@ -1,18 +1,17 @@
 package memdproto
 
 import (
-	"bufio"
 	"bytes"
 	"encoding/base64"
 	"fmt"
 	"io"
-	"strings"
 )
 
 type MetaDeleteCmd struct {
 	key        string
 	b64        *FlagKeyAsBase64
 	ccas       *FlagCompareCas
+	ncas       *FlagNewCas
 	rkey       *FlagRetrieveKey
 	invalidate *FlagInvalidateOnOldCas
 	opaque     FlagOpaque
@@ -20,6 +19,8 @@ type MetaDeleteCmd struct {
 	ttl        *FlagUpdateTTL
 }
 
+var _ Cmd = (*MetaDeleteCmd)(nil)
+
 func NewMetaDeleteCmd(key string) *MetaDeleteCmd {
 	return &MetaDeleteCmd{
 		key: key,
@@ -30,6 +31,22 @@ func (cmd *MetaDeleteCmd) Key() string {
 	return cmd.key
 }
 
+func (cmd *MetaDeleteCmd) Verb() string {
+	return "md"
+}
+
+func (cmd *MetaDeleteCmd) Keys() []string {
+	return []string{cmd.key}
+}
+
+func (cmd *MetaDeleteCmd) NoReply() bool {
+	return cmd.noreply != nil
+}
+
+func (cmd *MetaDeleteCmd) NewReply() Reply {
+	return NewMetaDeleteReply()
+}
+
 func (cmd *MetaDeleteCmd) SetKeyAsBase64(b64 bool) *MetaDeleteCmd {
 	if b64 {
 		cmd.b64 = &FlagKeyAsBase64{}
@@ -45,6 +62,23 @@ func (cmd *MetaDeleteCmd) SetCompareCas(cas uint64) *MetaDeleteCmd {
 	return cmd
 }
 
+// SetNewCas makes the server use cas as the new CAS value of the item,
+// if the item is modified ("E" flag)
+func (cmd *MetaDeleteCmd) SetNewCas(cas uint64) *MetaDeleteCmd {
+	v := FlagNewCas(cas)
+	cmd.ncas = &v
+	return cmd
+}
+
+func (cmd *MetaDeleteCmd) SetRetrieveKey(v bool) *MetaDeleteCmd {
+	if v {
+		cmd.rkey = &FlagRetrieveKey{}
+	} else {
+		cmd.rkey = nil
+	}
+	return cmd
+}
+
 func (cmd *MetaDeleteCmd) SetInvalidateOnOldCas(v bool) *MetaDeleteCmd {
 	if v {
 		cmd.invalidate = &FlagInvalidateOnOldCas{}
@@ -54,6 +88,17 @@ func (cmd *MetaDeleteCmd) SetInvalidateOnOldCas(v bool) *MetaDeleteCmd {
 	return cmd
 }
 
+// RetrieveKey returns true if the command requests the key to be
+// returned in the reply ("k" flag)
+func (cmd *MetaDeleteCmd) RetrieveKey() bool {
+	return cmd.rkey != nil
+}
+
+// Opaque returns the opaque value ("O" flag) associated with the command
+func (cmd *MetaDeleteCmd) Opaque() []byte {
+	return cmd.opaque
+}
+
 func (cmd *MetaDeleteCmd) SetOpaque(opaque []byte) *MetaDeleteCmd {
 	cmd.opaque = FlagOpaque(opaque)
 	return cmd
@@ -74,6 +119,43 @@ func (cmd *MetaDeleteCmd) SetUpdateTTL(ttl uint32) *MetaDeleteCmd {
 	return cmd
 }
 
+// KeyAsBase64 returns true if the key is sent base64 encoded ("b" flag)
+func (cmd *MetaDeleteCmd) KeyAsBase64() bool {
+	return cmd.b64 != nil
+}
+
+// CompareCas returns the CAS value to compare against ("C" flag), if any
+func (cmd *MetaDeleteCmd) CompareCas() (uint64, bool) {
+	if cmd.ccas == nil {
+		return 0, false
+	}
+	return uint64(*cmd.ccas), true
+}
+
+// NewCas returns the CAS value to be used if the item is modified
+// ("E" flag), if any
+func (cmd *MetaDeleteCmd) NewCas() (uint64, bool) {
+	if cmd.ncas == nil {
+		return 0, false
+	}
+	return uint64(*cmd.ncas), true
+}
+
+// InvalidateOnOldCas returns true if the item should be marked as stale
+// instead of being removed ("I" flag)
+func (cmd *MetaDeleteCmd) InvalidateOnOldCas() bool {
+	return cmd.invalidate != nil
+}
+
+// UpdateTTL returns the new TTL of the item when it is
+// invalidated ("T" flag), if any
+func (cmd *MetaDeleteCmd) UpdateTTL() (uint32, bool) {
+	if cmd.ttl == nil {
+		return 0, false
+	}
+	return uint32(*cmd.ttl), true
+}
+
 func (cmd *MetaDeleteCmd) WriteTo(dst io.Writer) (int64, error) {
 	var written int64
 
@@ -90,7 +172,7 @@ func (cmd *MetaDeleteCmd) WriteTo(dst io.Writer) (int64, error) {
 	}
 	written += int64(n)
 
-	n64, err := writeFlags(dst, cmd.b64, cmd.ccas, cmd.invalidate, cmd.opaque, cmd.noreply, cmd.ttl)
+	n64, err := writeFlags(dst, cmd.b64, cmd.ccas, cmd.ncas, cmd.invalidate, cmd.rkey, cmd.opaque, cmd.noreply, cmd.ttl)
 	written += n64
 	if err != nil {
 		return written, err
@@ -104,6 +186,115 @@ func (cmd *MetaDeleteCmd) WriteTo(dst io.Writer) (int64, error) {
 	return written, nil
 }
 
+var metadeleteCmd = []byte{'m', 'd'}
+
+func (cmd *MetaDeleteCmd) UnmarshalText(data []byte) error {
+	*cmd = MetaDeleteCmd{}
+
+	ldata := len(data)
+	// offset returns the position of the current head of data
+	// relative to the beginning of the original input
+	offset := func() int {
+		return ldata - len(data)
+	}
+
+	if ldata < 2 || !bytes.Equal(data[:2], metadeleteCmd) {
+		return parseErrorf(`md`, 0, ``, `invalid md command`)
+	}
+
+	data = data[2:]
+	if len(data) == 0 || data[0] != ' ' {
+		return parseErrorf(`md`, offset(), ``, `expected space after md command`)
+	}
+	data = data[1:]
+
+	keyb, count, err := readBytes(data, 250)
+	if err != nil {
+		return parseError(`md`, offset(), string(keyb), fmt.Errorf(`invalid key: %w`, err))
+	}
+	data = data[count:]
+	cmd.key = string(keyb)
+
+	for len(data) > 0 {
+		if data[0] == ' ' {
+			data = data[1:]
+			continue
+		}
+		if bytes.Equal(data, crlf) {
+			break
+		}
+
+		flag := data[0]
+		switch flag {
+		case 'b', 'I', 'k', 'q':
+			if !isSuffixedWithSpaceOrEOL(data) {
+				return parseErrorf(`md`, offset(), string(flag), `extra characters following md flag %c`, flag)
+			}
+		}
+
+		switch flag {
+		case 'b':
+			cmd.b64 = &FlagKeyAsBase64{}
+			data = data[1:]
+		case 'I':
+			cmd.invalidate = &FlagInvalidateOnOldCas{}
+			data = data[1:]
+		case 'k':
+			cmd.rkey = &FlagRetrieveKey{}
+			data = data[1:]
+		case 'q':
+			cmd.noreply = &FlagNoReply{}
+			data = data[1:]
+		case 'C':
+			data = data[1:]
+			u64, count, err := readU64(data)
+			if err != nil {
+				return parseError(`md`, offset(), `C`, err)
+			}
+			data = data[count:]
+			v := FlagCompareCas(u64)
+			cmd.ccas = &v
+		case 'E':
+			data = data[1:]
+			u64, count, err := readU64(data)
+			if err != nil {
+				return parseError(`md`, offset(), `E`, fmt.Errorf(`failed to parse new cas: %w`, err))
+			}
+			data = data[count:]
+			v := FlagNewCas(u64)
+			cmd.ncas = &v
+		case 'O':
+			data = data[1:]
+			b, count, err := readBytes(data, 32)
+			if err != nil {
+				return parseError(`md`, offset(), `O`, err)
+			}
+			data = data[count:]
+			cmd.opaque = FlagOpaque(b)
+		case 'T':
+			data = data[1:]
+			i64, count, err := readI64(data)
+			if err != nil {
+				return parseError(`md`, offset(), `T`, err)
+			}
+			data = data[count:]
+			v := FlagUpdateTTL(i64)
+			cmd.ttl = &v
+		default:
+			return parseError(`md`, offset(), string(flag), ErrUnknownFlag)
+		}
+	}
+
+	if cmd.b64 != nil {
+		decoded, err := base64.StdEncoding.DecodeString(cmd.key)
+		if err != nil {
+			return parseError(`md`, 3, cmd.key, fmt.Errorf(`failed to decode base64 key: %w`, err))
+		}
+		cmd.key = string(decoded)
+	}
+	return nil
+}
+
 type MetaDeleteCmdStatus uint8
 
 const (
@@ -120,24 +311,104 @@ type MetaDeleteReply struct {
 	opaque FlagOpaque
 }
 
+var _ Reply = (*MetaDeleteReply)(nil)
+
+func NewMetaDeleteReply() *MetaDeleteReply {
+	return &MetaDeleteReply{}
+}
+
 func (reply *MetaDeleteReply) Status() MetaDeleteCmdStatus {
 	return reply.status
 }
 
+func (reply *MetaDeleteReply) SetStatus(status MetaDeleteCmdStatus) *MetaDeleteReply {
+	reply.status = status
+	return reply
+}
+
+// Key returns the value associated with the key flag ("k") in the response.
+//
+// If the base64 flag is toggled, the key is base64 decoded before being returned.
+func (reply *MetaDeleteReply) Key() string {
+	return replyKey(reply.rkey, reply.b64)
+}
+
+// SetKey sets the key to be returned with the response using the key flag ("k").
+// If b64 is true, the key is base64 encoded and the base64 flag ("b") is set.
+//
+// If s is an empty string, both the key flag ("k") and the base64 flag ("b")
+// will be cleared, regardless of the value of b64.
+func (reply *MetaDeleteReply) SetKey(s string, b64 bool) *MetaDeleteReply {
+	reply.rkey, reply.b64 = replyKeyFlags(s, b64)
+	return reply
+}
+
+// Opaque returns the opaque value ("O" flag) echoed back in the reply
+func (reply *MetaDeleteReply) Opaque() []byte {
+	return reply.opaque
+}
+
+func (reply *MetaDeleteReply) SetOpaque(o []byte) *MetaDeleteReply {
+	reply.opaque = FlagOpaque(o)
+	return reply
+}
+
+func (status MetaDeleteCmdStatus) code() ([]byte, error) {
+	switch status {
+	case MetaDeleteCmdStatusDeleted:
+		return []byte("HD"), nil
+	case MetaDeleteCmdStatusExists:
+		return []byte("EX"), nil
+	case MetaDeleteCmdStatusNotFound:
+		return []byte("NF"), nil
+	default:
+		return nil, fmt.Errorf(`invalid md reply status %d`, status)
+	}
+}
+
+func (reply *MetaDeleteReply) WriteTo(dst io.Writer) (int64, error) {
+	code, err := reply.status.code()
+	if err != nil {
+		return 0, err
+	}
+
+	var written int64
+	n, err := dst.Write(code)
+	written += int64(n)
+	if err != nil {
+		return written, err
+	}
+
+	n64, err := writeFlags(dst, reply.b64, reply.rkey, reply.opaque)
+	written += n64
+	if err != nil {
+		return written, err
+	}
+
+	n, err = dst.Write(crlf)
+	written += int64(n)
+	return written, err
+}
+
+func (reply *MetaDeleteReply) UnmarshalText(data []byte) error {
+	*reply = MetaDeleteReply{}
+	return unmarshalReply(`md`, reply, data)
+}
+
 func (reply *MetaDeleteReply) ReadFrom(src io.Reader) (int64, error) {
-	line, err := bufio.NewReader(src).ReadBytes('\n')
+	line, err := bufferedReader(src).ReadBytes('\n')
 	lline := len(line)
 	if err != nil {
-		return int64(lline), fmt.Errorf(`failed to read reply: %w`, err)
+		return int64(lline), parseError(`md`, lline, ``, fmt.Errorf(`failed to read reply: %w`, err))
 	}
 
 	// we need at least 4 bytes for <CD>\r\n
 	if lline < 4 {
-		return int64(lline), fmt.Errorf(`invalid response for md command`)
+		return int64(lline), parseErrorf(`md`, 0, ``, `invalid response for md command`)
 	}
 
 	if line[lline-2] != '\r' || line[lline-1] != '\n' {
-		return int64(lline), fmt.Errorf(`expected CRLF in response for md command`)
+		return int64(lline), parseErrorf(`md`, lline-2, ``, `expected CRLF in response for md command`)
 	}
 	line = line[:lline-2]
 
@@ -150,23 +421,25 @@ func (reply *MetaDeleteReply) ReadFrom(src io.Reader) (int64, error) {
 	} else if line[0] == 'N' && line[1] == 'F' {
 		reply.status = MetaDeleteCmdStatusNotFound
 	} else if lline >= 14 && bytes.Equal(line[:12], []byte(`CLIENT_ERROR`)) {
-		return int64(lline), fmt.Errorf(`memdproto.MetaDeleteReply: client error: %s`, line[13:])
+		return int64(lline), parseErrorf(`CLIENT_ERROR`, 13, ``, `client error: %s`, line[13:])
 	} else {
-		return int64(lline), fmt.Errorf(`memdproto.MetaDeleteReply: expected HD/EX/NF: invalid response for md command %q`, line[:2])
+		return int64(lline), parseErrorf(``, 0, string(firstToken(line)), `expected HD/EX/NF: invalid response for md command`)
 	}
 
 	if lline == 4 {
 		return int64(lline), nil
 	}
 
-	if err := reply.readFlags(line[2:]); err != nil {
-		return int64(lline), fmt.Errorf(`memdproto.MetaDeleteReply: failed to read flags: %w`, err)
+	if err := reply.readFlags(string(line[:2]), line[2:]); err != nil {
+		return int64(lline), err
 	}
 
 	return int64(lline), nil
 }
 
-func (reply *MetaDeleteReply) readFlags(data []byte) error {
+func (reply *MetaDeleteReply) readFlags(verb string, data []byte) error {
+	// flags start right after the two byte status code
+	const base = 2
 	rb := readbuf{data: data}
 	for rb.Len() > 0 {
 		// first, a space
@@ -175,24 +448,26 @@ func (reply *MetaDeleteReply) readFlags(data []byte) error {
 			continue
 		}
 
-		switch rb.data[0] {
+		pos := base + rb.NRead()
+		flag := rb.data[0]
+		rb.Advance()
+		switch flag {
 		case 'b':
-			rb.Advance()
 			reply.b64 = &FlagKeyAsBase64{}
 		case 'k':
-			rb.Advance()
-			var val strings.Builder
-			for rb.Len() > 0 && rb.data[0] != ' ' {
-				val.WriteByte(rb.data[0])
-				rb.Advance()
-			}
-
-			if val.Len() == 0 {
-				return fmt.Errorf(`expected value after k flag`)
+			key := rb.ReadToken()
+			if key == "" {
+				return parseErrorf(verb, pos, `k`, `expected value after k flag`)
 			}
-
-			key := val.String()
 			reply.rkey = &FlagRetrieveKey{key: &key}
+		case 'O':
+			val := rb.ReadTokenBytes()
+			if len(val) == 0 {
+				return parseErrorf(verb, pos, `O`, `expected value after O flag`)
+			}
+			reply.opaque = FlagOpaque(val)
+		default:
+			return parseError(verb, pos, string(flag), ErrUnknownFlag)
 		}
 	}
 	return nil
@@ -1,13 +1,11 @@
 package memdproto
 
 import (
-	"bufio"
 	"bytes"
 	"encoding/base64"
 	"fmt"
 	"io"
 	"strconv"
-	"strings"
 	"unicode"
 )
 
@@ -16,6 +14,7 @@ type MetaGetCmd struct {
 	key                 string
 	b64                 *FlagKeyAsBase64
 	cas                 *FlagRetrieveCas
+	ncas                *FlagNewCas
 	clientFlags         *FlagRetrieveClientFlags
 	prevHit             *FlagRetrievePreviousHit
 	rkey                *FlagRetrieveKey
@@ -43,6 +42,22 @@ func (cmd *MetaGetCmd) Key() string {
 	return cmd.key
 }
 
+func (cmd *MetaGetCmd) Verb() string {
+	return "mg"
+}
+
+func (cmd *MetaGetCmd) Keys() []string {
+	return []string{cmd.key}
+}
+
+func (cmd *MetaGetCmd) NoReply() bool {
+	return cmd.noreply != nil
+}
+
+func (cmd *MetaGetCmd) NewReply() Reply {
+	return NewMetaGetReply()
+}
+
 func (cmd *MetaGetCmd) SetKeyAsBase64(b bool) *MetaGetCmd {
 	if b {
 		cmd.b64 = &FlagKeyAsBase64{}
@@ -104,6 +119,17 @@ func (cmd *MetaGetCmd) SetVivifyOnMiss(ttl uint64) *MetaGetCmd {
 	return cmd
 }
 
+// RetrieveKey returns true if the command requests the key to be
+// returned in the reply ("k" flag)
+func (cmd *MetaGetCmd) RetrieveKey() bool {
+	return cmd.rkey != nil
+}
+
+// Opaque returns the opaque value ("O" flag) associated with the command
+func (cmd *MetaGetCmd) Opaque() []byte {
+	return cmd.opaque
+}
+
 func (cmd *MetaGetCmd) SetOpaque(o []byte) *MetaGetCmd {
 	cmd.opaque = FlagOpaque(o)
 	return cmd
@@ -160,6 +186,105 @@ func (cmd *MetaGetCmd) SetRetrieveValue(b bool) *MetaGetCmd {
 	return cmd
 }
 
+// SetRecache makes the server win the right to recache the item if its
+// remaining TTL is less than ttl ("R" flag)
+func (cmd *MetaGetCmd) SetRecache(ttl uint64) *MetaGetCmd {
+	v := FlagRecache(ttl)
+	cmd.recache = &v
+	return cmd
+}
+
+// SetNewCas makes the server use cas as the new CAS value of the item,
+// if the item is modified ("E" flag)
+func (cmd *MetaGetCmd) SetNewCas(cas uint64) *MetaGetCmd {
+	v := FlagNewCas(cas)
+	cmd.ncas = &v
+	return cmd
+}
+
+// KeyAsBase64 returns true if the key is sent base64 encoded ("b" flag)
+func (cmd *MetaGetCmd) KeyAsBase64() bool {
+	return cmd.b64 != nil
+}
+
+// RetrieveCas returns true if the CAS value is requested ("c" flag)
+func (cmd *MetaGetCmd) RetrieveCas() bool {
+	return cmd.cas != nil
+}
+
+// NewCas returns the CAS value to be used if the item is modified
+// ("E" flag), if any
+func (cmd *MetaGetCmd) NewCas() (uint64, bool) {
+	if cmd.ncas == nil {
+		return 0, false
+	}
+	return uint64(*cmd.ncas), true
+}
+
+// RetrieveClientFlags returns true if the client flags are requested ("f" flag)
+func (cmd *MetaGetCmd) RetrieveClientFlags() bool {
+	return cmd.clientFlags != nil
+}
+
+// RetrievePreviousHit returns true if the command asks whether the item
+// has been hit before ("h" flag)
+func (cmd *MetaGetCmd) RetrievePreviousHit() bool {
+	return cmd.prevHit != nil
+}
+
+// RetrieveTimeSinceLastAccess returns true if the time since the item
+// was last accessed is requested ("l" flag)
+func (cmd *MetaGetCmd) RetrieveTimeSinceLastAccess() bool {
+	return cmd.timeSinceLastAccess != nil
+}
+
+// VivifyOnMiss returns the TTL of the item to be created on a miss
+// ("N" flag), if any
+func (cmd *MetaGetCmd) VivifyOnMiss() (uint64, bool) {
+	if cmd.vivify == nil {
+		return 0, false
+	}
+	return uint64(*cmd.vivify), true
+}
+
+// Recache returns the TTL below which the client wins the right to
+// recache the item ("R" flag), if any
+func (cmd *MetaGetCmd) Recache() (uint64, bool) {
+	if cmd.recache == nil {
+		return 0, false
+	}
+	return uint64(*cmd.recache), true
+}
+
+// RetrieveSize returns true if the size of the item is requested ("s" flag)
+func (cmd *MetaGetCmd) RetrieveSize() bool {
+	return cmd.itemSize != nil
+}
+
+// RetrieveRemainingTTL returns true if the remaining TTL of the item
+// is requested ("t" flag)
+func (cmd *MetaGetCmd) RetrieveRemainingTTL() bool {
+	return cmd.remainingTTL != nil
+}
+
+// UpdateTTL returns the new TTL of the item ("T" flag), if any
+func (cmd *MetaGetCmd) UpdateTTL() (int64, bool) {
+	if cmd.updateTTL == nil {
+		return 0, false
+	}
+	return int64(*cmd.updateTTL), true
+}
+
+// SkipLRUBump returns true if the item should not be bumped in the LRU ("u" flag)
+func (cmd *MetaGetCmd) SkipLRUBump() bool {
+	return cmd.skipLRUBump != nil
+}
+
+// RetrieveValue returns true if the value of the item is requested ("v" flag)
+func (cmd *MetaGetCmd) RetrieveValue() bool {
+	return cmd.value != nil
+}
+
 func (cmd *MetaGetCmd) WriteTo(dst io.Writer) (int64, error) {
 	var written int64
 
@@ -175,7 +300,7 @@ func (cmd *MetaGetCmd) WriteTo(dst io.Writer) (int64, error) {
 	}
 	written += int64(n)
 
-	n64, err := writeFlags(dst, cmd.b64, cmd.cas, cmd.clientFlags, cmd.prevHit, cmd.rkey, cmd.timeSinceLastAccess, cmd.vivify, cmd.opaque, cmd.noreply, cmd.recache, cmd.itemSize, cmd.remainingTTL, cmd.updateTTL, cmd.skipLRUBump, cmd.value)
+	n64, err := writeFlags(dst, cmd.b64, cmd.cas, cmd.ncas, cmd.clientFlags, cmd.prevHit, cmd.rkey, cmd.timeSinceLastAccess, cmd.vivify, cmd.opaque, cmd.noreply, cmd.recache, cmd.itemSize, cmd.remainingTTL, cmd.updateTTL, cmd.skipLRUBump, cmd.value)
 	written += n64
 	if err != nil {
 		return written, err
@@ -189,12 +314,6 @@ func (cmd *MetaGetCmd) WriteTo(dst io.Writer) (int64, error) {
 	return written, nil
 }
 
-func (cmd *MetaGetCmd) String() string {
-	var sb strings.Builder
-	cmd.WriteTo(&sb)
-	return sb.String()
-}
-
 func (cmd *MetaGetCmd) Reset() *MetaGetCmd {
 	cmd.b64 = nil
 	cmd.cas = nil
@@ -217,12 +336,12 @@ func (cmd *MetaGetCmd) Reset() *MetaGetCmd {
 
 // receives a byte slice with at least 1 byte.
 // if the len(data) <=1, then we just processed the last char (the flag)/
-// otherwise checks if the subsequent byte is a space
+// otherwise checks if the subsequent byte is a space or the line terminator
 func isSuffixedWithSpaceOrEOL(data []byte) bool {
 	if len(data) <= 1 {
 		return true
 	}
-	return data[1] == ' '
+	return data[1] == ' ' || data[1] == '\r' || data[1] == '\n'
 }
 
 var metagetCmd = []byte{'m', 'g'}
@@ -231,23 +350,25 @@ func (cmd *MetaGetCmd) UnmarshalText(data []byte) error {
 	cmd.Reset()
 
 	ldata := len(data)
-	if ldata < 2 {
-		return fmt.Errorf(`invalid mg command`)
+	// offset returns the position of the current head of data
+	// relative to the beginning of the original input
+	offset := func() int {
+		return ldata - len(data)
 	}
 
-	if !bytes.Equal(data[:2], metagetCmd) {
-		return fmt.Errorf(`invalid mg command`)
+	if ldata < 2 || !bytes.Equal(data[:2], metagetCmd) {
+		return parseErrorf(`mg`, 0, ``, `invalid mg command`)
 	}
 
 	data = data[2:]
-	if data[0] != ' ' {
-		return fmt.Errorf(`expected space after mg command`)
+	if len(data) == 0 || data[0] != ' ' {
+		return parseErrorf(`mg`, offset(), ``, `expected space after mg command`)
 	}
 	data = data[1:]
 
 	keyb, count, err := readBytes(data, 250)
 	if err != nil {
-		return err
+		return parseError(`mg`, offset(), string(keyb), fmt.Errorf(`invalid key: %w`, err))
 	}
 	data = data[count:]
 	cmd.key = string(keyb)
@@ -257,181 +378,172 @@ func (cmd *MetaGetCmd) UnmarshalText(data []byte) error {
 			data = data[1:]
 			continue
 		}
+		if bytes.Equal(data, crlf) {
+			break
+		}
 
-		switch data[0] {
-		case 'b':
+		flag := data[0]
+		switch flag {
+		case 'b', 'c', 'f', 'h', 'k', 'l', 'q', 's', 't', 'u', 'v':
 			if !isSuffixedWithSpaceOrEOL(data) {
-				return fmt.Errorf(`extra characters following mg flag b`)
+				return parseErrorf(`mg`, offset(), string(flag), `extra characters following mg flag %c`, flag)
 			}
+		}
+
+		switch flag {
+		case 'b':
 			cmd.b64 = &FlagKeyAsBase64{}
-			data = data[1:]
 
 			// Also, at this point we have already read the key, so we need to
 			// decode it from base64
 			decoded, err := base64.StdEncoding.DecodeString(cmd.key)
 			if err != nil {
-				return fmt.Errorf(`failed to decode base64 key: %w`, err)
+				return parseError(`mg`, offset(), cmd.key, fmt.Errorf(`failed to decode base64 key: %w`, err))
 			}
 			cmd.key = string(decoded)
+			data = data[1:]
 		case 'c':
-			if !isSuffixedWithSpaceOrEOL(data) {
-				return fmt.Errorf(`extra characters following mg flag c`)
-			}
 			cmd.cas = new(FlagRetrieveCas)
 			data = data[1:]
 		case 'f':
-			if !isSuffixedWithSpaceOrEOL(data) {
-				return fmt.Errorf(`extra characters following mg flag f`)
-			}
 			cmd.clientFlags = new(FlagRetrieveClientFlags)
 			data = data[1:]
 		case 'h':
-			if !isSuffixedWithSpaceOrEOL(data) {
-				return fmt.Errorf(`extra characters following mg flag h`)
-			}
 			cmd.prevHit = new(FlagRetrievePreviousHit)
 			data = data[1:]
 		case 'k':
-			if !isSuffixedWithSpaceOrEOL(data) {
-				return fmt.Errorf(`extra characters following mg flag k`)
-			}
 			cmd.rkey = new(FlagRetrieveKey)
 			data = data[1:]
 		case 'l':
-			if !isSuffixedWithSpaceOrEOL(data) {
-				return fmt.Errorf(`extra characters following mg flag l`)
-			}
 			cmd.timeSinceLastAccess = new(FlagRetrieveTimeSinceLastAccess)
 			data = data[1:]
 		case 'O':
 			// O must be followed by a string
 			data = data[1:]
 			if len(data) == 0 {
-				return fmt.Errorf(`unexpected end of data after mg flag O`)
+				return parseError(`mg`, offset(), `O`, ErrUnexpectedEOL)
 			}
 			b, count, err := readBytes(data, 32)
 			if err != nil {
-				return err
+				return parseError(`mg`, offset(), `O`, err)
 			}
 			data = data[count:]
 			cmd.opaque = FlagOpaque(b)
+		case 'E':
+			data = data[1:]
+			if len(data) == 0 {
+				return parseError(`mg`, offset(), `E`, ErrUnexpectedEOL)
+			}
+			u64, count, err := readU64(data)
+			if err != nil {
+				return parseError(`mg`, offset(), `E`, fmt.Errorf(`failed to parse new cas: %w`, err))
+			}
+			data = data[count:]
+			v := FlagNewCas(u64)
+			cmd.ncas = &v
 		case 'N':
 			// N must be followed by a number
 			data = data[1:]
 			if len(data) == 0 {
-				return fmt.Errorf(`unexpected end of data after mg flag N`)
+				return parseError(`mg`, offset(), `N`, ErrUnexpectedEOL)
 			}
 			u64, count, err := readU64(data)
 			if err != nil {
-				return err
+				return parseError(`mg`, offset(), `N`, err)
 			}
 			data = data[count:]
 			v := FlagVivifyOnMiss(u64)
 			cmd.vivify = &v
 		case 'q':
-			if !isSuffixedWithSpaceOrEOL(data) {
-				return fmt.Errorf(`extra characters following mg flag q`)
-			}
 			cmd.noreply = new(FlagNoReply)
 			data = data[1:]
 		case 'R':
 			// R must be followed by a number
 			data = data[1:]
 			if len(data) == 0 {
-				return fmt.Errorf(`unexpected end of data after mg flag R`)
+				return parseError(`mg`, offset(), `R`, ErrUnexpectedEOL)
 			}
-			i64, count, err := readI64(data)
+			u64, count, err := readU64(data)
 			if err != nil {
-				return err
+				return parseError(`mg`, offset(), `R`, err)
 			}
-			v := FlagRecache(i64)
+			v := FlagRecache(u64)
 			cmd.recache = &v
 			data = data[count:]
 		case 's':
-			if !isSuffixedWithSpaceOrEOL(data) {
-				return fmt.Errorf(`extra characters following mg flag s`)
-			}
 			cmd.itemSize = new(FlagRetrieveSize)
 			data = data[1:]
 		case 't':
-			if !isSuffixedWithSpaceOrEOL(data) {
-				return fmt.Errorf(`extra characters following mg flag t`)
-			}
 			cmd.remainingTTL = new(FlagRetrieveRemainingTTL)
 			data = data[1:]
 		case 'T':
 			// T must be followed by a number
 			data = data[1:]
 			if len(data) == 0 {
-				return fmt.Errorf(`unexpected end of data after mg flag T`)
+				return parseError(`mg`, offset(), `T`, ErrUnexpectedEOL)
 			}
 			i64, count, err := readI64(data)
 			if err != nil {
-				return err
+				return parseError(`mg`, offset(), `T`, err)
 			}
 			v := FlagUpdateTTL(i64)
 			cmd.updateTTL = &v
 			data = data[count:]
 		case 'u':
-			if !isSuffixedWithSpaceOrEOL(data) {
-				return fmt.Errorf(`extra characters following mg flag u`)
-			}
 			cmd.skipLRUBump = new(FlagSkipLRUBump)
 			data = data[1:]
 		case 'v':
-			if !isSuffixedWithSpaceOrEOL(data) {
-				return fmt.Errorf(`extra characters following mg flag v`)
-			}
 			cmd.value = new(FlagRetrieveValue)
 			data = data[1:]
 		default:
-			return fmt.Errorf(`unknown flag %c`, data[0])
+			return parseError(`mg`, offset(), string(flag), ErrUnknownFlag)
 		}
 	}
 
 	return nil
 }
 
-func readI64(data []byte) (int64, int, error) {
-	var ttlstr []byte
+// readNumeric reads the run of bytes up to the next space or
+// control character, and makes sure that they are all digits.
+// A leading '-' is allowed if signed is true.
+func readNumeric(data []byte, signed bool) (string, int, error) {
+	var numstr []byte
 	var count int
 	for len(data) > 0 {
 		c := data[0]
-		if c == ' ' {
+		if c == ' ' || unicode.IsControl(rune(c)) {
 			break
 		}
-		if c < '0' && c > '9' {
-			return 0, count, fmt.Errorf(`unexpected character %c, expected numeric`, c)
+		if (c < '0' || c > '9') && !(signed && c == '-' && count == 0) {
+			return "", count, fmt.Errorf(`unexpected character %c, expected numeric`, c)
 		}
-		ttlstr = append(ttlstr, c)
+		numstr = append(numstr, c)
 		data = data[1:]
 		count++
 	}
-	i64, err := strconv.ParseInt(string(ttlstr), 10, 64)
+	return string(numstr), count, nil
+}
+
+func readI64(data []byte) (int64, int, error) {
+	s, count, err := readNumeric(data, true)
 	if err != nil {
-		return 0, count, fmt.Errorf(`failed to parse ttl for mg flag R: %w`, err)
+		return 0, count, err
+	}
+	i64, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, count, fmt.Errorf(`failed to parse numeric value: %w`, err)
 	}
 	return i64, count, nil
 }
 
 func readU64(data []byte) (uint64, int, error) {
-	var ttlstr []byte
-	var count int
-	for len(data) > 0 {
-		c := data[0]
-		if c == ' ' {
-			break
-		}
-		if c < '0' && c > '9' {
-			return 0, count, fmt.Errorf(`unexpected character %c, expected numeric`, c)
-		}
-		ttlstr = append(ttlstr, c)
-		count++
-		data = data[1:]
+	s, count, err := readNumeric(data, false)
+	if err != nil {
+		return 0, count, err
 	}
-	u64, err := strconv.ParseUint(string(ttlstr), 10, 64)
+	u64, err := strconv.ParseUint(s, 10, 64)
 	if err != nil {
-		return 0, count, fmt.Errorf(`failed to parse ttl for mg flag N: %w`, err)
+		return 0, count, fmt.Errorf(`failed to parse numeric value: %w`, err)
 	}
 	return u64, count, nil
 }
@@ -449,9 +561,12 @@ func readBytes(data []byte, maxlen int) ([]byte, int, error) {
 		count++
 		data = data[1:]
 		if len(b) > maxlen {
-			return nil, count, fmt.Errorf(`opaque value too long`)
+			return b, count, fmt.Errorf(`value too long (max %d bytes)`, maxlen)
 		}
 	}
+	if len(b) == 0 {
+		return nil, count, ErrUnexpectedEOL
+	}
 	return b, count, nil
 }
 
@@ -471,6 +586,8 @@ type MetaGetReply struct {
 	stale               *FlagStale
 }
 
+var _ Reply = (*MetaGetReply)(nil)
+
 func NewMetaGetReply() *MetaGetReply {
 	return &MetaGetReply{}
 }
@@ -502,6 +619,23 @@ func (mr *MetaGetReply) SetKeyAsBase64(b bool) *MetaGetReply {
 	return mr
 }
 
+// Cas returns the CAS value of the item ("c" flag), if it was returned
+func (mr *MetaGetReply) Cas() (uint64, bool) {
+	if mr.cas == nil {
+		return 0, false
+	}
+	return uint64(*mr.cas), true
+}
+
+// ClientFlags returns the client flags of the item ("f" flag), if
+// they were returned
+func (mr *MetaGetReply) ClientFlags() (uint32, bool) {
+	if mr.clientFlags == nil {
+		return 0, false
+	}
+	return uint32(*mr.clientFlags), true
+}
+
 func (mr *MetaGetReply) SetCas(v uint64) *MetaGetReply {
 	f := FlagRetrieveCas(v)
 	mr.cas = &f
@@ -515,11 +649,11 @@ func (mr *MetaGetReply) SetClientFlags(v uint32) *MetaGetReply {
 }
 
 func (mr *MetaGetReply) SetPreviousHit(b bool) *MetaGetReply {
+	var hit uint8
 	if b {
-		mr.prevHit = new(FlagRetrievePreviousHit)
-	} else {
-		mr.prevHit = nil
+		hit = 1
 	}
+	mr.prevHit = &FlagRetrievePreviousHit{hit: &hit}
 	return mr
 }
 
@@ -536,19 +670,7 @@ func (mr *MetaGetReply) SetRetrieveKey(s string) *MetaGetReply {
 //
 // If the base64 flag is toggled, the key is base64 decoded before being returned.
 func (mr *MetaGetReply) Key() string {
-	if mr.rkey == nil || mr.rkey.key == nil {
-		return ""
-	}
-	s := *mr.rkey.key
-
-	if mr.b64 != nil {
-		decoded, err := base64.StdEncoding.DecodeString(s)
-		if err != nil {
-			return ""
-		}
-		s = string(decoded)
-	}
-	return s
+	return replyKey(mr.rkey, mr.b64)
 }
 
 // SetKey sets the key to be returned with the response using the key flag ("k").
@@ -562,20 +684,7 @@ func (mr *MetaGetReply) Key() string {
 // If s is an empty string, both the key flag ("k") and the base64 flag ("b")
 // will be cleared, regardless of the value of b64.
 func (mr *MetaGetReply) SetKey(s string, b64 bool) *MetaGetReply {
-	if s == "" {
-		mr.rkey = nil
-		mr.b64 = nil
-	} else {
-		mr.rkey = new(FlagRetrieveKey)
-
-		s = base64.StdEncoding.EncodeToString([]byte(s))
-		if b64 {
-			mr.b64 = new(FlagKeyAsBase64)
-		} else {
-			mr.b64 = nil
-		}
-		mr.rkey.key = &s
-	}
+	mr.rkey, mr.b64 = replyKeyFlags(s, b64)
 	return mr
 }
 
@@ -584,6 +693,11 @@ func (mr *MetaGetReply) SetTimeSinceLastAccess(v uint64) *MetaGetReply {
 	return mr
 }
 
+// Opaque returns the opaque value ("O" flag) echoed back in the reply
+func (mr *MetaGetReply) Opaque() []byte {
+	return mr.opaque
+}
+
 func (mr *MetaGetReply) SetOpaque(o []byte) *MetaGetReply {
 	mr.opaque = FlagOpaque(o)
 	return mr
@@ -614,12 +728,26 @@ func (mr *MetaGetReply) SetStale(b bool) *MetaGetReply {
 }
 
 func (mr *MetaGetReply) WriteTo(dst io.Writer) (int64, error) {
+	var written int64
 	if mr.miss {
-		n, err := fmt.Fprintf(dst, "EN\r\n")
-		return int64(n), err
+		// only the key and opaque flags are echoed back on a miss
+		n, err := fmt.Fprintf(dst, "EN")
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+
+		n64, err := writeFlags(dst, mr.b64, mr.rkey, mr.opaque)
+		written += n64
+		if err != nil {
+			return written, err
+		}
+
+		n, err = dst.Write(crlf)
+		written += int64(n)
+		return written, err
 	}
 
-	var written int64
 	if mr.value == nil {
 		n, err := fmt.Fprintf(dst, "HD")
 		written += int64(n)
@@ -634,7 +762,7 @@ func (mr *MetaGetReply) WriteTo(dst io.Writer) (int64, error) {
 		}
 	}
 
-	n64, err := writeFlags(dst, mr.b64, mr.cas, mr.clientFlags, mr.prevHit, mr.rkey, mr.timeSinceLastAccess, mr.opaque, mr.itemSize, mr.remainingTTL, mr.recacheResult, mr.stale)
+	n64, err := writeFlags(dst, mr.b64, replyNumberFlag('c', mr.cas), replyNumberFlag('f', mr.clientFlags), mr.prevHit, mr.rkey, mr.timeSinceLastAccess, mr.opaque, mr.itemSize, mr.remainingTTL, mr.recacheResult, mr.stale)
 	written += n64
 	if err != nil {
 		return written, err
@@ -661,188 +789,185 @@ func (mr *MetaGetReply) WriteTo(dst io.Writer) (int64, error) {
 
 func (reply *MetaGetReply) ReadFrom(src io.Reader) (int64, error) {
 	// Read the next line
-	brdr := bufio.NewReader(src)
+	brdr := bufferedReader(src)
 	line, err := brdr.ReadBytes('\n')
 	if err != nil {
-		return int64(len(line)), err
+		return int64(len(line)), parseError(`mg`, len(line), ``, err)
 	}
 
 	lline := len(line)
 	nread := int64(lline)
 	if lline < 2 || (line[lline-2] != '\r') || (line[lline-1] != '\n') {
-		return int64(lline), fmt.Errorf(`memdproto.MetaGetReply: expected CRLF at end of line`)
+		return nread, parseErrorf(`mg`, lline, ``, `expected CRLF at end of line`)
 	}
 
 	line = line[:lline-2] // strip CRLF
 
-	if lline == 4 && line[0] == 'E' && line[1] == 'N' {
+	if len(line) >= 2 && line[0] == 'E' && line[1] == 'N' {
+		// misses may still echo back the opaque and key flags
 		reply.miss = true
+		if err := reply.readFlags(`EN`, line[2:], 2); err != nil {
+			return nread, err
+		}
 		return nread, nil
-	} else if lline > 2 && line[0] == 'H' && line[1] == 'D' && line[2] == ' ' {
-		reply.readFlags(line[3:])
-		if err != nil {
-			return nread, fmt.Errorf(`memdproto.MetaGetReply: failed to read flags: %w`, err)
+	} else if len(line) >= 2 && line[0] == 'H' && line[1] == 'D' {
+		if err := reply.readFlags(`HD`, line[2:], 2); err != nil {
+			return nread, err
 		}
 		return nread, nil
-	} else if lline > 4 && line[0] == 'V' && line[1] == 'A' && line[2] == ' ' {
+	} else if len(line) > 3 && line[0] == 'V' && line[1] == 'A' && line[2] == ' ' {
 		rb := readbuf{data: line[3:]}
 		size := rb.ReadToken()
-		sz, err := strconv.ParseUint(size, 10, 64)
+		sz, err := strconv.ParseUint(size, 10, 31)
 		if err != nil {
-			return 0, fmt.Errorf(`failed to parse size: %w`, err)
+			return nread, parseError(`VA`, 3, size, fmt.Errorf(`failed to parse size: %w`, err))
 		}
 
-		_, err = reply.readFlags(rb.data)
-		if err != nil {
-			return int64(nread), fmt.Errorf(`failed to read flags: %w`, err)
+		if err := reply.readFlags(`VA`, rb.data, 3+rb.NRead()); err != nil {
+			return nread, err
 		}
 
 		// we should read sz bytes, followed by CRLF
-		buf := make([]byte, sz)
-		valread, err := brdr.Read(buf)
+		buf, valread, err := readValue(brdr, int64(sz))
 		nread += int64(valread)
-		if uint64(valread) != sz {
-			return nread, fmt.Errorf(`failed to read value: expected %d bytes, got %d`, sz, valread)
-		}
 		if err != nil {
-			return nread, fmt.Errorf(`failed to read value: %w`, err)
+			return nread, parseError(`VA`, int(nread), ``, fmt.Errorf(`failed to read value: expected %d bytes, got %d: %w`, sz, valread, err))
 		}
 		reply.value = buf
 
 		// read the CRLF
 		var crlfbuf [2]byte
-		ncrlf, err := brdr.Read(crlfbuf[:])
+		ncrlf, err := io.ReadFull(brdr, crlfbuf[:])
 		nread += int64(ncrlf)
 		if err != nil {
-			return int64(nread), fmt.Errorf(`failed to read CRLF: %w`, err)
+			return nread, parseError(`VA`, int(nread), ``, fmt.Errorf(`failed to read CRLF: %w`, err))
 		}
-		if ncrlf != 2 || !bytes.Equal(crlfbuf[:], crlf) {
-			return int64(nread), fmt.Errorf(`expected CRLF after value, got %d bytes`, ncrlf)
+		if !bytes.Equal(crlfbuf[:], crlf) {
+			return nread, parseErrorf(`VA`, int(nread)-ncrlf, string(crlfbuf[:]), `expected CRLF after value`)
 		}
 		return nread, nil
-	} else if lline > 12 && bytes.Equal(line[:13], []byte("CLIENT_ERROR ")) {
-		return nread, fmt.Errorf(`client error: %s`, line[13:])
+	} else if len(line) > 12 && bytes.Equal(line[:13], []byte("CLIENT_ERROR ")) {
+		return nread, parseErrorf(`CLIENT_ERROR`, 13, ``, `client error: %s`, line[13:])
 	}
 
-	return nread, fmt.Errorf(`unexpected response for mg command`)
+	return nread, parseErrorf(``, 0, string(firstToken(line)), `unexpected response for mg command`)
 }
 
-func (reply *MetaGetReply) readFlags(line []byte) (int, error) {
+func (reply *MetaGetReply) UnmarshalText(data []byte) error {
+	*reply = MetaGetReply{}
+	return unmarshalReply(`mg`, reply, data)
+}
+
+// readFlags reads the flags in a mg reply line. base is the offset
+// of the beginning of line from the beginning of the reply, and is
+// only used to report errors
+func (reply *MetaGetReply) readFlags(verb string, line []byte, base int) error {
 	rb := readbuf{data: line}
 	for rb.Len() > 0 {
 		if rb.data[0] != ' ' {
-			return 0, fmt.Errorf(`memdproto.MetaGetReply: unexpected character %c, expected space`, rb.data[0])
+			return parseErrorf(verb, base+rb.NRead(), string(rb.data[:1]), `unexpected character %c, expected space`, rb.data[0])
 		}
 		rb.Advance()
+		if rb.Len() == 0 {
+			return parseError(verb, base+rb.NRead(), ``, ErrUnexpectedEOL)
+		}
 
-		switch rb.data[0] {
+		pos := base + rb.NRead()
+		flag := rb.data[0]
+		rb.Advance()
+		switch flag {
 		case 'b':
-			rb.Advance()
 			reply.SetKeyAsBase64(true)
 		case 'c':
-			rb.Advance()
 			s := rb.ReadToken()
 			if s == "" {
-				return 0, fmt.Errorf(`memdproto.MetaGetReply: expected value after mg flag c`)
+				return parseErrorf(verb, pos, `c`, `expected value after mg flag c`)
 			}
 
 			u64, err := strconv.ParseUint(s, 10, 64)
 			if err != nil {
-				return 0, fmt.Errorf(`memdproto.MetaGetReply: failed to parse cas: %w`, err)
+				return parseError(verb, pos, `c`, fmt.Errorf(`failed to parse cas: %w`, err))
 			}
 			reply.SetCas(u64)
 		case 'f':
-			rb.Advance()
 			s := rb.ReadToken()
 			if s == "" {
-				return 0, fmt.Errorf(`memdproto.MetaGetReply: expected value after mg flag f`)
+				return parseErrorf(verb, pos, `f`, `expected value after mg flag f`)
 			}
 
 			u32, err := strconv.ParseUint(s, 10, 32)
 			if err != nil {
-				return 0, fmt.Errorf(`memdproto.MetaGetReply: failed to parse client flags: %w`, err)
+				return parseError(verb, pos, `f`, fmt.Errorf(`failed to parse client flags: %w`, err))
 			}
 			reply.SetClientFlags(uint32(u32))
 		case 'h':
-			rb.Advance()
 			if rb.Len() < 1 {
-				return 0, fmt.Errorf(`expected value after mg flag h`)
+				return parseErrorf(verb, pos, `h`, `expected value after mg flag h`)
 			}
 
 			switch rb.data[0] {
-			case ' ':
-				return 0, fmt.Errorf(`unexpected space after mg flag h`)
 			case '0':
 				reply.SetPreviousHit(false)
 			case '1':
 				reply.SetPreviousHit(true)
 			default:
-				return 0, fmt.Errorf(`memdproto.MetaGetReply: unexpected character %c after flag h, expected 0 or 1`, rb.data[0])
+				return parseErrorf(verb, pos, `h`, `unexpected character %c after flag h, expected 0 or 1`, rb.data[0])
 			}
 			rb.Advance() // consume '0' or '1'
 		case 'k':
-			rb.Advance()
 			s := rb.ReadToken()
 			if s == "" {
-				return 0, fmt.Errorf(`memdproto.MetaGetReply: expected value after mg flag k`)
+				return parseErrorf(verb, pos, `k`, `expected value after mg flag k`)
 			}
 			reply.SetRetrieveKey(s)
 		case 'l':
-			rb.Advance()
 			s := rb.ReadToken()
 			if s == "" {
-				return 0, fmt.Errorf(`memdproto.MetaGetReply: expected value after mg flag l`)
+				return parseErrorf(verb, pos, `l`, `expected value after mg flag l`)
 			}
 
 			u64, err := strconv.ParseUint(s, 10, 64)
 			if err != nil {
-				return 0, fmt.Errorf(`memdproto.MetaGetReply: failed to parse time since last access: %w`, err)
+				return parseError(verb, pos, `l`, fmt.Errorf(`failed to parse time since last access: %w`, err))
 			}
 			reply.SetTimeSinceLastAccess(u64)
 		case 'O':
-			rb.Advance()
 			s := rb.ReadTokenBytes()
 			if len(s) == 0 {
-				return 0, fmt.Errorf(`memdproto.MetaGetReply: expected value after mg flag O`)
+				return parseErrorf(verb, pos, `O`, `expected value after mg flag O`)
 			}
 			reply.SetOpaque(s)
 		case 's':
-			rb.Advance()
 			s := rb.ReadToken()
 			if s == "" {
-				return 0, fmt.Errorf(`memdproto.MetaGetReply: expected value after mg flag s`)
+				return parseErrorf(verb, pos, `s`, `expected value after mg flag s`)
 			}
 
 			u64, err := strconv.ParseUint(s, 10, 64)
 			if err != nil {
-				return 0, fmt.Errorf(`memdproto.MetaGetReply: failed to parse item size: %w`, err)
+				return parseError(verb, pos, `s`, fmt.Errorf(`failed to parse item size: %w`, err))
 			}
 			reply.SetItemSize(u64)
 		case 't':
-			rb.Advance()
 			s := rb.ReadToken()
 			if s == "" {
-				return 0, fmt.Errorf(`memdproto.MetaGetReply: expected value after mg flag t`)
+				return parseErrorf(verb, pos, `t`, `expected value after mg flag t`)
 			}
 
 			i64, err := strconv.ParseInt(s, 10, 64)
 			if err != nil {
-				return 0, fmt.Errorf(`memdproto.MetaGetReply: failed to parse remaining ttl: %w`, err)
+				return parseError(verb, pos, `t`, fmt.Errorf(`failed to parse remaining ttl: %w`, err))
 			}
 			reply.SetRemainingTTL(i64)
 		case 'W':
-			rb.Advance()
 			reply.SetRecacheResult(true)
 		case 'X':
-			rb.Advance()
 			reply.SetStale(true)
 		case 'Z':
-			rb.Advance()
 			reply.SetRecacheResult(false)
 		default:
-			return 0, fmt.Errorf(`memdproto.MetaGetReply: unknown flag %c`, line[0])
+			return parseError(verb, pos, string(flag), ErrUnknownFlag)
 		}
-		line = line[1:]
 	}
-	return rb.NRead(), nil
+	return nil
 }
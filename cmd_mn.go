@@ -0,0 +1,84 @@
+package memdproto
+
+import (
+	"bytes"
+	"io"
+)
+
+var metanoopCmd = []byte("mn")
+var metanoopReply = []byte("MN")
+
+// MetaNoopCmd represents the memcached meta no-op command. The server
+// replies with "MN" once all previous commands in the pipeline have been
+// processed, which makes it useful as a terminator for batches of
+// quiet mode commands.
+type MetaNoopCmd struct{}
+
+var _ Cmd = (*MetaNoopCmd)(nil)
+
+func NewMetaNoopCmd() *MetaNoopCmd {
+	return &MetaNoopCmd{}
+}
+
+func (cmd *MetaNoopCmd) Verb() string {
+	return "mn"
+}
+
+// Keys always returns nil, as the no-op command does not operate on any key
+func (cmd *MetaNoopCmd) Keys() []string {
+	return nil
+}
+
+func (cmd *MetaNoopCmd) NoReply() bool {
+	return false
+}
+
+func (cmd *MetaNoopCmd) NewReply() Reply {
+	return NewMetaNoopReply()
+}
+
+func (cmd *MetaNoopCmd) WriteTo(dst io.Writer) (int64, error) {
+	n, err := dst.Write([]byte("mn\r\n"))
+	return int64(n), err
+}
+
+func (cmd *MetaNoopCmd) UnmarshalText(data []byte) error {
+	data = bytes.TrimSuffix(data, crlf)
+	if !bytes.Equal(data, metanoopCmd) {
+		return parseErrorf(``, 0, string(firstToken(data)), `invalid mn command`)
+	}
+	return nil
+}
+
+// MetaNoopReply represents the "MN" reply to a meta no-op command
+type MetaNoopReply struct{}
+
+var _ Reply = (*MetaNoopReply)(nil)
+
+func NewMetaNoopReply() *MetaNoopReply {
+	return &MetaNoopReply{}
+}
+
+func (reply *MetaNoopReply) WriteTo(dst io.Writer) (int64, error) {
+	n, err := dst.Write([]byte("MN\r\n"))
+	return int64(n), err
+}
+
+func (reply *MetaNoopReply) ReadFrom(src io.Reader) (int64, error) {
+	line, err := bufferedReader(src).ReadBytes('\n')
+	if err != nil {
+		return int64(len(line)), parseError(`mn`, len(line), ``, err)
+	}
+	return int64(len(line)), reply.UnmarshalText(line)
+}
+
+func (reply *MetaNoopReply) UnmarshalText(data []byte) error {
+	if !bytes.HasSuffix(data, crlf) {
+		return parseErrorf(`MN`, len(data), ``, `expected CRLF`)
+	}
+	data = data[:len(data)-2]
+	if !bytes.Equal(data, metanoopReply) {
+		return parseErrorf(``, 0, string(firstToken(data)), `expected MN`)
+	}
+	return nil
+}
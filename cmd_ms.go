@@ -1,22 +1,28 @@
 package memdproto
 
 import (
-	"bufio"
+	"bytes"
 	"encoding/base64"
 	"fmt"
 	"io"
+	"math"
 	"strconv"
-	"strings"
 )
 
 type MetaSetCmd struct {
-	key     string
-	data    []byte
-	b64     *FlagKeyAsBase64
-	rkey    *FlagRetrieveKey
-	opaque  FlagOpaque
-	mode    *MetaSetMode
-	noreply *FlagNoReply
+	key        string
+	data       []byte
+	b64        *FlagKeyAsBase64
+	cas        *FlagRetrieveCas
+	ccas       *FlagCompareCas
+	ncas       *FlagNewCas
+	flags      *FlagSetClientFlags
+	invalidate *FlagInvalidateOnOldCas
+	rkey       *FlagRetrieveKey
+	opaque     FlagOpaque
+	mode       *MetaSetMode
+	noreply    *FlagNoReply
+	ttl        *FlagSetTTL
 }
 
 var _ Cmd = (*MetaSetCmd)(nil)
@@ -32,6 +38,22 @@ func (cmd *MetaSetCmd) Key() string {
 	return cmd.key
 }
 
+func (cmd *MetaSetCmd) Verb() string {
+	return "ms"
+}
+
+func (cmd *MetaSetCmd) Keys() []string {
+	return []string{cmd.key}
+}
+
+func (cmd *MetaSetCmd) NoReply() bool {
+	return cmd.noreply != nil
+}
+
+func (cmd *MetaSetCmd) NewReply() Reply {
+	return NewMetaSetReply()
+}
+
 func (cmd *MetaSetCmd) SetKeyAsBase64(b64 bool) *MetaSetCmd {
 	if b64 {
 		cmd.b64 = &FlagKeyAsBase64{}
@@ -51,11 +73,74 @@ func (cmd *MetaSetCmd) SetRetrieveKey(v bool) *MetaSetCmd {
 
 }
 
+// SetRetrieveCas requests the CAS value of the item to be
+// returned in the reply ("c" flag)
+func (cmd *MetaSetCmd) SetRetrieveCas(v bool) *MetaSetCmd {
+	if v {
+		cmd.cas = new(FlagRetrieveCas)
+	} else {
+		cmd.cas = nil
+	}
+	return cmd
+}
+
+// SetCompareCas makes the command fail unless the CAS value of
+// the item matches cas ("C" flag)
+func (cmd *MetaSetCmd) SetCompareCas(cas uint64) *MetaSetCmd {
+	v := FlagCompareCas(cas)
+	cmd.ccas = &v
+	return cmd
+}
+
+// SetNewCas makes the server use cas as the new CAS value of the item,
+// if the item is modified ("E" flag)
+func (cmd *MetaSetCmd) SetNewCas(cas uint64) *MetaSetCmd {
+	v := FlagNewCas(cas)
+	cmd.ncas = &v
+	return cmd
+}
+
+// SetClientFlags sets the client flags stored along with the item ("F" flag)
+func (cmd *MetaSetCmd) SetClientFlags(flags uint32) *MetaSetCmd {
+	v := FlagSetClientFlags(flags)
+	cmd.flags = &v
+	return cmd
+}
+
+// SetInvalidateOnOldCas makes the item stale instead of failing
+// when the compared CAS value is older than the item's ("I" flag)
+func (cmd *MetaSetCmd) SetInvalidateOnOldCas(v bool) *MetaSetCmd {
+	if v {
+		cmd.invalidate = &FlagInvalidateOnOldCas{}
+	} else {
+		cmd.invalidate = nil
+	}
+	return cmd
+}
+
+// SetTTL sets the time-to-live of the item ("T" flag)
+func (cmd *MetaSetCmd) SetTTL(ttl int64) *MetaSetCmd {
+	v := FlagSetTTL(ttl)
+	cmd.ttl = &v
+	return cmd
+}
+
 func (cmd *MetaSetCmd) SetMode(mode MetaSetMode) *MetaSetCmd {
 	cmd.mode = &mode
 	return cmd
 }
 
+// RetrieveKey returns true if the command requests the key to be
+// returned in the reply ("k" flag)
+func (cmd *MetaSetCmd) RetrieveKey() bool {
+	return cmd.rkey != nil
+}
+
+// Opaque returns the opaque value ("O" flag) associated with the command
+func (cmd *MetaSetCmd) Opaque() []byte {
+	return cmd.opaque
+}
+
 func (cmd *MetaSetCmd) SetOpaque(opaque []byte) *MetaSetCmd {
 	cmd.opaque = FlagOpaque(opaque)
 	return cmd
@@ -70,6 +155,68 @@ func (cmd *MetaSetCmd) SetNoReply(noreply bool) *MetaSetCmd {
 	return cmd
 }
 
+// Value returns the data to be stored
+func (cmd *MetaSetCmd) Value() []byte {
+	return cmd.data
+}
+
+// KeyAsBase64 returns true if the key is sent base64 encoded ("b" flag)
+func (cmd *MetaSetCmd) KeyAsBase64() bool {
+	return cmd.b64 != nil
+}
+
+// RetrieveCas returns true if the CAS value is requested ("c" flag)
+func (cmd *MetaSetCmd) RetrieveCas() bool {
+	return cmd.cas != nil
+}
+
+// CompareCas returns the CAS value to compare against ("C" flag), if any
+func (cmd *MetaSetCmd) CompareCas() (uint64, bool) {
+	if cmd.ccas == nil {
+		return 0, false
+	}
+	return uint64(*cmd.ccas), true
+}
+
+// NewCas returns the CAS value to be used if the item is modified
+// ("E" flag), if any
+func (cmd *MetaSetCmd) NewCas() (uint64, bool) {
+	if cmd.ncas == nil {
+		return 0, false
+	}
+	return uint64(*cmd.ncas), true
+}
+
+// ClientFlags returns the client flags to be stored ("F" flag), if any
+func (cmd *MetaSetCmd) ClientFlags() (uint32, bool) {
+	if cmd.flags == nil {
+		return 0, false
+	}
+	return uint32(*cmd.flags), true
+}
+
+// InvalidateOnOldCas returns true if the "I" flag is set
+func (cmd *MetaSetCmd) InvalidateOnOldCas() bool {
+	return cmd.invalidate != nil
+}
+
+// TTL returns the time-to-live of the item ("T" flag), if any
+func (cmd *MetaSetCmd) TTL() (int64, bool) {
+	if cmd.ttl == nil {
+		return 0, false
+	}
+	return int64(*cmd.ttl), true
+}
+
+// Mode returns the mode of the command ("M" flag). MetaSetModeSet is
+// returned if the mode was not specified.
+func (cmd *MetaSetCmd) Mode() MetaSetMode {
+	if cmd.mode == nil {
+		return MetaSetModeSet
+	}
+	return *cmd.mode
+}
+
 func (cmd *MetaSetCmd) WriteTo(dst io.Writer) (int64, error) {
 	var key string
 	if cmd.b64 != nil {
@@ -86,7 +233,7 @@ func (cmd *MetaSetCmd) WriteTo(dst io.Writer) (int64, error) {
 		return written, err
 	}
 
-	n64, err := writeFlags(dst, cmd.b64, cmd.rkey, cmd.mode, cmd.opaque, cmd.noreply)
+	n64, err := writeFlags(dst, cmd.b64, cmd.cas, cmd.ccas, cmd.ncas, cmd.flags, cmd.invalidate, cmd.rkey, cmd.mode, cmd.opaque, cmd.noreply, cmd.ttl)
 	written += n64
 	if err != nil {
 		return written, err
@@ -113,8 +260,176 @@ func (cmd *MetaSetCmd) WriteTo(dst io.Writer) (int64, error) {
 	return written, err
 }
 
+var metasetCmd = []byte{'m', 's'}
+
 func (cmd *MetaSetCmd) UnmarshalText(data []byte) error {
-	return fmt.Errorf("not implemented")
+	*cmd = MetaSetCmd{}
+
+	ldata := len(data)
+	// offset returns the position of the current head of data
+	// relative to the beginning of the original input
+	offset := func() int {
+		return ldata - len(data)
+	}
+
+	if ldata < 2 || !bytes.Equal(data[:2], metasetCmd) {
+		return parseErrorf(`ms`, 0, ``, `invalid ms command`)
+	}
+
+	data = data[2:]
+	if len(data) == 0 || data[0] != ' ' {
+		return parseErrorf(`ms`, offset(), ``, `expected space after ms command`)
+	}
+	data = data[1:]
+
+	keyb, count, err := readBytes(data, 250)
+	if err != nil {
+		return parseError(`ms`, offset(), string(keyb), fmt.Errorf(`invalid key: %w`, err))
+	}
+	data = data[count:]
+	cmd.key = string(keyb)
+
+	if len(data) == 0 || data[0] != ' ' {
+		return parseErrorf(`ms`, offset(), ``, `expected space after key`)
+	}
+	data = data[1:]
+
+	datalen, count, err := readU64(data)
+	if err != nil {
+		return parseError(`ms`, offset(), string(firstToken(data)), fmt.Errorf(`invalid data length: %w`, err))
+	}
+	data = data[count:]
+
+	for len(data) > 0 {
+		if data[0] == ' ' {
+			data = data[1:]
+			continue
+		}
+		if data[0] == '\r' || data[0] == '\n' {
+			break
+		}
+
+		flag := data[0]
+		switch flag {
+		case 'b', 'c', 'I', 'k', 'q':
+			if !isSuffixedWithSpaceOrEOL(data) {
+				return parseErrorf(`ms`, offset(), string(flag), `extra characters following ms flag %c`, flag)
+			}
+		}
+
+		switch flag {
+		case 'b':
+			cmd.b64 = &FlagKeyAsBase64{}
+			data = data[1:]
+		case 'c':
+			cmd.cas = new(FlagRetrieveCas)
+			data = data[1:]
+		case 'C':
+			data = data[1:]
+			v, count, err := readU64(data)
+			if err != nil {
+				return parseError(`ms`, offset(), `C`, fmt.Errorf(`failed to parse cas: %w`, err))
+			}
+			data = data[count:]
+			ccas := FlagCompareCas(v)
+			cmd.ccas = &ccas
+		case 'E':
+			data = data[1:]
+			u64, count, err := readU64(data)
+			if err != nil {
+				return parseError(`ms`, offset(), `E`, fmt.Errorf(`failed to parse new cas: %w`, err))
+			}
+			data = data[count:]
+			v := FlagNewCas(u64)
+			cmd.ncas = &v
+		case 'F':
+			data = data[1:]
+			v, count, err := readU64(data)
+			if err == nil && v > math.MaxUint32 {
+				err = fmt.Errorf(`value %d out of range`, v)
+			}
+			if err != nil {
+				return parseError(`ms`, offset(), `F`, fmt.Errorf(`failed to parse client flags: %w`, err))
+			}
+			data = data[count:]
+			flags := FlagSetClientFlags(v)
+			cmd.flags = &flags
+		case 'I':
+			cmd.invalidate = &FlagInvalidateOnOldCas{}
+			data = data[1:]
+		case 'k':
+			cmd.rkey = &FlagRetrieveKey{}
+			data = data[1:]
+		case 'q':
+			cmd.noreply = &FlagNoReply{}
+			data = data[1:]
+		case 'T':
+			data = data[1:]
+			v, count, err := readI64(data)
+			if err != nil {
+				return parseError(`ms`, offset(), `T`, fmt.Errorf(`failed to parse TTL: %w`, err))
+			}
+			data = data[count:]
+			ttl := FlagSetTTL(v)
+			cmd.ttl = &ttl
+		case 'M':
+			data = data[1:]
+			if len(data) == 0 {
+				return parseError(`ms`, offset(), `M`, ErrUnexpectedEOL)
+			}
+			var mode MetaSetMode
+			switch data[0] {
+			case 'S', 's':
+				mode = MetaSetModeSet
+			case 'E', 'e':
+				mode = MetaSetModeAdd
+			case 'A', 'a':
+				mode = MetaSetModeAppend
+			case 'P', 'p':
+				mode = MetaSetModePrepend
+			case 'R', 'r':
+				mode = MetaSetModeReplace
+			default:
+				return parseErrorf(`ms`, offset(), `M`, `invalid mode %c`, data[0])
+			}
+			cmd.mode = &mode
+			data = data[1:]
+		case 'O':
+			data = data[1:]
+			b, count, err := readBytes(data, 32)
+			if err != nil {
+				return parseError(`ms`, offset(), `O`, err)
+			}
+			data = data[count:]
+			cmd.opaque = FlagOpaque(b)
+		default:
+			return parseError(`ms`, offset(), string(flag), ErrUnknownFlag)
+		}
+	}
+
+	if cmd.b64 != nil {
+		decoded, err := base64.StdEncoding.DecodeString(cmd.key)
+		if err != nil {
+			return parseError(`ms`, 3, cmd.key, fmt.Errorf(`failed to decode base64 key: %w`, err))
+		}
+		cmd.key = string(decoded)
+	}
+
+	if !bytes.HasPrefix(data, crlf) {
+		return parseErrorf(`ms`, offset(), ``, `expected CRLF after ms command line`)
+	}
+	data = data[2:]
+
+	if uint64(len(data)) < datalen {
+		return parseError(`ms`, offset(), ``, fmt.Errorf(`expected %d bytes of data, got %d: %w`, datalen, len(data), ErrUnexpectedEOL))
+	}
+	cmd.data = data[:datalen]
+	data = data[datalen:]
+
+	if !bytes.Equal(data, crlf) {
+		return parseErrorf(`ms`, offset(), ``, `expected CRLF after data block`)
+	}
+	return nil
 }
 
 type MetaSetCmdStatus int
@@ -148,35 +463,124 @@ type MetaSetReply struct {
 	vivify     *FlagVivifyOnMiss
 }
 
+var _ Reply = (*MetaSetReply)(nil)
+
+func NewMetaSetReply() *MetaSetReply {
+	return &MetaSetReply{}
+}
+
+// Key returns the value associated with the key flag ("k") in the response.
+//
+// If the base64 flag is toggled, the key is base64 decoded before being returned.
 func (reply *MetaSetReply) Key() string {
-	if reply.rkey == nil {
-		return ""
-	}
-	return *reply.rkey.key
+	return replyKey(reply.rkey, reply.b64)
 }
 
 func (reply *MetaSetReply) Status() MetaSetCmdStatus {
 	return reply.status
 }
 
+func (reply *MetaSetReply) SetStatus(status MetaSetCmdStatus) *MetaSetReply {
+	reply.status = status
+	return reply
+}
+
+func (reply *MetaSetReply) SetCas(v uint64) *MetaSetReply {
+	f := FlagRetrieveCas(v)
+	reply.cas = &f
+	return reply
+}
+
+// SetKey sets the key to be returned with the response using the key flag ("k").
+// If b64 is true, the key is base64 encoded and the base64 flag ("b") is set.
+//
+// If s is an empty string, both the key flag ("k") and the base64 flag ("b")
+// will be cleared, regardless of the value of b64.
+func (reply *MetaSetReply) SetKey(s string, b64 bool) *MetaSetReply {
+	reply.rkey, reply.b64 = replyKeyFlags(s, b64)
+	return reply
+}
+
+// Opaque returns the opaque value ("O" flag) echoed back in the reply
+func (reply *MetaSetReply) Opaque() []byte {
+	if reply.opaque == nil {
+		return nil
+	}
+	return *reply.opaque
+}
+
+func (reply *MetaSetReply) SetOpaque(o []byte) *MetaSetReply {
+	if len(o) == 0 {
+		reply.opaque = nil
+	} else {
+		opaque := FlagOpaque(o)
+		reply.opaque = &opaque
+	}
+	return reply
+}
+
+func (status MetaSetCmdStatus) code() ([]byte, error) {
+	switch status {
+	case MetaSetCmdStatusStored:
+		return []byte("HD"), nil
+	case MetaSetCmdStatusNotStored:
+		return []byte("NS"), nil
+	case MetaSetCmdStatusExists:
+		return []byte("EX"), nil
+	case MetaSetCmdStatusNotFound:
+		return []byte("NF"), nil
+	default:
+		return nil, fmt.Errorf(`invalid ms reply status %d`, status)
+	}
+}
+
 func (reply *MetaSetReply) WriteTo(dst io.Writer) (int64, error) {
-	return 0, nil
+	code, err := reply.status.code()
+	if err != nil {
+		return 0, err
+	}
+
+	var written int64
+	n, err := dst.Write(code)
+	written += int64(n)
+	if err != nil {
+		return written, err
+	}
+
+	var opaque FlagOpaque
+	if reply.opaque != nil {
+		opaque = *reply.opaque
+	}
+	n64, err := writeFlags(dst, reply.b64, replyNumberFlag('c', reply.cas), reply.rkey, opaque)
+	written += n64
+	if err != nil {
+		return written, err
+	}
+
+	n, err = dst.Write(crlf)
+	written += int64(n)
+	return written, err
+}
+
+func (reply *MetaSetReply) UnmarshalText(data []byte) error {
+	*reply = MetaSetReply{}
+	return unmarshalReply(`ms`, reply, data)
 }
 
 func (reply *MetaSetReply) ReadFrom(src io.Reader) (int64, error) {
-	line, err := bufio.NewReader(src).ReadBytes('\n')
+	line, err := bufferedReader(src).ReadBytes('\n')
 	lline := len(line)
 	if err != nil {
-		return int64(lline), fmt.Errorf(`failed to read reply: %w`, err)
+		return int64(lline), parseError(`ms`, lline, ``, fmt.Errorf(`failed to read reply: %w`, err))
 	}
 
 	// we need at least 4 bytes for <CD>\r\n
 	if lline < 4 {
-		return int64(lline), fmt.Errorf(`invalid response for ms command`)
+		return int64(lline), parseErrorf(`ms`, 0, ``, `invalid response for ms command`)
 	}
 
 	if line[lline-2] != '\r' || line[lline-1] != '\n' {
-		return int64(lline), fmt.Errorf(`expected CRLF in response for ms command`)
+		return int64(lline), parseErrorf(`ms`, lline-2, ``, `expected CRLF in response for ms command`)
 	}
 	line = line[:lline-2]
 
@@ -190,66 +594,70 @@ func (reply *MetaSetReply) ReadFrom(src io.Reader) (int64, error) {
 		reply.status = MetaSetCmdStatusExists
 	} else if line[0] == 'N' && line[1] == 'F' {
 		reply.status = MetaSetCmdStatusNotFound
+	} else if len(line) > 12 && bytes.Equal(line[:13], []byte("CLIENT_ERROR ")) {
+		return int64(lline), parseErrorf(`CLIENT_ERROR`, 13, ``, `client error: %s`, line[13:])
 	} else {
-		return int64(lline), fmt.Errorf(`expected HD/NS/EX/NF: invalid response for ms command`)
+		return int64(lline), parseErrorf(``, 0, string(firstToken(line)), `expected HD/NS/EX/NF: invalid response for ms command`)
 	}
 
 	if lline == 4 {
 		return int64(lline), nil
 	}
 
-	if err := reply.readFlags(line[2:]); err != nil {
-		return int64(lline), fmt.Errorf(`failed to read flags: %w`, err)
+	if err := reply.readFlags(string(line[:2]), line[2:]); err != nil {
+		return int64(lline), err
 	}
 
 	return int64(lline), nil
 }
 
-func (reply *MetaSetReply) readFlags(data []byte) error {
+func (reply *MetaSetReply) readFlags(verb string, data []byte) error {
+	// flags start right after the two byte status code
+	const base = 2
 	rb := readbuf{data: data}
 	for rb.Len() > 0 {
 		// first, a space
 		if rb.data[0] != ' ' {
-			return fmt.Errorf(`expected space`)
+			return parseErrorf(verb, base+rb.NRead(), string(rb.data[:1]), `expected space`)
 		}
 		rb.Advance()
+		if rb.Len() == 0 {
+			return parseError(verb, base+rb.NRead(), ``, ErrUnexpectedEOL)
+		}
 
-		switch rb.data[0] {
+		pos := base + rb.NRead()
+		flag := rb.data[0]
+		rb.Advance()
+		switch flag {
 		case 'b':
-			rb.Advance()
 			reply.b64 = &FlagKeyAsBase64{}
 		case 'c':
-			rb.Advance()
-			var val strings.Builder
-			for rb.Len() > 0 && rb.data[0] != ' ' {
-				val.WriteByte(rb.data[0])
-				rb.Advance()
-			}
-
-			if val.Len() == 0 {
-				return fmt.Errorf(`expected value after c flag`)
+			val := rb.ReadToken()
+			if val == "" {
+				return parseErrorf(verb, pos, `c`, `expected value after c flag`)
 			}
 
-			u64, err := strconv.ParseUint(val.String(), 64, 10)
+			u64, err := strconv.ParseUint(val, 10, 64)
 			if err != nil {
-				return fmt.Errorf(`expected numeric value after c flag: %w`, err)
+				return parseError(verb, pos, `c`, fmt.Errorf(`expected numeric value after c flag: %w`, err))
 			}
 			casval := FlagRetrieveCas(u64)
 			reply.cas = &casval
 		case 'k':
-			rb.Advance()
-			var val strings.Builder
-			for rb.Len() > 0 && rb.data[0] != ' ' {
-				val.WriteByte(rb.data[0])
-				rb.Advance()
-			}
-
-			if val.Len() == 0 {
-				return fmt.Errorf(`expected value after k flag`)
+			key := rb.ReadToken()
+			if key == "" {
+				return parseErrorf(verb, pos, `k`, `expected value after k flag`)
 			}
-
-			key := val.String()
 			reply.rkey = &FlagRetrieveKey{key: &key}
+		case 'O':
+			val := rb.ReadTokenBytes()
+			if len(val) == 0 {
+				return parseErrorf(verb, pos, `O`, `expected value after O flag`)
+			}
+			opaque := FlagOpaque(val)
+			reply.opaque = &opaque
+		default:
+			return parseError(verb, pos, string(flag), ErrUnknownFlag)
 		}
 	}
 	return nil
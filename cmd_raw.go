@@ -0,0 +1,195 @@
+package memdproto
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// RawCmd represents a command whose verb is not modeled by this package,
+// such as "lru_crawler", "extstore", or vendor specific commands.
+//
+// A RawCmd keeps the command line exactly as it was read, along with
+// the data block that follows it if the CmdReader was told how to find
+// its length (see CmdReader.SetDataLengthField). It is re-encoded
+// byte-for-byte, so that proxies can pass such commands through to
+// the server untouched.
+type RawCmd struct {
+	line       []byte
+	data       []byte
+	terminator []byte
+}
+
+var _ Cmd = (*RawCmd)(nil)
+
+// NewRawCmd creates a new RawCmd from line, which must include the
+// line terminator.
+func NewRawCmd(line []byte) *RawCmd {
+	return &RawCmd{
+		line: line,
+	}
+}
+
+// Line returns the command line, including the line terminator
+func (cmd *RawCmd) Line() []byte {
+	return cmd.line
+}
+
+// Data returns the data block following the command line, without
+// its trailing CRLF. It returns nil if the command has no data block.
+func (cmd *RawCmd) Data() []byte {
+	return cmd.data
+}
+
+// SetData sets the data block that follows the command line.
+func (cmd *RawCmd) SetData(data []byte) *RawCmd {
+	cmd.data = data
+	return cmd
+}
+
+// SetReplyTerminator sets the line that terminates the reply to this
+// command. It is passed to the RawReply created by NewReply.
+func (cmd *RawCmd) SetReplyTerminator(terminator []byte) *RawCmd {
+	cmd.terminator = terminator
+	return cmd
+}
+
+func (cmd *RawCmd) Verb() string {
+	return string(firstToken(bytes.TrimRight(cmd.line, "\r\n")))
+}
+
+// Keys always returns nil, as the keys of an unknown command can not
+// be determined
+func (cmd *RawCmd) Keys() []string {
+	return nil
+}
+
+// NoReply returns true if the last token in the command line is "noreply",
+// which is the convention followed by memcached's own text commands
+func (cmd *RawCmd) NoReply() bool {
+	fields := bytes.Fields(cmd.line)
+	return len(fields) > 1 && bytes.Equal(fields[len(fields)-1], noreplyToken)
+}
+
+func (cmd *RawCmd) NewReply() Reply {
+	return NewRawReply(cmd.terminator)
+}
+
+func (cmd *RawCmd) WriteTo(dst io.Writer) (int64, error) {
+	var written int64
+	n, err := dst.Write(cmd.line)
+	written += int64(n)
+	if err != nil {
+		return written, err
+	}
+
+	if cmd.data != nil {
+		n, err = dst.Write(cmd.data)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+
+		n, err = dst.Write(crlf)
+		written += int64(n)
+	}
+	return written, err
+}
+
+// UnmarshalText stores the first line of data as the command line,
+// and anything after it as the data block, which must be terminated
+// by CRLF.
+func (cmd *RawCmd) UnmarshalText(data []byte) error {
+	*cmd = RawCmd{}
+
+	i := bytes.IndexByte(data, '\n')
+	if i < 0 {
+		return parseErrorf(string(firstToken(data)), len(data), ``, `expected line terminator`)
+	}
+	cmd.line = bytes.Clone(data[:i+1])
+
+	if rest := data[i+1:]; len(rest) > 0 {
+		if !bytes.HasSuffix(rest, crlf) {
+			return parseErrorf(cmd.Verb(), len(data), ``, `expected CRLF after data block`)
+		}
+		cmd.data = bytes.Clone(rest[:len(rest)-2])
+	}
+	return nil
+}
+
+// RawReply represents a reply that is read verbatim, up to and including
+// a caller-specified terminator line, such as "END\r\n". It is used for
+// replies to commands that are not modeled by this package.
+type RawReply struct {
+	terminator []byte
+	data       []byte
+}
+
+var _ Reply = (*RawReply)(nil)
+
+// NewRawReply creates a new RawReply that reads lines until it finds one
+// that is equal to terminator, including its line terminator. If terminator
+// is empty, exactly one line is read.
+//
+// Lines that start with ERROR, CLIENT_ERROR or SERVER_ERROR also end
+// the reply, as the server does not send anything else after an error.
+func NewRawReply(terminator []byte) *RawReply {
+	return &RawReply{
+		terminator: terminator,
+	}
+}
+
+// Terminator returns the line that terminates the reply
+func (reply *RawReply) Terminator() []byte {
+	return reply.terminator
+}
+
+// Bytes returns the reply exactly as it was read, including the terminator
+func (reply *RawReply) Bytes() []byte {
+	return reply.data
+}
+
+// SetBytes sets the reply data that is written by WriteTo
+func (reply *RawReply) SetBytes(data []byte) *RawReply {
+	reply.data = data
+	return reply
+}
+
+func (reply *RawReply) WriteTo(dst io.Writer) (int64, error) {
+	n, err := dst.Write(reply.data)
+	return int64(n), err
+}
+
+func (reply *RawReply) ReadFrom(src io.Reader) (int64, error) {
+	rdr := bufferedReader(src)
+
+	var buf bytes.Buffer
+	for {
+		line, err := rdr.ReadBytes('\n')
+		buf.Write(line)
+		if err != nil {
+			return int64(buf.Len()), parseError(string(firstToken(buf.Bytes())), buf.Len(), ``, fmt.Errorf(`failed to read reply: %w`, err))
+		}
+
+		if len(reply.terminator) == 0 || bytes.Equal(line, reply.terminator) || isErrorLine(line) {
+			break
+		}
+	}
+	reply.data = buf.Bytes()
+	return int64(buf.Len()), nil
+}
+
+func (reply *RawReply) UnmarshalText(data []byte) error {
+	return unmarshalReply(``, reply, data)
+}
+
+// isErrorLine returns true if line is an ERROR, CLIENT_ERROR,
+// or SERVER_ERROR reply
+func isErrorLine(line []byte) bool {
+	switch string(firstToken(bytes.TrimRight(line, "\r\n"))) {
+	case "ERROR", "CLIENT_ERROR", "SERVER_ERROR":
+		return true
+	default:
+		return false
+	}
+}
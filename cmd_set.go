@@ -12,14 +12,54 @@ import (
 type storageCmd struct {
 	cmdName string
 	key     string
-	flags   uint16
+	flags   uint32
 	expires int64
 	noreply bool
 	data    []byte
 	cas     uint64
 }
 
-func (cmd *storageCmd) SetFlags(flags uint16) *storageCmd {
+func (cmd *storageCmd) Key() string {
+	return cmd.key
+}
+
+func (cmd *storageCmd) Verb() string {
+	return cmd.cmdName
+}
+
+func (cmd *storageCmd) Keys() []string {
+	return []string{cmd.key}
+}
+
+func (cmd *storageCmd) NoReply() bool {
+	return cmd.noreply
+}
+
+func (cmd *storageCmd) NewReply() Reply {
+	return NewSetCmdReply()
+}
+
+// Flags returns the client flags to be stored along with the item
+func (cmd *storageCmd) Flags() uint32 {
+	return cmd.flags
+}
+
+// Expires returns the expiration time of the item, as sent on the wire
+func (cmd *storageCmd) Expires() int64 {
+	return cmd.expires
+}
+
+// Value returns the data block to be stored
+func (cmd *storageCmd) Value() []byte {
+	return cmd.data
+}
+
+// Cas returns the CAS unique value. It is only meaningful for CasCmd
+func (cmd *storageCmd) Cas() uint64 {
+	return cmd.cas
+}
+
+func (cmd *storageCmd) SetFlags(flags uint32) *storageCmd {
 	cmd.flags = flags
 	return cmd
 }
@@ -91,169 +131,152 @@ func (cmd *storageCmd) WriteTo(dst io.Writer) (int64, error) {
 	return written, err
 }
 
+// readStorageToken reads the run of bytes up to the next space or
+// control character
+func readStorageToken(data []byte) string {
+	var sb strings.Builder
+	for len(data) > 0 {
+		if data[0] == ' ' || data[0] > unicode.MaxASCII || unicode.IsControl(rune(data[0])) {
+			break
+		}
+		sb.WriteByte(data[0])
+		data = data[1:]
+	}
+	return sb.String()
+}
+
 func (cmd *storageCmd) UnmarshalText(data []byte) error {
-	// set, cas, add, append, prepend, replace (3, 3, 3, 6, 7, 7) bytes
 	ldata := len(data)
-	if ldata < 4 {
-		return fmt.Errorf("invalid storage command")
+	// offset returns the position of the current head of data
+	// relative to the beginning of the original input
+	offset := func() int {
+		return ldata - len(data)
 	}
 
-	switch data[0] {
-	case 's':
-		if !bytes.Equal(data[:3], setCmdName) {
-			return fmt.Errorf("invalid storage command: expected set command")
-		}
-		cmd.cmdName = "set"
-		data = data[3:]
-	case 'c':
-		if !bytes.Equal(data[:3], casCmdName) {
-			return fmt.Errorf("invalid storage command")
-		}
-		cmd.cmdName = "cas"
-		data = data[3:]
-	case 'a':
-		if ldata > 6 && bytes.Equal(data[:6], appendCmdName) {
-			cmd.cmdName = "append"
-			data = data[6:]
-		} else {
-			if !bytes.Equal(data[:3], addCmdName) {
-				return fmt.Errorf("invalid storage command")
-			}
-			cmd.cmdName = "add"
-			data = data[3:]
-		}
-	case 'r':
-		if ldata < 7 && !bytes.Equal(data[:6], replaceCmdName) {
-			return fmt.Errorf("invalid storage command")
-		}
-		cmd.cmdName = "replace"
-		data = data[7:]
-	case 'p':
-		if ldata < 7 && !bytes.Equal(data[:7], prependCmdName) {
-			return fmt.Errorf("invalid storage command")
+	// set, cas, add, append, prepend, replace (3, 3, 3, 6, 7, 7) bytes
+	verb := string(firstToken(data))
+	switch verb {
+	case "set", "cas", "add", "append", "prepend", "replace":
+		// objects such as SetCmd and AddCmd know their own command
+		// names, and should not be populated with anything else
+		if cmd.cmdName != "" && cmd.cmdName != verb {
+			return parseErrorf(``, 0, verb, `expected %s command`, cmd.cmdName)
 		}
-		data = data[7:]
+		cmd.cmdName = verb
+		data = data[len(verb):]
 	default:
-		return fmt.Errorf("invalid storage command: unknown command")
+		return parseErrorf(``, 0, verb, `invalid storage command: unknown command`)
 	}
 
 	// must be a space
 	if len(data) < 1 || data[0] != ' ' {
-		return fmt.Errorf("invalid storage command: missing space after command")
+		return parseErrorf(verb, offset(), ``, `missing space after command`)
 	}
 	data = data[1:]
 
-	var sb strings.Builder
-	for len(data) > 0 {
-		if data[0] == ' ' || data[0] > unicode.MaxASCII || unicode.IsControl(rune(data[0])) {
-			break
-		}
-		sb.WriteByte(data[0])
-		data = data[1:]
-	}
-	if sb.Len() == 0 {
-		return fmt.Errorf("invalid storage command: missing key")
+	key := readStorageToken(data)
+	if key == "" {
+		return parseErrorf(verb, offset(), ``, `missing key`)
 	}
-	cmd.key = sb.String()
+	cmd.key = key
+	data = data[len(key):]
 
 	// must be a space
 	if len(data) < 1 || data[0] != ' ' {
-		return fmt.Errorf("invalid storage command")
+		return parseErrorf(verb, offset(), ``, `missing space after key`)
 	}
 	data = data[1:]
 
 	// flags
-	sb.Reset()
-	for len(data) > 0 {
-		if data[0] == ' ' || data[0] > unicode.MaxASCII || unicode.IsControl(rune(data[0])) {
-			break
-		}
-		sb.WriteByte(data[0])
-		data = data[1:]
-	}
-	if sb.Len() == 0 {
-		return fmt.Errorf("invalid storage command: missing flags")
+	tok := readStorageToken(data)
+	if tok == "" {
+		return parseErrorf(verb, offset(), ``, `missing flags`)
 	}
 
-	u16, err := strconv.ParseUint(sb.String(), 10, 16)
+	u32, err := strconv.ParseUint(tok, 10, 32)
 	if err != nil {
-		return fmt.Errorf("invalid storage command: invalid flags")
+		return parseError(verb, offset(), tok, fmt.Errorf(`invalid flags: %w`, err))
 	}
-	cmd.flags = uint16(u16)
+	cmd.flags = uint32(u32)
+	data = data[len(tok):]
 
 	// must be a space
 	if len(data) < 1 || data[0] != ' ' {
-		return fmt.Errorf("invalid storage command")
+		return parseErrorf(verb, offset(), ``, `missing space after flags`)
 	}
 	data = data[1:]
 
 	// expires
-	sb.Reset()
-	for len(data) > 0 {
-		if data[0] == ' ' || data[0] > unicode.MaxASCII || unicode.IsControl(rune(data[0])) {
-			break
-		}
-		sb.WriteByte(data[0])
-		data = data[1:]
-	}
-	if sb.Len() == 0 {
-		return fmt.Errorf("invalid storage command: missing expires")
+	tok = readStorageToken(data)
+	if tok == "" {
+		return parseErrorf(verb, offset(), ``, `missing expires`)
 	}
 
-	i64, err := strconv.ParseInt(sb.String(), 10, 64)
+	i64, err := strconv.ParseInt(tok, 10, 64)
 	if err != nil {
-		return fmt.Errorf("invalid storage command: invalid expires")
+		return parseError(verb, offset(), tok, fmt.Errorf(`invalid expires: %w`, err))
 	}
 	cmd.expires = i64
+	data = data[len(tok):]
 
 	// must be a space
 	if len(data) < 1 || data[0] != ' ' {
-		return fmt.Errorf("invalid storage command")
+		return parseErrorf(verb, offset(), ``, `missing space after expires`)
 	}
 	data = data[1:]
 
 	// data length
-	sb.Reset()
-	for len(data) > 0 {
-		if data[0] == ' ' || data[0] > unicode.MaxASCII || unicode.IsControl(rune(data[0])) {
-			break
-		}
-		sb.WriteByte(data[0])
-		data = data[1:]
-	}
-	if sb.Len() == 0 {
-		return fmt.Errorf("invalid storage command: missing data length")
+	tok = readStorageToken(data)
+	if tok == "" {
+		return parseErrorf(verb, offset(), ``, `missing data length`)
 	}
 
-	datalen, err := strconv.ParseUint(sb.String(), 10, 64)
+	datalen, err := strconv.ParseUint(tok, 10, 64)
 	if err != nil {
-		return fmt.Errorf("invalid storage command: invalid data length")
+		return parseError(verb, offset(), tok, fmt.Errorf(`invalid data length: %w`, err))
+	}
+	data = data[len(tok):]
+
+	// cas commands carry the cas unique value after the data length
+	if verb == "cas" {
+		if len(data) < 1 || data[0] != ' ' {
+			return parseErrorf(verb, offset(), ``, `missing space after data length`)
+		}
+		data = data[1:]
+
+		tok = readStorageToken(data)
+		u64, err := strconv.ParseUint(tok, 10, 64)
+		if err != nil {
+			return parseError(verb, offset(), tok, fmt.Errorf(`invalid cas unique: %w`, err))
+		}
+		cmd.cas = u64
+		data = data[len(tok):]
 	}
 
 	// if there's a space, we're expecting "noreply"
 	if len(data) > 0 && data[0] == ' ' {
 		data = data[1:]
 		if len(data) < 7 || !bytes.Equal(data[:7], []byte("noreply")) {
-			return fmt.Errorf("invalid storage command: expected noreply")
+			return parseErrorf(verb, offset(), string(firstToken(data)), `expected noreply`)
 		}
 		cmd.noreply = true
 		data = data[7:]
 	}
 
-	if !bytes.Equal(data[:2], crlf) {
-		return fmt.Errorf("invalid storage command: expected CRLF")
+	if !bytes.HasPrefix(data, crlf) {
+		return parseErrorf(verb, offset(), ``, `expected CRLF`)
 	}
 	data = data[2:]
 
 	if uint64(len(data)) < datalen {
-		return fmt.Errorf("invalid storage command: data length mismatch")
+		return parseError(verb, offset(), ``, fmt.Errorf(`data length mismatch: %w`, ErrUnexpectedEOL))
 	}
 
 	cmd.data = data[:datalen]
 	data = data[datalen:]
 
 	if !bytes.Equal(data, crlf) {
-		return fmt.Errorf("invalid storage command: expected CRLF")
+		return parseErrorf(verb, offset(), ``, `expected CRLF after data block`)
 	}
 	return nil
 }
@@ -406,6 +429,15 @@ type SetCmdReply struct {
 
 var _ Reply = (*SetCmdReply)(nil)
 
+func NewSetCmdReply() *SetCmdReply {
+	return &SetCmdReply{}
+}
+
+func (cmd *SetCmdReply) SetStatus(status SetCmdReplyType) *SetCmdReply {
+	cmd.status = status
+	return cmd
+}
+
 func (cmd *SetCmdReply) Status() SetCmdReplyType {
 	return cmd.status
 }
@@ -451,29 +483,32 @@ var setCmdReplyNotStored = []byte("NOT_STORED")
 var setCmdReplyExists = []byte("EXISTS")
 var setCmdReplyNotFound = []byte("NOT_FOUND")
 
+func (reply *SetCmdReply) ReadFrom(src io.Reader) (int64, error) {
+	line, err := bufferedReader(src).ReadBytes('\n')
+	if err != nil {
+		return int64(len(line)), parseError(string(firstToken(line)), len(line), ``, err)
+	}
+	return int64(len(line)), reply.UnmarshalText(line)
+}
+
 func (reply *SetCmdReply) UnmarshalText(data []byte) error {
 	ldata := len(data)
-	if ldata == len(setCmdReplyStored)+2 { // STORED or EXISTS followed by CRLF
-		switch {
-		case bytes.Equal(data, setCmdReplyStored):
-			reply.status = SetCmdReplyStored
-		case bytes.Equal(data, setCmdReplyExists):
-			reply.status = SetCmdReplyExists
-		default:
-			return fmt.Errorf("invalid set command reply")
-		}
-	} else if ldata == len(setCmdReplyNotStored)+2 { // NOT_STORED followed by CRLF
-		if !bytes.Equal(data, setCmdReplyNotStored) {
-			return fmt.Errorf("invalid set command reply")
-		}
+	if ldata < 2 || !bytes.Equal(data[ldata-2:], crlf) {
+		return parseErrorf(string(firstToken(data)), ldata, ``, `expected CRLF`)
+	}
+	data = data[:ldata-2]
+
+	switch {
+	case bytes.Equal(data, setCmdReplyStored):
+		reply.status = SetCmdReplyStored
+	case bytes.Equal(data, setCmdReplyExists):
+		reply.status = SetCmdReplyExists
+	case bytes.Equal(data, setCmdReplyNotStored):
 		reply.status = SetCmdReplyNotStored
-	} else if ldata == len(setCmdReplyNotFound)+2 { // NOT_FOUND followed by CRLF
-		if !bytes.Equal(data, setCmdReplyNotFound) {
-			return fmt.Errorf("invalid set command reply")
-		}
+	case bytes.Equal(data, setCmdReplyNotFound):
 		reply.status = SetCmdReplyNotFound
-	} else {
-		return fmt.Errorf("invalid set command reply")
+	default:
+		return parseErrorf(``, 0, string(firstToken(data)), `invalid set command reply`)
 	}
 	return nil
 }
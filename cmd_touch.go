@@ -0,0 +1,212 @@
+package memdproto
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// TouchCmd represents the classic touch command, which updates
+// the expiration time of an item without fetching it
+type TouchCmd struct {
+	key     string
+	expires int64
+	noreply bool
+}
+
+var _ Cmd = (*TouchCmd)(nil)
+
+func NewTouchCmd(key string, expires int64) *TouchCmd {
+	return &TouchCmd{
+		key:     key,
+		expires: expires,
+	}
+}
+
+func (cmd *TouchCmd) Key() string {
+	return cmd.key
+}
+
+func (cmd *TouchCmd) Verb() string {
+	return "touch"
+}
+
+func (cmd *TouchCmd) Keys() []string {
+	return []string{cmd.key}
+}
+
+func (cmd *TouchCmd) NoReply() bool {
+	return cmd.noreply
+}
+
+func (cmd *TouchCmd) NewReply() Reply {
+	return NewTouchCmdReply()
+}
+
+// Expires returns the new expiration time of the item, as sent on the wire
+func (cmd *TouchCmd) Expires() int64 {
+	return cmd.expires
+}
+
+func (cmd *TouchCmd) SetExpires(expires int64) *TouchCmd {
+	cmd.expires = expires
+	return cmd
+}
+
+func (cmd *TouchCmd) SetNoReply(noreply bool) *TouchCmd {
+	cmd.noreply = noreply
+	return cmd
+}
+
+func (cmd *TouchCmd) WriteTo(dst io.Writer) (int64, error) {
+	var written int64
+
+	n, err := fmt.Fprintf(dst, "touch %s %d", cmd.key, cmd.expires)
+	written += int64(n)
+	if err != nil {
+		return written, err
+	}
+
+	if cmd.noreply {
+		n, err := fmt.Fprintf(dst, " noreply")
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+
+	n, err = dst.Write(crlf)
+	written += int64(n)
+	return written, err
+}
+
+var touchCmdName = []byte("touch")
+
+func (cmd *TouchCmd) UnmarshalText(data []byte) error {
+	*cmd = TouchCmd{}
+
+	ldata := len(data)
+	if !bytes.HasPrefix(data, touchCmdName) {
+		return parseErrorf(``, 0, string(firstToken(data)), `invalid touch command`)
+	}
+	data = data[len(touchCmdName):]
+
+	if !bytes.HasSuffix(data, crlf) {
+		return parseErrorf(`touch`, ldata, ``, `expected CRLF`)
+	}
+	data = data[:len(data)-2]
+
+	if len(data) == 0 || data[0] != ' ' {
+		return parseErrorf(`touch`, len(touchCmdName), ``, `missing space after command`)
+	}
+
+	fields := bytes.Split(data[1:], space)
+	switch {
+	case len(fields) < 2:
+		return parseError(`touch`, ldata-2, ``, fmt.Errorf(`missing exptime: %w`, ErrUnexpectedEOL))
+	case len(fields) == 2:
+	case len(fields) == 3 && bytes.Equal(fields[2], noreplyToken):
+		cmd.noreply = true
+	default:
+		return parseErrorf(`touch`, len(touchCmdName)+1+len(fields[0])+1+len(fields[1])+1, string(fields[2]), `expected noreply`)
+	}
+
+	key, count, err := readBytes(fields[0], 250)
+	if err == nil && count != len(fields[0]) {
+		err = fmt.Errorf(`unexpected character %q`, fields[0][count])
+	}
+	if err != nil {
+		return parseError(`touch`, len(touchCmdName)+1, string(fields[0]), fmt.Errorf(`invalid key: %w`, err))
+	}
+
+	expires, err := strconv.ParseInt(string(fields[1]), 10, 64)
+	if err != nil {
+		return parseError(`touch`, len(touchCmdName)+1+len(fields[0])+1, string(fields[1]), fmt.Errorf(`invalid exptime: %w`, err))
+	}
+
+	cmd.key = string(key)
+	cmd.expires = expires
+	return nil
+}
+
+type TouchCmdReplyType uint8
+
+const (
+	TouchCmdReplyInvalid TouchCmdReplyType = iota
+	TouchCmdReplyTouched
+	TouchCmdReplyNotFound
+	TouchCmdReplyTypeMax
+)
+
+var touchCmdReplyTouched = []byte("TOUCHED")
+var touchCmdReplyNotFound = []byte("NOT_FOUND")
+
+// TouchCmdReply represents the reply to a classic touch command
+type TouchCmdReply struct {
+	status TouchCmdReplyType
+}
+
+var _ Reply = (*TouchCmdReply)(nil)
+
+func NewTouchCmdReply() *TouchCmdReply {
+	return &TouchCmdReply{}
+}
+
+func (reply *TouchCmdReply) Status() TouchCmdReplyType {
+	return reply.status
+}
+
+func (reply *TouchCmdReply) SetStatus(status TouchCmdReplyType) *TouchCmdReply {
+	reply.status = status
+	return reply
+}
+
+func (reply *TouchCmdReply) WriteTo(dst io.Writer) (int64, error) {
+	var status []byte
+	switch reply.status {
+	case TouchCmdReplyTouched:
+		status = touchCmdReplyTouched
+	case TouchCmdReplyNotFound:
+		status = touchCmdReplyNotFound
+	default:
+		return 0, fmt.Errorf("invalid touch command reply")
+	}
+
+	var written int64
+	n, err := dst.Write(status)
+	written += int64(n)
+	if err != nil {
+		return written, err
+	}
+
+	n, err = dst.Write(crlf)
+	written += int64(n)
+	return written, err
+}
+
+func (reply *TouchCmdReply) ReadFrom(src io.Reader) (int64, error) {
+	line, err := bufferedReader(src).ReadBytes('\n')
+	if err != nil {
+		return int64(len(line)), parseError(string(firstToken(line)), len(line), ``, err)
+	}
+	return int64(len(line)), reply.UnmarshalText(line)
+}
+
+func (reply *TouchCmdReply) UnmarshalText(data []byte) error {
+	ldata := len(data)
+	if ldata < 2 || !bytes.Equal(data[ldata-2:], crlf) {
+		return parseErrorf(string(firstToken(data)), ldata, ``, `expected CRLF`)
+	}
+	data = data[:ldata-2]
+
+	switch {
+	case bytes.Equal(data, touchCmdReplyTouched):
+		reply.status = TouchCmdReplyTouched
+	case bytes.Equal(data, touchCmdReplyNotFound):
+		reply.status = TouchCmdReplyNotFound
+	default:
+		return parseErrorf(``, 0, string(firstToken(data)), `invalid touch command reply`)
+	}
+	return nil
+}
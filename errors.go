@@ -0,0 +1,78 @@
+package memdproto
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrUnknownFlag is the cause reported by a ParseError when a meta
+// command or reply contains a flag that is not known for its verb.
+var ErrUnknownFlag = errors.New("unknown flag")
+
+// ErrUnknownVerb is the cause reported by a ParseError when a command
+// verb is not supported by the code handling it. Note that CmdReader
+// does not report it, and returns such commands as *RawCmd instead.
+var ErrUnknownVerb = errors.New("unknown verb")
+
+// ErrUnexpectedEOL is the cause reported by a ParseError when the
+// input ends before the command or reply is complete.
+var ErrUnexpectedEOL = errors.New("unexpected end of line")
+
+// ErrDataTooLarge is the cause reported by a ParseError when the data
+// block of a command is longer than allowed by CmdReader.SetMaxDataLength.
+var ErrDataTooLarge = errors.New("data block too large")
+
+// ParseError is returned by all UnmarshalText and ReadFrom methods
+// in this package when the input cannot be parsed.
+//
+// Verb is the command (e.g. "mg", "set") or reply (e.g. "VA", "STORED")
+// being parsed, if it could be determined. Offset is the byte offset,
+// relative to the beginning of the line, at which the problem was detected.
+// Token contains the offending flag or token, if any.
+//
+// The underlying cause is available through errors.Unwrap, so it is
+// possible to use errors.Is to check for causes such as ErrUnknownFlag
+// or io.EOF.
+type ParseError struct {
+	Verb   string
+	Offset int
+	Token  string
+	Err    error
+}
+
+func (e *ParseError) Error() string {
+	var sb strings.Builder
+	sb.WriteString("memdproto: failed to parse")
+	if e.Verb != "" {
+		fmt.Fprintf(&sb, " %s", e.Verb)
+	}
+	fmt.Fprintf(&sb, " at offset %d", e.Offset)
+	if e.Token != "" {
+		fmt.Fprintf(&sb, " near %q", e.Token)
+	}
+	if e.Err != nil {
+		fmt.Fprintf(&sb, ": %s", e.Err)
+	}
+	return sb.String()
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// parseError creates a new ParseError with err as its cause.
+func parseError(verb string, offset int, token string, err error) *ParseError {
+	return &ParseError{
+		Verb:   verb,
+		Offset: offset,
+		Token:  token,
+		Err:    err,
+	}
+}
+
+// parseErrorf creates a new ParseError whose cause is created
+// from the given format and arguments.
+func parseErrorf(verb string, offset int, token string, format string, args ...interface{}) *ParseError {
+	return parseError(verb, offset, token, fmt.Errorf(format, args...))
+}
@@ -0,0 +1,1248 @@
+package memdproto
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// This file contains the JSON and debug (String) representations of
+// all commands and replies.
+//
+// The JSON representations are lossless: unmarshaling the JSON produced
+// by MarshalJSON results in a command or reply that encodes to the same
+// bytes on the wire. Keys are stored as they appear on the wire, so a
+// key sent in base64 mode ("b" flag) is stored in its encoded form along
+// with "base64": true, even though commands hold their keys decoded.
+// Values and opaque tokens are stored as []byte, and are therefore
+// encoded in base64 by encoding/json.
+
+// debugString renders the wire representation of v on a single line,
+// suitable for logging. The trailing CRLF is removed, and the data block,
+// if any, is quoted so that binary values do not corrupt the output.
+func debugString(v io.WriterTo) string {
+	var buf bytes.Buffer
+	if _, err := v.WriteTo(&buf); err != nil {
+		return fmt.Sprintf("<invalid: %s>", err)
+	}
+
+	data := bytes.TrimSuffix(buf.Bytes(), crlf)
+	line, block, found := bytes.Cut(data, crlf)
+	if !found {
+		return string(line)
+	}
+	return string(line) + " " + strconv.Quote(string(block))
+}
+
+type jsonInteger interface {
+	~uint8 | ~uint16 | ~uint32 | ~uint64 | ~int64
+}
+
+// convertPtr converts between pointers to integer types, so that flags
+// can be converted to and from their JSON counterparts
+func convertPtr[U, T jsonInteger](v *T) *U {
+	if v == nil {
+		return nil
+	}
+	u := U(*v)
+	return &u
+}
+
+// flagIf returns a new flag if b is true, and nil otherwise
+func flagIf[T any](b bool) *T {
+	if b {
+		return new(T)
+	}
+	return nil
+}
+
+func checkJSONVerb(want, got string) error {
+	if got != "" && got != want {
+		return fmt.Errorf(`memdproto: expected verb %q in JSON, got %q`, want, got)
+	}
+	return nil
+}
+
+// jsonCmdKey returns the key of a meta command as it appears on
+// the wire, which is base64 encoded if b64 is true
+func jsonCmdKey(key string, b64 bool) string {
+	if !b64 {
+		return key
+	}
+	return base64.StdEncoding.EncodeToString([]byte(key))
+}
+
+// decodeJSONCmdKey is the inverse of jsonCmdKey
+func decodeJSONCmdKey(key string, b64 bool) (string, error) {
+	if !b64 {
+		return key, nil
+	}
+	decoded, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return "", fmt.Errorf(`memdproto: failed to decode base64 key in JSON: %w`, err)
+	}
+	return string(decoded), nil
+}
+
+func jsonReplyKey(rkey *FlagRetrieveKey) string {
+	if rkey == nil || rkey.key == nil {
+		return ""
+	}
+	return *rkey.key
+}
+
+func jsonRetrieveKey(s string) *FlagRetrieveKey {
+	if s == "" {
+		return nil
+	}
+	return &FlagRetrieveKey{key: &s}
+}
+
+func (cmd *MetaGetCmd) String() string {
+	return debugString(cmd)
+}
+
+type metaGetCmdJSON struct {
+	Verb                        string  `json:"verb"`
+	Key                         string  `json:"key"`
+	Base64                      bool    `json:"base64,omitempty"`
+	RetrieveCas                 bool    `json:"retrieve_cas,omitempty"`
+	NewCas                      *uint64 `json:"new_cas,omitempty"`
+	RetrieveClientFlags         bool    `json:"retrieve_client_flags,omitempty"`
+	RetrievePreviousHit         bool    `json:"retrieve_previous_hit,omitempty"`
+	RetrieveKey                 bool    `json:"retrieve_key,omitempty"`
+	RetrieveTimeSinceLastAccess bool    `json:"retrieve_time_since_last_access,omitempty"`
+	Opaque                      []byte  `json:"opaque,omitempty"`
+	VivifyOnMiss                *uint64 `json:"vivify_on_miss,omitempty"`
+	NoReply                     bool    `json:"noreply,omitempty"`
+	Recache                     *uint64 `json:"recache,omitempty"`
+	RetrieveSize                bool    `json:"retrieve_size,omitempty"`
+	RetrieveRemainingTTL        bool    `json:"retrieve_remaining_ttl,omitempty"`
+	UpdateTTL                   *int64  `json:"update_ttl,omitempty"`
+	SkipLRUBump                 bool    `json:"skip_lru_bump,omitempty"`
+	RetrieveValue               bool    `json:"retrieve_value,omitempty"`
+}
+
+func (cmd *MetaGetCmd) MarshalJSON() ([]byte, error) {
+	return json.Marshal(metaGetCmdJSON{
+		Verb:                        cmd.Verb(),
+		Key:                         jsonCmdKey(cmd.key, cmd.b64 != nil),
+		Base64:                      cmd.b64 != nil,
+		RetrieveCas:                 cmd.cas != nil,
+		NewCas:                      convertPtr[uint64](cmd.ncas),
+		RetrieveClientFlags:         cmd.clientFlags != nil,
+		RetrievePreviousHit:         cmd.prevHit != nil,
+		RetrieveKey:                 cmd.rkey != nil,
+		RetrieveTimeSinceLastAccess: cmd.timeSinceLastAccess != nil,
+		Opaque:                      cmd.opaque,
+		VivifyOnMiss:                convertPtr[uint64](cmd.vivify),
+		NoReply:                     cmd.noreply != nil,
+		Recache:                     convertPtr[uint64](cmd.recache),
+		RetrieveSize:                cmd.itemSize != nil,
+		RetrieveRemainingTTL:        cmd.remainingTTL != nil,
+		UpdateTTL:                   convertPtr[int64](cmd.updateTTL),
+		SkipLRUBump:                 cmd.skipLRUBump != nil,
+		RetrieveValue:               cmd.value != nil,
+	})
+}
+
+func (cmd *MetaGetCmd) UnmarshalJSON(data []byte) error {
+	var v metaGetCmdJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if err := checkJSONVerb(cmd.Verb(), v.Verb); err != nil {
+		return err
+	}
+
+	key, err := decodeJSONCmdKey(v.Key, v.Base64)
+	if err != nil {
+		return err
+	}
+
+	*cmd = MetaGetCmd{
+		key:                 key,
+		b64:                 flagIf[FlagKeyAsBase64](v.Base64),
+		cas:                 flagIf[FlagRetrieveCas](v.RetrieveCas),
+		ncas:                convertPtr[FlagNewCas](v.NewCas),
+		clientFlags:         flagIf[FlagRetrieveClientFlags](v.RetrieveClientFlags),
+		prevHit:             flagIf[FlagRetrievePreviousHit](v.RetrievePreviousHit),
+		rkey:                flagIf[FlagRetrieveKey](v.RetrieveKey),
+		timeSinceLastAccess: flagIf[FlagRetrieveTimeSinceLastAccess](v.RetrieveTimeSinceLastAccess),
+		opaque:              FlagOpaque(v.Opaque),
+		vivify:              convertPtr[FlagVivifyOnMiss](v.VivifyOnMiss),
+		noreply:             flagIf[FlagNoReply](v.NoReply),
+		recache:             convertPtr[FlagRecache](v.Recache),
+		itemSize:            flagIf[FlagRetrieveSize](v.RetrieveSize),
+		remainingTTL:        flagIf[FlagRetrieveRemainingTTL](v.RetrieveRemainingTTL),
+		updateTTL:           convertPtr[FlagUpdateTTL](v.UpdateTTL),
+		skipLRUBump:         flagIf[FlagSkipLRUBump](v.SkipLRUBump),
+		value:               flagIf[FlagRetrieveValue](v.RetrieveValue),
+	}
+	return nil
+}
+
+func (mr *MetaGetReply) String() string {
+	return debugString(mr)
+}
+
+type metaGetReplyJSON struct {
+	Status              string  `json:"status"`
+	Value               []byte  `json:"value,omitempty"`
+	Base64              bool    `json:"base64,omitempty"`
+	Cas                 *uint64 `json:"cas,omitempty"`
+	ClientFlags         *uint32 `json:"client_flags,omitempty"`
+	PreviousHit         *bool   `json:"previous_hit,omitempty"`
+	Key                 string  `json:"key,omitempty"`
+	TimeSinceLastAccess *uint64 `json:"time_since_last_access,omitempty"`
+	Opaque              []byte  `json:"opaque,omitempty"`
+	Size                *uint64 `json:"size,omitempty"`
+	RemainingTTL        *int64  `json:"remaining_ttl,omitempty"`
+	RecacheWon          *bool   `json:"recache_won,omitempty"`
+	Stale               bool    `json:"stale,omitempty"`
+}
+
+func (mr *MetaGetReply) MarshalJSON() ([]byte, error) {
+	v := metaGetReplyJSON{
+		Status:      "HD",
+		Value:       mr.value,
+		Base64:      mr.b64 != nil,
+		Cas:         convertPtr[uint64](mr.cas),
+		ClientFlags: convertPtr[uint32](mr.clientFlags),
+		Key:         jsonReplyKey(mr.rkey),
+		Opaque:      mr.opaque,
+		Stale:       mr.stale != nil,
+	}
+
+	switch {
+	case mr.miss:
+		v.Status = "EN"
+	case mr.value != nil:
+		v.Status = "VA"
+	}
+
+	if mr.prevHit != nil {
+		hit := mr.prevHit.hit != nil && *mr.prevHit.hit == 1
+		v.PreviousHit = &hit
+	}
+	if mr.timeSinceLastAccess != nil {
+		v.TimeSinceLastAccess = mr.timeSinceLastAccess.value
+	}
+	if mr.itemSize != nil {
+		v.Size = mr.itemSize.value
+	}
+	if mr.remainingTTL != nil {
+		v.RemainingTTL = mr.remainingTTL.value
+	}
+	if mr.recacheResult != nil {
+		won := mr.recacheResult.won
+		v.RecacheWon = &won
+	}
+	return json.Marshal(v)
+}
+
+func (mr *MetaGetReply) UnmarshalJSON(data []byte) error {
+	var v metaGetReplyJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	*mr = MetaGetReply{
+		b64:         flagIf[FlagKeyAsBase64](v.Base64),
+		cas:         convertPtr[FlagRetrieveCas](v.Cas),
+		clientFlags: convertPtr[FlagRetrieveClientFlags](v.ClientFlags),
+		rkey:        jsonRetrieveKey(v.Key),
+		opaque:      FlagOpaque(v.Opaque),
+		stale:       flagIf[FlagStale](v.Stale),
+	}
+
+	switch v.Status {
+	case "EN":
+		mr.miss = true
+	case "HD":
+	case "VA":
+		mr.value = v.Value
+		if mr.value == nil {
+			mr.value = []byte{}
+		}
+	default:
+		return fmt.Errorf(`memdproto: invalid mg reply status %q in JSON`, v.Status)
+	}
+
+	if v.PreviousHit != nil {
+		mr.SetPreviousHit(*v.PreviousHit)
+	}
+	if v.TimeSinceLastAccess != nil {
+		mr.SetTimeSinceLastAccess(*v.TimeSinceLastAccess)
+	}
+	if v.Size != nil {
+		mr.SetItemSize(*v.Size)
+	}
+	if v.RemainingTTL != nil {
+		mr.SetRemainingTTL(*v.RemainingTTL)
+	}
+	if v.RecacheWon != nil {
+		mr.SetRecacheResult(*v.RecacheWon)
+	}
+	return nil
+}
+
+var metaSetModeNames = map[MetaSetMode]string{
+	MetaSetModeSet:     "set",
+	MetaSetModeAdd:     "add",
+	MetaSetModeAppend:  "append",
+	MetaSetModePrepend: "prepend",
+	MetaSetModeReplace: "replace",
+}
+
+func (cmd *MetaSetCmd) String() string {
+	return debugString(cmd)
+}
+
+type metaSetCmdJSON struct {
+	Verb               string  `json:"verb"`
+	Key                string  `json:"key"`
+	Base64             bool    `json:"base64,omitempty"`
+	Value              []byte  `json:"value"`
+	RetrieveCas        bool    `json:"retrieve_cas,omitempty"`
+	CompareCas         *uint64 `json:"compare_cas,omitempty"`
+	NewCas             *uint64 `json:"new_cas,omitempty"`
+	ClientFlags        *uint32 `json:"client_flags,omitempty"`
+	InvalidateOnOldCas bool    `json:"invalidate,omitempty"`
+	RetrieveKey        bool    `json:"retrieve_key,omitempty"`
+	Opaque             []byte  `json:"opaque,omitempty"`
+	Mode               string  `json:"mode,omitempty"`
+	NoReply            bool    `json:"noreply,omitempty"`
+	TTL                *int64  `json:"ttl,omitempty"`
+}
+
+func (cmd *MetaSetCmd) MarshalJSON() ([]byte, error) {
+	v := metaSetCmdJSON{
+		Verb:               cmd.Verb(),
+		Key:                jsonCmdKey(cmd.key, cmd.b64 != nil),
+		Base64:             cmd.b64 != nil,
+		Value:              cmd.data,
+		RetrieveCas:        cmd.cas != nil,
+		CompareCas:         convertPtr[uint64](cmd.ccas),
+		NewCas:             convertPtr[uint64](cmd.ncas),
+		ClientFlags:        convertPtr[uint32](cmd.flags),
+		InvalidateOnOldCas: cmd.invalidate != nil,
+		RetrieveKey:        cmd.rkey != nil,
+		Opaque:             cmd.opaque,
+		NoReply:            cmd.noreply != nil,
+		TTL:                convertPtr[int64](cmd.ttl),
+	}
+	if cmd.mode != nil {
+		name, ok := metaSetModeNames[*cmd.mode]
+		if !ok {
+			return nil, fmt.Errorf(`memdproto: invalid ms mode %d`, *cmd.mode)
+		}
+		v.Mode = name
+	}
+	return json.Marshal(v)
+}
+
+func (cmd *MetaSetCmd) UnmarshalJSON(data []byte) error {
+	var v metaSetCmdJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if err := checkJSONVerb(cmd.Verb(), v.Verb); err != nil {
+		return err
+	}
+
+	key, err := decodeJSONCmdKey(v.Key, v.Base64)
+	if err != nil {
+		return err
+	}
+
+	*cmd = MetaSetCmd{
+		key:        key,
+		data:       v.Value,
+		b64:        flagIf[FlagKeyAsBase64](v.Base64),
+		cas:        flagIf[FlagRetrieveCas](v.RetrieveCas),
+		ccas:       convertPtr[FlagCompareCas](v.CompareCas),
+		ncas:       convertPtr[FlagNewCas](v.NewCas),
+		flags:      convertPtr[FlagSetClientFlags](v.ClientFlags),
+		invalidate: flagIf[FlagInvalidateOnOldCas](v.InvalidateOnOldCas),
+		rkey:       flagIf[FlagRetrieveKey](v.RetrieveKey),
+		opaque:     FlagOpaque(v.Opaque),
+		noreply:    flagIf[FlagNoReply](v.NoReply),
+		ttl:        convertPtr[FlagSetTTL](v.TTL),
+	}
+
+	if v.Mode != "" {
+		var found bool
+		for mode, name := range metaSetModeNames {
+			if name == v.Mode {
+				cmd.mode = &mode
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf(`memdproto: invalid ms mode %q in JSON`, v.Mode)
+		}
+	}
+	return nil
+}
+
+func (reply *MetaSetReply) String() string {
+	return debugString(reply)
+}
+
+type metaSetReplyJSON struct {
+	Status string  `json:"status"`
+	Base64 bool    `json:"base64,omitempty"`
+	Cas    *uint64 `json:"cas,omitempty"`
+	Key    string  `json:"key,omitempty"`
+	Opaque []byte  `json:"opaque,omitempty"`
+}
+
+func (reply *MetaSetReply) MarshalJSON() ([]byte, error) {
+	code, err := reply.status.code()
+	if err != nil {
+		return nil, err
+	}
+
+	v := metaSetReplyJSON{
+		Status: string(code),
+		Base64: reply.b64 != nil,
+		Cas:    convertPtr[uint64](reply.cas),
+		Key:    jsonReplyKey(reply.rkey),
+	}
+	if reply.opaque != nil {
+		v.Opaque = *reply.opaque
+	}
+	return json.Marshal(v)
+}
+
+func (reply *MetaSetReply) UnmarshalJSON(data []byte) error {
+	var v metaSetReplyJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	*reply = MetaSetReply{
+		b64:  flagIf[FlagKeyAsBase64](v.Base64),
+		cas:  convertPtr[FlagRetrieveCas](v.Cas),
+		rkey: jsonRetrieveKey(v.Key),
+	}
+	if v.Opaque != nil {
+		reply.SetOpaque(v.Opaque)
+	}
+
+	for status := MetaSetCmdStatusStored; status <= MetaSetCmdStatusNotFound; status++ {
+		if code, _ := status.code(); string(code) == v.Status {
+			reply.status = status
+			return nil
+		}
+	}
+	return fmt.Errorf(`memdproto: invalid ms reply status %q in JSON`, v.Status)
+}
+
+func (cmd *MetaDeleteCmd) String() string {
+	return debugString(cmd)
+}
+
+type metaDeleteCmdJSON struct {
+	Verb        string  `json:"verb"`
+	Key         string  `json:"key"`
+	Base64      bool    `json:"base64,omitempty"`
+	CompareCas  *uint64 `json:"compare_cas,omitempty"`
+	NewCas      *uint64 `json:"new_cas,omitempty"`
+	RetrieveKey bool    `json:"retrieve_key,omitempty"`
+	Invalidate  bool    `json:"invalidate,omitempty"`
+	Opaque      []byte  `json:"opaque,omitempty"`
+	NoReply     bool    `json:"noreply,omitempty"`
+	UpdateTTL   *int64  `json:"update_ttl,omitempty"`
+}
+
+func (cmd *MetaDeleteCmd) MarshalJSON() ([]byte, error) {
+	return json.Marshal(metaDeleteCmdJSON{
+		Verb:        cmd.Verb(),
+		Key:         jsonCmdKey(cmd.key, cmd.b64 != nil),
+		Base64:      cmd.b64 != nil,
+		CompareCas:  convertPtr[uint64](cmd.ccas),
+		NewCas:      convertPtr[uint64](cmd.ncas),
+		RetrieveKey: cmd.rkey != nil,
+		Invalidate:  cmd.invalidate != nil,
+		Opaque:      cmd.opaque,
+		NoReply:     cmd.noreply != nil,
+		UpdateTTL:   convertPtr[int64](cmd.ttl),
+	})
+}
+
+func (cmd *MetaDeleteCmd) UnmarshalJSON(data []byte) error {
+	var v metaDeleteCmdJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if err := checkJSONVerb(cmd.Verb(), v.Verb); err != nil {
+		return err
+	}
+
+	key, err := decodeJSONCmdKey(v.Key, v.Base64)
+	if err != nil {
+		return err
+	}
+
+	*cmd = MetaDeleteCmd{
+		key:        key,
+		b64:        flagIf[FlagKeyAsBase64](v.Base64),
+		ccas:       convertPtr[FlagCompareCas](v.CompareCas),
+		ncas:       convertPtr[FlagNewCas](v.NewCas),
+		rkey:       flagIf[FlagRetrieveKey](v.RetrieveKey),
+		invalidate: flagIf[FlagInvalidateOnOldCas](v.Invalidate),
+		opaque:     FlagOpaque(v.Opaque),
+		noreply:    flagIf[FlagNoReply](v.NoReply),
+		ttl:        convertPtr[FlagUpdateTTL](v.UpdateTTL),
+	}
+	return nil
+}
+
+func (reply *MetaDeleteReply) String() string {
+	return debugString(reply)
+}
+
+type metaDeleteReplyJSON struct {
+	Status string `json:"status"`
+	Base64 bool   `json:"base64,omitempty"`
+	Key    string `json:"key,omitempty"`
+	Opaque []byte `json:"opaque,omitempty"`
+}
+
+func (reply *MetaDeleteReply) MarshalJSON() ([]byte, error) {
+	code, err := reply.status.code()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(metaDeleteReplyJSON{
+		Status: string(code),
+		Base64: reply.b64 != nil,
+		Key:    jsonReplyKey(reply.rkey),
+		Opaque: reply.opaque,
+	})
+}
+
+func (reply *MetaDeleteReply) UnmarshalJSON(data []byte) error {
+	var v metaDeleteReplyJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	*reply = MetaDeleteReply{
+		b64:    flagIf[FlagKeyAsBase64](v.Base64),
+		rkey:   jsonRetrieveKey(v.Key),
+		opaque: FlagOpaque(v.Opaque),
+	}
+
+	for status := MetaDeleteCmdStatusDeleted; status <= MetaDeleteCmdStatusNotFound; status++ {
+		if code, _ := status.code(); string(code) == v.Status {
+			reply.status = status
+			return nil
+		}
+	}
+	return fmt.Errorf(`memdproto: invalid md reply status %q in JSON`, v.Status)
+}
+
+var metaArithmeticModeNames = map[MetaArithmeticMode]string{
+	MetaArithmeticModeIncr: "incr",
+	MetaArithmeticModeDecr: "decr",
+}
+
+func (cmd *MetaArithmeticCmd) String() string {
+	return debugString(cmd)
+}
+
+type metaArithmeticCmdJSON struct {
+	Verb                 string  `json:"verb"`
+	Key                  string  `json:"key"`
+	Base64               bool    `json:"base64,omitempty"`
+	RetrieveCas          bool    `json:"retrieve_cas,omitempty"`
+	CompareCas           *uint64 `json:"compare_cas,omitempty"`
+	NewCas               *uint64 `json:"new_cas,omitempty"`
+	VivifyOnMiss         *uint64 `json:"vivify_on_miss,omitempty"`
+	InitialValue         *uint64 `json:"initial_value,omitempty"`
+	Delta                *uint64 `json:"delta,omitempty"`
+	UpdateTTL            *int64  `json:"update_ttl,omitempty"`
+	Mode                 string  `json:"mode,omitempty"`
+	Opaque               []byte  `json:"opaque,omitempty"`
+	NoReply              bool    `json:"noreply,omitempty"`
+	RetrieveRemainingTTL bool    `json:"retrieve_remaining_ttl,omitempty"`
+	RetrieveValue        bool    `json:"retrieve_value,omitempty"`
+	RetrieveKey          bool    `json:"retrieve_key,omitempty"`
+}
+
+func (cmd *MetaArithmeticCmd) MarshalJSON() ([]byte, error) {
+	v := metaArithmeticCmdJSON{
+		Verb:                 cmd.Verb(),
+		Key:                  jsonCmdKey(cmd.key, cmd.b64 != nil),
+		Base64:               cmd.b64 != nil,
+		RetrieveCas:          cmd.cas != nil,
+		CompareCas:           convertPtr[uint64](cmd.ccas),
+		NewCas:               convertPtr[uint64](cmd.ncas),
+		VivifyOnMiss:         convertPtr[uint64](cmd.vivify),
+		InitialValue:         convertPtr[uint64](cmd.initial),
+		Delta:                convertPtr[uint64](cmd.delta),
+		UpdateTTL:            convertPtr[int64](cmd.updateTTL),
+		Opaque:               cmd.opaque,
+		NoReply:              cmd.noreply != nil,
+		RetrieveRemainingTTL: cmd.remainingTTL != nil,
+		RetrieveValue:        cmd.value != nil,
+		RetrieveKey:          cmd.rkey != nil,
+	}
+	if cmd.mode != nil {
+		name, ok := metaArithmeticModeNames[*cmd.mode]
+		if !ok {
+			return nil, fmt.Errorf(`memdproto: invalid ma mode %d`, *cmd.mode)
+		}
+		v.Mode = name
+	}
+	return json.Marshal(v)
+}
+
+func (cmd *MetaArithmeticCmd) UnmarshalJSON(data []byte) error {
+	var v metaArithmeticCmdJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if err := checkJSONVerb(cmd.Verb(), v.Verb); err != nil {
+		return err
+	}
+
+	key, err := decodeJSONCmdKey(v.Key, v.Base64)
+	if err != nil {
+		return err
+	}
+
+	*cmd = MetaArithmeticCmd{
+		key:          key,
+		b64:          flagIf[FlagKeyAsBase64](v.Base64),
+		cas:          flagIf[FlagRetrieveCas](v.RetrieveCas),
+		ccas:         convertPtr[FlagCompareCas](v.CompareCas),
+		ncas:         convertPtr[FlagNewCas](v.NewCas),
+		vivify:       convertPtr[FlagVivifyOnMiss](v.VivifyOnMiss),
+		initial:      convertPtr[FlagInitialValue](v.InitialValue),
+		delta:        convertPtr[FlagDelta](v.Delta),
+		updateTTL:    convertPtr[FlagUpdateTTL](v.UpdateTTL),
+		opaque:       FlagOpaque(v.Opaque),
+		noreply:      flagIf[FlagNoReply](v.NoReply),
+		remainingTTL: flagIf[FlagRetrieveRemainingTTL](v.RetrieveRemainingTTL),
+		value:        flagIf[FlagRetrieveValue](v.RetrieveValue),
+		rkey:         flagIf[FlagRetrieveKey](v.RetrieveKey),
+	}
+
+	if v.Mode != "" {
+		var found bool
+		for mode, name := range metaArithmeticModeNames {
+			if name == v.Mode {
+				cmd.mode = &mode
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf(`memdproto: invalid ma mode %q in JSON`, v.Mode)
+		}
+	}
+	return nil
+}
+
+var metaArithmeticStatusCodes = map[MetaArithmeticCmdStatus]string{
+	MetaArithmeticCmdStatusSuccess:   "HD",
+	MetaArithmeticCmdStatusNotFound:  "NF",
+	MetaArithmeticCmdStatusNotStored: "NS",
+	MetaArithmeticCmdStatusExists:    "EX",
+}
+
+func (reply *MetaArithmeticReply) String() string {
+	return debugString(reply)
+}
+
+type metaArithmeticReplyJSON struct {
+	Status       string  `json:"status"`
+	Value        []byte  `json:"value,omitempty"`
+	Base64       bool    `json:"base64,omitempty"`
+	Cas          *uint64 `json:"cas,omitempty"`
+	Key          string  `json:"key,omitempty"`
+	Opaque       []byte  `json:"opaque,omitempty"`
+	RemainingTTL *int64  `json:"remaining_ttl,omitempty"`
+}
+
+func (reply *MetaArithmeticReply) MarshalJSON() ([]byte, error) {
+	code, ok := metaArithmeticStatusCodes[reply.status]
+	if !ok {
+		return nil, fmt.Errorf(`invalid ma reply status %d`, reply.status)
+	}
+
+	v := metaArithmeticReplyJSON{
+		Status: code,
+		Base64: reply.b64 != nil,
+		Cas:    convertPtr[uint64](reply.cas),
+		Key:    jsonReplyKey(reply.rkey),
+		Opaque: reply.opaque,
+	}
+	if reply.status == MetaArithmeticCmdStatusSuccess && reply.value != nil {
+		v.Status = "VA"
+		v.Value = reply.value
+	}
+	if reply.remainingTTL != nil {
+		v.RemainingTTL = reply.remainingTTL.value
+	}
+	return json.Marshal(v)
+}
+
+func (reply *MetaArithmeticReply) UnmarshalJSON(data []byte) error {
+	var v metaArithmeticReplyJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	*reply = MetaArithmeticReply{
+		b64:    flagIf[FlagKeyAsBase64](v.Base64),
+		cas:    convertPtr[FlagRetrieveCas](v.Cas),
+		rkey:   jsonRetrieveKey(v.Key),
+		opaque: FlagOpaque(v.Opaque),
+	}
+	if v.RemainingTTL != nil {
+		reply.SetRemainingTTL(*v.RemainingTTL)
+	}
+
+	if v.Status == "VA" {
+		reply.status = MetaArithmeticCmdStatusSuccess
+		reply.value = v.Value
+		if reply.value == nil {
+			reply.value = []byte{}
+		}
+		return nil
+	}
+
+	for status, code := range metaArithmeticStatusCodes {
+		if code == v.Status {
+			reply.status = status
+			return nil
+		}
+	}
+	return fmt.Errorf(`memdproto: invalid ma reply status %q in JSON`, v.Status)
+}
+
+func (cmd *MetaNoopCmd) String() string {
+	return debugString(cmd)
+}
+
+type metaNoopCmdJSON struct {
+	Verb string `json:"verb"`
+}
+
+func (cmd *MetaNoopCmd) MarshalJSON() ([]byte, error) {
+	return json.Marshal(metaNoopCmdJSON{Verb: cmd.Verb()})
+}
+
+func (cmd *MetaNoopCmd) UnmarshalJSON(data []byte) error {
+	var v metaNoopCmdJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	return checkJSONVerb(cmd.Verb(), v.Verb)
+}
+
+func (reply *MetaNoopReply) String() string {
+	return debugString(reply)
+}
+
+type metaNoopReplyJSON struct {
+	Status string `json:"status"`
+}
+
+func (reply *MetaNoopReply) MarshalJSON() ([]byte, error) {
+	return json.Marshal(metaNoopReplyJSON{Status: string(metanoopReply)})
+}
+
+func (reply *MetaNoopReply) UnmarshalJSON(data []byte) error {
+	var v metaNoopReplyJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if v.Status != string(metanoopReply) {
+		return fmt.Errorf(`memdproto: invalid mn reply status %q in JSON`, v.Status)
+	}
+	return nil
+}
+
+func (cmd *GetCmd) String() string {
+	return debugString(cmd)
+}
+
+type getCmdJSON struct {
+	Verb string   `json:"verb"`
+	Keys []string `json:"keys"`
+}
+
+func (cmd *GetCmd) MarshalJSON() ([]byte, error) {
+	cmd.mu.RLock()
+	defer cmd.mu.RUnlock()
+
+	verb := "get"
+	if cmd.cas {
+		verb = "gets"
+	}
+	return json.Marshal(getCmdJSON{Verb: verb, Keys: cmd.keys})
+}
+
+func (cmd *GetCmd) UnmarshalJSON(data []byte) error {
+	var v getCmdJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	var cas bool
+	switch v.Verb {
+	case "", "get":
+	case "gets":
+		cas = true
+	default:
+		return fmt.Errorf(`memdproto: expected verb "get" or "gets" in JSON, got %q`, v.Verb)
+	}
+
+	cmd.mu.Lock()
+	defer cmd.mu.Unlock()
+	cmd.keys = v.Keys
+	cmd.cas = cas
+	return nil
+}
+
+// String returns each item on a single line, with values quoted.
+func (reply *GetReply) String() string {
+	reply.mu.RLock()
+	defer reply.mu.RUnlock()
+
+	var sb strings.Builder
+	for _, item := range reply.items {
+		fmt.Fprintf(&sb, "VALUE %s %d %d", item.key, item.flags, len(item.value))
+		if item.cas != nil {
+			fmt.Fprintf(&sb, " %d", *item.cas)
+		}
+		fmt.Fprintf(&sb, " %q ", item.value)
+	}
+	sb.Write(end)
+	return sb.String()
+}
+
+type getReplyItemJSON struct {
+	Key   string  `json:"key"`
+	Flags uint32  `json:"flags"`
+	Cas   *uint64 `json:"cas,omitempty"`
+	Value []byte  `json:"value"`
+}
+
+type getReplyJSON struct {
+	Items []getReplyItemJSON `json:"items"`
+}
+
+func (reply *GetReply) MarshalJSON() ([]byte, error) {
+	reply.mu.RLock()
+	defer reply.mu.RUnlock()
+
+	v := getReplyJSON{Items: make([]getReplyItemJSON, 0, len(reply.items))}
+	for _, item := range reply.items {
+		v.Items = append(v.Items, getReplyItemJSON{
+			Key:   item.key,
+			Flags: item.flags,
+			Cas:   item.cas,
+			Value: item.value,
+		})
+	}
+	return json.Marshal(v)
+}
+
+func (reply *GetReply) UnmarshalJSON(data []byte) error {
+	var v getReplyJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	reply.mu.Lock()
+	defer reply.mu.Unlock()
+	reply.items = nil
+	for _, item := range v.Items {
+		reply.items = append(reply.items, &GetReplyItem{
+			key:   item.Key,
+			flags: item.Flags,
+			cas:   item.Cas,
+			value: item.Value,
+		})
+	}
+	return nil
+}
+
+func (cmd *storageCmd) String() string {
+	return debugString(cmd)
+}
+
+type storageCmdJSON struct {
+	Verb    string `json:"verb"`
+	Key     string `json:"key"`
+	Flags   uint32 `json:"flags"`
+	Exptime int64  `json:"exptime"`
+	Cas     uint64 `json:"cas,omitempty"`
+	NoReply bool   `json:"noreply,omitempty"`
+	Value   []byte `json:"value"`
+}
+
+func (cmd *storageCmd) MarshalJSON() ([]byte, error) {
+	return json.Marshal(storageCmdJSON{
+		Verb:    cmd.cmdName,
+		Key:     cmd.key,
+		Flags:   cmd.flags,
+		Exptime: cmd.expires,
+		Cas:     cmd.cas,
+		NoReply: cmd.noreply,
+		Value:   cmd.data,
+	})
+}
+
+func (cmd *storageCmd) UnmarshalJSON(data []byte) error {
+	var v storageCmdJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	verb := cmd.cmdName
+	if verb == "" {
+		switch v.Verb {
+		case "set", "add", "replace", "append", "prepend", "cas":
+			verb = v.Verb
+		default:
+			return fmt.Errorf(`memdproto: invalid storage command verb %q in JSON`, v.Verb)
+		}
+	} else if err := checkJSONVerb(verb, v.Verb); err != nil {
+		return err
+	}
+
+	*cmd = storageCmd{
+		cmdName: verb,
+		key:     v.Key,
+		flags:   v.Flags,
+		expires: v.Exptime,
+		noreply: v.NoReply,
+		data:    v.Value,
+		cas:     v.Cas,
+	}
+	return nil
+}
+
+// classicStatusJSON is the JSON representation of replies to classic
+// commands, which consist of a single status line
+type classicStatusJSON struct {
+	Status string `json:"status"`
+}
+
+// marshalStatusJSON creates the JSON representation of a reply that
+// consists of a single status line
+func marshalStatusJSON(reply Reply) ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := reply.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+	return json.Marshal(classicStatusJSON{Status: string(bytes.TrimSuffix(buf.Bytes(), crlf))})
+}
+
+// unmarshalStatusJSON parses the status line stored in the JSON
+// representation of a reply that consists of a single status line
+func unmarshalStatusJSON(reply Reply, data []byte) error {
+	var v classicStatusJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if strings.ContainsAny(v.Status, "\r\n") {
+		return fmt.Errorf(`memdproto: invalid reply status %q in JSON`, v.Status)
+	}
+	return reply.UnmarshalText([]byte(v.Status + "\r\n"))
+}
+
+func (reply *SetCmdReply) String() string {
+	return debugString(reply)
+}
+
+func (reply *SetCmdReply) MarshalJSON() ([]byte, error) {
+	return marshalStatusJSON(reply)
+}
+
+func (reply *SetCmdReply) UnmarshalJSON(data []byte) error {
+	return unmarshalStatusJSON(reply, data)
+}
+
+func (cmd *DeleteCmd) String() string {
+	return debugString(cmd)
+}
+
+type deleteCmdJSON struct {
+	Verb    string `json:"verb"`
+	Key     string `json:"key"`
+	NoReply bool   `json:"noreply,omitempty"`
+}
+
+func (cmd *DeleteCmd) MarshalJSON() ([]byte, error) {
+	return json.Marshal(deleteCmdJSON{
+		Verb:    cmd.Verb(),
+		Key:     cmd.key,
+		NoReply: cmd.noreply,
+	})
+}
+
+func (cmd *DeleteCmd) UnmarshalJSON(data []byte) error {
+	var v deleteCmdJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if err := checkJSONVerb(cmd.Verb(), v.Verb); err != nil {
+		return err
+	}
+
+	*cmd = DeleteCmd{
+		key:     v.Key,
+		noreply: v.NoReply,
+	}
+	return nil
+}
+
+func (reply *DeleteCmdReply) String() string {
+	return debugString(reply)
+}
+
+func (reply *DeleteCmdReply) MarshalJSON() ([]byte, error) {
+	return marshalStatusJSON(reply)
+}
+
+func (reply *DeleteCmdReply) UnmarshalJSON(data []byte) error {
+	return unmarshalStatusJSON(reply, data)
+}
+
+// String returns the command line, with the data block quoted
+func (cmd *RawCmd) String() string {
+	return debugString(cmd)
+}
+
+type rawCmdJSON struct {
+	Verb string `json:"verb"`
+	Line []byte `json:"line"`
+	// Data is null if there is no data block, and "" if the data
+	// block is empty
+	Data []byte `json:"data"`
+}
+
+func (cmd *RawCmd) MarshalJSON() ([]byte, error) {
+	return json.Marshal(rawCmdJSON{
+		Verb: cmd.Verb(),
+		Line: cmd.line,
+		Data: cmd.data,
+	})
+}
+
+func (cmd *RawCmd) UnmarshalJSON(data []byte) error {
+	var v rawCmdJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	*cmd = RawCmd{
+		line: v.Line,
+		data: v.Data,
+	}
+	return nil
+}
+
+func (reply *RawReply) String() string {
+	return strconv.Quote(string(reply.data))
+}
+
+type rawReplyJSON struct {
+	Terminator []byte `json:"terminator,omitempty"`
+	Data       []byte `json:"data"`
+}
+
+func (reply *RawReply) MarshalJSON() ([]byte, error) {
+	return json.Marshal(rawReplyJSON{
+		Terminator: reply.terminator,
+		Data:       reply.data,
+	})
+}
+
+func (reply *RawReply) UnmarshalJSON(data []byte) error {
+	var v rawReplyJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	*reply = RawReply{
+		terminator: v.Terminator,
+		data:       v.Data,
+	}
+	return nil
+}
+
+func (cmd *arithmeticCmd) String() string {
+	return debugString(cmd)
+}
+
+type arithmeticCmdJSON struct {
+	Verb    string `json:"verb"`
+	Key     string `json:"key"`
+	Delta   uint64 `json:"delta"`
+	NoReply bool   `json:"noreply,omitempty"`
+}
+
+func (cmd *arithmeticCmd) MarshalJSON() ([]byte, error) {
+	return json.Marshal(arithmeticCmdJSON{
+		Verb:    cmd.cmdName,
+		Key:     cmd.key,
+		Delta:   cmd.delta,
+		NoReply: cmd.noreply,
+	})
+}
+
+func (cmd *arithmeticCmd) UnmarshalJSON(data []byte) error {
+	var v arithmeticCmdJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	verb := cmd.cmdName
+	if verb == "" {
+		switch v.Verb {
+		case "incr", "decr":
+			verb = v.Verb
+		default:
+			return fmt.Errorf(`memdproto: invalid arithmetic command verb %q in JSON`, v.Verb)
+		}
+	} else if err := checkJSONVerb(verb, v.Verb); err != nil {
+		return err
+	}
+
+	*cmd = arithmeticCmd{
+		cmdName: verb,
+		key:     v.Key,
+		delta:   v.Delta,
+		noreply: v.NoReply,
+	}
+	return nil
+}
+
+func (reply *ArithmeticCmdReply) String() string {
+	return debugString(reply)
+}
+
+func (reply *ArithmeticCmdReply) MarshalJSON() ([]byte, error) {
+	return marshalStatusJSON(reply)
+}
+
+func (reply *ArithmeticCmdReply) UnmarshalJSON(data []byte) error {
+	return unmarshalStatusJSON(reply, data)
+}
+
+func (cmd *TouchCmd) String() string {
+	return debugString(cmd)
+}
+
+type touchCmdJSON struct {
+	Verb    string `json:"verb"`
+	Key     string `json:"key"`
+	Exptime int64  `json:"exptime"`
+	NoReply bool   `json:"noreply,omitempty"`
+}
+
+func (cmd *TouchCmd) MarshalJSON() ([]byte, error) {
+	return json.Marshal(touchCmdJSON{
+		Verb:    cmd.Verb(),
+		Key:     cmd.key,
+		Exptime: cmd.expires,
+		NoReply: cmd.noreply,
+	})
+}
+
+func (cmd *TouchCmd) UnmarshalJSON(data []byte) error {
+	var v touchCmdJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if err := checkJSONVerb(cmd.Verb(), v.Verb); err != nil {
+		return err
+	}
+
+	*cmd = TouchCmd{
+		key:     v.Key,
+		expires: v.Exptime,
+		noreply: v.NoReply,
+	}
+	return nil
+}
+
+func (reply *TouchCmdReply) String() string {
+	return debugString(reply)
+}
+
+func (reply *TouchCmdReply) MarshalJSON() ([]byte, error) {
+	return marshalStatusJSON(reply)
+}
+
+func (reply *TouchCmdReply) UnmarshalJSON(data []byte) error {
+	return unmarshalStatusJSON(reply, data)
+}
+
+func (cmd *GatCmd) String() string {
+	return debugString(cmd)
+}
+
+type gatCmdJSON struct {
+	Verb    string   `json:"verb"`
+	Exptime int64    `json:"exptime"`
+	Keys    []string `json:"keys"`
+}
+
+func (cmd *GatCmd) MarshalJSON() ([]byte, error) {
+	return json.Marshal(gatCmdJSON{
+		Verb:    cmd.Verb(),
+		Exptime: cmd.expires,
+		Keys:    cmd.keys,
+	})
+}
+
+func (cmd *GatCmd) UnmarshalJSON(data []byte) error {
+	var v gatCmdJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	var cas bool
+	switch v.Verb {
+	case "", "gat":
+	case "gats":
+		cas = true
+	default:
+		return fmt.Errorf(`memdproto: expected verb "gat" or "gats" in JSON, got %q`, v.Verb)
+	}
+
+	*cmd = GatCmd{
+		expires: v.Exptime,
+		keys:    v.Keys,
+		cas:     cas,
+	}
+	return nil
+}
+
+func (reply *ErrorReply) String() string {
+	return reply.Error()
+}
+
+func (reply *ErrorReply) MarshalJSON() ([]byte, error) {
+	return marshalStatusJSON(reply)
+}
+
+func (reply *ErrorReply) UnmarshalJSON(data []byte) error {
+	return unmarshalStatusJSON(reply, data)
+}
@@ -1,6 +1,7 @@
 package memdproto
 
 import (
+	"bytes"
 	"encoding"
 	"io"
 )
@@ -15,12 +16,49 @@ var appendCmdName = []byte("append")
 var prependCmdName = []byte("prepend")
 var replaceCmdName = []byte("replace")
 
+// Cmd represents a memcached command, either classic or meta.
 type Cmd interface {
 	io.WriterTo
 	encoding.TextUnmarshaler
+
+	// Verb returns the name of the command as it appears on the wire,
+	// e.g. "mg", "set", or "gets".
+	Verb() string
+
+	// Keys returns the keys that the command operates on. Most commands
+	// operate on exactly one key, but retrieval commands such as `get`
+	// may operate on multiple keys.
+	Keys() []string
+
+	// NoReply returns true if the command has been issued in quiet mode
+	// ("q" flag for meta commands, "noreply" for classic commands).
+	NoReply() bool
+
+	// NewReply returns an empty Reply object that can be used to
+	// read the server's response to this command.
+	NewReply() Reply
 }
 
+// Reply represents a reply to a memcached command.
+//
+// ReadFrom reads exactly one reply from the source. If the source is
+// a *bufio.Reader, it is used as-is, so that multiple replies can be
+// read from the same connection without losing buffered data.
 type Reply interface {
 	io.WriterTo
+	io.ReaderFrom
 	encoding.TextUnmarshaler
 }
+
+// unmarshalReply parses data using the ReadFrom method of r, and
+// makes sure that the entire data was consumed.
+func unmarshalReply(verb string, r io.ReaderFrom, data []byte) error {
+	n, err := r.ReadFrom(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if int(n) != len(data) {
+		return parseErrorf(verb, int(n), ``, `trailing data after reply`)
+	}
+	return nil
+}
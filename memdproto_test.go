@@ -1,9 +1,15 @@
 package memdproto_test
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"encoding"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"math"
 	"net"
 	"os"
 	"os/exec"
@@ -13,6 +19,7 @@ import (
 	"time"
 
 	"github.com/lestrrat-go/memdproto"
+	"github.com/lestrrat-go/memdproto/memdtest"
 	"github.com/stretchr/testify/require"
 )
 
@@ -73,6 +80,14 @@ func testMain(m *testing.M) (int, error) {
 		}
 	}
 
+	if MemcachedAddr == "" {
+		// memcached is not installed: run the tests against
+		// an in-process server instead
+		srv := memdtest.NewServer()
+		defer srv.Close()
+		MemcachedAddr = srv.Addr()
+	}
+
 	return m.Run(), nil
 }
 
@@ -103,11 +118,9 @@ func TestGetCmdMarshal(t *testing.T) {
 		require.NoError(t, err, "reply.WriteTo should succeed")
 		t.Logf("reply = %q", buf.String())
 
-		/*
-			var reply2 memdproto.GetReply
-			require.NoError(t, reply2.UnmarshalText(buf.Bytes()), "reply2.UnmarshalText should succeed")
-			require.Equal(t, reply, &reply2, "reply and reply2 should be equal")
-		*/
+		var reply2 memdproto.GetReply
+		require.NoError(t, reply2.UnmarshalText(buf.Bytes()), "reply2.UnmarshalText should succeed")
+		require.Equal(t, reply, &reply2, "reply and reply2 should be equal")
 	})
 	t.Run("gets", func(t *testing.T) {
 		cmd := memdproto.NewGetCmd("/foo", "/bar")
@@ -145,6 +158,14 @@ func TestSetCmdMarshal(t *testing.T) {
 				return cmd
 			},
 		},
+		{
+			Name: "set 32-bit flags",
+			Construct: func() memdproto.Cmd {
+				cmd := memdproto.NewSetCmd("/foo", []byte("bar"))
+				cmd.SetFlags(math.MaxUint32)
+				return cmd
+			},
+		},
 	}
 	for _, tc := range testcases {
 		tc := tc
@@ -164,6 +185,894 @@ func TestSetCmdMarshal(t *testing.T) {
 	}
 }
 
+// parseStrict runs its input through ParseCmd in strict mode, which
+// checks the command line before the command parses it
+type parseStrict struct{}
+
+func (parseStrict) UnmarshalText(data []byte) error {
+	_, err := memdproto.ParseCmd(data, memdproto.ParseModeStrict)
+	return err
+}
+
+func TestParseError(t *testing.T) {
+	testcases := []struct {
+		Name   string
+		Input  string
+		Target encoding.TextUnmarshaler
+		Verb   string
+		Offset int
+		Token  string
+		Cause  error
+	}{
+		{
+			Name:   "mg unknown flag",
+			Input:  "mg foo v Y\r\n",
+			Target: &memdproto.MetaGetCmd{},
+			Verb:   "mg",
+			Offset: 9,
+			Token:  "Y",
+			Cause:  memdproto.ErrUnknownFlag,
+		},
+		{
+			Name:   "mg missing opaque",
+			Input:  "mg foo O",
+			Target: &memdproto.MetaGetCmd{},
+			Verb:   "mg",
+			Offset: 8,
+			Token:  "O",
+			Cause:  memdproto.ErrUnexpectedEOL,
+		},
+		{
+			Name:   "ms short data block",
+			Input:  "ms foo 10\r\nbar\r\n",
+			Target: &memdproto.MetaSetCmd{},
+			Verb:   "ms",
+			Offset: 11,
+			Cause:  memdproto.ErrUnexpectedEOL,
+		},
+		{
+			Name:   "md unknown flag",
+			Input:  "md foo q Y\r\n",
+			Target: &memdproto.MetaDeleteCmd{},
+			Verb:   "md",
+			Offset: 9,
+			Token:  "Y",
+			Cause:  memdproto.ErrUnknownFlag,
+		},
+		{
+			Name:   "set bad flags",
+			Input:  "set foo abc 0 3\r\nbar\r\n",
+			Target: &memdproto.SetCmd{},
+			Verb:   "set",
+			Offset: 8,
+			Token:  "abc",
+		},
+		{
+			Name:   "set noreply before key substring",
+			Input:  "set mynoreplykey noreply 0 0 3\r\nbar\r\n",
+			Target: parseStrict{},
+			Verb:   "set",
+			Offset: 17,
+			Token:  "noreply",
+		},
+		{
+			Name:   "mg reply oversized value",
+			Input:  "VA 18446744073709551615\r\n",
+			Target: &memdproto.MetaGetReply{},
+			Verb:   "VA",
+			Offset: 3,
+			Token:  "18446744073709551615",
+		},
+		{
+			Name:   "get reply oversized value",
+			Input:  "VALUE foo 0 18446744073709551615\r\nEND\r\n",
+			Target: &memdproto.GetReply{},
+			Verb:   "VALUE",
+			Token:  "18446744073709551615",
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			err := tc.Target.UnmarshalText([]byte(tc.Input))
+			require.Error(t, err, "UnmarshalText should fail")
+
+			var perr *memdproto.ParseError
+			require.True(t, errors.As(err, &perr), "error should be a ParseError")
+			require.Equal(t, tc.Verb, perr.Verb, "verb should match")
+			require.Equal(t, tc.Offset, perr.Offset, "offset should match")
+			require.Equal(t, tc.Token, perr.Token, "token should match")
+			if tc.Cause != nil {
+				require.ErrorIs(t, err, tc.Cause, "cause should match")
+			}
+		})
+	}
+
+	t.Run("mg reply unknown flag", func(t *testing.T) {
+		var reply memdproto.MetaGetReply
+		_, err := reply.ReadFrom(bytes.NewBufferString("HD c123 Q\r\n"))
+		require.Error(t, err, "reply.ReadFrom should fail")
+
+		var perr *memdproto.ParseError
+		require.True(t, errors.As(err, &perr), "error should be a ParseError")
+		require.Equal(t, "HD", perr.Verb, "verb should match")
+		require.Equal(t, 8, perr.Offset, "offset should match")
+		require.ErrorIs(t, err, memdproto.ErrUnknownFlag, "cause should match")
+	})
+}
+
+func TestCmdReaderMaxDataLength(t *testing.T) {
+	src := bytes.NewBufferString(fmt.Sprintf("ms foo %d\r\n", memdproto.DefaultMaxDataLength+1))
+	_, err := memdproto.NewCmdReader(src).ReadCmd()
+	require.ErrorIs(t, err, memdproto.ErrDataTooLarge, "rdr.ReadCmd should fail with the default limit")
+}
+
+func TestCmdReaderSync(t *testing.T) {
+	src := bytes.NewBufferString("ms foo 10\r\n0123456789\r\nmg foo !\r\nms foo 3\r\nbar\r\nms foo 3\r\nbarbaz\r\n")
+	rdr := memdproto.NewCmdReader(src).SetMaxDataLength(5)
+
+	_, err := rdr.ReadCmd()
+	require.ErrorIs(t, err, memdproto.ErrDataTooLarge, "rdr.ReadCmd should fail")
+	require.True(t, rdr.InSync(), "stream should be in sync after skipping the data block")
+
+	_, err = rdr.ReadCmd()
+	var perr *memdproto.ParseError
+	require.ErrorAs(t, err, &perr, "rdr.ReadCmd should fail")
+	require.True(t, rdr.InSync(), "stream should be in sync after an invalid command line")
+
+	cmd, err := rdr.ReadCmd()
+	require.NoError(t, err, "rdr.ReadCmd should succeed")
+	require.Equal(t, []byte("bar"), cmd.(*memdproto.MetaSetCmd).Value(), "value should match")
+
+	_, err = rdr.ReadCmd()
+	require.ErrorAs(t, err, &perr, "rdr.ReadCmd should fail")
+	require.False(t, rdr.InSync(), "stream should be out of sync after a missing data terminator")
+}
+
+func TestParseMode(t *testing.T) {
+	testcases := []struct {
+		Name     string
+		Input    string
+		Mode     memdproto.ParseMode
+		Expected string // expected output of WriteTo. empty if an error is expected
+	}{
+		{
+			Name:     "strict mg",
+			Input:    "mg foo v k\r\n",
+			Mode:     memdproto.ParseModeStrict,
+			Expected: "mg foo k v\r\n",
+		},
+		{
+			Name:  "strict bare LF",
+			Input: "mg foo v\n",
+			Mode:  memdproto.ParseModeStrict,
+		},
+		{
+			Name:     "lenient bare LF",
+			Input:    "mg foo v\n",
+			Mode:     memdproto.ParseModeLenient,
+			Expected: "mg foo v\r\n",
+		},
+		{
+			Name:  "strict repeated spaces",
+			Input: "get  foo bar\r\n",
+			Mode:  memdproto.ParseModeStrict,
+		},
+		{
+			Name:     "lenient repeated spaces",
+			Input:    "get  foo   bar\r\n",
+			Mode:     memdproto.ParseModeLenient,
+			Expected: "get foo bar\r\n",
+		},
+		{
+			Name:  "strict trailing spaces",
+			Input: "delete foo \r\n",
+			Mode:  memdproto.ParseModeStrict,
+		},
+		{
+			Name:     "lenient trailing spaces",
+			Input:    "delete foo  \r\n",
+			Mode:     memdproto.ParseModeLenient,
+			Expected: "delete foo\r\n",
+		},
+		{
+			Name:     "strict set with data",
+			Input:    "set foo 1 0 3 noreply\r\nbar\r\n",
+			Mode:     memdproto.ParseModeStrict,
+			Expected: "set foo 1 0 3 noreply\r\nbar\r\n",
+		},
+		{
+			Name:  "strict noreply in odd position",
+			Input: "delete foo noreply 0\r\n",
+			Mode:  memdproto.ParseModeStrict,
+		},
+		{
+			Name:  "lenient noreply in odd position",
+			Input: "delete foo noreply 0\n",
+			Mode:  memdproto.ParseModeLenient,
+		},
+		{
+			Name:     "lenient legacy delete with noreply",
+			Input:    "delete  foo 0 noreply\n",
+			Mode:     memdproto.ParseModeLenient,
+			Expected: "delete foo noreply\r\n",
+		},
+		{
+			Name:  "lenient set with noreply before data length",
+			Input: "set foo noreply 1 0 3 \nbar\r\n",
+			Mode:  memdproto.ParseModeLenient,
+		},
+		{
+			Name:     "lenient set with trailing noreply",
+			Input:    "set foo 1 0 3  noreply \nbar\r\n",
+			Mode:     memdproto.ParseModeLenient,
+			Expected: "set foo 1 0 3 noreply\r\nbar\r\n",
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			cmd, err := memdproto.ParseCmd([]byte(tc.Input), tc.Mode)
+			if tc.Expected == "" {
+				require.Error(t, err, "ParseCmd should fail")
+				var perr *memdproto.ParseError
+				require.True(t, errors.As(err, &perr), "error should be a ParseError")
+				return
+			}
+			require.NoError(t, err, "ParseCmd should succeed")
+
+			var buf bytes.Buffer
+			_, err = cmd.WriteTo(&buf)
+			require.NoError(t, err, "cmd.WriteTo should succeed")
+			require.Equal(t, tc.Expected, buf.String(), "output should match")
+		})
+	}
+
+	t.Run("CmdReader", func(t *testing.T) {
+		src := bytes.NewBufferString("mg foo v\nms foo 3\nbar\r\nmd  foo q \n")
+		rdr := memdproto.NewCmdReader(src).SetParseMode(memdproto.ParseModeLenient)
+
+		var verbs []string
+		for {
+			cmd, err := rdr.ReadCmd()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err, "rdr.ReadCmd should succeed")
+			verbs = append(verbs, reflect.TypeOf(cmd).Elem().Name())
+		}
+		require.Equal(t, []string{"MetaGetCmd", "MetaSetCmd", "MetaDeleteCmd"}, verbs, "commands should match")
+	})
+}
+
+func TestRawCmd(t *testing.T) {
+	input := "lru_crawler  metadump all\nmg foo v\r\nvendor_set foo 4 noreply\r\nab\r\n\r\nextstore drop\r\n"
+	rdr := memdproto.NewCmdReader(bytes.NewBufferString(input)).
+		SetParseMode(memdproto.ParseModeLenient).
+		SetDataLengthField("vendor_set", 2)
+
+	var buf bytes.Buffer
+	var raws []*memdproto.RawCmd
+	for {
+		cmd, err := rdr.ReadCmd()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err, "rdr.ReadCmd should succeed")
+		if raw, ok := cmd.(*memdproto.RawCmd); ok {
+			raws = append(raws, raw)
+			_, err := raw.WriteTo(&buf)
+			require.NoError(t, err, "raw.WriteTo should succeed")
+		}
+	}
+
+	require.Len(t, raws, 3, "unknown verbs should result in RawCmd")
+	require.Equal(t, "lru_crawler", raws[0].Verb(), "verb should match")
+	require.Equal(t, "vendor_set", raws[1].Verb(), "verb should match")
+	require.Equal(t, []byte("ab\r\n"), raws[1].Data(), "data block should match")
+	require.True(t, raws[1].NoReply(), "noreply should be detected")
+	require.Nil(t, raws[2].Data(), "data block should be nil")
+	require.Equal(t, "lru_crawler  metadump all\nvendor_set foo 4 noreply\r\nab\r\n\r\nextstore drop\r\n", buf.String(), "raw commands should be re-encoded byte-for-byte")
+
+	t.Run("RawReply", func(t *testing.T) {
+		src := bufio.NewReader(bytes.NewBufferString("key=foo exp=-1\r\nkey=bar exp=-1\r\nEND\r\nOK\r\nSERVER_ERROR busy\r\n"))
+
+		reply := raws[0].SetReplyTerminator([]byte("END\r\n")).NewReply().(*memdproto.RawReply)
+		_, err := reply.ReadFrom(src)
+		require.NoError(t, err, "reply.ReadFrom should succeed")
+		require.Equal(t, "key=foo exp=-1\r\nkey=bar exp=-1\r\nEND\r\n", string(reply.Bytes()), "reply should be read up to the terminator")
+
+		reply = memdproto.NewRawReply(nil)
+		_, err = reply.ReadFrom(src)
+		require.NoError(t, err, "reply.ReadFrom should succeed")
+		require.Equal(t, "OK\r\n", string(reply.Bytes()), "a single line should be read without a terminator")
+
+		reply = memdproto.NewRawReply([]byte("END\r\n"))
+		_, err = reply.ReadFrom(src)
+		require.NoError(t, err, "reply.ReadFrom should succeed")
+		require.Equal(t, "SERVER_ERROR busy\r\n", string(reply.Bytes()), "errors should end the reply")
+	})
+}
+
+func TestCmdInterface(t *testing.T) {
+	testcases := []struct {
+		Name    string
+		Cmd     memdproto.Cmd
+		Verb    string
+		Keys    []string
+		NoReply bool
+		Reply   memdproto.Reply
+	}{
+		{
+			Name:    "mg",
+			Cmd:     memdproto.NewMetaGetCmd("foo").SetNoReply(true),
+			Verb:    "mg",
+			Keys:    []string{"foo"},
+			NoReply: true,
+			Reply:   memdproto.NewMetaGetReply(),
+		},
+		{
+			Name:  "ms",
+			Cmd:   memdproto.NewMetaSetCmd("foo", []byte("bar")),
+			Verb:  "ms",
+			Keys:  []string{"foo"},
+			Reply: memdproto.NewMetaSetReply(),
+		},
+		{
+			Name:    "md",
+			Cmd:     memdproto.NewMetaDeleteCmd("foo").SetNoReply(true),
+			Verb:    "md",
+			Keys:    []string{"foo"},
+			NoReply: true,
+			Reply:   memdproto.NewMetaDeleteReply(),
+		},
+		{
+			Name:  "gets",
+			Cmd:   memdproto.NewGetCmd("foo", "bar").SetRetrieveCas(true),
+			Verb:  "gets",
+			Keys:  []string{"foo", "bar"},
+			Reply: memdproto.NewGetReply(),
+		},
+		{
+			Name: "cas",
+			Cmd: func() memdproto.Cmd {
+				cmd := memdproto.NewCasCmd("foo", []byte("bar"), 1)
+				cmd.SetNoReply(true)
+				return cmd
+			}(),
+			Verb:    "cas",
+			Keys:    []string{"foo"},
+			NoReply: true,
+			Reply:   memdproto.NewSetCmdReply(),
+		},
+		{
+			Name:  "delete",
+			Cmd:   memdproto.NewDeleteCmd("foo"),
+			Verb:  "delete",
+			Keys:  []string{"foo"},
+			Reply: memdproto.NewDeleteCmdReply(),
+		},
+		{
+			Name:  "ma",
+			Cmd:   memdproto.NewMetaArithmeticCmd("foo"),
+			Verb:  "ma",
+			Keys:  []string{"foo"},
+			Reply: memdproto.NewMetaArithmeticReply(),
+		},
+		{
+			Name:  "mn",
+			Cmd:   memdproto.NewMetaNoopCmd(),
+			Verb:  "mn",
+			Reply: memdproto.NewMetaNoopReply(),
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			require.Equal(t, tc.Verb, tc.Cmd.Verb(), "Verb should match")
+			require.Equal(t, tc.Keys, tc.Cmd.Keys(), "Keys should match")
+			require.Equal(t, tc.NoReply, tc.Cmd.NoReply(), "NoReply should match")
+			require.IsType(t, tc.Reply, tc.Cmd.NewReply(), "NewReply should return the matching reply type")
+		})
+	}
+}
+
+func TestMetaReplyRoundtrip(t *testing.T) {
+	testcases := []struct {
+		Name  string
+		Input string
+		Reply memdproto.Reply
+	}{
+		{Name: "mg hit", Input: "VA 3 c12 kfoo Oabc\r\nbar\r\n", Reply: &memdproto.MetaGetReply{}},
+		{Name: "mg miss", Input: "EN\r\n", Reply: &memdproto.MetaGetReply{}},
+		{Name: "mg zero values", Input: "VA 0 c0 f0\r\n\r\n", Reply: &memdproto.MetaGetReply{}},
+		{Name: "ms stored", Input: "HD c12 kfoo Oabc\r\n", Reply: &memdproto.MetaSetReply{}},
+		{Name: "ms not stored", Input: "NS\r\n", Reply: &memdproto.MetaSetReply{}},
+		{Name: "md not found", Input: "NF kfoo\r\n", Reply: &memdproto.MetaDeleteReply{}},
+		{Name: "delete", Input: "DELETED\r\n", Reply: &memdproto.DeleteCmdReply{}},
+		{Name: "ma", Input: "VA 2 c12 kfoo\r\n10\r\n", Reply: &memdproto.MetaArithmeticReply{}},
+		{Name: "mn", Input: "MN\r\n", Reply: &memdproto.MetaNoopReply{}},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			require.NoError(t, tc.Reply.UnmarshalText([]byte(tc.Input)), "UnmarshalText should succeed")
+			var buf bytes.Buffer
+			_, err := tc.Reply.WriteTo(&buf)
+			require.NoError(t, err, "WriteTo should succeed")
+			require.Equal(t, tc.Input, buf.String(), "output should match input")
+		})
+	}
+}
+
+func TestExpectReply(t *testing.T) {
+	testcases := []struct {
+		Name       string
+		Cmd        memdproto.Cmd
+		Always     bool
+		Never      bool
+		Suppressed []string
+	}{
+		{Name: "mg", Cmd: memdproto.NewMetaGetCmd("foo"), Always: true},
+		{Name: "mg q", Cmd: memdproto.NewMetaGetCmd("foo").SetNoReply(true), Suppressed: []string{"EN"}},
+		{Name: "ms q", Cmd: memdproto.NewMetaSetCmd("foo", nil).SetNoReply(true), Suppressed: []string{"HD"}},
+		{Name: "md q", Cmd: memdproto.NewMetaDeleteCmd("foo").SetNoReply(true), Suppressed: []string{"HD", "NF"}},
+		{Name: "ma q", Cmd: memdproto.NewMetaArithmeticCmd("foo").SetNoReply(true), Suppressed: []string{"HD"}},
+		{Name: "mn", Cmd: memdproto.NewMetaNoopCmd(), Always: true},
+		{Name: "delete noreply", Cmd: memdproto.NewDeleteCmd("foo").SetNoReply(true), Never: true},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			expect := memdproto.ExpectReply(tc.Cmd)
+			require.Equal(t, tc.Always, expect.Always(), "Always should match")
+			require.Equal(t, tc.Never, expect.Never(), "Never should match")
+			require.Equal(t, tc.Suppressed, expect.Suppressed(), "Suppressed should match")
+			for _, code := range tc.Suppressed {
+				require.True(t, expect.IsSuppressed(code), "IsSuppressed(%q) should be true", code)
+			}
+			require.False(t, expect.IsSuppressed("SERVER_ERROR"), "errors should never be suppressed")
+		})
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	cmds := []memdproto.Cmd{
+		memdproto.NewMetaGetCmd("a").SetRetrieveValue(true).SetNoReply(true),
+		memdproto.NewMetaGetCmd("b").SetRetrieveValue(true).SetNoReply(true),
+		memdproto.NewMetaSetCmd("c", []byte("C")).SetNoReply(true),
+		memdproto.NewMetaDeleteCmd("d").SetNoReply(true).SetOpaque([]byte("1")),
+		memdproto.NewMetaDeleteCmd("e").SetNoReply(true).SetOpaque([]byte("2")),
+		memdproto.NewDeleteCmd("f").SetNoReply(true),
+		memdproto.NewMetaGetCmd("g").SetRetrieveValue(true),
+		memdproto.NewMetaNoopCmd(),
+	}
+
+	// a: hit, b: miss (suppressed), c: stored (suppressed), d: not found
+	// (suppressed), e: exists, f: no reply, g: miss, followed by MN
+	src := bytes.NewBufferString("VA 1\r\nA\r\nEX O2\r\nEN\r\nMN\r\n")
+	p := memdproto.NewPipeline(src).Push(cmds...)
+
+	expected := []string{"VA 1\r\nA\r\n", "", "", "", "EX O2\r\n", "", "EN\r\n", "MN\r\n"}
+	for i, want := range expected {
+		cmd, reply, err := p.ReadReply()
+		require.NoError(t, err, "p.ReadReply should succeed (%d)", i)
+		require.Equal(t, cmds[i], cmd, "command should match (%d)", i)
+		if want == "" {
+			require.Nil(t, reply, "reply should be suppressed (%d)", i)
+			continue
+		}
+		require.NotNil(t, reply, "reply should not be suppressed (%d)", i)
+
+		var buf bytes.Buffer
+		_, err = reply.WriteTo(&buf)
+		require.NoError(t, err, "reply.WriteTo should succeed")
+		require.Equal(t, want, buf.String(), "reply should match (%d)", i)
+	}
+
+	_, _, err := p.ReadReply()
+	require.ErrorIs(t, err, memdproto.ErrPipelineEmpty, "p.ReadReply should fail after all replies have been read")
+}
+
+func TestPipelineNoReplyError(t *testing.T) {
+	cmds := []memdproto.Cmd{
+		memdproto.NewDeleteCmd("a").SetNoReply(true),
+		memdproto.NewMetaGetCmd("b").SetRetrieveValue(true),
+		memdproto.NewDeleteCmd("c").SetNoReply(true),
+	}
+
+	// a: error, b: miss, c: no reply
+	src := bytes.NewBufferString("CLIENT_ERROR bad command line format\r\nEN\r\n")
+	p := memdproto.NewPipeline(src).Push(cmds...)
+
+	cmd, reply, err := p.ReadReply()
+	var ereply *memdproto.ErrorReply
+	require.ErrorAs(t, err, &ereply, "p.ReadReply should return the error reply")
+	require.Equal(t, cmds[0], cmd, "command should match")
+	require.Equal(t, ereply, reply, "reply should be the error reply")
+
+	cmd, reply, err = p.ReadReply()
+	require.NoError(t, err, "p.ReadReply should succeed")
+	require.Equal(t, cmds[1], cmd, "command should match")
+	require.IsType(t, &memdproto.MetaGetReply{}, reply, "reply should be a MetaGetReply")
+
+	cmd, reply, err = p.ReadReply()
+	require.NoError(t, err, "p.ReadReply should succeed")
+	require.Equal(t, cmds[2], cmd, "command should match")
+	require.Nil(t, reply, "reply should be suppressed")
+}
+
+func TestPipelineNoReplyLateError(t *testing.T) {
+	cmd := memdproto.NewDeleteCmd("a").SetNoReply(true)
+	next := memdproto.NewMetaNoopCmd()
+
+	pr, pw := io.Pipe()
+	defer pr.Close()
+	p := memdproto.NewPipeline(pr).Push(cmd)
+
+	type result struct {
+		cmd   memdproto.Cmd
+		reply memdproto.Reply
+		err   error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		cmd, reply, err := p.ReadReply()
+		ch <- result{cmd, reply, err}
+	}()
+
+	// the error reply arrives after ReadReply was called, and after
+	// the next command was pushed
+	time.Sleep(50 * time.Millisecond)
+	p.Push(next)
+	go func() {
+		_, _ = io.WriteString(pw, "CLIENT_ERROR bad command line format\r\nMN\r\n")
+	}()
+
+	res := <-ch
+	var ereply *memdproto.ErrorReply
+	require.ErrorAs(t, res.err, &ereply, "p.ReadReply should return the error reply")
+	require.Equal(t, cmd, res.cmd, "command should match")
+
+	got, reply, err := p.ReadReply()
+	require.NoError(t, err, "p.ReadReply should succeed")
+	require.Equal(t, next, got, "command should match")
+	require.IsType(t, &memdproto.MetaNoopReply{}, reply, "reply should be a MetaNoopReply")
+}
+
+func TestOpaqueAllocator(t *testing.T) {
+	alloc := memdproto.NewOpaqueAllocator()
+	seen := make(map[string]struct{})
+	for i := 0; i < 10000; i++ {
+		token := alloc.Next()
+		require.True(t, len(token) > 0 && len(token) <= 32, "token should fit in an opaque flag")
+		require.NotContains(t, string(token), " ", "token should not contain spaces")
+		_, dup := seen[string(token)]
+		require.False(t, dup, "token %q should be unique", token)
+		seen[string(token)] = struct{}{}
+	}
+	require.Equal(t, "2Bi", string(alloc.Next()), "token should be base62 encoded")
+}
+
+func TestCorrelate(t *testing.T) {
+	testcases := []struct {
+		Name  string
+		Cmd   memdproto.Cmd
+		Reply memdproto.Reply
+		Field string // empty if the reply should correlate
+	}{
+		{
+			Name:  "matching opaque and key",
+			Cmd:   memdproto.NewMetaGetCmd("foo").SetOpaque([]byte("1")).SetRetrieveKey(true),
+			Reply: memdproto.NewMetaGetReply().SetOpaque([]byte("1")).SetKey("foo", false),
+		},
+		{
+			Name:  "matching base64 key",
+			Cmd:   memdproto.NewMetaDeleteCmd("foo").SetKeyAsBase64(true).SetRetrieveKey(true),
+			Reply: memdproto.NewMetaDeleteReply().SetKey("foo", true),
+		},
+		{
+			Name:  "opaque mismatch",
+			Cmd:   memdproto.NewMetaSetCmd("foo", nil).SetOpaque([]byte("1")),
+			Reply: memdproto.NewMetaSetReply().SetStatus(memdproto.MetaSetCmdStatusStored).SetOpaque([]byte("2")),
+			Field: "opaque",
+		},
+		{
+			Name:  "unexpected opaque",
+			Cmd:   memdproto.NewMetaArithmeticCmd("foo"),
+			Reply: memdproto.NewMetaArithmeticReply().SetOpaque([]byte("2")),
+			Field: "opaque",
+		},
+		{
+			Name:  "key mismatch",
+			Cmd:   memdproto.NewMetaGetCmd("foo").SetRetrieveKey(true),
+			Reply: memdproto.NewMetaGetReply().SetKey("bar", false),
+			Field: "key",
+		},
+		{
+			Name: "suppressed reply",
+			Cmd:  memdproto.NewMetaGetCmd("foo").SetOpaque([]byte("1")),
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			err := memdproto.Correlate(tc.Cmd, tc.Reply)
+			if tc.Field == "" {
+				require.NoError(t, err, "Correlate should succeed")
+				return
+			}
+			var cerr *memdproto.CorrelationError
+			require.True(t, errors.As(err, &cerr), "error should be a CorrelationError")
+			require.Equal(t, tc.Field, cerr.Field, "field should match")
+		})
+	}
+
+	t.Run("pipeline", func(t *testing.T) {
+		cmd := memdproto.NewMetaGetCmd("foo").SetOpaque([]byte("1"))
+		p := memdproto.NewPipeline(bytes.NewBufferString("EN O2\r\n")).Push(cmd)
+		_, _, err := p.ReadReply()
+		var cerr *memdproto.CorrelationError
+		require.True(t, errors.As(err, &cerr), "p.ReadReply should report a CorrelationError")
+	})
+}
+
+func TestTap(t *testing.T) {
+	const cmds = "mg foo v q\r\nmg bar v q\r\nmn\r\nset baz 0 0 3\r\nabc\r\ndelete qux\r\n"
+	const replies = "VA 3\r\nFOO\r\nMN\r\nSTORED\r\nSERVER_ERROR out of memory\r\n"
+
+	var txs []*memdproto.TapTransaction
+	tap := memdproto.NewTap(func(tx *memdproto.TapTransaction) {
+		txs = append(txs, tx)
+	})
+
+	client, server := net.Pipe()
+	client = tap.WrapConn(client, memdproto.TapClientSide)
+
+	received := make(chan []byte, 1)
+	go func() {
+		buf := make([]byte, len(cmds))
+		_, _ = io.ReadFull(server, buf)
+		received <- buf
+		_, _ = server.Write([]byte(replies))
+		_ = server.Close()
+	}()
+
+	_, err := client.Write([]byte(cmds))
+	require.NoError(t, err, "client.Write should succeed")
+	require.Equal(t, cmds, string(<-received), "commands should not be altered")
+
+	got, err := io.ReadAll(client)
+	require.NoError(t, err, "io.ReadAll should succeed")
+	require.Equal(t, replies, string(got), "replies should not be altered")
+	require.NoError(t, client.Close(), "client.Close should succeed")
+
+	expected := []struct {
+		Cmd   string
+		Reply string
+	}{
+		{Cmd: "mg", Reply: "VA 3\r\nFOO\r\n"},
+		{Cmd: "mg"},
+		{Cmd: "mn", Reply: "MN\r\n"},
+		{Cmd: "set", Reply: "STORED\r\n"},
+		{Cmd: "delete", Reply: "SERVER_ERROR out of memory\r\n"},
+	}
+	require.Len(t, txs, len(expected), "number of transactions should match")
+	for i, want := range expected {
+		tx := txs[i]
+		require.Equal(t, want.Cmd, tx.Cmd.Verb(), "command should match (%d)", i)
+		require.False(t, tx.End.Before(tx.Start), "reply should not be seen before the command (%d)", i)
+		if want.Reply == "" {
+			require.NoError(t, tx.Err, "transaction should succeed (%d)", i)
+			require.Nil(t, tx.Reply, "reply should be suppressed (%d)", i)
+			continue
+		}
+
+		var buf bytes.Buffer
+		_, err := tx.Reply.WriteTo(&buf)
+		require.NoError(t, err, "tx.Reply.WriteTo should succeed")
+		require.Equal(t, want.Reply, buf.String(), "reply should match (%d)", i)
+	}
+
+	var ereply *memdproto.ErrorReply
+	require.True(t, errors.As(txs[4].Err, &ereply), "error reply should be reported as an error")
+
+	t.Run("unanswered", func(t *testing.T) {
+		var txs []*memdproto.TapTransaction
+		tap := memdproto.NewTap(func(tx *memdproto.TapTransaction) {
+			txs = append(txs, tx)
+		})
+		_, _ = io.WriteString(tap.Commands(), "mg foo v\r\n")
+		require.NoError(t, tap.Close(), "tap.Close should succeed")
+
+		require.Len(t, txs, 1, "unanswered command should be reported")
+		require.ErrorIs(t, txs[0].Err, io.ErrUnexpectedEOF, "unanswered command should be reported with io.ErrUnexpectedEOF")
+	})
+
+	t.Run("oversized", func(t *testing.T) {
+		var txs []*memdproto.TapTransaction
+		tap := memdproto.NewTap(func(tx *memdproto.TapTransaction) {
+			txs = append(txs, tx)
+		})
+		_, _ = io.WriteString(tap.Commands(), "set foo 0 0 2000000000\r\n")
+		require.NoError(t, tap.Close(), "tap.Close should succeed")
+
+		require.Len(t, txs, 1, "oversized command should be reported")
+		require.ErrorIs(t, txs[0].Err, memdproto.ErrDataTooLarge, "oversized command should be reported with ErrDataTooLarge")
+	})
+}
+
+func TestJSON(t *testing.T) {
+	type jsonValue interface {
+		io.WriterTo
+		encoding.TextUnmarshaler
+		json.Marshaler
+		json.Unmarshaler
+		fmt.Stringer
+	}
+
+	testcases := []struct {
+		Name   string
+		New    func() jsonValue
+		Wire   string
+		String string
+	}{
+		{Name: "mg", New: func() jsonValue { return &memdproto.MetaGetCmd{} }, Wire: "mg Zm9v b c f h k l N30 O123 q R10 s t T60 u v\r\n", String: "mg Zm9v b c f h k l N30 O123 q R10 s t T60 u v"},
+		{Name: "ms", New: func() jsonValue { return &memdproto.MetaSetCmd{} }, Wire: "ms foo 3 k MA O1 q\r\n\x00\r\n\r\n", String: `ms foo 3 k MA O1 q "\x00\r\n"`},
+		{Name: "md", New: func() jsonValue { return &memdproto.MetaDeleteCmd{} }, Wire: "md foo C99 I k O1 q T30\r\n"},
+		{Name: "ma", New: func() jsonValue { return &memdproto.MetaArithmeticCmd{} }, Wire: "ma foo c D5 J1 k MD N0 O1 q t T10 v\r\n"},
+		{Name: "mn", New: func() jsonValue { return &memdproto.MetaNoopCmd{} }, Wire: "mn\r\n"},
+		{Name: "gets", New: func() jsonValue { return &memdproto.GetCmd{} }, Wire: "gets foo bar\r\n"},
+		{Name: "cas", New: func() jsonValue { return &memdproto.CasCmd{} }, Wire: "cas foo 1 0 3 12 noreply\r\nbar\r\n"},
+		{Name: "delete", New: func() jsonValue { return &memdproto.DeleteCmd{} }, Wire: "delete foo noreply\r\n"},
+		{Name: "VA", New: func() jsonValue { return memdproto.NewMetaGetReply() }, Wire: "VA 3 b c12 f5 h1 kZm9v l3 O1 s3 t-1 W X\r\n\xffar\r\n", String: `VA 3 b c12 f5 h1 kZm9v l3 O1 s3 t-1 W X "\xffar"`},
+		{Name: "VA empty", New: func() jsonValue { return memdproto.NewMetaGetReply() }, Wire: "VA 0 h0 Z\r\n\r\n"},
+		{Name: "EN", New: func() jsonValue { return memdproto.NewMetaGetReply() }, Wire: "EN O1\r\n"},
+		{Name: "HD", New: func() jsonValue { return memdproto.NewMetaSetReply() }, Wire: "NS c5 kfoo O1\r\n"},
+		{Name: "md reply", New: func() jsonValue { return memdproto.NewMetaDeleteReply() }, Wire: "EX b kZm9v O1\r\n"},
+		{Name: "ma reply", New: func() jsonValue { return memdproto.NewMetaArithmeticReply() }, Wire: "VA 2 c3 t10\r\n10\r\n"},
+		{Name: "MN", New: func() jsonValue { return memdproto.NewMetaNoopReply() }, Wire: "MN\r\n"},
+		{Name: "VALUE", New: func() jsonValue { return memdproto.NewGetReply() }, Wire: "VALUE foo 1 3 5\r\nbar\r\nVALUE baz 0 0\r\n\r\nEND\r\n", String: `VALUE foo 1 3 5 "bar" VALUE baz 0 0 "" END`},
+		{Name: "STORED", New: func() jsonValue { return memdproto.NewSetCmdReply() }, Wire: "STORED\r\n", String: "STORED"},
+		{Name: "NOT_FOUND", New: func() jsonValue { return memdproto.NewDeleteCmdReply() }, Wire: "NOT_FOUND\r\n"},
+		{Name: "ms flags", New: func() jsonValue { return &memdproto.MetaSetCmd{} }, Wire: "ms foo 3 c C12 F7 I T-1\r\nbar\r\n"},
+		{Name: "mg new cas", New: func() jsonValue { return &memdproto.MetaGetCmd{} }, Wire: "mg foo E13 N30 v\r\n"},
+		{Name: "ms new cas", New: func() jsonValue { return &memdproto.MetaSetCmd{} }, Wire: "ms foo 3 C12 E13\r\nbar\r\n"},
+		{Name: "md new cas", New: func() jsonValue { return &memdproto.MetaDeleteCmd{} }, Wire: "md foo C12 E13 I\r\n"},
+		{Name: "ma new cas", New: func() jsonValue { return &memdproto.MetaArithmeticCmd{} }, Wire: "ma foo C12 D2 E13 v\r\n"},
+		{Name: "incr", New: func() jsonValue { return &memdproto.IncrCmd{} }, Wire: "incr foo 5 noreply\r\n"},
+		{Name: "decr", New: func() jsonValue { return &memdproto.DecrCmd{} }, Wire: "decr foo 18446744073709551615\r\n"},
+		{Name: "incr reply", New: func() jsonValue { return memdproto.NewArithmeticCmdReply() }, Wire: "42\r\n"},
+		{Name: "incr reply not found", New: func() jsonValue { return memdproto.NewArithmeticCmdReply() }, Wire: "NOT_FOUND\r\n"},
+		{Name: "touch", New: func() jsonValue { return &memdproto.TouchCmd{} }, Wire: "touch foo -1\r\n"},
+		{Name: "TOUCHED", New: func() jsonValue { return memdproto.NewTouchCmdReply() }, Wire: "TOUCHED\r\n"},
+		{Name: "gats", New: func() jsonValue { return &memdproto.GatCmd{} }, Wire: "gats 30 foo bar\r\n"},
+		{Name: "CLIENT_ERROR", New: func() jsonValue { return memdproto.NewErrorReply() }, Wire: "CLIENT_ERROR bad data chunk\r\n", String: "CLIENT_ERROR bad data chunk"},
+		{Name: "ERROR", New: func() jsonValue { return memdproto.NewErrorReply() }, Wire: "ERROR\r\n"},
+		{Name: "raw", New: func() jsonValue { return &memdproto.RawCmd{} }, Wire: "vendor_set foo 0\r\n\r\n", String: `vendor_set foo 0 ""`},
+		{Name: "raw reply", New: func() jsonValue { return memdproto.NewRawReply(nil) }, Wire: "OK\r\n"},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			v := tc.New()
+			require.NoError(t, v.UnmarshalText([]byte(tc.Wire)), "UnmarshalText should succeed")
+			if tc.String != "" {
+				require.Equal(t, tc.String, v.String(), "String should match")
+			}
+
+			encoded, err := json.Marshal(v)
+			require.NoError(t, err, "json.Marshal should succeed")
+
+			decoded := tc.New()
+			require.NoError(t, json.Unmarshal(encoded, decoded), "json.Unmarshal should succeed")
+
+			var buf bytes.Buffer
+			_, err = decoded.WriteTo(&buf)
+			require.NoError(t, err, "WriteTo should succeed")
+			require.Equal(t, tc.Wire, buf.String(), "JSON round trip should preserve the wire format")
+		})
+	}
+
+	t.Run("binary base64 key", func(t *testing.T) {
+		cmd := memdproto.NewMetaDeleteCmd("\xff\xfe").SetKeyAsBase64(true)
+		encoded, err := json.Marshal(cmd)
+		require.NoError(t, err, "json.Marshal should succeed")
+
+		var decoded memdproto.MetaDeleteCmd
+		require.NoError(t, json.Unmarshal(encoded, &decoded), "json.Unmarshal should succeed")
+		require.Equal(t, "\xff\xfe", decoded.Key(), "binary key should survive the round trip")
+	})
+
+	t.Run("verb mismatch", func(t *testing.T) {
+		var cmd memdproto.MetaGetCmd
+		require.Error(t, json.Unmarshal([]byte(`{"verb":"ms","key":"foo"}`), &cmd), "json.Unmarshal should fail")
+	})
+}
+
+func TestUDP(t *testing.T) {
+	t.Run("header", func(t *testing.T) {
+		h := memdproto.UDPHeader{RequestID: 0x1234, Seq: 1, Total: 2}
+		datagram := append(h.AppendTo(nil), "foo"...)
+		require.Equal(t, []byte("\x12\x34\x00\x01\x00\x02\x00\x00foo"), datagram, "encoded header should match")
+
+		decoded, payload, err := memdproto.ParseUDPDatagram(datagram)
+		require.NoError(t, err, "memdproto.ParseUDPDatagram should succeed")
+		require.Equal(t, h, decoded, "decoded header should match")
+		require.Equal(t, []byte("foo"), payload, "payload should match")
+
+		_, _, err = memdproto.ParseUDPDatagram([]byte("\x00\x01\x00\x00"))
+		require.Error(t, err, "memdproto.ParseUDPDatagram should fail on short datagrams")
+		_, _, err = memdproto.ParseUDPDatagram([]byte("\x00\x01\x00\x02\x00\x02\x00\x00"))
+		require.Error(t, err, "memdproto.ParseUDPDatagram should fail on sequence numbers past the total")
+	})
+
+	t.Run("datagrams", func(t *testing.T) {
+		datagrams, err := memdproto.UDPDatagrams(7, []byte("0123456789"), memdproto.UDPHeaderSize+4)
+		require.NoError(t, err, "memdproto.UDPDatagrams should succeed")
+		require.Len(t, datagrams, 3, "message should be split in 3 datagrams")
+		for i, d := range datagrams {
+			h, _, err := memdproto.ParseUDPDatagram(d)
+			require.NoError(t, err, "memdproto.ParseUDPDatagram should succeed")
+			require.Equal(t, memdproto.UDPHeader{RequestID: 7, Seq: uint16(i), Total: 3}, h, "header should match")
+		}
+
+		datagrams, err = memdproto.UDPDatagrams(7, nil, 0)
+		require.NoError(t, err, "memdproto.UDPDatagrams should succeed")
+		require.Equal(t, [][]byte{[]byte("\x00\x07\x00\x00\x00\x01\x00\x00")}, datagrams, "empty messages should take one datagram")
+
+		_, err = memdproto.UDPDatagrams(7, []byte("foo"), memdproto.UDPHeaderSize)
+		require.Error(t, err, "memdproto.UDPDatagrams should fail without room for a payload")
+	})
+
+	t.Run("assembler", func(t *testing.T) {
+		message := bytes.Repeat([]byte("0123456789"), 300)
+		datagrams, err := memdproto.UDPDatagrams(1, message, 0)
+		require.NoError(t, err, "memdproto.UDPDatagrams should succeed")
+		require.Len(t, datagrams, 3, "message should be split in 3 datagrams")
+		other, err := memdproto.UDPDatagrams(2, []byte("END\r\n"), 0)
+		require.NoError(t, err, "memdproto.UDPDatagrams should succeed")
+
+		asm := memdproto.NewUDPAssembler()
+		for _, d := range [][]byte{datagrams[2], datagrams[0], datagrams[2]} {
+			_, _, ok, err := asm.Add(d)
+			require.NoError(t, err, "asm.Add should succeed")
+			require.False(t, ok, "message should not be complete")
+		}
+
+		h, got, ok, err := asm.Add(other[0])
+		require.NoError(t, err, "asm.Add should succeed")
+		require.True(t, ok, "single datagram messages should be complete")
+		require.Equal(t, uint16(2), h.RequestID, "request ID should match")
+		require.Equal(t, []byte("END\r\n"), got, "message should match")
+
+		h, got, ok, err = asm.Add(datagrams[1])
+		require.NoError(t, err, "asm.Add should succeed")
+		require.True(t, ok, "message should be complete")
+		require.Equal(t, uint16(1), h.RequestID, "request ID should match")
+		require.Equal(t, message, got, "message should match")
+
+		_, _, ok, err = asm.Add(datagrams[0])
+		require.NoError(t, err, "asm.Add should succeed")
+		require.False(t, ok, "completed messages should be forgotten")
+		bogus := memdproto.UDPHeader{RequestID: 1, Seq: 1, Total: 2}.AppendTo(nil)
+		_, _, _, err = asm.Add(bogus)
+		require.Error(t, err, "asm.Add should fail when the total changes")
+
+		asm.Discard(1)
+		_, _, ok, err = asm.Add(bogus)
+		require.NoError(t, err, "asm.Add should succeed after Discard")
+		require.False(t, ok, "message should not be complete")
+	})
+}
+
 func TestLive(t *testing.T) {
 	if MemcachedAddr == "" {
 		t.Skip("memcached not running")
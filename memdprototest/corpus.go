@@ -0,0 +1,363 @@
+package memdprototest
+
+// Example is a golden example of a command and the replies that a
+// server may send in response to it, in their wire form. The examples
+// are taken from protocol.txt, and are written the way memdproto encodes
+// them, so that they can be used to check both decoding and encoding.
+type Example struct {
+	// Name uniquely identifies the example
+	Name string
+	// Cmd is the command, including its data block if any
+	Cmd string
+	// Replies lists replies that are valid for Cmd, each one
+	// complete with its data block if any
+	Replies []string
+}
+
+// Corpus returns the golden examples. A fresh copy is returned every
+// time, so callers are free to modify it.
+func Corpus() []Example {
+	examples := make([]Example, len(corpus))
+	for i, ex := range corpus {
+		ex.Replies = append([]string(nil), ex.Replies...)
+		examples[i] = ex
+	}
+	return examples
+}
+
+// errorReplies are valid replies to any command
+var errorReplies = []string{
+	"ERROR\r\n",
+	"CLIENT_ERROR bad data chunk\r\n",
+	"SERVER_ERROR out of memory storing object\r\n",
+}
+
+func withErrors(replies ...string) []string {
+	return append(replies, errorReplies...)
+}
+
+var corpus = []Example{
+	// meta get
+	{
+		Name:    "mg value",
+		Cmd:     "mg foo v\r\n",
+		Replies: withErrors("VA 3\r\nbar\r\n", "VA 0\r\n\r\n", "EN\r\n"),
+	},
+	{
+		Name:    "mg no value",
+		Cmd:     "mg foo\r\n",
+		Replies: withErrors("HD\r\n", "EN\r\n"),
+	},
+	{
+		Name: "mg metadata",
+		Cmd:  "mg foo c f h k l O123 s t v\r\n",
+		Replies: withErrors(
+			"VA 3 c42 f5 h1 kfoo l10 O123 s3 t-1\r\nbar\r\n",
+			"VA 3 c42 f5 h0 kfoo l0 O123 s3 t30\r\nbar\r\n",
+			"EN kfoo O123\r\n",
+		),
+	},
+	{
+		Name:    "mg base64 key",
+		Cmd:     "mg Zm9v b k v\r\n",
+		Replies: withErrors("VA 3 b kZm9v\r\nbar\r\n", "EN b kZm9v\r\n"),
+	},
+	{
+		Name:    "mg quiet",
+		Cmd:     "mg foo k q v\r\n",
+		Replies: withErrors("VA 3 kfoo\r\nbar\r\n"),
+	},
+	{
+		Name:    "mg touch",
+		Cmd:     "mg foo t T30 v\r\n",
+		Replies: withErrors("VA 3 t30\r\nbar\r\n", "EN\r\n"),
+	},
+	{
+		Name:    "mg vivify",
+		Cmd:     "mg foo c N30 s t v\r\n",
+		Replies: withErrors("VA 0 c2 s0 t30 W\r\n\r\n", "VA 3 c3 s3 t29 Z\r\nbar\r\n"),
+	},
+	{
+		Name:    "mg vivify new cas",
+		Cmd:     "mg foo c E42 N30 v\r\n",
+		Replies: withErrors("VA 0 c42 W\r\n\r\n"),
+	},
+	{
+		Name:    "mg recache",
+		Cmd:     "mg foo c R30 t v\r\n",
+		Replies: withErrors("VA 3 c4 t10 W\r\nbar\r\n", "VA 3 c4 t10 Z\r\nbar\r\n"),
+	},
+	{
+		Name:    "mg stale",
+		Cmd:     "mg foo c v\r\n",
+		Replies: withErrors("VA 3 c5 W X\r\nbar\r\n", "VA 3 c5 X\r\nbar\r\n"),
+	},
+	{
+		Name:    "mg no bump",
+		Cmd:     "mg foo u v\r\n",
+		Replies: withErrors("VA 3\r\nbar\r\n", "EN\r\n"),
+	},
+
+	// meta set
+	{
+		Name:    "ms",
+		Cmd:     "ms foo 3\r\nbar\r\n",
+		Replies: withErrors("HD\r\n", "NS\r\n"),
+	},
+	{
+		Name:    "ms empty",
+		Cmd:     "ms foo 0\r\n\r\n",
+		Replies: withErrors("HD\r\n"),
+	},
+	{
+		Name:    "ms binary value",
+		Cmd:     "ms foo 4\r\n\x00\r\n\xff\r\n",
+		Replies: withErrors("HD\r\n"),
+	},
+	{
+		Name:    "ms flags and ttl",
+		Cmd:     "ms foo 3 c F5 k O1 T60\r\nbar\r\n",
+		Replies: withErrors("HD c42 kfoo O1\r\n", "NS kfoo O1\r\n"),
+	},
+	{
+		Name:    "ms compare cas",
+		Cmd:     "ms foo 3 C42 I\r\nbar\r\n",
+		Replies: withErrors("HD\r\n", "EX\r\n", "NF\r\n"),
+	},
+	{
+		Name:    "ms new cas",
+		Cmd:     "ms foo 3 c C42 E43\r\nbar\r\n",
+		Replies: withErrors("HD c43\r\n", "EX\r\n", "NF\r\n"),
+	},
+	{
+		Name:    "ms base64 key",
+		Cmd:     "ms Zm9v 3 b k\r\nbar\r\n",
+		Replies: withErrors("HD b kZm9v\r\n"),
+	},
+	{
+		Name:    "ms add",
+		Cmd:     "ms foo 3 ME\r\nbar\r\n",
+		Replies: withErrors("HD\r\n", "NS\r\n"),
+	},
+	{
+		Name:    "ms append",
+		Cmd:     "ms foo 3 MA\r\nbar\r\n",
+		Replies: withErrors("HD\r\n", "NS\r\n"),
+	},
+	{
+		Name:    "ms prepend",
+		Cmd:     "ms foo 3 MP\r\nbar\r\n",
+		Replies: withErrors("HD\r\n", "NS\r\n"),
+	},
+	{
+		Name:    "ms replace",
+		Cmd:     "ms foo 3 MR\r\nbar\r\n",
+		Replies: withErrors("HD\r\n", "NS\r\n"),
+	},
+	{
+		Name:    "ms quiet",
+		Cmd:     "ms foo 3 O9 q\r\nbar\r\n",
+		Replies: withErrors("NS O9\r\n", "EX O9\r\n"),
+	},
+
+	// meta delete
+	{
+		Name:    "md",
+		Cmd:     "md foo\r\n",
+		Replies: withErrors("HD\r\n", "NF\r\n"),
+	},
+	{
+		Name:    "md metadata",
+		Cmd:     "md foo C42 k O1\r\n",
+		Replies: withErrors("HD kfoo O1\r\n", "NF kfoo O1\r\n", "EX kfoo O1\r\n"),
+	},
+	{
+		Name:    "md invalidate",
+		Cmd:     "md foo I T30\r\n",
+		Replies: withErrors("HD\r\n", "NF\r\n"),
+	},
+	{
+		Name:    "md invalidate new cas",
+		Cmd:     "md foo E43 I\r\n",
+		Replies: withErrors("HD\r\n", "NF\r\n"),
+	},
+	{
+		Name:    "md base64 key",
+		Cmd:     "md Zm9v b k\r\n",
+		Replies: withErrors("HD b kZm9v\r\n"),
+	},
+	{
+		Name:    "md quiet",
+		Cmd:     "md foo q\r\n",
+		Replies: withErrors("EX\r\n"),
+	},
+
+	// meta arithmetic
+	{
+		Name:    "ma",
+		Cmd:     "ma foo\r\n",
+		Replies: withErrors("HD\r\n", "NF\r\n", "NS\r\n"),
+	},
+	{
+		Name:    "ma value",
+		Cmd:     "ma foo D5 v\r\n",
+		Replies: withErrors("VA 2\r\n15\r\n", "NF\r\n"),
+	},
+	{
+		Name:    "ma decr",
+		Cmd:     "ma foo D5 MD v\r\n",
+		Replies: withErrors("VA 1\r\n0\r\n", "NF\r\n"),
+	},
+	{
+		Name:    "ma vivify",
+		Cmd:     "ma foo c J10 N30 t v\r\n",
+		Replies: withErrors("VA 2 c1 t30\r\n10\r\n"),
+	},
+	{
+		Name:    "ma compare cas",
+		Cmd:     "ma foo C42 k O1\r\n",
+		Replies: withErrors("HD kfoo O1\r\n", "EX kfoo O1\r\n"),
+	},
+	{
+		Name:    "ma new cas",
+		Cmd:     "ma foo c E43 v\r\n",
+		Replies: withErrors("VA 2 c43\r\n11\r\n", "NF\r\n"),
+	},
+	{
+		Name:    "ma quiet",
+		Cmd:     "ma foo q\r\n",
+		Replies: withErrors("NF\r\n", "NS\r\n"),
+	},
+
+	// meta noop
+	{
+		Name:    "mn",
+		Cmd:     "mn\r\n",
+		Replies: withErrors("MN\r\n"),
+	},
+
+	// retrieval
+	{
+		Name: "get",
+		Cmd:  "get foo\r\n",
+		Replies: withErrors(
+			"VALUE foo 0 3\r\nbar\r\nEND\r\n",
+			"VALUE foo 5 0\r\n\r\nEND\r\n",
+			"END\r\n",
+		),
+	},
+	{
+		Name: "get multi",
+		Cmd:  "get foo bar baz\r\n",
+		Replies: withErrors(
+			"VALUE foo 0 3\r\nbar\r\nVALUE baz 1 3\r\nqux\r\nEND\r\n",
+			"END\r\n",
+		),
+	},
+	{
+		Name:    "gets",
+		Cmd:     "gets foo\r\n",
+		Replies: withErrors("VALUE foo 0 3 42\r\nbar\r\nEND\r\n", "END\r\n"),
+	},
+	{
+		Name:    "gat",
+		Cmd:     "gat 30 foo bar\r\n",
+		Replies: withErrors("VALUE foo 0 3\r\nbar\r\nEND\r\n", "END\r\n"),
+	},
+	{
+		Name:    "gats",
+		Cmd:     "gats 0 foo\r\n",
+		Replies: withErrors("VALUE foo 0 3 42\r\nbar\r\nEND\r\n", "END\r\n"),
+	},
+
+	// storage
+	{
+		Name:    "set",
+		Cmd:     "set foo 5 60 3\r\nbar\r\n",
+		Replies: withErrors("STORED\r\n"),
+	},
+	{
+		Name: "set noreply",
+		Cmd:  "set foo 0 0 3 noreply\r\nbar\r\n",
+	},
+	{
+		Name:    "add",
+		Cmd:     "add foo 0 0 3\r\nbar\r\n",
+		Replies: withErrors("STORED\r\n", "NOT_STORED\r\n"),
+	},
+	{
+		Name:    "replace",
+		Cmd:     "replace foo 0 0 3\r\nbar\r\n",
+		Replies: withErrors("STORED\r\n", "NOT_STORED\r\n"),
+	},
+	{
+		Name:    "append",
+		Cmd:     "append foo 0 0 3\r\nbar\r\n",
+		Replies: withErrors("STORED\r\n", "NOT_STORED\r\n"),
+	},
+	{
+		Name:    "prepend",
+		Cmd:     "prepend foo 0 0 3\r\nbar\r\n",
+		Replies: withErrors("STORED\r\n", "NOT_STORED\r\n"),
+	},
+	{
+		Name:    "cas",
+		Cmd:     "cas foo 0 0 3 42\r\nbar\r\n",
+		Replies: withErrors("STORED\r\n", "EXISTS\r\n", "NOT_FOUND\r\n"),
+	},
+
+	// deletion
+	{
+		Name:    "delete",
+		Cmd:     "delete foo\r\n",
+		Replies: withErrors("DELETED\r\n", "NOT_FOUND\r\n"),
+	},
+	{
+		Name: "delete noreply",
+		Cmd:  "delete foo noreply\r\n",
+	},
+
+	// increment/decrement
+	{
+		Name:    "incr",
+		Cmd:     "incr foo 5\r\n",
+		Replies: withErrors("15\r\n", "NOT_FOUND\r\n", "CLIENT_ERROR cannot increment or decrement non-numeric value\r\n"),
+	},
+	{
+		Name:    "decr",
+		Cmd:     "decr foo 18446744073709551615\r\n",
+		Replies: withErrors("0\r\n", "NOT_FOUND\r\n"),
+	},
+	{
+		Name: "incr noreply",
+		Cmd:  "incr foo 1 noreply\r\n",
+	},
+
+	// touch
+	{
+		Name:    "touch",
+		Cmd:     "touch foo 30\r\n",
+		Replies: withErrors("TOUCHED\r\n", "NOT_FOUND\r\n"),
+	},
+	{
+		Name: "touch noreply",
+		Cmd:  "touch foo 30 noreply\r\n",
+	},
+
+	// other commands are passed through as-is
+	{
+		Name:    "version",
+		Cmd:     "version\r\n",
+		Replies: withErrors("VERSION 1.6.21\r\n"),
+	},
+	{
+		Name:    "verbosity",
+		Cmd:     "verbosity 1\r\n",
+		Replies: withErrors("OK\r\n"),
+	},
+	{
+		Name:    "flush_all",
+		Cmd:     "flush_all 10\r\n",
+		Replies: withErrors("OK\r\n"),
+	},
+}
@@ -0,0 +1,68 @@
+package memdprototest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/lestrrat-go/memdproto"
+)
+
+// Exchange sends cmds over conn as a single pipeline, and reads back
+// their replies. Each reply must be well-formed and must belong to its
+// command (see memdproto.Correlate), otherwise an error is returned.
+//
+// The returned slice holds the reply to each command, with nil in place
+// of replies that were suppressed because of quiet or noreply mode.
+// Error replies from the server are returned as *memdproto.ErrorReply
+// without causing Exchange to fail.
+//
+// If the reply to any of the commands may be suppressed, including
+// commands in noreply mode, an additional mn command is sent last so
+// that the end of the pipeline can be found.
+// Exchange does not set any deadlines on conn, and conn should not be
+// used any further if Exchange fails.
+func Exchange(conn net.Conn, cmds ...memdproto.Cmd) ([]memdproto.Reply, error) {
+	sent := cmds
+	for _, cmd := range cmds {
+		expect := memdproto.ExpectReply(cmd)
+		if !expect.Always() {
+			sent = append(cmds[:len(cmds):len(cmds)], memdproto.NewMetaNoopCmd())
+			break
+		}
+	}
+
+	var buf bytes.Buffer
+	for _, cmd := range sent {
+		if _, err := cmd.WriteTo(&buf); err != nil {
+			return nil, fmt.Errorf(`memdprototest.Exchange: failed to encode %s command: %w`, cmd.Verb(), err)
+		}
+	}
+
+	// the server may not read the rest of the commands until it has
+	// written its replies, so the replies must be read while writing
+	werr := make(chan error, 1)
+	go func() {
+		_, err := buf.WriteTo(conn)
+		werr <- err
+	}()
+
+	p := memdproto.NewPipeline(conn).Push(sent...)
+	replies := make([]memdproto.Reply, len(sent))
+	for i := range sent {
+		_, reply, err := p.ReadReply()
+		if err != nil {
+			var ereply *memdproto.ErrorReply
+			if !errors.As(err, &ereply) {
+				return nil, fmt.Errorf(`memdprototest.Exchange: failed to read reply to %s command #%d: %w`, sent[i].Verb(), i, err)
+			}
+		}
+		replies[i] = reply
+	}
+
+	if err := <-werr; err != nil {
+		return nil, fmt.Errorf(`memdprototest.Exchange: failed to write commands: %w`, err)
+	}
+	return replies[:len(cmds)], nil
+}
@@ -0,0 +1,264 @@
+package memdprototest
+
+import (
+	"math/rand"
+	"strconv"
+
+	"github.com/lestrrat-go/memdproto"
+)
+
+// Generator creates random commands. Generated commands always follow
+// protocol.txt, and are encoded exactly the way they are parsed, so they
+// may be round tripped using RoundTripCmd as well as sent to a server.
+//
+// A Generator is deterministic for a given seed, but is not safe for
+// concurrent use.
+type Generator struct {
+	rng  *rand.Rand
+	keys []string
+}
+
+var defaultKeys = []string{"foo", "bar", "baz", "qux", "quux"}
+
+// NewGenerator creates a new Generator from the given seed. By default
+// keys are picked from a small set, so that commands are likely to
+// operate on the same items.
+func NewGenerator(seed int64) *Generator {
+	return &Generator{
+		rng:  rand.New(rand.NewSource(seed)),
+		keys: defaultKeys,
+	}
+}
+
+// SetKeys sets the keys that commands are generated for. The keys
+// must be valid for classic commands, that is, they must not contain
+// spaces or control characters.
+func (g *Generator) SetKeys(keys ...string) *Generator {
+	g.keys = keys
+	return g
+}
+
+// Cmds returns n random commands
+func (g *Generator) Cmds(n int) []memdproto.Cmd {
+	cmds := make([]memdproto.Cmd, n)
+	for i := range cmds {
+		cmds[i] = g.Cmd()
+	}
+	return cmds
+}
+
+// Cmd returns a random command
+func (g *Generator) Cmd() memdproto.Cmd {
+	switch g.rng.Intn(16) {
+	case 0, 1:
+		return g.metaGet()
+	case 2, 3:
+		return g.metaSet()
+	case 4:
+		return g.metaDelete()
+	case 5:
+		return g.metaArithmetic()
+	case 6:
+		return memdproto.NewMetaNoopCmd()
+	case 7:
+		return memdproto.NewGetCmd(g.someKeys()...).SetRetrieveCas(g.bool())
+	case 8:
+		return memdproto.NewGatCmd(g.ttl(), g.someKeys()...).SetRetrieveCas(g.bool())
+	case 9, 10:
+		return g.storage()
+	case 11:
+		return memdproto.NewDeleteCmd(g.key()).SetNoReply(g.bool())
+	case 12:
+		cmd := memdproto.NewIncrCmd(g.key(), g.rng.Uint64())
+		cmd.SetNoReply(g.bool())
+		return cmd
+	case 13:
+		cmd := memdproto.NewDecrCmd(g.key(), g.rng.Uint64())
+		cmd.SetNoReply(g.bool())
+		return cmd
+	case 14:
+		return memdproto.NewTouchCmd(g.key(), g.ttl()).SetNoReply(g.bool())
+	default:
+		return g.metaGet().SetUpdateTTL(g.ttl())
+	}
+}
+
+func (g *Generator) bool() bool {
+	return g.rng.Intn(2) == 0
+}
+
+func (g *Generator) key() string {
+	return g.keys[g.rng.Intn(len(g.keys))]
+}
+
+func (g *Generator) someKeys() []string {
+	keys := make([]string, 1+g.rng.Intn(3))
+	for i := range keys {
+		keys[i] = g.key()
+	}
+	return keys
+}
+
+func (g *Generator) ttl() int64 {
+	return int64(g.rng.Intn(3600))
+}
+
+func (g *Generator) value() []byte {
+	// values are arbitrary bytes, including CR and LF
+	value := make([]byte, g.rng.Intn(64))
+	_, _ = g.rng.Read(value)
+	return value
+}
+
+func (g *Generator) opaque() []byte {
+	if g.bool() {
+		return nil
+	}
+	return []byte(strconv.Itoa(g.rng.Intn(1000)))
+}
+
+func (g *Generator) metaGet() *memdproto.MetaGetCmd {
+	cmd := memdproto.NewMetaGetCmd(g.key()).
+		SetKeyAsBase64(g.bool()).
+		SetRetrieveCas(g.bool()).
+		SetRetrieveClientFlags(g.bool()).
+		SetRetrievePreviousHit(g.bool()).
+		SetRetrieveKey(g.bool()).
+		SetRetrieveTimeSinceLastAccess(g.bool()).
+		SetNoReply(g.bool()).
+		SetRetrieveSize(g.bool()).
+		SetRetrieveRemainingTTL(g.bool()).
+		SetSkipLRUBump(g.bool()).
+		SetRetrieveValue(g.bool())
+	if o := g.opaque(); o != nil {
+		cmd.SetOpaque(o)
+	}
+	if g.rng.Intn(4) == 0 {
+		cmd.SetVivifyOnMiss(uint64(g.ttl()))
+	}
+	if g.rng.Intn(4) == 0 {
+		cmd.SetNewCas(g.rng.Uint64())
+	}
+	return cmd
+}
+
+var metaSetModes = []memdproto.MetaSetMode{
+	memdproto.MetaSetModeAdd,
+	memdproto.MetaSetModeAppend,
+	memdproto.MetaSetModePrepend,
+	memdproto.MetaSetModeReplace,
+}
+
+func (g *Generator) metaSet() *memdproto.MetaSetCmd {
+	cmd := memdproto.NewMetaSetCmd(g.key(), g.value()).
+		SetKeyAsBase64(g.bool()).
+		SetRetrieveKey(g.bool()).
+		SetRetrieveCas(g.bool()).
+		SetNoReply(g.bool())
+	if o := g.opaque(); o != nil {
+		cmd.SetOpaque(o)
+	}
+	if g.bool() {
+		cmd.SetClientFlags(g.rng.Uint32())
+	}
+	if g.bool() {
+		cmd.SetTTL(g.ttl())
+	}
+	if g.rng.Intn(4) == 0 {
+		cmd.SetCompareCas(g.rng.Uint64()).
+			SetInvalidateOnOldCas(g.bool())
+	}
+	if g.rng.Intn(4) == 0 {
+		cmd.SetNewCas(g.rng.Uint64())
+	}
+	if g.bool() {
+		cmd.SetMode(metaSetModes[g.rng.Intn(len(metaSetModes))])
+	}
+	return cmd
+}
+
+func (g *Generator) metaDelete() *memdproto.MetaDeleteCmd {
+	cmd := memdproto.NewMetaDeleteCmd(g.key()).
+		SetKeyAsBase64(g.bool()).
+		SetRetrieveKey(g.bool()).
+		SetNoReply(g.bool())
+	if o := g.opaque(); o != nil {
+		cmd.SetOpaque(o)
+	}
+	if g.rng.Intn(4) == 0 {
+		cmd.SetCompareCas(g.rng.Uint64())
+	}
+	if g.rng.Intn(4) == 0 {
+		cmd.SetNewCas(g.rng.Uint64())
+	}
+	if g.rng.Intn(4) == 0 {
+		cmd.SetInvalidateOnOldCas(true).
+			SetUpdateTTL(uint32(g.ttl()))
+	}
+	return cmd
+}
+
+func (g *Generator) metaArithmetic() *memdproto.MetaArithmeticCmd {
+	cmd := memdproto.NewMetaArithmeticCmd(g.key()).
+		SetKeyAsBase64(g.bool()).
+		SetRetrieveCas(g.bool()).
+		SetRetrieveRemainingTTL(g.bool()).
+		SetRetrieveValue(g.bool()).
+		SetRetrieveKey(g.bool()).
+		SetNoReply(g.bool())
+	if o := g.opaque(); o != nil {
+		cmd.SetOpaque(o)
+	}
+	if g.bool() {
+		cmd.SetDelta(uint64(g.rng.Intn(100)))
+	}
+	if g.bool() {
+		cmd.SetMode(memdproto.MetaArithmeticModeDecr)
+	}
+	if g.rng.Intn(4) == 0 {
+		cmd.SetVivifyOnMiss(uint64(g.ttl())).
+			SetInitialValue(uint64(g.rng.Intn(100)))
+	}
+	if g.rng.Intn(4) == 0 {
+		cmd.SetCompareCas(g.rng.Uint64())
+	}
+	if g.rng.Intn(4) == 0 {
+		cmd.SetNewCas(g.rng.Uint64())
+	}
+	if g.rng.Intn(4) == 0 {
+		cmd.SetUpdateTTL(g.ttl())
+	}
+	return cmd
+}
+
+func (g *Generator) storage() memdproto.Cmd {
+	key, value := g.key(), g.value()
+	flags, expires, noreply := g.rng.Uint32(), g.ttl(), g.bool()
+
+	switch g.rng.Intn(6) {
+	case 0:
+		cmd := memdproto.NewSetCmd(key, value)
+		cmd.SetFlags(flags).SetExpires(expires).SetNoReply(noreply)
+		return cmd
+	case 1:
+		cmd := memdproto.NewAddCmd(key, value)
+		cmd.SetFlags(flags).SetExpires(expires).SetNoReply(noreply)
+		return cmd
+	case 2:
+		cmd := memdproto.NewReplaceCmd(key, value)
+		cmd.SetFlags(flags).SetExpires(expires).SetNoReply(noreply)
+		return cmd
+	case 3:
+		cmd := memdproto.NewAppendCmd(key, value)
+		cmd.SetFlags(flags).SetExpires(expires).SetNoReply(noreply)
+		return cmd
+	case 4:
+		cmd := memdproto.NewPrependCmd(key, value)
+		cmd.SetFlags(flags).SetExpires(expires).SetNoReply(noreply)
+		return cmd
+	default:
+		cmd := memdproto.NewCasCmd(key, value, g.rng.Uint64())
+		cmd.SetFlags(flags).SetExpires(expires).SetNoReply(noreply)
+		return cmd
+	}
+}
@@ -0,0 +1,94 @@
+// Package memdprototest provides utilities for testing implementations
+// of the memcached protocol.
+//
+// Corpus holds golden examples of every command and reply on the wire,
+// and RoundTripCmd and RoundTripReply check that they decode and encode
+// back into the same bytes. TestCorpus runs all of the examples.
+//
+// Generator creates random, valid commands, and Exchange sends commands
+// over a net.Conn and checks that whatever comes back are well-formed
+// replies that belong to them, which allows servers and proxies to be
+// exercised over their own connections.
+package memdprototest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/lestrrat-go/memdproto"
+)
+
+// RoundTripCmd parses wire as exactly one command in strict mode, and
+// encodes it again. An error is returned if the command can not be parsed,
+// or if the encoded command differs from wire.
+func RoundTripCmd(wire []byte) (memdproto.Cmd, error) {
+	cmd, err := memdproto.ParseCmd(wire, memdproto.ParseModeStrict)
+	if err != nil {
+		return nil, fmt.Errorf(`memdprototest.RoundTripCmd: failed to parse %q: %w`, wire, err)
+	}
+
+	if err := compareEncoded(cmd, wire); err != nil {
+		return cmd, fmt.Errorf(`memdprototest.RoundTripCmd: %w`, err)
+	}
+	return cmd, nil
+}
+
+// RoundTripReply parses wire as exactly one reply to cmd, and encodes it
+// again. An error is returned if the reply can not be parsed, if it does
+// not belong to cmd, or if the encoded reply differs from wire.
+func RoundTripReply(cmd memdproto.Cmd, wire []byte) (memdproto.Reply, error) {
+	src := bytes.NewReader(wire)
+	p := memdproto.NewPipeline(src).Push(cmd)
+	_, reply, err := p.ReadReply()
+	if err != nil {
+		var ereply *memdproto.ErrorReply
+		if !errors.As(err, &ereply) {
+			return reply, fmt.Errorf(`memdprototest.RoundTripReply: failed to read reply %q to %s: %w`, wire, cmd.Verb(), err)
+		}
+	}
+	if reply == nil {
+		return nil, fmt.Errorf(`memdprototest.RoundTripReply: reply %q to %s was treated as suppressed`, wire, cmd.Verb())
+	}
+	if src.Len() > 0 {
+		return reply, fmt.Errorf(`memdprototest.RoundTripReply: trailing data after reply %q to %s`, wire, cmd.Verb())
+	}
+
+	if err := compareEncoded(reply, wire); err != nil {
+		return reply, fmt.Errorf(`memdprototest.RoundTripReply: %w`, err)
+	}
+	return reply, nil
+}
+
+// compareEncoded encodes v, and checks that the result matches wire
+func compareEncoded(v io.WriterTo, wire []byte) error {
+	var buf bytes.Buffer
+	if _, err := v.WriteTo(&buf); err != nil {
+		return fmt.Errorf(`failed to encode %q: %w`, wire, err)
+	}
+	if !bytes.Equal(buf.Bytes(), wire) {
+		return fmt.Errorf(`%q was encoded as %q`, wire, buf.Bytes())
+	}
+	return nil
+}
+
+// TestCorpus round trips every command and reply in Corpus, and
+// returns all of the errors that were found.
+func TestCorpus() error {
+	var errs []error
+	for _, ex := range Corpus() {
+		cmd, err := RoundTripCmd([]byte(ex.Cmd))
+		if err != nil {
+			errs = append(errs, fmt.Errorf(`%s: %w`, ex.Name, err))
+			continue
+		}
+
+		for _, reply := range ex.Replies {
+			if _, err := RoundTripReply(cmd, []byte(reply)); err != nil {
+				errs = append(errs, fmt.Errorf(`%s: %w`, ex.Name, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,104 @@
+package memdprototest_test
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/lestrrat-go/memdproto"
+	"github.com/lestrrat-go/memdproto/memdprototest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCorpus(t *testing.T) {
+	require.NoError(t, memdprototest.TestCorpus(), "memdprototest.TestCorpus should succeed")
+}
+
+func TestGenerator(t *testing.T) {
+	encode := func(cmds []memdproto.Cmd) []string {
+		wires := make([]string, len(cmds))
+		for i, cmd := range cmds {
+			var buf bytes.Buffer
+			_, err := cmd.WriteTo(&buf)
+			require.NoError(t, err, "cmd.WriteTo should succeed (%d)", i)
+			wires[i] = buf.String()
+		}
+		return wires
+	}
+
+	wires := encode(memdprototest.NewGenerator(1).Cmds(1000))
+	for _, wire := range wires {
+		_, err := memdprototest.RoundTripCmd([]byte(wire))
+		require.NoError(t, err, "memdprototest.RoundTripCmd should succeed")
+	}
+	require.Equal(t, wires, encode(memdprototest.NewGenerator(1).Cmds(1000)), "generator should be deterministic")
+}
+
+// serve replies to each command with a canned reply until the
+// connection is closed
+func serve(conn net.Conn, reply func(memdproto.Cmd) string) {
+	defer conn.Close()
+	rdr := memdproto.NewCmdReader(conn)
+	for {
+		cmd, err := rdr.ReadCmd()
+		if err != nil {
+			return
+		}
+		if _, err := conn.Write([]byte(reply(cmd))); err != nil {
+			return
+		}
+	}
+}
+
+func TestExchange(t *testing.T) {
+	t.Run("generated", func(t *testing.T) {
+		client, server := net.Pipe()
+		defer client.Close()
+		go serve(server, func(cmd memdproto.Cmd) string {
+			if cmd.Verb() == "mn" {
+				return "MN\r\n"
+			}
+			// errors are sent even in noreply mode
+			return "SERVER_ERROR out of memory\r\n"
+		})
+
+		cmds := memdprototest.NewGenerator(2).Cmds(100)
+		replies, err := memdprototest.Exchange(client, cmds...)
+		require.NoError(t, err, "memdprototest.Exchange should succeed")
+		require.Len(t, replies, len(cmds), "there should be one reply per command")
+		for i, cmd := range cmds {
+			if cmd.Verb() == "mn" {
+				require.IsType(t, &memdproto.MetaNoopReply{}, replies[i], "reply should be MN (%d)", i)
+				continue
+			}
+			require.IsType(t, &memdproto.ErrorReply{}, replies[i], "reply should be an error (%d)", i)
+		}
+	})
+
+	t.Run("quiet", func(t *testing.T) {
+		client, server := net.Pipe()
+		defer client.Close()
+		go serve(server, func(cmd memdproto.Cmd) string {
+			if cmd.Verb() == "mn" {
+				return "MN\r\n"
+			}
+			return "" // a miss is not reported in quiet mode
+		})
+
+		replies, err := memdprototest.Exchange(client, memdproto.NewMetaGetCmd("foo").SetNoReply(true))
+		require.NoError(t, err, "memdprototest.Exchange should succeed")
+		require.Equal(t, []memdproto.Reply{nil}, replies, "reply should be suppressed")
+	})
+
+	t.Run("mismatch", func(t *testing.T) {
+		client, server := net.Pipe()
+		defer client.Close()
+		go serve(server, func(memdproto.Cmd) string {
+			return "HD O2\r\n"
+		})
+
+		_, err := memdprototest.Exchange(client, memdproto.NewMetaGetCmd("foo").SetOpaque([]byte("1")))
+		var cerr *memdproto.CorrelationError
+		require.ErrorAs(t, err, &cerr, "memdprototest.Exchange should fail with a CorrelationError")
+	})
+}
@@ -0,0 +1,183 @@
+package memdtest_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/memdproto/memdtest"
+	"github.com/stretchr/testify/require"
+)
+
+type exchange struct {
+	Cmd   string
+	Reply string
+}
+
+func runExchanges(t *testing.T, conn net.Conn, exchanges []exchange) {
+	t.Helper()
+	for _, x := range exchanges {
+		_, err := io.WriteString(conn, x.Cmd)
+		require.NoError(t, err, "conn.Write should succeed")
+
+		buf := make([]byte, len(x.Reply))
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)), "conn.SetReadDeadline should succeed")
+		n, err := io.ReadFull(conn, buf)
+		require.NoError(t, err, "io.ReadFull should succeed (%q, got %q)", x.Cmd, buf[:n])
+		require.Equal(t, x.Reply, string(buf), "reply to %q should match", x.Cmd)
+	}
+}
+
+// newServer starts a new server for the test, and connects to it
+func newServer(t *testing.T) (*memdtest.Server, net.Conn) {
+	t.Helper()
+	srv := memdtest.NewServer()
+	t.Cleanup(srv.Close)
+
+	conn, err := net.Dial("tcp", srv.Addr())
+	require.NoError(t, err, "net.Dial should succeed")
+	t.Cleanup(func() { conn.Close() })
+	return srv, conn
+}
+
+func TestServer(t *testing.T) {
+	t.Run("meta", func(t *testing.T) {
+		_, conn := newServer(t)
+		runExchanges(t, conn, []exchange{
+			{Cmd: "mg foo v\r\n", Reply: "EN\r\n"},
+			{Cmd: "ms foo 3 c F5 T60\r\nbar\r\n", Reply: "HD c1\r\n"},
+			{Cmd: "mg foo c f h k s t v\r\n", Reply: "VA 3 c1 f5 h0 kfoo s3 t60\r\nbar\r\n"},
+			{Cmd: "mg foo h\r\n", Reply: "HD h1\r\n"},
+			{Cmd: "ms foo 3 C99\r\nbaz\r\n", Reply: "EX\r\n"},
+			{Cmd: "ms foo 3 C1 c\r\nbaz\r\n", Reply: "HD c2\r\n"},
+			{Cmd: "ms foo 3 MA\r\nqux\r\n", Reply: "HD\r\n"},
+			{Cmd: "mg foo f v\r\n", Reply: "VA 6 f0\r\nbazqux\r\n"},
+			{Cmd: "ms foo 1 ME\r\nx\r\n", Reply: "NS\r\n"},
+			{Cmd: "md foo C1\r\n", Reply: "EX\r\n"},
+			{Cmd: "md foo k O1\r\n", Reply: "HD kfoo O1\r\n"},
+			{Cmd: "md foo\r\n", Reply: "NF\r\n"},
+			{Cmd: "ma cnt\r\n", Reply: "NF\r\n"},
+			{Cmd: "ma cnt J10 N0 v\r\n", Reply: "VA 2\r\n10\r\n"},
+			{Cmd: "ma cnt D5 v\r\n", Reply: "VA 2\r\n15\r\n"},
+			{Cmd: "ma cnt D20 MD v\r\n", Reply: "VA 1\r\n0\r\n"},
+			{Cmd: "mg Zm9v b k v\r\n", Reply: "EN b kZm9v\r\n"},
+			{Cmd: "ms Zm9v 1 b\r\nx\r\n", Reply: "HD\r\n"},
+			{Cmd: "mg foo v\r\n", Reply: "VA 1\r\nx\r\n"},
+			{Cmd: "mn\r\n", Reply: "MN\r\n"},
+		})
+	})
+
+	t.Run("quiet", func(t *testing.T) {
+		_, conn := newServer(t)
+		runExchanges(t, conn, []exchange{
+			{Cmd: "ms foo 3 q\r\nbar\r\nmg foo q v\r\nmg bar q v\r\nmd bar q\r\nmd foo q\r\nms foo 1 C1 O1 q\r\nx\r\nmn\r\n", Reply: "VA 3\r\nbar\r\nNF O1\r\nMN\r\n"},
+		})
+	})
+
+	t.Run("ttl", func(t *testing.T) {
+		srv, conn := newServer(t)
+		runExchanges(t, conn, []exchange{
+			{Cmd: "ms foo 3 T10\r\nbar\r\n", Reply: "HD\r\n"},
+			{Cmd: "ms bar 3\r\nbar\r\n", Reply: "HD\r\n"},
+			{Cmd: "mg foo t\r\n", Reply: "HD t10\r\n"},
+			{Cmd: "mg bar t\r\n", Reply: "HD t-1\r\n"},
+		})
+		srv.Advance(10 * time.Second)
+		runExchanges(t, conn, []exchange{
+			{Cmd: "mg foo v\r\n", Reply: "EN\r\n"},
+			{Cmd: "mg bar v\r\n", Reply: "VA 3\r\nbar\r\n"},
+			{Cmd: "touch bar 5\r\n", Reply: "TOUCHED\r\n"},
+		})
+		srv.Advance(5 * time.Second)
+		runExchanges(t, conn, []exchange{
+			{Cmd: "get bar\r\n", Reply: "END\r\n"},
+		})
+	})
+
+	t.Run("recache", func(t *testing.T) {
+		_, conn := newServer(t)
+		runExchanges(t, conn, []exchange{
+			{Cmd: "mg foo N30 v\r\n", Reply: "VA 0 W\r\n\r\n"},
+			{Cmd: "mg foo N30 v\r\n", Reply: "VA 0 Z\r\n\r\n"},
+			{Cmd: "ms foo 3 T30\r\nbar\r\n", Reply: "HD\r\n"},
+			{Cmd: "mg foo R60 v\r\n", Reply: "VA 3 W\r\nbar\r\n"},
+			{Cmd: "mg foo R60 v\r\n", Reply: "VA 3 Z\r\nbar\r\n"},
+			{Cmd: "md foo I\r\n", Reply: "HD\r\n"},
+			{Cmd: "mg foo c v\r\n", Reply: "VA 3 c3 W X\r\nbar\r\n"},
+			{Cmd: "mg foo v\r\n", Reply: "VA 3 Z X\r\nbar\r\n"},
+		})
+	})
+
+	t.Run("classic", func(t *testing.T) {
+		_, conn := newServer(t)
+		runExchanges(t, conn, []exchange{
+			{Cmd: "get foo\r\n", Reply: "END\r\n"},
+			{Cmd: "set foo 5 0 3\r\nbar\r\n", Reply: "STORED\r\n"},
+			{Cmd: "add foo 0 0 3\r\nbaz\r\n", Reply: "NOT_STORED\r\n"},
+			{Cmd: "replace nope 0 0 3\r\nbaz\r\n", Reply: "NOT_STORED\r\n"},
+			{Cmd: "prepend foo 0 0 1\r\n>\r\n", Reply: "STORED\r\n"},
+			{Cmd: "gets foo bar\r\n", Reply: "VALUE foo 5 4 2\r\n>bar\r\nEND\r\n"},
+			{Cmd: "cas foo 0 0 1 1\r\nx\r\n", Reply: "EXISTS\r\n"},
+			{Cmd: "cas foo 0 0 1 2\r\nx\r\n", Reply: "STORED\r\n"},
+			{Cmd: "cas nope 0 0 1 2\r\nx\r\n", Reply: "NOT_FOUND\r\n"},
+			{Cmd: "incr foo 1\r\n", Reply: "CLIENT_ERROR cannot increment or decrement non-numeric value\r\n"},
+			{Cmd: "set cnt 0 0 20 noreply\r\n18446744073709551615\r\n", Reply: ""},
+			{Cmd: "incr cnt 2\r\n", Reply: "1\r\n"},
+			{Cmd: "decr cnt 5\r\n", Reply: "0\r\n"},
+			{Cmd: "incr nope 1\r\n", Reply: "NOT_FOUND\r\n"},
+			{Cmd: "gat 100 foo\r\n", Reply: "VALUE foo 0 1\r\nx\r\nEND\r\n"},
+			{Cmd: "touch nope 10\r\n", Reply: "NOT_FOUND\r\n"},
+			{Cmd: "delete foo noreply\r\ndelete foo\r\n", Reply: "NOT_FOUND\r\n"},
+			{Cmd: "version\r\n", Reply: "VERSION memdtest\r\n"},
+			{Cmd: "bogus\r\n", Reply: "ERROR\r\n"},
+		})
+	})
+
+	t.Run("eviction", func(t *testing.T) {
+		srv, conn := newServer(t)
+		// a single 1KB page, which fits 10 of the items below
+		srv.Store().SetItemSizeMax(1024).SetMemoryLimit(1024)
+
+		var exchanges []exchange
+		for i := 0; i < 11; i++ {
+			exchanges = append(exchanges, exchange{Cmd: fmt.Sprintf("ms k%02d 10\r\n0123456789\r\n", i), Reply: "HD\r\n"})
+		}
+		exchanges = append(exchanges,
+			exchange{Cmd: "mg k00 v\r\n", Reply: "EN\r\n"},
+			exchange{Cmd: "mg k10 v\r\n", Reply: "VA 10\r\n0123456789\r\n"},
+		)
+		runExchanges(t, conn, exchanges)
+	})
+
+	t.Run("quit", func(t *testing.T) {
+		_, conn := newServer(t)
+		_, err := io.WriteString(conn, "quit\r\n")
+		require.NoError(t, err, "conn.Write should succeed")
+		_, err = conn.Read(make([]byte, 1))
+		require.ErrorIs(t, err, io.EOF, "connection should be closed")
+	})
+}
+
+func TestTLSServer(t *testing.T) {
+	srv := memdtest.NewTLSServer()
+	t.Cleanup(srv.Close)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+	conn, err := tls.Dial("tcp", srv.Addr(), &tls.Config{RootCAs: roots})
+	require.NoError(t, err, "tls.Dial should succeed")
+	t.Cleanup(func() { conn.Close() })
+
+	runExchanges(t, conn, []exchange{
+		{Cmd: "ms foo 3\r\nbar\r\n", Reply: "HD\r\n"},
+		{Cmd: "mg foo v\r\n", Reply: "VA 3\r\nbar\r\n"},
+	})
+
+	plain := memdtest.NewServer()
+	t.Cleanup(plain.Close)
+	require.Nil(t, plain.Certificate(), "plain servers should have no certificate")
+}
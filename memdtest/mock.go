@@ -0,0 +1,299 @@
+package memdtest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/lestrrat-go/memdproto"
+)
+
+// Mock is a scripted memcached server for unit tests. Unlike Server,
+// it does not implement any semantics: it expects an exact sequence of
+// commands, and responds to each one exactly as it was told to, which
+// includes misbehaving on purpose.
+//
+//	mock := memdtest.NewMock()
+//	defer mock.Close()
+//
+//	mock.Expect("mg foo v").Write("SERVER_ERROR out of memory\r\n")
+//	mock.Expect("mg foo v").Delay(time.Second).Reset()
+//
+//	... exercise the client against mock.Addr() ...
+//
+//	if err := mock.Verify(); err != nil {
+//	  t.Fatal(err)
+//	}
+//
+// Expectations are matched in the order that they were registered,
+// regardless of the connection that the commands arrive on. A command
+// that does not match the next expectation is recorded as an error,
+// and the connection that it arrived on is closed.
+type Mock struct {
+	// Listener is the listener that the mock accepts connections from
+	Listener net.Listener
+
+	mu           sync.Mutex
+	expectations []*Expectation
+	next         int
+	errs         []error
+	conns        map[net.Conn]struct{}
+	closed       bool
+	wg           sync.WaitGroup
+}
+
+// NewMock starts and returns a new Mock listening on a loopback port.
+// The caller should call Close when finished, to shut it down.
+//
+// Like NewServer, NewMock panics if it can not listen.
+func NewMock() *Mock {
+	m := &Mock{
+		Listener: listenLoopback(),
+		conns:    make(map[net.Conn]struct{}),
+	}
+	m.wg.Add(1)
+	go m.serve()
+	return m
+}
+
+// Addr returns the address that the mock is listening on,
+// in the form "host:port"
+func (m *Mock) Addr() string {
+	return m.Listener.Addr().String()
+}
+
+// Close shuts down the mock, closing all client connections, and
+// waits for all of the connection handlers to return.
+func (m *Mock) Close() {
+	m.mu.Lock()
+	if !m.closed {
+		m.closed = true
+		m.Listener.Close()
+		for conn := range m.conns {
+			conn.Close()
+		}
+	}
+	m.mu.Unlock()
+	m.wg.Wait()
+}
+
+// Expect registers an expectation for a command, given in its wire form.
+// The trailing CRLF may be omitted, unless the command has a data block:
+//
+//	mock.Expect("mg foo v")
+//	mock.Expect("ms foo 3\r\nbar\r\n")
+//
+// Commands are compared after being decoded, so the order of meta flags
+// does not matter. Expect panics if cmd can not be parsed.
+func (m *Mock) Expect(cmd string) *Expectation {
+	if !bytes.HasSuffix([]byte(cmd), []byte("\r\n")) {
+		cmd += "\r\n"
+	}
+	parsed, err := memdproto.ParseCmd([]byte(cmd), memdproto.ParseModeLenient)
+	if err != nil {
+		panic(fmt.Sprintf("memdtest: invalid expected command %q: %v", cmd, err))
+	}
+	return m.ExpectCmd(parsed)
+}
+
+// ExpectCmd registers an expectation for cmd
+func (m *Mock) ExpectCmd(cmd memdproto.Cmd) *Expectation {
+	var buf bytes.Buffer
+	if _, err := cmd.WriteTo(&buf); err != nil {
+		panic(fmt.Sprintf("memdtest: failed to encode expected %s command: %v", cmd.Verb(), err))
+	}
+
+	e := &Expectation{wire: buf.Bytes()}
+	m.mu.Lock()
+	m.expectations = append(m.expectations, e)
+	m.mu.Unlock()
+	return e
+}
+
+// Verify returns an error if any of the expectations were not met,
+// or if any unexpected commands were received.
+func (m *Mock) Verify() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	errs := append([]error(nil), m.errs...)
+	for _, e := range m.expectations[m.next:] {
+		errs = append(errs, fmt.Errorf(`memdtest: expected command %q was not received`, e.wire))
+	}
+	return errors.Join(errs...)
+}
+
+func (m *Mock) serve() {
+	defer m.wg.Done()
+	for {
+		conn, err := m.Listener.Accept()
+		if err != nil {
+			return
+		}
+
+		m.mu.Lock()
+		if m.closed {
+			m.mu.Unlock()
+			conn.Close()
+			return
+		}
+		m.conns[conn] = struct{}{}
+		m.wg.Add(1)
+		m.mu.Unlock()
+
+		go m.serveConn(conn)
+	}
+}
+
+func (m *Mock) serveConn(conn net.Conn) {
+	defer m.wg.Done()
+	defer func() {
+		m.mu.Lock()
+		delete(m.conns, conn)
+		m.mu.Unlock()
+		conn.Close()
+	}()
+
+	rdr := memdproto.NewCmdReader(conn).SetParseMode(memdproto.ParseModeLenient)
+	var buf bytes.Buffer
+	for {
+		cmd, err := rdr.ReadCmd()
+		if err != nil {
+			// only malformed commands are reported: the client is
+			// free to go away at any time
+			var perr *memdproto.ParseError
+			if errors.As(err, &perr) {
+				m.fail(fmt.Errorf(`memdtest: failed to read command: %w`, err))
+			}
+			return
+		}
+
+		buf.Reset()
+		if _, err := cmd.WriteTo(&buf); err != nil {
+			m.fail(fmt.Errorf(`memdtest: failed to encode received %s command: %w`, cmd.Verb(), err))
+			return
+		}
+
+		e, err := m.match(buf.Bytes())
+		if err != nil {
+			m.fail(err)
+			return
+		}
+
+		for _, action := range e.actions {
+			if !action(conn) {
+				return
+			}
+		}
+	}
+}
+
+// match pops the next expectation, which must be for the command in wire
+func (m *Mock) match(wire []byte) (*Expectation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.next >= len(m.expectations) {
+		return nil, fmt.Errorf(`memdtest: unexpected command %q`, wire)
+	}
+
+	e := m.expectations[m.next]
+	if !bytes.Equal(e.wire, wire) {
+		return nil, fmt.Errorf(`memdtest: expected command %q, got %q`, e.wire, wire)
+	}
+	m.next++
+	return e, nil
+}
+
+func (m *Mock) fail(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.errs = append(m.errs, err)
+}
+
+// Expectation describes what the Mock does once it receives an
+// expected command. Actions are carried out in the order that they
+// were added. If no actions are added, nothing is sent back.
+//
+// Expectations must be fully set up before the command is received.
+type Expectation struct {
+	wire    []byte
+	actions []func(net.Conn) bool
+}
+
+// Reply sends reply back to the client
+func (e *Expectation) Reply(reply memdproto.Reply) *Expectation {
+	var buf bytes.Buffer
+	if _, err := reply.WriteTo(&buf); err != nil {
+		panic(fmt.Sprintf("memdtest: failed to encode reply: %v", err))
+	}
+	return e.Write(buf.String())
+}
+
+// Write sends data back to the client as-is. This is used for replies
+// written in their wire form, as well as for sending garbage.
+func (e *Expectation) Write(data string) *Expectation {
+	e.actions = append(e.actions, func(conn net.Conn) bool {
+		_, err := conn.Write([]byte(data))
+		return err == nil
+	})
+	return e
+}
+
+// WritePartial sends data back to the client in chunks of size bytes,
+// pausing for interval between each chunk, so that the client observes
+// short reads.
+func (e *Expectation) WritePartial(data string, size int, interval time.Duration) *Expectation {
+	if size <= 0 {
+		panic("memdtest: chunk size must be positive")
+	}
+	e.actions = append(e.actions, func(conn net.Conn) bool {
+		rest := data
+		for len(rest) > 0 {
+			n := min(size, len(rest))
+			if _, err := conn.Write([]byte(rest[:n])); err != nil {
+				return false
+			}
+			rest = rest[n:]
+			if len(rest) > 0 {
+				time.Sleep(interval)
+			}
+		}
+		return true
+	})
+	return e
+}
+
+// Delay pauses for d before carrying out the next action
+func (e *Expectation) Delay(d time.Duration) *Expectation {
+	e.actions = append(e.actions, func(net.Conn) bool {
+		time.Sleep(d)
+		return true
+	})
+	return e
+}
+
+// Close closes the connection gracefully. Any further actions are ignored.
+func (e *Expectation) Close() *Expectation {
+	e.actions = append(e.actions, func(net.Conn) bool {
+		return false
+	})
+	return e
+}
+
+// Reset closes the connection abruptly, so that the client sees
+// the connection being reset rather than closed. Any further actions
+// are ignored.
+func (e *Expectation) Reset() *Expectation {
+	e.actions = append(e.actions, func(conn net.Conn) bool {
+		if tcp, ok := conn.(*net.TCPConn); ok {
+			// discard unsent data and send RST instead of FIN
+			_ = tcp.SetLinger(0)
+		}
+		return false
+	})
+	return e
+}
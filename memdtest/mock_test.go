@@ -0,0 +1,110 @@
+package memdtest_test
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/memdproto"
+	"github.com/lestrrat-go/memdproto/memdtest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMock(t *testing.T) {
+	newMock := func(t *testing.T) (*memdtest.Mock, net.Conn) {
+		t.Helper()
+		mock := memdtest.NewMock()
+		t.Cleanup(mock.Close)
+
+		conn, err := net.Dial("tcp", mock.Addr())
+		require.NoError(t, err, "net.Dial should succeed")
+		t.Cleanup(func() { conn.Close() })
+		require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)), "conn.SetDeadline should succeed")
+		return mock, conn
+	}
+
+	t.Run("replies", func(t *testing.T) {
+		mock, conn := newMock(t)
+		mock.Expect("mg foo v").Write("SERVER_ERROR out of memory\r\n")
+		mock.Expect("ms foo 3 T10 c\r\nbar\r\n").Reply(memdproto.NewMetaSetReply().SetStatus(memdproto.MetaSetCmdStatusStored).SetCas(1))
+
+		_, err := io.WriteString(conn, "mg foo v\r\nms foo 3 c T10\r\nbar\r\n")
+		require.NoError(t, err, "conn.Write should succeed")
+
+		buf := make([]byte, len("SERVER_ERROR out of memory\r\nHD c1\r\n"))
+		_, err = io.ReadFull(conn, buf)
+		require.NoError(t, err, "io.ReadFull should succeed")
+		require.Equal(t, "SERVER_ERROR out of memory\r\nHD c1\r\n", string(buf), "replies should match")
+		require.NoError(t, mock.Verify(), "mock.Verify should succeed")
+	})
+
+	t.Run("partial write", func(t *testing.T) {
+		mock, conn := newMock(t)
+		mock.Expect("mg foo v").
+			Delay(10*time.Millisecond).
+			WritePartial("VA 3\r\nbar\r\n", 2, 10*time.Millisecond)
+
+		_, err := io.WriteString(conn, "mg foo v\r\n")
+		require.NoError(t, err, "conn.Write should succeed")
+
+		n, err := conn.Read(make([]byte, 64))
+		require.NoError(t, err, "conn.Read should succeed")
+		require.Equal(t, 2, n, "reply should arrive in chunks")
+
+		var reply memdproto.MetaGetReply
+		_, err = reply.ReadFrom(bufio.NewReader(conn))
+		require.Error(t, err, "reading the rest as a reply should fail")
+		require.NoError(t, mock.Verify(), "mock.Verify should succeed")
+	})
+
+	t.Run("reset", func(t *testing.T) {
+		mock, conn := newMock(t)
+		mock.Expect("mg foo v").Reset()
+
+		_, err := io.WriteString(conn, "mg foo v\r\n")
+		require.NoError(t, err, "conn.Write should succeed")
+
+		_, err = conn.Read(make([]byte, 1))
+		require.ErrorIs(t, err, syscall.ECONNRESET, "connection should be reset")
+		require.NoError(t, mock.Verify(), "mock.Verify should succeed")
+	})
+
+	t.Run("close", func(t *testing.T) {
+		mock, conn := newMock(t)
+		mock.Expect("mn").Write("garbage").Close()
+
+		_, err := io.WriteString(conn, "mn\r\n")
+		require.NoError(t, err, "conn.Write should succeed")
+
+		data, err := io.ReadAll(conn)
+		require.NoError(t, err, "io.ReadAll should succeed")
+		require.Equal(t, "garbage", string(data), "garbage should be sent before closing")
+		require.NoError(t, mock.Verify(), "mock.Verify should succeed")
+	})
+
+	t.Run("unmet", func(t *testing.T) {
+		mock, conn := newMock(t)
+		mock.Expect("mg foo v").Write("EN\r\n")
+		mock.Expect("mg bar v").Write("EN\r\n")
+
+		_, err := io.WriteString(conn, "mg foo v\r\n")
+		require.NoError(t, err, "conn.Write should succeed")
+		_, err = io.ReadFull(conn, make([]byte, 4))
+		require.NoError(t, err, "io.ReadFull should succeed")
+		require.Error(t, mock.Verify(), "mock.Verify should fail")
+	})
+
+	t.Run("unexpected", func(t *testing.T) {
+		mock, conn := newMock(t)
+		mock.Expect("mg foo v").Write("EN\r\n")
+
+		_, err := io.WriteString(conn, "mg bar v\r\n")
+		require.NoError(t, err, "conn.Write should succeed")
+		_, err = io.ReadAll(conn)
+		require.NoError(t, err, "connection should be closed")
+		require.Error(t, mock.Verify(), "mock.Verify should fail")
+	})
+}
@@ -0,0 +1,117 @@
+// Package memdtest provides a memcached server for use in tests,
+// in the spirit of net/http/httptest.
+//
+// The server listens on a loopback port, and serves an in-memory
+// store.Store, which implements the meta commands and the classic
+// storage, retrieval, deletion, arithmetic and touch commands with
+// the semantics of memcached. This is enough to exercise clients
+// without a memcached binary:
+//
+//	srv := memdtest.NewServer()
+//	defer srv.Close()
+//
+//	conn, err := net.Dial("tcp", srv.Addr())
+//
+// NewTLSServer starts a server that speaks TLS instead, with a
+// certificate issued by a certificate authority of its own.
+//
+// By default, the store has no memory limit, so that items are only ever
+// removed when they expire, or when they are deleted. Tests that exercise
+// eviction can set a limit through Store.
+package memdtest
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/lestrrat-go/memdproto/server"
+	"github.com/lestrrat-go/memdproto/store"
+)
+
+// Server is an in-memory memcached server listening on a loopback port.
+type Server struct {
+	// Listener is the listener that the server accepts connections from
+	Listener net.Listener
+
+	srv    *server.Server
+	store  *store.Store
+	cert   *x509.Certificate
+	mu     sync.Mutex
+	offset time.Duration
+	done   chan struct{}
+}
+
+// NewServer starts and returns a new Server listening on a loopback
+// port. The caller should call Close when finished, to shut it down.
+//
+// Like httptest.NewServer, NewServer panics if it can not listen.
+func NewServer() *Server {
+	return newServer(nil)
+}
+
+func newServer(cfg *tls.Config) *Server {
+	s := &Server{
+		Listener: listenLoopback(),
+		done:     make(chan struct{}),
+	}
+	s.store = store.New().SetClock(s.now).SetVersion("memdtest")
+	s.srv = server.New(s.store).SetTLSConfig(cfg)
+	go func() {
+		defer close(s.done)
+		s.srv.Serve(s.Listener)
+	}()
+	return s
+}
+
+func listenLoopback() net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		if ln, err = net.Listen("tcp6", "[::1]:0"); err != nil {
+			panic(fmt.Sprintf("memdtest: failed to listen on a port: %v", err))
+		}
+	}
+	return ln
+}
+
+// Addr returns the address that the server is listening on,
+// in the form "host:port"
+func (s *Server) Addr() string {
+	return s.Listener.Addr().String()
+}
+
+// Close shuts down the server, closing all client connections, and
+// waits for all of the connection handlers to return.
+func (s *Server) Close() {
+	s.srv.Close()
+	<-s.done
+}
+
+// Store returns the store that the server serves, so that tests can
+// change its settings, such as its memory limit
+func (s *Server) Store() *store.Store {
+	return s.store
+}
+
+// Flush removes all items from the server
+func (s *Server) Flush() {
+	s.store.Flush()
+}
+
+// Advance moves the clock of the server forward by d, so that tests
+// can expire items without having to sleep.
+func (s *Server) Advance(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.offset += d
+}
+
+// now returns the current time according to the server
+func (s *Server) now() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return time.Now().Add(s.offset)
+}
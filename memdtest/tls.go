@@ -0,0 +1,89 @@
+package memdtest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"time"
+)
+
+// NewTLSServer starts and returns a new Server that speaks TLS. Its
+// certificate is valid for "localhost" and the loopback addresses, and
+// is issued by a certificate authority that is generated for the
+// server, see Certificate.
+//
+// Like NewServer, NewTLSServer panics if it can not listen.
+func NewTLSServer() *Server {
+	caCert, caKey, err := newCA()
+	if err != nil {
+		panic(fmt.Sprintf("memdtest: failed to generate certificate authority: %v", err))
+	}
+	cert, err := newServerCert(caCert, caKey)
+	if err != nil {
+		panic(fmt.Sprintf("memdtest: failed to generate certificate: %v", err))
+	}
+
+	s := newServer(&tls.Config{Certificates: []tls.Certificate{cert}})
+	s.cert = caCert
+	return s
+}
+
+// Certificate returns the certificate authority that issued the
+// certificate of the server, or nil if the server does not speak TLS.
+// Clients can trust it by adding it to the RootCAs of their tls.Config.
+func (s *Server) Certificate() *x509.Certificate {
+	return s.cert
+}
+
+func newCA() (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "memdtest CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
+
+func newServerCert(ca *x509.Certificate, caKey *ecdsa.PrivateKey) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "memdtest"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
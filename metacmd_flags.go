@@ -2,6 +2,7 @@ package memdproto
 
 import (
 	"bytes"
+	"encoding/base64"
 	"fmt"
 	"io"
 )
@@ -30,6 +31,28 @@ func writeFlags(dst io.Writer, flags ...io.WriterTo) (int64, error) {
 	return written, nil
 }
 
+// replyNumber is a numeric flag in a reply. Unlike the same flag in
+// a command, it is always followed by its value, even if it is 0.
+type replyNumber struct {
+	flag  byte
+	value uint64
+}
+
+func replyNumberFlag[T ~uint32 | ~uint64](flag byte, v *T) *replyNumber {
+	if v == nil {
+		return nil
+	}
+	return &replyNumber{flag: flag, value: uint64(*v)}
+}
+
+func (f *replyNumber) WriteTo(dst io.Writer) (int64, error) {
+	if f == nil {
+		return 0, nil
+	}
+	n, err := fmt.Fprintf(dst, "%c%d", f.flag, f.value)
+	return int64(n), err
+}
+
 // FlagKeyAsBase64 is a flag used in Meta Commands to indicate
 // if the key used should be treated as a base64 encoded string
 type FlagKeyAsBase64 struct{}
@@ -327,6 +350,18 @@ func (f *FlagCompareCas) WriteTo(dst io.Writer) (int64, error) {
 	return int64(n), err
 }
 
+// FlagNewCas is the "E" flag, which sets the CAS value of the item
+// to the given value, instead of one generated by the server
+type FlagNewCas uint64
+
+func (f *FlagNewCas) WriteTo(dst io.Writer) (int64, error) {
+	if f == nil {
+		return 0, nil
+	}
+	n, err := fmt.Fprintf(dst, "E%d", *f)
+	return int64(n), err
+}
+
 type FlagSetClientFlags uint32
 
 func (f *FlagSetClientFlags) WriteTo(dst io.Writer) (int64, error) {
@@ -356,3 +391,86 @@ func (f *FlagSetTTL) WriteTo(dst io.Writer) (int64, error) {
 	n, err := fmt.Fprintf(dst, "T%d", *f)
 	return int64(n), err
 }
+
+// replyKeyFlags creates the key ("k") and base64 ("b") flags to be
+// used in a reply. If b64 is true, s is base64 encoded.
+func replyKeyFlags(s string, b64 bool) (*FlagRetrieveKey, *FlagKeyAsBase64) {
+	if s == "" {
+		return nil, nil
+	}
+	if !b64 {
+		return &FlagRetrieveKey{key: &s}, nil
+	}
+	encoded := base64.StdEncoding.EncodeToString([]byte(s))
+	return &FlagRetrieveKey{key: &encoded}, &FlagKeyAsBase64{}
+}
+
+// replyKey returns the key stored in the key ("k") flag of a reply,
+// decoding it if the base64 ("b") flag is present.
+func replyKey(rkey *FlagRetrieveKey, b64 *FlagKeyAsBase64) string {
+	if rkey == nil || rkey.key == nil {
+		return ""
+	}
+	s := *rkey.key
+
+	if b64 != nil {
+		decoded, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return ""
+		}
+		s = string(decoded)
+	}
+	return s
+}
+
+// FlagInitialValue is a flag used in the meta arithmetic command to
+// specify the initial value of an item that is auto-created on miss
+type FlagInitialValue uint64
+
+func (f *FlagInitialValue) WriteTo(dst io.Writer) (int64, error) {
+	if f == nil {
+		return 0, nil
+	}
+	n, err := fmt.Fprintf(dst, "J%d", *f)
+	return int64(n), err
+}
+
+// FlagDelta is a flag used in the meta arithmetic command to
+// specify the amount to increment or decrement by
+type FlagDelta uint64
+
+func (f *FlagDelta) WriteTo(dst io.Writer) (int64, error) {
+	if f == nil {
+		return 0, nil
+	}
+	n, err := fmt.Fprintf(dst, "D%d", *f)
+	return int64(n), err
+}
+
+type MetaArithmeticMode uint8
+
+const (
+	MetaArithmeticModeIncr MetaArithmeticMode = iota
+	MetaArithmeticModeDecr
+	MetaArithmeticModeMax
+)
+
+func (m *MetaArithmeticMode) WriteTo(dst io.Writer) (int64, error) {
+	if m == nil {
+		return 0, nil
+	}
+
+	flag := []byte{'M'}
+	switch *m {
+	case MetaArithmeticModeIncr:
+		flag = append(flag, 'I')
+	case MetaArithmeticModeDecr:
+		flag = append(flag, 'D')
+	default:
+		return 0, fmt.Errorf("invalid MetaArithmeticMode")
+	}
+
+	n, err := dst.Write(flag)
+
+	return int64(n), err
+}
@@ -0,0 +1,121 @@
+package memdproto
+
+import (
+	"bytes"
+	"fmt"
+	"sync/atomic"
+)
+
+const opaqueAlphabet = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+// OpaqueAllocator generates opaque tokens ("O" flag) for meta commands.
+//
+// Tokens are generated from a counter and encoded in base 62, so they
+// stay short (at most 11 bytes) and never contain characters that are
+// not allowed in the protocol. Tokens are unique for the lifetime of the
+// allocator, so one allocator should be used per connection.
+//
+// It is safe to call the methods on this object concurrently.
+type OpaqueAllocator struct {
+	next atomic.Uint64
+}
+
+func NewOpaqueAllocator() *OpaqueAllocator {
+	return &OpaqueAllocator{}
+}
+
+// Next returns a new opaque token.
+func (a *OpaqueAllocator) Next() []byte {
+	return encodeOpaque(a.next.Add(1) - 1)
+}
+
+func encodeOpaque(v uint64) []byte {
+	var buf [11]byte
+	i := len(buf)
+	for {
+		i--
+		buf[i] = opaqueAlphabet[v%uint64(len(opaqueAlphabet))]
+		v /= uint64(len(opaqueAlphabet))
+		if v == 0 {
+			break
+		}
+	}
+	return append([]byte(nil), buf[i:]...)
+}
+
+// CorrelationError is returned by Correlate when a reply does not
+// echo back the opaque token or the key of the command that it was
+// supposedly sent in response to. This usually means that the
+// connection is out of sync, and should be discarded.
+type CorrelationError struct {
+	Verb     string
+	Field    string // "opaque" or "key"
+	Expected string
+	Actual   string
+}
+
+func (e *CorrelationError) Error() string {
+	return fmt.Sprintf(`memdproto: reply does not match %s command: expected %s %q, got %q`, e.Verb, e.Field, e.Expected, e.Actual)
+}
+
+// keyRetriever is implemented by meta commands that can request
+// the key to be echoed back in the reply
+type keyRetriever interface {
+	RetrieveKey() bool
+}
+
+// keyer is implemented by meta replies that may contain a key
+type keyer interface {
+	Key() string
+}
+
+// Correlate checks that reply could have been sent in response to cmd.
+//
+// If cmd carries an opaque token ("O" flag), the reply must echo back
+// the same token. If cmd requests the key to be returned ("k" flag),
+// the key in the reply must match the key in the command. A mismatch
+// is reported as a *CorrelationError.
+//
+// A nil reply, e.g. a reply that has been suppressed in quiet mode,
+// always correlates.
+func Correlate(cmd Cmd, reply Reply) error {
+	if reply == nil {
+		return nil
+	}
+
+	if co, ok := cmd.(opaquer); ok {
+		var actual []byte
+		if ro, ok := reply.(opaquer); ok {
+			actual = ro.Opaque()
+		}
+		if !bytes.Equal(co.Opaque(), actual) {
+			return &CorrelationError{
+				Verb:     cmd.Verb(),
+				Field:    "opaque",
+				Expected: string(co.Opaque()),
+				Actual:   string(actual),
+			}
+		}
+	}
+
+	if kr, ok := cmd.(keyRetriever); ok && kr.RetrieveKey() {
+		var actual string
+		if rk, ok := reply.(keyer); ok {
+			actual = rk.Key()
+		}
+
+		var expected string
+		if keys := cmd.Keys(); len(keys) > 0 {
+			expected = keys[0]
+		}
+		if expected != actual {
+			return &CorrelationError{
+				Verb:     cmd.Verb(),
+				Field:    "key",
+				Expected: expected,
+				Actual:   actual,
+			}
+		}
+	}
+	return nil
+}
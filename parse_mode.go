@@ -0,0 +1,150 @@
+package memdproto
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// ParseMode controls how strictly a command line is checked before
+// it is handed to the command's UnmarshalText method.
+type ParseMode uint8
+
+const (
+	// ParseModeStrict accepts only command lines that follow protocol.txt
+	// to the letter: lines must be terminated by CRLF, tokens must be
+	// separated by exactly one space, there must be no leading or trailing
+	// spaces, and "noreply" may only appear as the last token.
+	ParseModeStrict ParseMode = iota
+	// ParseModeLenient accepts whatever memcached itself accepts: lines
+	// may be terminated by a bare LF, tokens may be separated by any number
+	// of spaces, and trailing spaces are ignored. As in memcached, "noreply"
+	// is only honored as the last token. The line is rewritten into its
+	// strict form before it is parsed.
+	ParseModeLenient
+	ParseModeMax
+)
+
+func (m ParseMode) String() string {
+	switch m {
+	case ParseModeStrict:
+		return "strict"
+	case ParseModeLenient:
+		return "lenient"
+	default:
+		return fmt.Sprintf("ParseMode(%d)", m)
+	}
+}
+
+var noreplyToken = []byte("noreply")
+
+// classic commands that accept a trailing "noreply" token.
+// retrieval commands such as get/gets are not listed here, as
+// memcached treats "noreply" as yet another key for those
+var noreplyVerbs = map[string]struct{}{
+	"set":       {},
+	"add":       {},
+	"replace":   {},
+	"append":    {},
+	"prepend":   {},
+	"cas":       {},
+	"delete":    {},
+	"incr":      {},
+	"decr":      {},
+	"touch":     {},
+	"flush_all": {},
+	"verbosity": {},
+}
+
+// canonicalizeLine checks a single command line, including its line
+// terminator, according to mode. It returns the line in its strict form,
+// always terminated by CRLF.
+func canonicalizeLine(line []byte, mode ParseMode) ([]byte, error) {
+	switch mode {
+	case ParseModeStrict:
+		return checkStrictLine(line)
+	case ParseModeLenient:
+		return normalizeLenientLine(line)
+	default:
+		return nil, fmt.Errorf(`memdproto: invalid parse mode %s`, mode)
+	}
+}
+
+func checkStrictLine(line []byte) ([]byte, error) {
+	verb := string(firstToken(line))
+	lline := len(line)
+	if lline < 2 || line[lline-2] != '\r' || line[lline-1] != '\n' {
+		return nil, parseErrorf(verb, lline, ``, `expected CRLF at end of line`)
+	}
+
+	body := line[:lline-2]
+	if len(body) == 0 {
+		return nil, parseErrorf(``, 0, ``, `empty command line`)
+	}
+
+	if body[0] == ' ' {
+		return nil, parseErrorf(``, 0, ``, `unexpected leading space`)
+	}
+
+	if body[len(body)-1] == ' ' {
+		return nil, parseErrorf(verb, len(body)-1, ``, `unexpected trailing space`)
+	}
+
+	if i := bytes.Index(body, []byte("  ")); i >= 0 {
+		return nil, parseErrorf(verb, i, ``, `unexpected repeated space`)
+	}
+
+	if i := bytes.IndexAny(body, "\r\n"); i >= 0 {
+		return nil, parseErrorf(verb, i, ``, `unexpected line terminator`)
+	}
+
+	if _, ok := noreplyVerbs[verb]; ok {
+		// tokens are separated by exactly one space at this point
+		var offset int
+		fields := bytes.Split(body, space)
+		for i, field := range fields[:len(fields)-1] {
+			if i > 1 && bytes.Equal(field, noreplyToken) {
+				return nil, parseErrorf(verb, offset, `noreply`, `noreply must be the last token`)
+			}
+			offset += len(field) + 1
+		}
+	}
+	return line, nil
+}
+
+func normalizeLenientLine(line []byte) ([]byte, error) {
+	body := bytes.TrimSuffix(line, []byte{'\n'})
+	if len(body) == len(line) {
+		return nil, parseErrorf(string(firstToken(line)), len(line), ``, `expected LF at end of line`)
+	}
+	body = bytes.TrimSuffix(body, []byte{'\r'})
+
+	fields := bytes.FieldsFunc(body, func(r rune) bool { return r == ' ' })
+	if len(fields) == 0 {
+		return nil, parseErrorf(``, 0, ``, `empty command line`)
+	}
+
+	verb := string(fields[0])
+	if _, ok := noreplyVerbs[verb]; ok && len(fields) > 2 {
+		// memcached only looks for "noreply" in the last token, so
+		// anywhere else it is left alone, and rejected by the parser
+		args := fields
+		noreply := bytes.Equal(args[len(args)-1], noreplyToken)
+		if noreply {
+			args = args[:len(args)-1]
+		}
+
+		// memcached still accepts the legacy "delete <key> 0" form
+		if verb == "delete" && len(args) == 3 && bytes.Equal(args[2], []byte{'0'}) {
+			args = args[:2]
+		}
+
+		if noreply {
+			args = append(args, noreplyToken)
+		}
+		fields = args
+	}
+
+	normalized := bytes.Join(fields, space)
+	normalized = append(normalized, crlf...)
+	return normalized, nil
+}
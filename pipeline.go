@@ -0,0 +1,228 @@
+package memdproto
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// ErrPipelineEmpty is returned by Pipeline.ReadReply when there are
+// no outstanding commands to pair a reply with.
+var ErrPipelineEmpty = errors.New("memdproto: no outstanding commands in pipeline")
+
+// status codes that can be sent in response to each meta command
+var metaReplyCodes = map[string][]string{
+	"mg": {"VA", "HD", "EN"},
+	"ms": {"HD", "NS", "EX", "NF"},
+	"md": {"HD", "EX", "NF"},
+	"ma": {"VA", "HD", "NF", "NS", "EX"},
+	"mn": {"MN"},
+}
+
+// Pipeline pairs replies read from a connection with the commands that
+// were previously sent over it.
+//
+// Commands must be registered using Push in the same order that they
+// are written to the connection. ReadReply then reads the replies in
+// order, taking into account that commands in quiet mode may not receive
+// a reply at all. To decide whether a quiet command's reply was suppressed,
+// the next reply line is inspected: its status code, its opaque ("O") token,
+// and the "MN" reply to a meta no-op command are used to determine which
+// command it belongs to.
+//
+// Commands in noreply mode are treated the same way, since the server
+// still sends an error reply if they fail. This means that a batch of
+// quiet or noreply commands must always be terminated by a command that
+// always receives a reply, usually MetaNoopCmd. Otherwise ReadReply blocks
+// until the server sends something, or closes the connection.
+//
+// Push may be called concurrently with ReadReply, but ReadReply itself
+// should only be called from one goroutine at a time.
+type Pipeline struct {
+	mu    sync.Mutex
+	src   *bufio.Reader
+	queue []Cmd
+}
+
+// NewPipeline creates a new Pipeline that reads replies from src.
+func NewPipeline(src io.Reader) *Pipeline {
+	return &Pipeline{
+		src: bufferedReader(src),
+	}
+}
+
+// Push registers commands that have been sent to the server.
+func (p *Pipeline) Push(cmds ...Cmd) *Pipeline {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.queue = append(p.queue, cmds...)
+	return p
+}
+
+// Len returns the number of commands that have not been paired with a reply.
+func (p *Pipeline) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.queue)
+}
+
+func (p *Pipeline) head() (Cmd, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.queue) == 0 {
+		return nil, ErrPipelineEmpty
+	}
+	return p.queue[0], nil
+}
+
+func (p *Pipeline) pop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.queue[0] = nil
+	p.queue = p.queue[1:]
+}
+
+// ReadReply returns the oldest outstanding command along with its reply.
+// If the server did not send a reply for the command because it was
+// in quiet or noreply mode, the returned Reply is nil.
+//
+// If the server replied with ERROR, CLIENT_ERROR or SERVER_ERROR, the
+// reply is an *ErrorReply, which is also returned as the error. Since
+// the server sends error replies even to commands in noreply mode, an
+// error reply that follows such a command is paired with it. ReadReply
+// therefore blocks until the line that follows a command in noreply mode
+// has been received, or until the end of the stream.
+//
+// If the reply could not be parsed, the command is returned along
+// with the error. If the reply does not correlate with the command
+// (see Correlate), the command and the reply are returned along with
+// a *CorrelationError.
+func (p *Pipeline) ReadReply() (Cmd, Reply, error) {
+	cmd, err := p.head()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	expect := ExpectReply(cmd)
+	line, err := peekLine(p.src)
+	if err != nil {
+		// a command in noreply mode that was sent last is only
+		// known to have succeeded once the connection is closed
+		if expect.Never() && errors.Is(err, io.EOF) && p.src.Buffered() == 0 {
+			p.pop()
+			return cmd, nil, nil
+		}
+		return cmd, nil, fmt.Errorf(`memdproto.Pipeline: failed to read reply: %w`, err)
+	}
+
+	// commands in noreply mode still receive error replies, which
+	// must not be paired with the next command
+	if expect.Never() && !isErrorLine(line) {
+		p.pop()
+		return cmd, nil, nil
+	}
+	if !expect.Always() && !replyBelongsTo(cmd, line) {
+		p.pop()
+		return cmd, nil, nil
+	}
+
+	p.pop()
+	if isErrorLine(line) {
+		ereply := NewErrorReply()
+		if _, err := ereply.ReadFrom(p.src); err != nil {
+			return cmd, nil, err
+		}
+		return cmd, ereply, ereply
+	}
+
+	reply := cmd.NewReply()
+	if _, err := reply.ReadFrom(p.src); err != nil {
+		return cmd, nil, err
+	}
+	if err := Correlate(cmd, reply); err != nil {
+		return cmd, reply, err
+	}
+	return cmd, reply, nil
+}
+
+// peekLine returns the next line in src, including the line
+// terminator, without consuming it.
+func peekLine(src *bufio.Reader) ([]byte, error) {
+	n := src.Buffered()
+	if n == 0 {
+		n = 1
+	}
+	for {
+		buf, err := src.Peek(n)
+		if i := bytes.IndexByte(buf, '\n'); i >= 0 {
+			return buf[:i+1], nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if n >= src.Size() {
+			return nil, fmt.Errorf(`line exceeds %d bytes`, src.Size())
+		}
+		n = len(buf) + 1
+	}
+}
+
+// opaquer is implemented by meta commands and replies
+type opaquer interface {
+	Opaque() []byte
+}
+
+// replyLineOpaque returns the value of the opaque ("O") flag in a meta reply line
+func replyLineOpaque(line []byte) ([]byte, bool) {
+	fields := bytes.Split(line, space)
+	for _, field := range fields[1:] {
+		if len(field) > 1 && field[0] == 'O' {
+			return field[1:], true
+		}
+	}
+	return nil, false
+}
+
+// replyBelongsTo inspects a reply line, and decides whether it could
+// have been sent in response to cmd.
+func replyBelongsTo(cmd Cmd, line []byte) bool {
+	line = bytes.TrimRight(line, "\r\n")
+	code := string(firstToken(line))
+
+	if isErrorLine(line) {
+		// errors are never suppressed
+		return true
+	}
+
+	if ExpectReply(cmd).IsSuppressed(code) {
+		return false
+	}
+
+	if codes, ok := metaReplyCodes[cmd.Verb()]; ok {
+		var valid bool
+		for _, c := range codes {
+			if c == code {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return false
+		}
+	}
+
+	if o, ok := cmd.(opaquer); ok {
+		// memcached echoes back the opaque token verbatim, and only if
+		// one was provided in the command
+		opaque, found := replyLineOpaque(line)
+		if found || len(o.Opaque()) > 0 {
+			if !bytes.Equal(opaque, o.Opaque()) {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,68 @@
+package memdproto
+
+// ReplyExpectation describes which replies a server sends back for a
+// given command. Use ExpectReply to create one.
+//
+// Commands that are not in quiet mode always receive a reply. Meta
+// commands in quiet mode ("q" flag) only receive a reply for some status
+// codes, and classic commands in noreply mode never receive a reply.
+type ReplyExpectation struct {
+	never      bool
+	suppressed []string
+}
+
+// suppressed status codes for each meta command in quiet mode
+var quietSuppressed = map[string][]string{
+	"mg": {"EN"},
+	"ms": {"HD"},
+	"md": {"HD", "NF"},
+	"ma": {"HD"},
+}
+
+// ExpectReply returns the ReplyExpectation for cmd.
+func ExpectReply(cmd Cmd) ReplyExpectation {
+	if !cmd.NoReply() {
+		return ReplyExpectation{}
+	}
+
+	if suppressed, ok := quietSuppressed[cmd.Verb()]; ok {
+		return ReplyExpectation{suppressed: suppressed}
+	}
+	return ReplyExpectation{never: true}
+}
+
+// Always returns true if the server always replies to the command
+func (e ReplyExpectation) Always() bool {
+	return !e.never && len(e.suppressed) == 0
+}
+
+// Never returns true if the server never replies to the command
+func (e ReplyExpectation) Never() bool {
+	return e.never
+}
+
+// Suppressed returns the status codes (e.g. "EN", "HD") that the server
+// will not send for the command. It returns nil if the command always
+// or never receives a reply.
+func (e ReplyExpectation) Suppressed() []string {
+	return e.suppressed
+}
+
+// IsSuppressed returns true if a reply with the given status code would
+// not be sent by the server. Error replies (ERROR, CLIENT_ERROR, SERVER_ERROR)
+// are never suppressed, even for classic commands in noreply mode.
+func (e ReplyExpectation) IsSuppressed(code string) bool {
+	switch code {
+	case "ERROR", "CLIENT_ERROR", "SERVER_ERROR":
+		return false
+	}
+	if e.never {
+		return true
+	}
+	for _, s := range e.suppressed {
+		if s == code {
+			return true
+		}
+	}
+	return false
+}
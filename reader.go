@@ -0,0 +1,280 @@
+package memdproto
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// bufferedReader returns src as a *bufio.Reader, wrapping it
+// only if it isn't one already.
+func bufferedReader(src io.Reader) *bufio.Reader {
+	if brdr, ok := src.(*bufio.Reader); ok {
+		return brdr
+	}
+	return bufio.NewReader(src)
+}
+
+// DefaultMaxDataLength is the maximum length of the data block that may
+// follow a command read by a CmdReader, unless changed using SetMaxDataLength.
+// It matches the default maximum item size of memcached.
+const DefaultMaxDataLength = 1024 * 1024
+
+// CmdReader reads commands, one at a time, from a stream such as
+// a net.Conn. Each command line is checked or rewritten according
+// to the parse mode (ParseModeStrict by default) before it is parsed,
+// and the data block that follows storage commands is read along with it.
+//
+// Commands with verbs that are not modeled by this package are returned
+// as *RawCmd, keeping the line exactly as it was read.
+//
+// If ReadCmd returns an error other than io.EOF, the underlying stream
+// may be positioned in the middle of a command. Use InSync to find out
+// whether the next command can still be read: callers such as servers
+// will usually want to reply with CLIENT_ERROR, and close the connection
+// only if it can't.
+type CmdReader struct {
+	src           *bufio.Reader
+	mode          ParseMode
+	dataFields    map[string]int
+	maxDataLength int
+	skip          int
+	outOfSync     bool
+}
+
+// NewCmdReader creates a new CmdReader reading from src. If src is
+// already a *bufio.Reader, it is used as-is.
+func NewCmdReader(src io.Reader) *CmdReader {
+	return &CmdReader{
+		src:           bufferedReader(src),
+		maxDataLength: DefaultMaxDataLength,
+	}
+}
+
+// SetParseMode sets the parse mode used for subsequent commands.
+func (r *CmdReader) SetParseMode(mode ParseMode) *CmdReader {
+	r.mode = mode
+	return r
+}
+
+// SetDataLengthField tells the reader that commands with the given verb,
+// which must not be modeled by this package, are followed by a data block
+// whose length is given in the field-th token (zero-based, where the verb
+// is token 0) of the command line. The data block is then read along with
+// the command line into the resulting RawCmd.
+func (r *CmdReader) SetDataLengthField(verb string, field int) *CmdReader {
+	if r.dataFields == nil {
+		r.dataFields = make(map[string]int)
+	}
+	r.dataFields[verb] = field
+	return r
+}
+
+// SetMaxDataLength sets the maximum length of the data block that may
+// follow a command. When a command announces a longer data block, ReadCmd
+// returns a ParseError whose cause is ErrDataTooLarge right away, and the
+// data block is skipped without being buffered by the next call to ReadCmd.
+// The default is DefaultMaxDataLength, and zero means no limit.
+func (r *CmdReader) SetMaxDataLength(n int) *CmdReader {
+	r.maxDataLength = n
+	return r
+}
+
+// ParseMode returns the parse mode currently in use.
+func (r *CmdReader) ParseMode() ParseMode {
+	return r.mode
+}
+
+// InSync returns false if the last call to ReadCmd failed in a way that
+// leaves the stream positioned in the middle of a command, such as an
+// invalid data length or a data block that is not followed by CRLF.
+// Once this happens, no further commands can be read from the stream.
+func (r *CmdReader) InSync() bool {
+	return !r.outOfSync
+}
+
+// ReadCmd reads the next command from the stream. It returns io.EOF,
+// unwrapped, if the stream ends cleanly between two commands.
+func (r *CmdReader) ReadCmd() (Cmd, error) {
+	r.outOfSync = false
+	if r.skip > 0 {
+		n, err := r.src.Discard(r.skip)
+		r.skip -= n
+		if err != nil {
+			r.outOfSync = true
+			return nil, parseError(``, 0, ``, fmt.Errorf(`failed to skip data block: %w`, err))
+		}
+	}
+
+	line, err := r.src.ReadBytes('\n')
+	if err != nil {
+		if err == io.EOF && len(line) == 0 {
+			return nil, io.EOF
+		}
+		r.outOfSync = true
+		return nil, parseError(string(firstToken(line)), len(line), ``, err)
+	}
+
+	canonical, err := canonicalizeLine(line, r.mode)
+	if err != nil {
+		// the length of the data block that may follow an
+		// invalid line can not be trusted
+		verb := string(firstToken(bytes.TrimRight(line, "\r\n")))
+		_, data := dataLengthField(verb)
+		_, rawData := r.dataFields[verb]
+		r.outOfSync = data || rawData
+		return nil, err
+	}
+
+	body := canonical[:len(canonical)-2]
+	verb := string(firstToken(body))
+	cmd := newCmd(verb)
+
+	field, ok := dataLengthField(verb)
+	if _, raw := cmd.(*RawCmd); raw {
+		// unknown commands are kept exactly as they were sent
+		canonical = line
+		field, ok = r.dataFields[verb]
+	}
+
+	if ok {
+		size, err := dataBlockLength(verb, body, field)
+		if err != nil {
+			r.outOfSync = true
+			return nil, err
+		}
+
+		if r.maxDataLength > 0 && size > r.maxDataLength {
+			// the data block, plus the CRLF that follows it, is
+			// skipped before reading the next command
+			r.skip = size + 2
+			return nil, parseError(verb, len(canonical), ``, ErrDataTooLarge)
+		}
+
+		// read the data block, plus the CRLF that follows it
+		block, _, err := readValue(r.src, int64(size)+2)
+		if err != nil {
+			r.outOfSync = true
+			return nil, parseError(verb, len(canonical), ``, fmt.Errorf(`failed to read data block: %w`, err))
+		}
+		buf := append(canonical[:len(canonical):len(canonical)], block...)
+		if !bytes.HasSuffix(buf, crlf) {
+			r.outOfSync = true
+			return nil, parseErrorf(verb, len(buf)-2, ``, `expected CRLF after data block`)
+		}
+		canonical = buf
+	}
+
+	if err := cmd.UnmarshalText(canonical); err != nil {
+		return nil, err
+	}
+	return cmd, nil
+}
+
+// ParseCmd parses exactly one command, including its data block
+// if any, from data using the given parse mode.
+func ParseCmd(data []byte, mode ParseMode) (Cmd, error) {
+	// data is already in memory, so there is no point in limiting the data block
+	rdr := NewCmdReader(bytes.NewReader(data)).SetParseMode(mode).SetMaxDataLength(0)
+	cmd, err := rdr.ReadCmd()
+	if err != nil {
+		if err == io.EOF {
+			return nil, parseError(``, 0, ``, ErrUnexpectedEOL)
+		}
+		return nil, err
+	}
+
+	if rdr.src.Buffered() > 0 {
+		return nil, parseErrorf(``, len(data)-rdr.src.Buffered(), ``, `trailing data after command`)
+	}
+	return cmd, nil
+}
+
+// newCmd creates an empty command object for the given verb. Unknown
+// verbs result in a RawCmd
+func newCmd(verb string) Cmd {
+	switch verb {
+	case "mg":
+		return &MetaGetCmd{}
+	case "ms":
+		return &MetaSetCmd{}
+	case "md":
+		return &MetaDeleteCmd{}
+	case "ma":
+		return &MetaArithmeticCmd{}
+	case "mn":
+		return &MetaNoopCmd{}
+	case "get", "gets":
+		return &GetCmd{}
+	case "set":
+		return NewSetCmd("", nil)
+	case "add":
+		return NewAddCmd("", nil)
+	case "replace":
+		return NewReplaceCmd("", nil)
+	case "append":
+		return NewAppendCmd("", nil)
+	case "prepend":
+		return NewPrependCmd("", nil)
+	case "cas":
+		return NewCasCmd("", nil, 0)
+	case "delete":
+		return &DeleteCmd{}
+	case "incr":
+		return NewIncrCmd("", 0)
+	case "decr":
+		return NewDecrCmd("", 0)
+	case "touch":
+		return &TouchCmd{}
+	case "gat", "gats":
+		return &GatCmd{}
+	default:
+		return &RawCmd{}
+	}
+}
+
+// dataLengthField returns the index of the token that holds the length
+// of the data block following the command line, if the command carries one.
+func dataLengthField(verb string) (int, bool) {
+	switch verb {
+	case "ms":
+		// ms <key> <datalen> <flags>*
+		return 2, true
+	case "set", "add", "replace", "append", "prepend", "cas":
+		// <command name> <key> <flags> <exptime> <bytes> [<cas unique>] [noreply]
+		return 4, true
+	default:
+		return 0, false
+	}
+}
+
+// dataBlockLength returns the length of the data block that follows
+// the given command line, as found in the idx-th token.
+func dataBlockLength(verb string, line []byte, idx int) (int, error) {
+	fields := bytes.Split(line, space)
+	if idx <= 0 || len(fields) <= idx {
+		return 0, parseError(verb, len(line), ``, fmt.Errorf(`missing data length: %w`, ErrUnexpectedEOL))
+	}
+
+	size, err := strconv.ParseUint(string(fields[idx]), 10, 31)
+	if err != nil {
+		offset := len(bytes.Join(fields[:idx], space)) + 1
+		return 0, parseError(verb, offset, string(fields[idx]), fmt.Errorf(`invalid data length: %w`, err))
+	}
+	return int(size), nil
+}
+
+// readValue reads a value of the given size from src. The value is
+// buffered as it arrives, so that a bogus size does not cause a large
+// allocation up front.
+func readValue(src io.Reader, size int64) ([]byte, int, error) {
+	var buf bytes.Buffer
+	n, err := io.CopyN(&buf, src, size)
+	if err == io.EOF && n > 0 {
+		// same as io.ReadFull
+		err = io.ErrUnexpectedEOF
+	}
+	return buf.Bytes(), int(n), err
+}
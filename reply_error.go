@@ -0,0 +1,121 @@
+package memdproto
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+type ErrorReplyKind uint8
+
+const (
+	// ErrorReplyGeneric is the "ERROR" reply, sent when the
+	// command is not known to the server
+	ErrorReplyGeneric ErrorReplyKind = iota
+	// ErrorReplyClient is the "CLIENT_ERROR <message>" reply, sent
+	// when the command does not conform to the protocol
+	ErrorReplyClient
+	// ErrorReplyServer is the "SERVER_ERROR <message>" reply, sent when
+	// the server could not carry out the command
+	ErrorReplyServer
+	ErrorReplyKindMax
+)
+
+func (k ErrorReplyKind) String() string {
+	switch k {
+	case ErrorReplyGeneric:
+		return "ERROR"
+	case ErrorReplyClient:
+		return "CLIENT_ERROR"
+	case ErrorReplyServer:
+		return "SERVER_ERROR"
+	default:
+		return fmt.Sprintf("ErrorReplyKind(%d)", k)
+	}
+}
+
+// ErrorReply represents the ERROR, CLIENT_ERROR and SERVER_ERROR replies,
+// which may be sent in response to any command.
+//
+// ErrorReply also implements the error interface, so that it can be
+// returned as an error by code that handles commands.
+type ErrorReply struct {
+	kind    ErrorReplyKind
+	message string
+}
+
+var _ Reply = (*ErrorReply)(nil)
+var _ error = (*ErrorReply)(nil)
+
+// NewErrorReply creates a new "ERROR" reply
+func NewErrorReply() *ErrorReply {
+	return &ErrorReply{kind: ErrorReplyGeneric}
+}
+
+// NewClientErrorReply creates a new "CLIENT_ERROR <message>" reply
+func NewClientErrorReply(message string) *ErrorReply {
+	return &ErrorReply{kind: ErrorReplyClient, message: message}
+}
+
+// NewServerErrorReply creates a new "SERVER_ERROR <message>" reply
+func NewServerErrorReply(message string) *ErrorReply {
+	return &ErrorReply{kind: ErrorReplyServer, message: message}
+}
+
+func (reply *ErrorReply) Kind() ErrorReplyKind {
+	return reply.kind
+}
+
+func (reply *ErrorReply) Message() string {
+	return reply.message
+}
+
+func (reply *ErrorReply) Error() string {
+	if reply.message == "" {
+		return reply.kind.String()
+	}
+	return reply.kind.String() + " " + reply.message
+}
+
+func (reply *ErrorReply) WriteTo(dst io.Writer) (int64, error) {
+	if reply.kind >= ErrorReplyKindMax {
+		return 0, fmt.Errorf("invalid error reply kind %d", reply.kind)
+	}
+
+	var written int64
+	n, err := io.WriteString(dst, reply.Error())
+	written += int64(n)
+	if err != nil {
+		return written, err
+	}
+
+	n, err = dst.Write(crlf)
+	written += int64(n)
+	return written, err
+}
+
+func (reply *ErrorReply) ReadFrom(src io.Reader) (int64, error) {
+	line, err := bufferedReader(src).ReadBytes('\n')
+	if err != nil {
+		return int64(len(line)), parseError(string(firstToken(line)), len(line), ``, err)
+	}
+	return int64(len(line)), reply.UnmarshalText(line)
+}
+
+func (reply *ErrorReply) UnmarshalText(data []byte) error {
+	ldata := len(data)
+	if ldata < 2 || !bytes.Equal(data[ldata-2:], crlf) {
+		return parseErrorf(string(firstToken(data)), ldata, ``, `expected CRLF`)
+	}
+	data = data[:ldata-2]
+
+	code, message, _ := bytes.Cut(data, space)
+	for kind := ErrorReplyGeneric; kind < ErrorReplyKindMax; kind++ {
+		if string(code) == kind.String() {
+			reply.kind = kind
+			reply.message = string(message)
+			return nil
+		}
+	}
+	return parseErrorf(``, 0, string(code), `invalid error reply`)
+}
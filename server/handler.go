@@ -0,0 +1,250 @@
+package server
+
+import (
+	"context"
+
+	"github.com/lestrrat-go/memdproto"
+)
+
+// Handler responds to memcached commands. The Server calls the method
+// matching the verb of each command that it reads, in the order that
+// the commands were received on the connection.
+//
+// Each method returns the reply to send back to the client. Replies that
+// the client did not ask for because of quiet ("q") or noreply mode are
+// discarded by the Server, so handlers should always return the reply
+// that they would send otherwise. Returning a nil Reply and a nil error
+// sends nothing back.
+//
+// If a method returns an error, it is sent back instead of the reply:
+// a *memdproto.ErrorReply (or an error wrapping one) is sent as-is, and
+// any other error is sent as "SERVER_ERROR <message>".
+//
+// Handlers that only implement some of the verbs can embed BaseHandler.
+type Handler interface {
+	MetaGet(context.Context, *memdproto.MetaGetCmd) (memdproto.Reply, error)
+	MetaSet(context.Context, *memdproto.MetaSetCmd) (memdproto.Reply, error)
+	MetaDelete(context.Context, *memdproto.MetaDeleteCmd) (memdproto.Reply, error)
+	MetaArithmetic(context.Context, *memdproto.MetaArithmeticCmd) (memdproto.Reply, error)
+	MetaNoop(context.Context, *memdproto.MetaNoopCmd) (memdproto.Reply, error)
+
+	// Get handles both get and gets
+	Get(context.Context, *memdproto.GetCmd) (memdproto.Reply, error)
+	// Gat handles both gat and gats
+	Gat(context.Context, *memdproto.GatCmd) (memdproto.Reply, error)
+	Set(context.Context, *memdproto.SetCmd) (memdproto.Reply, error)
+	Add(context.Context, *memdproto.AddCmd) (memdproto.Reply, error)
+	Replace(context.Context, *memdproto.ReplaceCmd) (memdproto.Reply, error)
+	Append(context.Context, *memdproto.AppendCmd) (memdproto.Reply, error)
+	Prepend(context.Context, *memdproto.PrependCmd) (memdproto.Reply, error)
+	Cas(context.Context, *memdproto.CasCmd) (memdproto.Reply, error)
+	Delete(context.Context, *memdproto.DeleteCmd) (memdproto.Reply, error)
+	Incr(context.Context, *memdproto.IncrCmd) (memdproto.Reply, error)
+	Decr(context.Context, *memdproto.DecrCmd) (memdproto.Reply, error)
+	Touch(context.Context, *memdproto.TouchCmd) (memdproto.Reply, error)
+
+	// Fallback handles all other commands, such as "version", "stats"
+	// or "flush_all", which are read as *memdproto.RawCmd.
+	Fallback(context.Context, memdproto.Cmd) (memdproto.Reply, error)
+}
+
+// Dispatch calls the method of h that matches cmd, and returns its result.
+func Dispatch(ctx context.Context, h Handler, cmd memdproto.Cmd) (memdproto.Reply, error) {
+	switch cmd := cmd.(type) {
+	case *memdproto.MetaGetCmd:
+		return h.MetaGet(ctx, cmd)
+	case *memdproto.MetaSetCmd:
+		return h.MetaSet(ctx, cmd)
+	case *memdproto.MetaDeleteCmd:
+		return h.MetaDelete(ctx, cmd)
+	case *memdproto.MetaArithmeticCmd:
+		return h.MetaArithmetic(ctx, cmd)
+	case *memdproto.MetaNoopCmd:
+		return h.MetaNoop(ctx, cmd)
+	case *memdproto.GetCmd:
+		return h.Get(ctx, cmd)
+	case *memdproto.GatCmd:
+		return h.Gat(ctx, cmd)
+	case *memdproto.SetCmd:
+		return h.Set(ctx, cmd)
+	case *memdproto.AddCmd:
+		return h.Add(ctx, cmd)
+	case *memdproto.ReplaceCmd:
+		return h.Replace(ctx, cmd)
+	case *memdproto.AppendCmd:
+		return h.Append(ctx, cmd)
+	case *memdproto.PrependCmd:
+		return h.Prepend(ctx, cmd)
+	case *memdproto.CasCmd:
+		return h.Cas(ctx, cmd)
+	case *memdproto.DeleteCmd:
+		return h.Delete(ctx, cmd)
+	case *memdproto.IncrCmd:
+		return h.Incr(ctx, cmd)
+	case *memdproto.DecrCmd:
+		return h.Decr(ctx, cmd)
+	case *memdproto.TouchCmd:
+		return h.Touch(ctx, cmd)
+	default:
+		return h.Fallback(ctx, cmd)
+	}
+}
+
+// HandlerFunc is an adapter that allows an ordinary function to be used
+// as a Handler. Every method of the Handler calls the function, which
+// makes it the building block for handlers that treat all commands
+// alike, such as proxies and middleware.
+type HandlerFunc func(context.Context, memdproto.Cmd) (memdproto.Reply, error)
+
+var _ Handler = HandlerFunc(nil)
+
+func (f HandlerFunc) MetaGet(ctx context.Context, cmd *memdproto.MetaGetCmd) (memdproto.Reply, error) {
+	return f(ctx, cmd)
+}
+
+func (f HandlerFunc) MetaSet(ctx context.Context, cmd *memdproto.MetaSetCmd) (memdproto.Reply, error) {
+	return f(ctx, cmd)
+}
+
+func (f HandlerFunc) MetaDelete(ctx context.Context, cmd *memdproto.MetaDeleteCmd) (memdproto.Reply, error) {
+	return f(ctx, cmd)
+}
+
+func (f HandlerFunc) MetaArithmetic(ctx context.Context, cmd *memdproto.MetaArithmeticCmd) (memdproto.Reply, error) {
+	return f(ctx, cmd)
+}
+
+func (f HandlerFunc) MetaNoop(ctx context.Context, cmd *memdproto.MetaNoopCmd) (memdproto.Reply, error) {
+	return f(ctx, cmd)
+}
+
+func (f HandlerFunc) Get(ctx context.Context, cmd *memdproto.GetCmd) (memdproto.Reply, error) {
+	return f(ctx, cmd)
+}
+
+func (f HandlerFunc) Gat(ctx context.Context, cmd *memdproto.GatCmd) (memdproto.Reply, error) {
+	return f(ctx, cmd)
+}
+
+func (f HandlerFunc) Set(ctx context.Context, cmd *memdproto.SetCmd) (memdproto.Reply, error) {
+	return f(ctx, cmd)
+}
+
+func (f HandlerFunc) Add(ctx context.Context, cmd *memdproto.AddCmd) (memdproto.Reply, error) {
+	return f(ctx, cmd)
+}
+
+func (f HandlerFunc) Replace(ctx context.Context, cmd *memdproto.ReplaceCmd) (memdproto.Reply, error) {
+	return f(ctx, cmd)
+}
+
+func (f HandlerFunc) Append(ctx context.Context, cmd *memdproto.AppendCmd) (memdproto.Reply, error) {
+	return f(ctx, cmd)
+}
+
+func (f HandlerFunc) Prepend(ctx context.Context, cmd *memdproto.PrependCmd) (memdproto.Reply, error) {
+	return f(ctx, cmd)
+}
+
+func (f HandlerFunc) Cas(ctx context.Context, cmd *memdproto.CasCmd) (memdproto.Reply, error) {
+	return f(ctx, cmd)
+}
+
+func (f HandlerFunc) Delete(ctx context.Context, cmd *memdproto.DeleteCmd) (memdproto.Reply, error) {
+	return f(ctx, cmd)
+}
+
+func (f HandlerFunc) Incr(ctx context.Context, cmd *memdproto.IncrCmd) (memdproto.Reply, error) {
+	return f(ctx, cmd)
+}
+
+func (f HandlerFunc) Decr(ctx context.Context, cmd *memdproto.DecrCmd) (memdproto.Reply, error) {
+	return f(ctx, cmd)
+}
+
+func (f HandlerFunc) Touch(ctx context.Context, cmd *memdproto.TouchCmd) (memdproto.Reply, error) {
+	return f(ctx, cmd)
+}
+
+func (f HandlerFunc) Fallback(ctx context.Context, cmd memdproto.Cmd) (memdproto.Reply, error) {
+	return f(ctx, cmd)
+}
+
+// BaseHandler is meant to be embedded in handlers that only implement
+// some of the verbs. It replies to mn with MN, and to everything else
+// with ERROR, which is what memcached sends for unknown commands.
+type BaseHandler struct{}
+
+var _ Handler = BaseHandler{}
+
+func (BaseHandler) MetaGet(context.Context, *memdproto.MetaGetCmd) (memdproto.Reply, error) {
+	return nil, memdproto.NewErrorReply()
+}
+
+func (BaseHandler) MetaSet(context.Context, *memdproto.MetaSetCmd) (memdproto.Reply, error) {
+	return nil, memdproto.NewErrorReply()
+}
+
+func (BaseHandler) MetaDelete(context.Context, *memdproto.MetaDeleteCmd) (memdproto.Reply, error) {
+	return nil, memdproto.NewErrorReply()
+}
+
+func (BaseHandler) MetaArithmetic(context.Context, *memdproto.MetaArithmeticCmd) (memdproto.Reply, error) {
+	return nil, memdproto.NewErrorReply()
+}
+
+func (BaseHandler) MetaNoop(context.Context, *memdproto.MetaNoopCmd) (memdproto.Reply, error) {
+	return memdproto.NewMetaNoopReply(), nil
+}
+
+func (BaseHandler) Get(context.Context, *memdproto.GetCmd) (memdproto.Reply, error) {
+	return nil, memdproto.NewErrorReply()
+}
+
+func (BaseHandler) Gat(context.Context, *memdproto.GatCmd) (memdproto.Reply, error) {
+	return nil, memdproto.NewErrorReply()
+}
+
+func (BaseHandler) Set(context.Context, *memdproto.SetCmd) (memdproto.Reply, error) {
+	return nil, memdproto.NewErrorReply()
+}
+
+func (BaseHandler) Add(context.Context, *memdproto.AddCmd) (memdproto.Reply, error) {
+	return nil, memdproto.NewErrorReply()
+}
+
+func (BaseHandler) Replace(context.Context, *memdproto.ReplaceCmd) (memdproto.Reply, error) {
+	return nil, memdproto.NewErrorReply()
+}
+
+func (BaseHandler) Append(context.Context, *memdproto.AppendCmd) (memdproto.Reply, error) {
+	return nil, memdproto.NewErrorReply()
+}
+
+func (BaseHandler) Prepend(context.Context, *memdproto.PrependCmd) (memdproto.Reply, error) {
+	return nil, memdproto.NewErrorReply()
+}
+
+func (BaseHandler) Cas(context.Context, *memdproto.CasCmd) (memdproto.Reply, error) {
+	return nil, memdproto.NewErrorReply()
+}
+
+func (BaseHandler) Delete(context.Context, *memdproto.DeleteCmd) (memdproto.Reply, error) {
+	return nil, memdproto.NewErrorReply()
+}
+
+func (BaseHandler) Incr(context.Context, *memdproto.IncrCmd) (memdproto.Reply, error) {
+	return nil, memdproto.NewErrorReply()
+}
+
+func (BaseHandler) Decr(context.Context, *memdproto.DecrCmd) (memdproto.Reply, error) {
+	return nil, memdproto.NewErrorReply()
+}
+
+func (BaseHandler) Touch(context.Context, *memdproto.TouchCmd) (memdproto.Reply, error) {
+	return nil, memdproto.NewErrorReply()
+}
+
+func (BaseHandler) Fallback(context.Context, memdproto.Cmd) (memdproto.Reply, error) {
+	return nil, memdproto.NewErrorReply()
+}
@@ -0,0 +1,134 @@
+package server_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/memdproto"
+	"github.com/lestrrat-go/memdproto/server"
+	"github.com/stretchr/testify/require"
+)
+
+// blocking replies MN to everything, but waits for release before
+// handling any command with the key "block"
+type blocking struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func newBlocking() *blocking {
+	return &blocking{
+		started: make(chan struct{}, 1),
+		release: make(chan struct{}),
+	}
+}
+
+func (b *blocking) handle(_ context.Context, cmd memdproto.Cmd) (memdproto.Reply, error) {
+	if keys := cmd.Keys(); len(keys) > 0 && keys[0] == "block" {
+		b.started <- struct{}{}
+		<-b.release
+	}
+	return memdproto.NewMetaNoopReply(), nil
+}
+
+func TestLifecycle(t *testing.T) {
+	t.Run("shutdown", func(t *testing.T) {
+		b := newBlocking()
+		srv, addr := startServer(t, server.HandlerFunc(b.handle))
+		busy := dial(t, addr)
+		idle := dial(t, addr)
+		roundTrip(t, idle, "mn\r\n", "MN\r\n")
+
+		_, err := io.WriteString(busy, "mg block\r\nmg foo\r\nmn\r\n")
+		require.NoError(t, err, "conn.Write should succeed")
+		<-b.started
+
+		done := make(chan error, 1)
+		go func() { done <- srv.Shutdown(context.Background()) }()
+
+		_, err = idle.Read(make([]byte, 1))
+		require.ErrorIs(t, err, io.EOF, "idle connection should be closed right away")
+
+		_, err = net.Dial("tcp", addr)
+		require.Error(t, err, "new connections should be refused")
+
+		close(b.release)
+		data, err := io.ReadAll(busy)
+		require.NoError(t, err, "busy connection should be closed once drained")
+		require.Equal(t, "MN\r\nMN\r\nMN\r\n", string(data), "pipelined commands should be handled")
+		require.NoError(t, <-done, "srv.Shutdown should succeed")
+	})
+
+	t.Run("shutdown timeout", func(t *testing.T) {
+		b := newBlocking()
+		srv, addr := startServer(t, server.HandlerFunc(b.handle))
+		conn := dial(t, addr)
+		_, err := io.WriteString(conn, "mg block\r\n")
+		require.NoError(t, err, "conn.Write should succeed")
+		<-b.started
+		defer close(b.release)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		require.ErrorIs(t, srv.Shutdown(ctx), context.DeadlineExceeded, "srv.Shutdown should time out")
+	})
+
+	t.Run("max conns", func(t *testing.T) {
+		addr := serve(t, server.New(newBackend()).SetMaxConns(1))
+		first := dial(t, addr)
+		roundTrip(t, first, "mn\r\n", "MN\r\n")
+
+		data, err := io.ReadAll(dial(t, addr))
+		require.NoError(t, err, "second connection should be closed")
+		require.Equal(t, "SERVER_ERROR too many open connections\r\n", string(data), "second connection should be rejected")
+
+		roundTrip(t, first, "mn\r\n", "MN\r\n")
+		require.NoError(t, first.Close(), "first.Close should succeed")
+		require.Eventually(t, func() bool {
+			conn := dial(t, addr)
+			_, err := io.WriteString(conn, "mn\r\n")
+			if err != nil {
+				return false
+			}
+			buf := make([]byte, 4)
+			_, err = io.ReadFull(conn, buf)
+			return err == nil && string(buf) == "MN\r\n"
+		}, 5*time.Second, 10*time.Millisecond, "connections should be accepted again")
+	})
+
+	t.Run("idle timeout", func(t *testing.T) {
+		addr := serve(t, server.New(newBackend()).SetIdleTimeout(50*time.Millisecond))
+		conn := dial(t, addr)
+		roundTrip(t, conn, "mn\r\n", "MN\r\n")
+
+		data, err := io.ReadAll(conn)
+		require.NoError(t, err, "connection should be closed")
+		require.Empty(t, data, "nothing should be sent")
+	})
+
+	t.Run("read timeout", func(t *testing.T) {
+		addr := serve(t, server.New(newBackend()).SetReadTimeout(50*time.Millisecond))
+		conn := dial(t, addr)
+		roundTrip(t, conn, "ms foo 3\r\nbar\r\n", "HD\r\n")
+
+		_, err := io.WriteString(conn, "ms foo 3\r\nb")
+		require.NoError(t, err, "conn.Write should succeed")
+		data, err := io.ReadAll(conn)
+		require.NoError(t, err, "connection should be closed")
+		require.Empty(t, data, "nothing should be sent")
+	})
+
+	t.Run("quit", func(t *testing.T) {
+		_, addr := startServer(t, newBackend())
+		conn := dial(t, addr)
+		_, err := io.WriteString(conn, "mn\r\nquit\r\nmn\r\n")
+		require.NoError(t, err, "conn.Write should succeed")
+
+		data, err := io.ReadAll(conn)
+		require.NoError(t, err, "connection should be closed")
+		require.Equal(t, "MN\r\n", string(data), "commands after quit should be ignored")
+	})
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lestrrat-go/memdproto"
+)
+
+// ItemInfo describes an item listed by "lru_crawler metadump"
+type ItemInfo struct {
+	Key string
+	// Exptime is the time at which the item expires, or the zero
+	// time if it never does
+	Exptime    time.Time
+	LastAccess time.Time
+	Cas        uint64
+	// Fetched is true if the item has been fetched since it was stored
+	Fetched bool
+	// Class is the slab class that the item is stored in, if any.
+	// It is used to answer metadumps of specific classes.
+	Class int
+	Size  int64
+}
+
+// MetaDumper can be implemented by a Handler to let the Server answer
+// "lru_crawler metadump" by itself. MetaDump must call fn for each item,
+// and stop if fn returns an error, which it then returns.
+//
+// If the handler is wrapped in middlewares using Chain, use SetMetaDumper
+// to tell the Server about it, or use SetMiddleware instead.
+type MetaDumper interface {
+	MetaDump(ctx context.Context, fn func(ItemInfo) error) error
+}
+
+// SetMetaDumper sets the MetaDumper used to answer "lru_crawler metadump".
+// By default, this is the handler given to New if it implements MetaDumper.
+func (s *Server) SetMetaDumper(d MetaDumper) *Server {
+	s.metaDumper = d
+	return s
+}
+
+// isMetadump returns true if cmd is "lru_crawler metadump ..."
+func isMetadump(cmd *memdproto.RawCmd) bool {
+	args := strings.Fields(string(cmd.Line()))[1:]
+	return len(args) > 0 && args[0] == "metadump"
+}
+
+// metadump answers "lru_crawler metadump <all|hash|classid,...>" with
+// one line per item, in the same format as memcached:
+//
+//	key=foo exp=-1 la=1700000000 cas=1 fetch=no cls=1 size=63
+//
+// The whole dump is collected before it is returned, so that it goes
+// through the middlewares like any other reply.
+func (s *Server) metadump(ctx context.Context, cmd *memdproto.RawCmd) (memdproto.Reply, error) {
+	args := strings.Fields(string(cmd.Line()))[1:]
+	if len(args) != 2 {
+		return nil, memdproto.NewClientErrorReply(`usage: lru_crawler metadump <all|hash|classid,...>`)
+	}
+	classes := make(map[int]bool)
+	if args[1] != "all" && args[1] != "hash" {
+		for _, v := range strings.Split(args[1], ",") {
+			id, err := strconv.Atoi(v)
+			if err != nil || id <= 0 {
+				return nil, memdproto.NewClientErrorReply(`invalid class id`)
+			}
+			classes[id] = true
+		}
+	}
+
+	var buf bytes.Buffer
+	err := s.metaDumper.MetaDump(ctx, func(info ItemInfo) error {
+		if len(classes) > 0 && !classes[info.Class] {
+			return nil
+		}
+
+		exp := int64(-1)
+		if !info.Exptime.IsZero() {
+			exp = info.Exptime.Unix()
+		}
+		fmt.Fprintf(&buf, "key=%s exp=%d la=%d cas=%d fetch=%s cls=%d size=%d\n",
+			uriEncode(info.Key), exp, info.LastAccess.Unix(), info.Cas, yesNo(info.Fetched), info.Class, info.Size)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	buf.WriteString("END\r\n")
+	return memdproto.NewRawReply(nil).SetBytes(buf.Bytes()), nil
+}
+
+// uriEncode percent-encodes all characters of s but the unreserved ones,
+// which is how memcached prints keys in metadumps and watch events
+func uriEncode(s string) string {
+	var buf strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9',
+			c == '-', c == '.', c == '_', c == '~':
+			buf.WriteByte(c)
+		default:
+			fmt.Fprintf(&buf, "%%%02X", c)
+		}
+	}
+	return buf.String()
+}
@@ -0,0 +1,66 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/memdproto/server"
+)
+
+// dumpBackend lists a fixed set of items
+type dumpBackend struct {
+	*backend
+	items []server.ItemInfo
+	err   error
+}
+
+func (b dumpBackend) MetaDump(_ context.Context, fn func(server.ItemInfo) error) error {
+	for _, info := range b.items {
+		if err := fn(info); err != nil {
+			return err
+		}
+	}
+	return b.err
+}
+
+func TestMetaDump(t *testing.T) {
+	la := time.Unix(1700000000, 0)
+	items := []server.ItemInfo{
+		{Key: "foo", LastAccess: la, Cas: 1, Class: 1, Size: 63},
+		{Key: "a b", Exptime: la.Add(time.Hour), LastAccess: la, Cas: 2, Fetched: true, Class: 2, Size: 120},
+	}
+	const (
+		foo = "key=foo exp=-1 la=1700000000 cas=1 fetch=no cls=1 size=63\n"
+		ab  = "key=a%20b exp=1700003600 la=1700000000 cas=2 fetch=yes cls=2 size=120\n"
+	)
+
+	t.Run("all", func(t *testing.T) {
+		_, addr := startServer(t, dumpBackend{backend: newBackend(), items: items})
+		conn := dial(t, addr)
+		roundTrip(t, conn, "lru_crawler metadump all\r\n", foo+ab+"END\r\n")
+		roundTrip(t, conn, "lru_crawler metadump hash\r\n", foo+ab+"END\r\n")
+	})
+
+	t.Run("classes", func(t *testing.T) {
+		_, addr := startServer(t, dumpBackend{backend: newBackend(), items: items})
+		conn := dial(t, addr)
+		roundTrip(t, conn, "lru_crawler metadump 2\r\n", ab+"END\r\n")
+		roundTrip(t, conn, "lru_crawler metadump 1,2\r\n", foo+ab+"END\r\n")
+		roundTrip(t, conn, "lru_crawler metadump 3\r\n", "END\r\n")
+		roundTrip(t, conn, "lru_crawler metadump x\r\n", "CLIENT_ERROR invalid class id\r\n")
+	})
+
+	t.Run("error", func(t *testing.T) {
+		_, addr := startServer(t, dumpBackend{backend: newBackend(), items: items[:1], err: errors.New("boom")})
+		conn := dial(t, addr)
+		roundTrip(t, conn, "lru_crawler metadump all\r\n", "SERVER_ERROR boom\r\n")
+	})
+
+	t.Run("no dumper", func(t *testing.T) {
+		_, addr := startServer(t, newBackend())
+		conn := dial(t, addr)
+		roundTrip(t, conn, "lru_crawler metadump all\r\n", "ERROR\r\n")
+	})
+}
@@ -0,0 +1,326 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lestrrat-go/memdproto"
+)
+
+// Middleware wraps a Handler to add behavior before and after the
+// commands reach it, such as logging or access control.
+type Middleware func(Handler) Handler
+
+// Chain wraps h with the given middlewares. The first middleware is the
+// outermost one, meaning that it sees the commands first, and the
+// replies last. The commands that the Server answers by itself never
+// reach h, see Server.SetMiddleware.
+func Chain(h Handler, mws ...Middleware) Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+func loggerOrDefault(l *log.Logger) *log.Logger {
+	if l == nil {
+		return log.Default()
+	}
+	return l
+}
+
+// Logging returns a middleware that logs each command along with its
+// reply and the time that it took to handle, in their decoded form:
+//
+//	127.0.0.1:52000 mg foo v -> VA 3 "bar" (12.5µs)
+//
+// Replies are logged even if they are then discarded because the
+// command was sent in quiet or noreply mode.
+//
+// If l is nil, the standard logger of the log package is used.
+func Logging(l *log.Logger) Middleware {
+	l = loggerOrDefault(l)
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, cmd memdproto.Cmd) (memdproto.Reply, error) {
+			start := time.Now()
+			reply, err := Dispatch(ctx, next, cmd)
+			elapsed := time.Since(start)
+
+			switch {
+			case err != nil:
+				l.Printf(`%s %v -> %s (%s)`, RemoteAddr(ctx), cmd, oneLine(err.Error()), elapsed)
+			case reply == nil:
+				l.Printf(`%s %v -> <none> (%s)`, RemoteAddr(ctx), cmd, elapsed)
+			default:
+				l.Printf(`%s %v -> %v (%s)`, RemoteAddr(ctx), cmd, reply, elapsed)
+			}
+			return reply, err
+		})
+	}
+}
+
+// Recover returns a middleware that recovers from panics in the handler.
+// The panic is logged along with its stack trace, and the client receives
+// "SERVER_ERROR internal error" instead of the connection being killed.
+//
+// If l is nil, the standard logger of the log package is used.
+func Recover(l *log.Logger) Middleware {
+	l = loggerOrDefault(l)
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, cmd memdproto.Cmd) (reply memdproto.Reply, err error) {
+			defer func() {
+				if v := recover(); v != nil {
+					l.Printf("server: panic handling %s command: %v\n%s", cmd.Verb(), v, debug.Stack())
+					reply, err = nil, memdproto.NewServerErrorReply(`internal error`)
+				}
+			}()
+			return Dispatch(ctx, next, cmd)
+		})
+	}
+}
+
+// AllowKeyPrefixes returns a middleware that only lets commands through
+// if all of their keys start with one of prefixes. It is a shorthand for
+// NewKeyPrefixACL(prefixes...).Middleware(), and so lets none of the
+// commands that are read as *memdproto.RawCmd through.
+func AllowKeyPrefixes(prefixes ...string) Middleware {
+	return NewKeyPrefixACL(prefixes...).Middleware()
+}
+
+// KeyPrefixACL restricts clients to the keys that start with one of a set
+// of prefixes. Commands with other keys are refused with "CLIENT_ERROR
+// access denied". Commands that do not have keys, such as mn, are let
+// through, except for those that are read as *memdproto.RawCmd: the keys
+// of those are unknown, and some, such as "lru_crawler metadump" or "watch",
+// expose every key in the cache. They are refused, unless their verb has
+// been allowed using AllowVerbs.
+//
+// The ACL only covers commands answered by the Server itself, such as
+// "watch", if it is installed using Server.SetMiddleware.
+type KeyPrefixACL struct {
+	prefixes []string
+	verbs    map[string]struct{}
+}
+
+// NewKeyPrefixACL creates a new KeyPrefixACL that allows the keys
+// starting with one of prefixes
+func NewKeyPrefixACL(prefixes ...string) *KeyPrefixACL {
+	return &KeyPrefixACL{
+		prefixes: prefixes,
+		verbs:    make(map[string]struct{}),
+	}
+}
+
+// AllowVerbs lets commands that are read as *memdproto.RawCmd through
+// if they have one of the given verbs, such as "version" or "stats".
+func (acl *KeyPrefixACL) AllowVerbs(verbs ...string) *KeyPrefixACL {
+	for _, verb := range verbs {
+		acl.verbs[verb] = struct{}{}
+	}
+	return acl
+}
+
+func (acl *KeyPrefixACL) allowed(cmd memdproto.Cmd) bool {
+	if _, ok := cmd.(*memdproto.RawCmd); ok {
+		_, ok := acl.verbs[cmd.Verb()]
+		return ok
+	}
+
+	for _, key := range cmd.Keys() {
+		var ok bool
+		for _, prefix := range acl.prefixes {
+			if strings.HasPrefix(key, prefix) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Middleware returns the middleware that enforces the ACL. Changes
+// made to the ACL afterwards do not affect it.
+func (acl *KeyPrefixACL) Middleware() Middleware {
+	frozen := &KeyPrefixACL{
+		prefixes: append([]string(nil), acl.prefixes...),
+		verbs:    make(map[string]struct{}, len(acl.verbs)),
+	}
+	for verb := range acl.verbs {
+		frozen.verbs[verb] = struct{}{}
+	}
+
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, cmd memdproto.Cmd) (memdproto.Reply, error) {
+			if !frozen.allowed(cmd) {
+				return nil, memdproto.NewClientErrorReply(`access denied`)
+			}
+			return Dispatch(ctx, next, cmd)
+		})
+	}
+}
+
+// VerbStats holds the counters that Metrics keeps for each verb
+type VerbStats struct {
+	// Calls is the number of commands that were handled
+	Calls uint64
+	// Errors is the number of commands that resulted in an error reply
+	Errors uint64
+	// Hits and Misses count the keys that were, or were not found by
+	// commands that look up items: retrieval, touch, delete and
+	// arithmetic commands
+	Hits   uint64
+	Misses uint64
+	// Latency is the total time spent handling the commands, and
+	// MaxLatency the longest time spent handling a single command
+	Latency    time.Duration
+	MaxLatency time.Duration
+}
+
+// Metrics keeps per-verb counters of the commands that go through its
+// Middleware. Commands that are read as *memdproto.RawCmd are counted
+// under their verb only if it is the verb of a memcached command, such
+// as "version" or "flush_all". All others are counted under the "unknown"
+// verb, so that clients can not create an unbounded number of counters.
+type Metrics struct {
+	mu    sync.Mutex
+	verbs map[string]*VerbStats
+}
+
+// NewMetrics creates a new Metrics with all counters set to zero
+func NewMetrics() *Metrics {
+	return &Metrics{
+		verbs: make(map[string]*VerbStats),
+	}
+}
+
+// Middleware returns the middleware that updates the counters
+func (m *Metrics) Middleware() Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, cmd memdproto.Cmd) (memdproto.Reply, error) {
+			start := time.Now()
+			reply, err := Dispatch(ctx, next, cmd)
+			m.record(cmd, reply, err, time.Since(start))
+			return reply, err
+		})
+	}
+}
+
+// rawVerbs are the verbs of memcached commands that are read
+// as *memdproto.RawCmd
+var rawVerbs = map[string]struct{}{
+	"cache_memlimit": {},
+	"extstore":       {},
+	"flush_all":      {},
+	"lru":            {},
+	"lru_crawler":    {},
+	"me":             {},
+	"misbehave":      {},
+	"quit":           {},
+	"shutdown":       {},
+	"slabs":          {},
+	"stats":          {},
+	"verbosity":      {},
+	"version":        {},
+	"watch":          {},
+}
+
+func (m *Metrics) record(cmd memdproto.Cmd, reply memdproto.Reply, err error, elapsed time.Duration) {
+	var ereply *memdproto.ErrorReply
+	if err == nil {
+		ereply, _ = reply.(*memdproto.ErrorReply)
+	} else if !errors.As(err, &ereply) {
+		ereply = memdproto.NewServerErrorReply(err.Error())
+	}
+
+	verb := cmd.Verb()
+	if _, ok := cmd.(*memdproto.RawCmd); ok {
+		// the verb comes straight from the client, and could be anything
+		if _, known := rawVerbs[verb]; !known {
+			verb = "unknown"
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats, ok := m.verbs[verb]
+	if !ok {
+		stats = &VerbStats{}
+		m.verbs[verb] = stats
+	}
+	stats.Calls++
+	stats.Latency += elapsed
+	stats.MaxLatency = max(stats.MaxLatency, elapsed)
+	if ereply != nil {
+		stats.Errors++
+		return
+	}
+
+	hits, misses := lookups(cmd, reply)
+	stats.Hits += hits
+	stats.Misses += misses
+}
+
+// lookups returns the number of keys that were found, and not found,
+// according to reply
+func lookups(cmd memdproto.Cmd, reply memdproto.Reply) (uint64, uint64) {
+	found := func(b bool) (uint64, uint64) {
+		if b {
+			return 1, 0
+		}
+		return 0, 1
+	}
+
+	switch reply := reply.(type) {
+	case *memdproto.MetaGetReply:
+		return found(!reply.IsMiss())
+	case *memdproto.GetReply:
+		hits := uint64(len(reply.Items()))
+		return hits, uint64(len(cmd.Keys())) - min(hits, uint64(len(cmd.Keys())))
+	case *memdproto.TouchCmdReply:
+		return found(reply.Status() == memdproto.TouchCmdReplyTouched)
+	case *memdproto.MetaDeleteReply:
+		return found(reply.Status() != memdproto.MetaDeleteCmdStatusNotFound)
+	case *memdproto.DeleteCmdReply:
+		return found(reply.Status() == memdproto.DeleteCmdReplyDeleted)
+	case *memdproto.MetaArithmeticReply:
+		return found(reply.Status() != memdproto.MetaArithmeticCmdStatusNotFound)
+	case *memdproto.ArithmeticCmdReply:
+		return found(reply.Found())
+	default:
+		return 0, 0
+	}
+}
+
+// Verbs returns the verbs that have been counted so far, in sorted order
+func (m *Metrics) Verbs() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	verbs := make([]string, 0, len(m.verbs))
+	for verb := range m.verbs {
+		verbs = append(verbs, verb)
+	}
+	sort.Strings(verbs)
+	return verbs
+}
+
+// Stats returns a copy of the counters for verb
+func (m *Metrics) Stats(verb string) VerbStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if stats, ok := m.verbs[verb]; ok {
+		return *stats
+	}
+	return VerbStats{}
+}
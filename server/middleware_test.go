@@ -0,0 +1,154 @@
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"sync"
+	"testing"
+
+	"github.com/lestrrat-go/memdproto"
+	"github.com/lestrrat-go/memdproto/server"
+	"github.com/stretchr/testify/require"
+)
+
+// syncBuffer is a bytes.Buffer that can be written to by the server
+// while being read by the test
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// panicky panics on every get
+type panicky struct {
+	*backend
+}
+
+func (panicky) Get(context.Context, *memdproto.GetCmd) (memdproto.Reply, error) {
+	panic("boom")
+}
+
+// panickyDump panics while dumping items
+type panickyDump struct {
+	*backend
+}
+
+func (panickyDump) MetaDump(context.Context, func(server.ItemInfo) error) error {
+	panic("boom")
+}
+
+func TestMiddleware(t *testing.T) {
+	t.Run("chain", func(t *testing.T) {
+		var order []string
+		mw := func(name string) server.Middleware {
+			return func(next server.Handler) server.Handler {
+				return server.HandlerFunc(func(ctx context.Context, cmd memdproto.Cmd) (memdproto.Reply, error) {
+					order = append(order, name)
+					return server.Dispatch(ctx, next, cmd)
+				})
+			}
+		}
+
+		h := server.Chain(newBackend(), mw("first"), mw("second"))
+		reply, err := server.Dispatch(context.Background(), h, memdproto.NewMetaNoopCmd())
+		require.NoError(t, err, "server.Dispatch should succeed")
+		require.IsType(t, &memdproto.MetaNoopReply{}, reply, "reply should come from the handler")
+		require.Equal(t, []string{"first", "second"}, order, "middlewares should run in order")
+	})
+
+	t.Run("logging", func(t *testing.T) {
+		var buf syncBuffer
+		_, addr := startServer(t, server.Chain(newBackend(), server.Logging(log.New(&buf, "", 0))))
+		conn := dial(t, addr)
+		roundTrip(t, conn, "ms foo 3 q\r\nbar\r\nmg foo v\r\nbogus\r\n", "VA 3\r\nbar\r\nERROR\r\n")
+
+		lines := bytes.Split(bytes.TrimSpace([]byte(buf.String())), []byte("\n"))
+		require.Len(t, lines, 3, "each command should be logged")
+		require.Contains(t, string(lines[0]), conn.LocalAddr().String()+` ms foo 3 q "bar" -> HD (`, "log should contain the decoded command")
+		require.Contains(t, string(lines[1]), ` mg foo v -> VA 3 "bar" (`, "log should contain the decoded reply")
+		require.Contains(t, string(lines[2]), ` bogus -> ERROR (`, "log should contain the error")
+	})
+
+	t.Run("recover", func(t *testing.T) {
+		var buf syncBuffer
+		_, addr := startServer(t, server.Chain(panicky{newBackend()}, server.Recover(log.New(&buf, "", 0))))
+		conn := dial(t, addr)
+		roundTrip(t, conn, "get foo\r\nmn\r\n", "SERVER_ERROR internal error\r\nMN\r\n")
+		require.Contains(t, buf.String(), "server: panic handling get command: boom", "panic should be logged")
+	})
+
+	t.Run("key prefixes", func(t *testing.T) {
+		_, addr := startServer(t, server.Chain(newBackend(), server.AllowKeyPrefixes("app:", "shared:")))
+		conn := dial(t, addr)
+		roundTrip(t, conn, "ms app:foo 1 q\r\nx\r\nmg app:foo v\r\n", "VA 1\r\nx\r\n")
+		roundTrip(t, conn, "ms other:foo 1 q\r\nx\r\n", "CLIENT_ERROR access denied\r\n")
+		roundTrip(t, conn, "get shared:foo other:foo\r\n", "CLIENT_ERROR access denied\r\n")
+		roundTrip(t, conn, "mg YXBwOmZvbw== b v\r\n", "VA 1\r\nx\r\n")
+		roundTrip(t, conn, "mn\r\n", "MN\r\n")
+		roundTrip(t, conn, "version\r\nbogus app:foo\r\n", "CLIENT_ERROR access denied\r\nCLIENT_ERROR access denied\r\n")
+
+		acl := server.NewKeyPrefixACL("app:").AllowVerbs("version")
+		_, addr = startServer(t, server.Chain(newBackend(), acl.Middleware()))
+		conn = dial(t, addr)
+		roundTrip(t, conn, "version\r\nflush_all\r\n", "VERSION test\r\nCLIENT_ERROR access denied\r\n")
+	})
+
+	t.Run("server commands", func(t *testing.T) {
+		var buf syncBuffer
+		metrics := server.NewMetrics()
+		srv := server.New(panickyDump{newBackend()}).SetMiddleware(
+			metrics.Middleware(),
+			server.Recover(log.New(&buf, "", 0)),
+			server.NewKeyPrefixACL("app:").AllowVerbs("lru_crawler").Middleware(),
+		)
+		conn := dial(t, serve(t, srv))
+		roundTrip(t, conn, "watch mutations\r\nstats\r\n", "CLIENT_ERROR access denied\r\nCLIENT_ERROR access denied\r\n")
+		roundTrip(t, conn, "lru_crawler metadump all\r\nmn\r\n", "SERVER_ERROR internal error\r\nMN\r\n")
+		require.Contains(t, buf.String(), "server: panic handling lru_crawler command: boom", "panic should be logged")
+		require.Equal(t, uint64(1), metrics.Stats("watch").Errors, "watch errors should match")
+		require.Equal(t, uint64(1), metrics.Stats("lru_crawler").Errors, "lru_crawler errors should match")
+	})
+
+	t.Run("metrics", func(t *testing.T) {
+		metrics := server.NewMetrics()
+		_, addr := startServer(t, server.Chain(newBackend(), metrics.Middleware()))
+		conn := dial(t, addr)
+		roundTrip(t, conn,
+			"ms foo 3\r\nbar\r\nmg foo v\r\nmg bar v\r\nget foo bar baz\r\nmd foo\r\nmd foo\r\nbogus\r\nincr foo 1\r\nfail\r\nversion\r\n",
+			"HD\r\nVA 3\r\nbar\r\nEN\r\nVALUE foo 0 3\r\nbar\r\nEND\r\nHD\r\nNF\r\nERROR\r\nERROR\r\nSERVER_ERROR backend  failure\r\nVERSION test\r\n",
+		)
+
+		require.Equal(t, []string{"get", "incr", "md", "mg", "ms", "unknown", "version"}, metrics.Verbs(), "verbs should match")
+
+		mg := metrics.Stats("mg")
+		require.Equal(t, uint64(2), mg.Calls, "mg calls should match")
+		require.Equal(t, uint64(1), mg.Hits, "mg hits should match")
+		require.Equal(t, uint64(1), mg.Misses, "mg misses should match")
+		require.True(t, mg.Latency >= mg.MaxLatency && mg.MaxLatency > 0, "mg latency should be recorded")
+
+		get := metrics.Stats("get")
+		require.Equal(t, uint64(1), get.Hits, "get hits should match")
+		require.Equal(t, uint64(2), get.Misses, "get misses should match")
+
+		md := metrics.Stats("md")
+		require.Equal(t, uint64(1), md.Hits, "md hits should match")
+		require.Equal(t, uint64(1), md.Misses, "md misses should match")
+
+		require.Equal(t, uint64(1), metrics.Stats("incr").Errors, "incr errors should match")
+		require.Equal(t, uint64(2), metrics.Stats("unknown").Errors, "unknown errors should match")
+		require.Equal(t, uint64(1), metrics.Stats("version").Calls, "version calls should match")
+		require.Equal(t, server.VerbStats{}, metrics.Stats("nope"), "stats for an unseen verb should be zero")
+	})
+}
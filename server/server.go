@@ -0,0 +1,634 @@
+// Package server implements servers that speak the memcached protocol,
+// in the same way that net/http implements HTTP servers.
+//
+// A Server reads commands from each connection, passes them to a Handler,
+// and writes the replies back in order:
+//
+//	type backend struct {
+//	  server.BaseHandler
+//	  ...
+//	}
+//
+//	func (b *backend) MetaGet(ctx context.Context, cmd *memdproto.MetaGetCmd) (memdproto.Reply, error) {
+//	  ...
+//	}
+//
+//	srv := server.New(&backend{})
+//	if err := srv.ListenAndServe(":11211"); err != nil {
+//	  ...
+//	}
+//
+// Commands that are pipelined by the client are handled one after the
+// other, and their replies are buffered until the last of the pipelined
+// commands has been handled, so that they are sent back together.
+// Replies that are not expected because of quiet or noreply mode are
+// discarded by the Server (see memdproto.ExpectReply).
+//
+// The "quit" command is handled by the Server itself: it closes the
+// connection once the replies to the preceding commands have been sent.
+// So are "stats", "stats settings" and "stats conns", which are answered
+// from counters that the Server keeps, such as the number of connections
+// and of bytes transferred. Handlers can add their own fields to those
+// by implementing StatsProvider. Other groups of statistics, such as
+// "stats slabs", are passed to the handler.
+//
+// "lru_crawler metadump" is answered by the Server if the handler
+// implements MetaDumper. "watch" turns the connection into one that is
+// sent a line for each command handled on the other connections, like
+// memcached's fetchers, mutations and rawcmds log streams.
+//
+// Middlewares that must see the commands answered by the Server, such as
+// access control, have to be installed using SetMiddleware rather than
+// by wrapping the handler using Chain.
+//
+// Servers can also speak the UDP protocol of memcached, see ServeUDP. It
+// is only served when asked to, and should only be on trusted networks.
+//
+// Connections can be secured with TLS, optionally requiring clients to
+// present a certificate, see SetTLSConfig.
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lestrrat-go/memdproto"
+)
+
+// ErrServerClosed is returned by Serve and ListenAndServe after
+// the server has been closed.
+var ErrServerClosed = errors.New(`server: Server closed`)
+
+// DefaultMaxItemSize is the maximum length of the data block of a
+// command that is accepted by default. It matches the default of memcached.
+const DefaultMaxItemSize = 1024 * 1024
+
+// DefaultHandshakeTimeout is the maximum time that a client is given to
+// complete the TLS handshake by default.
+const DefaultHandshakeTimeout = 10 * time.Second
+
+// DefaultMaxUDPRequests is the maximum number of UDP requests that are
+// handled at the same time by default.
+const DefaultMaxUDPRequests = 64
+
+// Server serves memcached protocol connections using a Handler.
+type Server struct {
+	handler     Handler
+	chain       Handler
+	mode        memdproto.ParseMode
+	errorLog    *log.Logger
+	maxConns    int
+	maxItemSize int
+	readTimeout time.Duration
+	idleTimeout time.Duration
+	tlsConfig   *tls.Config
+
+	handshakeTimeout time.Duration
+	maxUDPRequests   int
+
+	statsProvider StatsProvider
+	metaDumper    MetaDumper
+	started       time.Time
+	counters      counters
+	nextGID       atomic.Uint64
+
+	watchMu  sync.Mutex
+	watchers atomic.Pointer[[]*watcher]
+
+	mu          sync.Mutex
+	nextID      uint64
+	ctx         context.Context
+	cancel      context.CancelFunc
+	listeners   map[net.Listener]struct{}
+	packetConns map[net.PacketConn]struct{}
+	conns       map[net.Conn]*connState
+	closed      bool
+	wg          sync.WaitGroup
+}
+
+// connState tracks whether a connection is waiting for the client to
+// send its next command, in which case it can be closed at any time,
+// along with what is reported by "stats conns"
+type connState struct {
+	idle    bool
+	id      uint64
+	addr    net.Addr
+	lastCmd atomic.Int64 // in unix nanoseconds
+}
+
+// New creates a new Server that passes commands to h. Commands are
+// read using memdproto.ParseModeLenient by default.
+func New(h Handler) *Server {
+	ctx, cancel := context.WithCancel(context.Background())
+	sp, _ := h.(StatsProvider)
+	md, _ := h.(MetaDumper)
+	s := &Server{
+		handler:          h,
+		mode:             memdproto.ParseModeLenient,
+		maxItemSize:      DefaultMaxItemSize,
+		handshakeTimeout: DefaultHandshakeTimeout,
+		maxUDPRequests:   DefaultMaxUDPRequests,
+		statsProvider:    sp,
+		metaDumper:       md,
+		started:          time.Now(),
+		ctx:              ctx,
+		cancel:           cancel,
+		listeners:        make(map[net.Listener]struct{}),
+		packetConns:      make(map[net.PacketConn]struct{}),
+		conns:            make(map[net.Conn]*connState),
+	}
+	s.chain = s.builtins()
+	return s
+}
+
+// SetMiddleware wraps the handler with the given middlewares, in the same
+// way as Chain. Unlike when the handler given to New is wrapped using
+// Chain, the middlewares also see the commands that the Server answers
+// by itself, such as "stats", "lru_crawler metadump" and "watch", which
+// is required for middlewares such as AllowKeyPrefixes and Recover to
+// cover them.
+func (s *Server) SetMiddleware(mws ...Middleware) *Server {
+	s.chain = Chain(s.builtins(), mws...)
+	return s
+}
+
+// SetParseMode sets the parse mode used to read commands from clients.
+func (s *Server) SetParseMode(mode memdproto.ParseMode) *Server {
+	s.mode = mode
+	return s
+}
+
+// SetErrorLog sets the logger for errors that can not be reported
+// back to the client, such as failures to accept connections. If it
+// is not set, the standard logger of the log package is used.
+func (s *Server) SetErrorLog(l *log.Logger) *Server {
+	s.errorLog = l
+	return s
+}
+
+// SetMaxConns sets the maximum number of connections that are served at
+// the same time. Clients connecting past the limit are sent
+// "SERVER_ERROR too many open connections", and are disconnected.
+// Zero, the default, means no limit.
+func (s *Server) SetMaxConns(n int) *Server {
+	s.maxConns = n
+	return s
+}
+
+// SetMaxItemSize sets the maximum length of the data block of a command.
+// Commands with longer data blocks are not passed to the handler: their
+// data block is skipped, and the client is sent "SERVER_ERROR object too
+// large for cache". The default is DefaultMaxItemSize, and zero means
+// no limit.
+func (s *Server) SetMaxItemSize(n int) *Server {
+	s.maxItemSize = n
+	return s
+}
+
+// SetReadTimeout sets the maximum time that a client may take to send
+// a command, from its first byte to its last. If it is exceeded, the
+// connection is closed. Zero, the default, means no timeout.
+func (s *Server) SetReadTimeout(d time.Duration) *Server {
+	s.readTimeout = d
+	return s
+}
+
+// SetIdleTimeout sets the maximum time that a client may stay connected
+// without sending a command. If it is exceeded, the connection is closed.
+// Zero, the default, means no timeout.
+func (s *Server) SetIdleTimeout(d time.Duration) *Server {
+	s.idleTimeout = d
+	return s
+}
+
+// SetTLSConfig makes the Server speak TLS on the connections that it
+// serves, using cfg. To require clients to present a certificate, set
+// cfg.ClientAuth to tls.RequireAndVerifyClientCert and cfg.ClientCAs to
+// the authorities that issue them. Handlers can then identify the client
+// with TLSState. A nil cfg, the default, means plain TCP.
+func (s *Server) SetTLSConfig(cfg *tls.Config) *Server {
+	s.tlsConfig = cfg
+	return s
+}
+
+// SetHandshakeTimeout sets the maximum time that a client may take to
+// complete the TLS handshake, starting when the connection is accepted.
+// If it is exceeded, the connection is closed. The default is
+// DefaultHandshakeTimeout, and zero means no timeout.
+func (s *Server) SetHandshakeTimeout(d time.Duration) *Server {
+	s.handshakeTimeout = d
+	return s
+}
+
+func (s *Server) logf(format string, args ...interface{}) {
+	if s.errorLog != nil {
+		s.errorLog.Printf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
+
+// ListenAndServe listens on addr, and then calls Serve. addr is either
+// a TCP address, or the path of a unix domain socket in the form
+// "unix:///path/to/socket". A socket file left behind at that path by a
+// previous server is removed.
+func (s *Server) ListenAndServe(addr string) error {
+	ln, err := listen(addr)
+	if err != nil {
+		return fmt.Errorf(`server.ListenAndServe: failed to listen on %s: %w`, addr, err)
+	}
+	return s.Serve(ln)
+}
+
+func listen(addr string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(addr, "unix://")
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	return net.Listen("unix", path)
+}
+
+// Serve accepts connections on ln, and serves each of them in a new
+// goroutine. ln is closed when Serve returns. After the server has been
+// closed, Serve returns ErrServerClosed.
+func (s *Server) Serve(ln net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		ln.Close()
+		return ErrServerClosed
+	}
+	s.listeners[ln] = struct{}{}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, ln)
+		s.mu.Unlock()
+		ln.Close()
+	}()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return ErrServerClosed
+			}
+			return fmt.Errorf(`server.Serve: failed to accept connection: %w`, err)
+		}
+
+		go s.ServeConn(conn)
+	}
+}
+
+func (s *Server) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// ServeConn serves a single connection until the client hangs up, or
+// until the server is shut down. conn is closed when ServeConn returns.
+// If a TLS configuration has been set, conn is wrapped in a tls.Conn,
+// unless it already is one.
+func (s *Server) ServeConn(conn net.Conn) {
+	if _, ok := conn.(*tls.Conn); !ok && s.tlsConfig != nil {
+		conn = tls.Server(conn, s.tlsConfig)
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		conn.Close()
+		return
+	}
+	if s.maxConns > 0 && len(s.conns) >= s.maxConns {
+		s.mu.Unlock()
+		s.reject(conn)
+		return
+	}
+	s.nextID++
+	state := &connState{id: s.nextID, addr: conn.RemoteAddr()}
+	state.lastCmd.Store(time.Now().UnixNano())
+	s.conns[conn] = state
+	s.wg.Add(1)
+	s.mu.Unlock()
+	s.counters.totalConns.Add(1)
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+		s.wg.Done()
+	}()
+
+	ctx := context.WithValue(s.ctx, remoteAddrKey{}, conn.RemoteAddr())
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		// the handshake is done right away, so that clients that never
+		// complete it do not hold on to the connection forever
+		if err := s.handshake(ctx, tlsConn); err != nil {
+			return
+		}
+		ctx = context.WithValue(ctx, tlsConnKey{}, tlsConn)
+	}
+	s.serveConn(ctx, conn, state)
+}
+
+func (s *Server) handshake(ctx context.Context, conn *tls.Conn) error {
+	if s.handshakeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.handshakeTimeout)
+		defer cancel()
+	}
+	return conn.HandshakeContext(ctx)
+}
+
+type tlsConnKey struct{}
+
+// TLSState returns the state of the TLS connection that the command
+// being handled was sent on, or nil if ctx does not come from a Server,
+// or if the connection does not use TLS. If the client presented a
+// certificate, it is the first of PeerCertificates.
+func TLSState(ctx context.Context) *tls.ConnectionState {
+	conn, ok := ctx.Value(tlsConnKey{}).(*tls.Conn)
+	if !ok {
+		return nil
+	}
+	state := conn.ConnectionState()
+	return &state
+}
+
+type remoteAddrKey struct{}
+
+// RemoteAddr returns the address of the client that sent the command
+// being handled, or nil if ctx does not come from a Server.
+func RemoteAddr(ctx context.Context) net.Addr {
+	addr, _ := ctx.Value(remoteAddrKey{}).(net.Addr)
+	return addr
+}
+
+// reject tells the client that the server is full, and hangs up
+func (s *Server) reject(conn net.Conn) {
+	defer conn.Close()
+	s.counters.rejectedConns.Add(1)
+	conn.SetWriteDeadline(time.Now().Add(time.Second))
+	var buf bytes.Buffer
+	writeReply(conn, &buf, memdproto.NewServerErrorReply(`too many open connections`))
+}
+
+// setIdle marks conn as idle or not. It returns false if conn should
+// not wait for more commands because the server is shutting down.
+func (s *Server) setIdle(conn net.Conn, idle bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if state, ok := s.conns[conn]; ok {
+		state.idle = idle
+	}
+	return !(idle && s.closed)
+}
+
+func (s *Server) serveConn(ctx context.Context, conn net.Conn, state *connState) {
+	src := bufio.NewReader(countingReader{conn, &s.counters.bytesRead})
+	rdr := s.newCmdReader(src)
+	w := bufio.NewWriter(countingWriter{conn, &s.counters.bytesWritten})
+	var buf bytes.Buffer
+	for {
+		// keep buffering replies for as long as the client has
+		// pipelined more commands, then wait for the next ones
+		if !hasLine(src) {
+			if err := w.Flush(); err != nil {
+				return
+			}
+			if !s.setIdle(conn, true) {
+				return
+			}
+			conn.SetReadDeadline(deadline(s.idleTimeout))
+			if _, err := src.Peek(1); err != nil {
+				return
+			}
+			s.setIdle(conn, false)
+		}
+
+		conn.SetReadDeadline(deadline(s.readTimeout))
+		cmd, err := rdr.ReadCmd()
+		if err != nil {
+			var nerr net.Error
+			if errors.Is(err, io.EOF) || errors.As(err, &nerr) {
+				return
+			}
+			// the reply is sent right away, as skipping the data
+			// block of a command that is too large may take a while.
+			// If the stream can not be trusted any further, hang up
+			// like memcached does
+			writeReply(w, &buf, parseErrorReply(err))
+			if err := w.Flush(); err != nil || !rdr.InSync() {
+				return
+			}
+			continue
+		}
+
+		state.lastCmd.Store(time.Now().UnixNano())
+
+		if cmd.Verb() == "quit" {
+			w.Flush()
+			return
+		}
+
+		if wr, ok := s.respond(ctx, w, &buf, cmd, state.id).(*watchReply); ok {
+			s.serveWatch(ctx, conn, w, wr.groups)
+			return
+		}
+	}
+}
+
+// deadline returns the deadline for a timeout of d, where zero
+// means no deadline
+func deadline(d time.Duration) time.Time {
+	if d <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(d)
+}
+
+type datagramKey struct{}
+
+// builtins returns the handler that answers the commands that the Server
+// handles by itself, and passes all others to the handler given to New.
+// Commands that only make sense on connections are not answered over UDP.
+func (s *Server) builtins() Handler {
+	return HandlerFunc(func(ctx context.Context, cmd memdproto.Cmd) (memdproto.Reply, error) {
+		if raw, ok := cmd.(*memdproto.RawCmd); ok {
+			_, datagram := ctx.Value(datagramKey{}).(bool)
+			switch raw.Verb() {
+			case "stats":
+				if reply := s.statsReply(ctx, raw); reply != nil {
+					return reply, nil
+				}
+			case "lru_crawler":
+				if !datagram && s.metaDumper != nil && isMetadump(raw) {
+					return s.metadump(ctx, raw)
+				}
+			case "watch":
+				if !datagram {
+					return watch(raw)
+				}
+			}
+		}
+		return Dispatch(ctx, s.handler, cmd)
+	})
+}
+
+// respond passes cmd to the handler, and writes the reply to w unless
+// the client did not ask for it. The reply is returned either way.
+func (s *Server) respond(ctx context.Context, w io.Writer, buf *bytes.Buffer, cmd memdproto.Cmd, connID uint64) memdproto.Reply {
+	reply, err := Dispatch(ctx, s.chain, cmd)
+	if err != nil {
+		reply = errorReply(err)
+	} else {
+		s.counters.record(cmd, reply)
+		s.publish(connID, cmd, reply)
+	}
+	if reply == nil {
+		return nil
+	}
+
+	buf.Reset()
+	if _, err := reply.WriteTo(buf); err != nil {
+		s.logf(`server: failed to encode reply to %s command: %s`, cmd.Verb(), err)
+		buf.Reset()
+		memdproto.NewServerErrorReply(`failed to encode reply`).WriteTo(buf)
+	}
+	if memdproto.ExpectReply(cmd).IsSuppressed(replyCode(buf.Bytes())) {
+		return reply
+	}
+	w.Write(buf.Bytes())
+	return reply
+}
+
+func writeReply(w io.Writer, buf *bytes.Buffer, reply memdproto.Reply) {
+	buf.Reset()
+	reply.WriteTo(buf)
+	w.Write(buf.Bytes())
+}
+
+func (s *Server) newCmdReader(src io.Reader) *memdproto.CmdReader {
+	return memdproto.NewCmdReader(src).
+		SetParseMode(s.mode).
+		SetMaxDataLength(s.maxItemSize)
+}
+
+// parseErrorReply converts an error returned by CmdReader into the
+// reply that is sent to the client
+func parseErrorReply(err error) *memdproto.ErrorReply {
+	if errors.Is(err, memdproto.ErrDataTooLarge) {
+		return memdproto.NewServerErrorReply(`object too large for cache`)
+	}
+	return memdproto.NewClientErrorReply(oneLine(err.Error()))
+}
+
+// errorReply converts an error returned by a handler into the reply
+// that is sent to the client
+func errorReply(err error) *memdproto.ErrorReply {
+	var ereply *memdproto.ErrorReply
+	if errors.As(err, &ereply) {
+		return ereply
+	}
+	return memdproto.NewServerErrorReply(oneLine(err.Error()))
+}
+
+// oneLine makes sure that s can be sent as the message of an error reply
+func oneLine(s string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(s)
+}
+
+// hasLine returns true if src has a complete line buffered, meaning that
+// the next command can be read without waiting for the client
+func hasLine(src *bufio.Reader) bool {
+	data, _ := src.Peek(src.Buffered())
+	return bytes.IndexByte(data, '\n') >= 0
+}
+
+// replyCode returns the first token of an encoded reply
+func replyCode(data []byte) string {
+	if i := bytes.IndexAny(data, " \r\n"); i >= 0 {
+		return string(data[:i])
+	}
+	return string(data)
+}
+
+// Close immediately closes all listeners and connections, and waits for
+// the connections to be done. Commands that are being handled will see
+// their context canceled, and their replies will not be sent.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	s.cancel()
+	for ln := range s.listeners {
+		ln.Close()
+	}
+	for pc := range s.packetConns {
+		pc.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return nil
+}
+
+// Shutdown gracefully shuts down the server. It closes all listeners,
+// then lets each connection finish handling the commands that it has
+// already read, and send back their replies, before closing it.
+// Connections that are idle are closed right away.
+//
+// Shutdown waits for all connections to be closed, or for ctx to be
+// done, in which case it returns the context's error. Close can then
+// be used to close the remaining connections.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closed = true
+	for ln := range s.listeners {
+		ln.Close()
+	}
+	for pc := range s.packetConns {
+		pc.Close()
+	}
+	for conn, state := range s.conns {
+		if state.idle {
+			conn.Close()
+		}
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,252 @@
+package server_test
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/memdproto"
+	"github.com/lestrrat-go/memdproto/server"
+	"github.com/stretchr/testify/require"
+)
+
+// backend is a minimal handler that keeps values in a map
+type backend struct {
+	server.BaseHandler
+
+	mu    sync.Mutex
+	items map[string][]byte
+}
+
+func newBackend() *backend {
+	return &backend{items: make(map[string][]byte)}
+}
+
+func (b *backend) MetaGet(_ context.Context, cmd *memdproto.MetaGetCmd) (memdproto.Reply, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	value, ok := b.items[cmd.Key()]
+	if !ok {
+		return memdproto.NewMetaGetReply().SetMiss(true), nil
+	}
+	reply := memdproto.NewMetaGetReply()
+	if cmd.RetrieveValue() {
+		reply.SetValue(value)
+	}
+	return reply, nil
+}
+
+func (b *backend) MetaSet(_ context.Context, cmd *memdproto.MetaSetCmd) (memdproto.Reply, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.items[cmd.Key()] = cmd.Value()
+	return memdproto.NewMetaSetReply().SetStatus(memdproto.MetaSetCmdStatusStored), nil
+}
+
+func (b *backend) MetaDelete(_ context.Context, cmd *memdproto.MetaDeleteCmd) (memdproto.Reply, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.items[cmd.Key()]; !ok {
+		return memdproto.NewMetaDeleteReply().SetStatus(memdproto.MetaDeleteCmdStatusNotFound), nil
+	}
+	delete(b.items, cmd.Key())
+	return memdproto.NewMetaDeleteReply().SetStatus(memdproto.MetaDeleteCmdStatusDeleted), nil
+}
+
+func (b *backend) Get(_ context.Context, cmd *memdproto.GetCmd) (memdproto.Reply, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	reply := memdproto.NewGetReply()
+	for _, key := range cmd.Keys() {
+		if value, ok := b.items[key]; ok {
+			reply.AddItems(memdproto.NewGetReplyItem(key, value))
+		}
+	}
+	return reply, nil
+}
+
+func (b *backend) Set(_ context.Context, cmd *memdproto.SetCmd) (memdproto.Reply, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.items[cmd.Key()] = cmd.Value()
+	return memdproto.NewSetCmdReply().SetStatus(memdproto.SetCmdReplyStored), nil
+}
+
+func (b *backend) Fallback(ctx context.Context, cmd memdproto.Cmd) (memdproto.Reply, error) {
+	switch cmd.Verb() {
+	case "version":
+		return memdproto.NewRawReply(nil).SetBytes([]byte("VERSION test\r\n")), nil
+	case "fail":
+		return nil, errors.New("backend\r\nfailure")
+	case "full":
+		return nil, fmt.Errorf(`wrapped: %w`, memdproto.NewServerErrorReply("out of memory"))
+	}
+	return b.BaseHandler.Fallback(ctx, cmd)
+}
+
+// startServer serves h on a loopback port, and returns the address
+func startServer(t *testing.T, h server.Handler) (*server.Server, string) {
+	t.Helper()
+	srv := server.New(h)
+	return srv, serve(t, srv)
+}
+
+// serve starts srv on a loopback port, and returns the address
+func serve(t *testing.T, srv *server.Server) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err, "net.Listen should succeed")
+
+	done := make(chan error, 1)
+	go func() { done <- srv.Serve(ln) }()
+	t.Cleanup(func() {
+		require.NoError(t, srv.Close(), "srv.Close should succeed")
+		require.ErrorIs(t, <-done, server.ErrServerClosed, "srv.Serve should return ErrServerClosed")
+	})
+	return ln.Addr().String()
+}
+
+func dial(t *testing.T, addr string) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err, "net.Dial should succeed")
+	t.Cleanup(func() { conn.Close() })
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)), "conn.SetDeadline should succeed")
+	return conn
+}
+
+// roundTrip writes cmds in a single write, and reads back len(reply) bytes
+func roundTrip(t *testing.T, conn net.Conn, cmds, reply string) {
+	t.Helper()
+	_, err := io.WriteString(conn, cmds)
+	require.NoError(t, err, "conn.Write should succeed")
+
+	buf := make([]byte, len(reply))
+	n, err := io.ReadFull(conn, buf)
+	require.NoError(t, err, "io.ReadFull should succeed (got %q)", buf[:n])
+	require.Equal(t, reply, string(buf), "replies should match")
+}
+
+func TestServer(t *testing.T) {
+	t.Run("handler", func(t *testing.T) {
+		_, addr := startServer(t, newBackend())
+		conn := dial(t, addr)
+		roundTrip(t, conn, "mg foo v\r\n", "EN\r\n")
+		roundTrip(t, conn, "ms foo 3\r\nbar\r\n", "HD\r\n")
+		roundTrip(t, conn, "mg foo v\r\n", "VA 3\r\nbar\r\n")
+		roundTrip(t, conn, "get foo nope\r\n", "VALUE foo 0 3\r\nbar\r\nEND\r\n")
+		roundTrip(t, conn, "set foo 0 0 3\r\nbaz\r\n", "STORED\r\n")
+		roundTrip(t, conn, "md foo\r\n", "HD\r\n")
+		roundTrip(t, conn, "md foo\r\n", "NF\r\n")
+		roundTrip(t, conn, "mn\r\n", "MN\r\n")
+		roundTrip(t, conn, "version\r\n", "VERSION test\r\n")
+		roundTrip(t, conn, "incr foo 1\r\n", "ERROR\r\n")
+		roundTrip(t, conn, "bogus\r\n", "ERROR\r\n")
+		roundTrip(t, conn, "fail\r\n", "SERVER_ERROR backend  failure\r\n")
+		roundTrip(t, conn, "full\r\n", "SERVER_ERROR out of memory\r\n")
+	})
+
+	t.Run("pipeline", func(t *testing.T) {
+		_, addr := startServer(t, newBackend())
+		conn := dial(t, addr)
+
+		var cmds, replies strings.Builder
+		for i := 0; i < 1000; i++ {
+			fmt.Fprintf(&cmds, "ms key%d 4\r\n%04d\r\nmg key%d v\r\n", i, i, i)
+			fmt.Fprintf(&replies, "HD\r\nVA 4\r\n%04d\r\n", i)
+		}
+		roundTrip(t, conn, cmds.String(), replies.String())
+	})
+
+	t.Run("quiet", func(t *testing.T) {
+		_, addr := startServer(t, newBackend())
+		conn := dial(t, addr)
+		roundTrip(t, conn,
+			"ms foo 3 q\r\nbar\r\nmg foo q v\r\nmg nope q v\r\nmd nope q\r\nset bar 0 0 1 noreply\r\nx\r\nincr bar 1 noreply\r\nmg bar v\r\nmn\r\n",
+			"VA 3\r\nbar\r\nERROR\r\nVA 1\r\nx\r\nMN\r\n",
+		)
+	})
+
+	t.Run("parse error", func(t *testing.T) {
+		_, addr := startServer(t, newBackend())
+		conn := dial(t, addr)
+		_, err := io.WriteString(conn, "ms foo bar\r\n")
+		require.NoError(t, err, "conn.Write should succeed")
+
+		data, err := io.ReadAll(conn)
+		require.NoError(t, err, "connection should be closed")
+		require.True(t, strings.HasPrefix(string(data), "CLIENT_ERROR "), "reply should be CLIENT_ERROR (got %q)", data)
+	})
+
+	t.Run("recoverable parse error", func(t *testing.T) {
+		_, addr := startServer(t, newBackend())
+		conn := dial(t, addr)
+		_, err := io.WriteString(conn, "mg foo !\r\nmn\r\n")
+		require.NoError(t, err, "conn.Write should succeed")
+
+		rdr := bufio.NewReader(conn)
+		line, err := rdr.ReadString('\n')
+		require.NoError(t, err, "rdr.ReadString should succeed")
+		require.True(t, strings.HasPrefix(line, "CLIENT_ERROR "), "reply should be CLIENT_ERROR (got %q)", line)
+		line, err = rdr.ReadString('\n')
+		require.NoError(t, err, "connection should stay open")
+		require.Equal(t, "MN\r\n", line, "next command should be handled")
+	})
+
+	t.Run("missing data terminator", func(t *testing.T) {
+		_, addr := startServer(t, newBackend())
+		conn := dial(t, addr)
+		_, err := io.WriteString(conn, "ms foo 3\r\nbarbaz\r\nmn\r\n")
+		require.NoError(t, err, "conn.Write should succeed")
+
+		data, err := io.ReadAll(conn)
+		require.NoError(t, err, "connection should be closed")
+		require.True(t, strings.HasPrefix(string(data), "CLIENT_ERROR "), "reply should be CLIENT_ERROR (got %q)", data)
+		require.NotContains(t, string(data), "MN", "no further commands should be handled")
+	})
+
+	t.Run("item too large", func(t *testing.T) {
+		srv := server.New(newBackend()).SetMaxItemSize(5)
+		conn := dial(t, serve(t, srv))
+		roundTrip(t, conn, "ms foo 10\r\n0123456789\r\nmg foo v\r\n", "SERVER_ERROR object too large for cache\r\nEN\r\n")
+		roundTrip(t, conn, "set foo 0 0 6 noreply\r\n012345\r\nms foo 5\r\n01234\r\n", "SERVER_ERROR object too large for cache\r\nHD\r\n")
+
+		// the reply is sent without waiting for the data block
+		conn = dial(t, serve(t, server.New(newBackend())))
+		roundTrip(t, conn, "set foo 0 0 2000000000\r\n", "SERVER_ERROR object too large for cache\r\n")
+	})
+
+	t.Run("handler func", func(t *testing.T) {
+		var verbs []string
+		h := server.HandlerFunc(func(_ context.Context, cmd memdproto.Cmd) (memdproto.Reply, error) {
+			verbs = append(verbs, fmt.Sprintf("%T", cmd))
+			return memdproto.NewMetaNoopReply(), nil
+		})
+		_, addr := startServer(t, h)
+		conn := dial(t, addr)
+		roundTrip(t, conn, "mg foo\r\ngets foo\r\ntouch foo 1\r\nversion\r\n", "MN\r\nMN\r\nMN\r\nMN\r\n")
+		require.Equal(t, []string{"*memdproto.MetaGetCmd", "*memdproto.GetCmd", "*memdproto.TouchCmd", "*memdproto.RawCmd"}, verbs, "commands should be dispatched")
+	})
+
+	t.Run("close", func(t *testing.T) {
+		srv, addr := startServer(t, newBackend())
+		conn := dial(t, addr)
+		roundTrip(t, conn, "mn\r\n", "MN\r\n")
+		require.NoError(t, srv.Close(), "srv.Close should succeed")
+
+		_, err := conn.Read(make([]byte, 1))
+		require.Error(t, err, "connection should be closed")
+	})
+}
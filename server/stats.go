@@ -0,0 +1,220 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/lestrrat-go/memdproto"
+)
+
+// Stat is a single field of the reply to a stats command. Value is
+// formatted with the %v verb of the fmt package.
+type Stat struct {
+	Name  string
+	Value any
+}
+
+// StatsProvider can be implemented by a Handler to add fields to the
+// stats commands that the Server answers by itself: "stats",
+// "stats settings" and "stats conns". group is the argument of the
+// command, which is empty for plain "stats". The fields are sent after
+// the ones that the Server keeps.
+//
+// If the handler is wrapped in middlewares using Chain, use
+// SetStatsProvider to tell the Server about it, or use SetMiddleware
+// instead.
+type StatsProvider interface {
+	StatFields(ctx context.Context, group string) []Stat
+}
+
+// counters are the statistics that a Server keeps about itself
+type counters struct {
+	totalConns    atomic.Uint64
+	rejectedConns atomic.Uint64
+	bytesRead     atomic.Uint64
+	bytesWritten  atomic.Uint64
+	cmdGet        atomic.Uint64
+	cmdSet        atomic.Uint64
+	cmdTouch      atomic.Uint64
+	getHits       atomic.Uint64
+	getMisses     atomic.Uint64
+	deleteHits    atomic.Uint64
+	deleteMisses  atomic.Uint64
+	touchHits     atomic.Uint64
+	touchMisses   atomic.Uint64
+}
+
+// record updates the command counters after cmd has been handled
+func (c *counters) record(cmd memdproto.Cmd, reply memdproto.Reply) {
+	hits, misses := lookups(cmd, reply)
+	switch cmd.(type) {
+	case *memdproto.MetaGetCmd, *memdproto.GetCmd, *memdproto.GatCmd:
+		c.cmdGet.Add(uint64(len(cmd.Keys())))
+		c.getHits.Add(hits)
+		c.getMisses.Add(misses)
+	case *memdproto.MetaSetCmd, *memdproto.SetCmd, *memdproto.AddCmd, *memdproto.ReplaceCmd,
+		*memdproto.AppendCmd, *memdproto.PrependCmd, *memdproto.CasCmd:
+		c.cmdSet.Add(1)
+	case *memdproto.MetaDeleteCmd, *memdproto.DeleteCmd:
+		c.deleteHits.Add(hits)
+		c.deleteMisses.Add(misses)
+	case *memdproto.TouchCmd:
+		c.cmdTouch.Add(1)
+		c.touchHits.Add(hits)
+		c.touchMisses.Add(misses)
+	}
+}
+
+// countingReader counts the bytes read from r into n
+type countingReader struct {
+	r io.Reader
+	n *atomic.Uint64
+}
+
+func (cr countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n.Add(uint64(n))
+	return n, err
+}
+
+// countingWriter counts the bytes written to w into n
+type countingWriter struct {
+	w io.Writer
+	n *atomic.Uint64
+}
+
+func (cw countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n.Add(uint64(n))
+	return n, err
+}
+
+// SetStatsProvider sets the StatsProvider that adds fields to the stats
+// commands answered by the Server. By default, this is the handler given
+// to New if it implements StatsProvider.
+func (s *Server) SetStatsProvider(p StatsProvider) *Server {
+	s.statsProvider = p
+	return s
+}
+
+// statsReply returns the reply to a stats command, or nil if the
+// command is for a group of statistics that the Server does not keep
+func (s *Server) statsReply(ctx context.Context, cmd *memdproto.RawCmd) memdproto.Reply {
+	args := strings.Fields(string(cmd.Line()))[1:]
+	if len(args) > 1 {
+		return nil
+	}
+	var group string
+	if len(args) == 1 {
+		group = args[0]
+	}
+
+	var stats []Stat
+	switch group {
+	case "":
+		stats = s.generalStats()
+	case "settings":
+		stats = s.settingsStats()
+	case "conns":
+		stats = s.connsStats()
+	default:
+		return nil
+	}
+	if s.statsProvider != nil {
+		stats = append(stats, s.statsProvider.StatFields(ctx, group)...)
+	}
+
+	var buf strings.Builder
+	for _, stat := range stats {
+		fmt.Fprintf(&buf, "STAT %s %v\r\n", stat.Name, stat.Value)
+	}
+	buf.WriteString("END\r\n")
+	return memdproto.NewRawReply(nil).SetBytes([]byte(buf.String()))
+}
+
+func (s *Server) generalStats() []Stat {
+	s.mu.Lock()
+	currConns := len(s.conns)
+	s.mu.Unlock()
+
+	now := time.Now()
+	c := &s.counters
+	return []Stat{
+		{"pid", os.Getpid()},
+		{"uptime", int64(now.Sub(s.started) / time.Second)},
+		{"time", now.Unix()},
+		{"curr_connections", currConns},
+		{"total_connections", c.totalConns.Load()},
+		{"rejected_connections", c.rejectedConns.Load()},
+		{"cmd_get", c.cmdGet.Load()},
+		{"cmd_set", c.cmdSet.Load()},
+		{"cmd_touch", c.cmdTouch.Load()},
+		{"get_hits", c.getHits.Load()},
+		{"get_misses", c.getMisses.Load()},
+		{"delete_misses", c.deleteMisses.Load()},
+		{"delete_hits", c.deleteHits.Load()},
+		{"touch_hits", c.touchHits.Load()},
+		{"touch_misses", c.touchMisses.Load()},
+		{"bytes_read", c.bytesRead.Load()},
+		{"bytes_written", c.bytesWritten.Load()},
+	}
+}
+
+func (s *Server) settingsStats() []Stat {
+	return []Stat{
+		{"maxconns", s.maxConns},
+		{"item_size_max", s.maxItemSize},
+		{"idle_timeout", int64(s.idleTimeout / time.Second)},
+		{"read_timeout", int64(s.readTimeout / time.Second)},
+		{"parse_mode", s.mode},
+		{"ssl_enabled", yesNo(s.tlsConfig != nil)},
+	}
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+// connsStats lists the open connections, in the order that they were
+// accepted in
+func (s *Server) connsStats() []Stat {
+	s.mu.Lock()
+	states := make([]*connState, 0, len(s.conns))
+	for _, state := range s.conns {
+		states = append(states, state)
+	}
+	idle := make(map[*connState]bool, len(states))
+	for _, state := range states {
+		idle[state] = state.idle
+	}
+	s.mu.Unlock()
+
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].id < states[j].id
+	})
+
+	now := time.Now()
+	var stats []Stat
+	for _, state := range states {
+		status := "conn_parse_cmd"
+		if idle[state] {
+			status = "conn_waiting"
+		}
+		lastCmd := time.Unix(0, state.lastCmd.Load())
+		stats = append(stats,
+			Stat{fmt.Sprintf("%d:addr", state.id), state.addr.Network() + ":" + state.addr.String()},
+			Stat{fmt.Sprintf("%d:state", state.id), status},
+			Stat{fmt.Sprintf("%d:secs_since_last_cmd", state.id), int64(now.Sub(lastCmd) / time.Second)},
+		)
+	}
+	return stats
+}
@@ -0,0 +1,119 @@
+package server_test
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/lestrrat-go/memdproto/server"
+	"github.com/stretchr/testify/require"
+)
+
+// statsBackend adds its own fields to the stats command
+type statsBackend struct {
+	*backend
+}
+
+func (statsBackend) StatFields(_ context.Context, group string) []server.Stat {
+	if group != "" {
+		return nil
+	}
+	return []server.Stat{{Name: "backend", Value: "test"}}
+}
+
+// readStats sends cmd, and returns the fields of the reply in order
+func readStats(t *testing.T, conn io.ReadWriter, cmd string) [][2]string {
+	t.Helper()
+	_, err := io.WriteString(conn, cmd)
+	require.NoError(t, err, "conn.Write should succeed")
+
+	var stats [][2]string
+	rdr := bufio.NewReader(conn)
+	for {
+		line, err := rdr.ReadString('\n')
+		require.NoError(t, err, "rdr.ReadString should succeed")
+		line = strings.TrimSuffix(line, "\r\n")
+		if line == "END" {
+			return stats
+		}
+		fields := strings.SplitN(line, " ", 3)
+		require.Len(t, fields, 3, "line should be a STAT line (got %q)", line)
+		require.Equal(t, "STAT", fields[0], "line should be a STAT line (got %q)", line)
+		stats = append(stats, [2]string{fields[1], fields[2]})
+	}
+}
+
+func statsMap(stats [][2]string) map[string]string {
+	m := make(map[string]string, len(stats))
+	for _, stat := range stats {
+		m[stat[0]] = stat[1]
+	}
+	return m
+}
+
+func TestStats(t *testing.T) {
+	t.Run("stats", func(t *testing.T) {
+		_, addr := startServer(t, statsBackend{newBackend()})
+		conn := dial(t, addr)
+		cmds := []struct{ cmd, reply string }{
+			{"ms foo 3\r\nbar\r\n", "HD\r\n"},
+			{"mg foo v\r\n", "VA 3\r\nbar\r\n"},
+			{"mg bar v\r\n", "EN\r\n"},
+			{"get foo bar\r\n", "VALUE foo 0 3\r\nbar\r\nEND\r\n"},
+			{"md foo\r\n", "HD\r\n"},
+		}
+		var read, written int
+		for _, x := range cmds {
+			roundTrip(t, conn, x.cmd, x.reply)
+			read += len(x.cmd)
+			written += len(x.reply)
+		}
+
+		stats := readStats(t, conn, "stats\r\n")
+		require.Equal(t, [2]string{"backend", "test"}, stats[len(stats)-1], "fields from the handler should come last")
+
+		m := statsMap(stats)
+		require.Equal(t, strconv.Itoa(os.Getpid()), m["pid"], "pid should match")
+		require.Equal(t, "0", m["uptime"], "uptime should match")
+		require.Equal(t, "1", m["curr_connections"], "curr_connections should match")
+		require.Equal(t, "1", m["total_connections"], "total_connections should match")
+		require.Equal(t, "4", m["cmd_get"], "cmd_get should match")
+		require.Equal(t, "1", m["cmd_set"], "cmd_set should match")
+		require.Equal(t, "2", m["get_hits"], "get_hits should match")
+		require.Equal(t, "2", m["get_misses"], "get_misses should match")
+		require.Equal(t, "1", m["delete_hits"], "delete_hits should match")
+		require.Equal(t, strconv.Itoa(read+len("stats\r\n")), m["bytes_read"], "bytes_read should match")
+		require.Equal(t, strconv.Itoa(written), m["bytes_written"], "bytes_written should match")
+	})
+
+	t.Run("settings", func(t *testing.T) {
+		addr := serve(t, server.New(newBackend()).SetMaxConns(10))
+		m := statsMap(readStats(t, dial(t, addr), "stats settings\r\n"))
+		require.Equal(t, "10", m["maxconns"], "maxconns should match")
+		require.Equal(t, "lenient", m["parse_mode"], "parse_mode should match")
+	})
+
+	t.Run("conns", func(t *testing.T) {
+		_, addr := startServer(t, newBackend())
+		idle := dial(t, addr)
+		roundTrip(t, idle, "mn\r\n", "MN\r\n")
+		conn := dial(t, addr)
+
+		m := statsMap(readStats(t, conn, "stats conns\r\n"))
+		require.Len(t, m, 6, "each connection should be listed")
+		require.Equal(t, "tcp:"+idle.LocalAddr().String(), m["1:addr"], "address should match")
+		require.Equal(t, "conn_waiting", m["1:state"], "idle connection should be waiting")
+		require.Equal(t, "tcp:"+conn.LocalAddr().String(), m["2:addr"], "address should match")
+		require.Equal(t, "conn_parse_cmd", m["2:state"], "connection should be handling a command")
+		require.Equal(t, "0", m["2:secs_since_last_cmd"], "secs_since_last_cmd should match")
+	})
+
+	t.Run("other groups", func(t *testing.T) {
+		_, addr := startServer(t, statsBackend{newBackend()})
+		roundTrip(t, dial(t, addr), "stats slabs\r\n", "ERROR\r\n")
+	})
+}
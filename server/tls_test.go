@@ -0,0 +1,176 @@
+package server_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/memdproto"
+	"github.com/lestrrat-go/memdproto/server"
+	"github.com/stretchr/testify/require"
+)
+
+// testCA issues certificates for the tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err, "ecdsa.GenerateKey should succeed")
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "memdproto test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err, "x509.CreateCertificate should succeed")
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err, "x509.ParseCertificate should succeed")
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+// issue returns a certificate for name, which is valid for servers on
+// the loopback address and for clients
+func (ca *testCA) issue(t *testing.T, name string) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err, "ecdsa.GenerateKey should succeed")
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err, "rand.Int should succeed")
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err, "x509.CreateCertificate should succeed")
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func dialTLS(t *testing.T, addr string, cfg *tls.Config) *tls.Conn {
+	t.Helper()
+	conn, err := tls.Dial("tcp", addr, cfg)
+	require.NoError(t, err, "tls.Dial should succeed")
+	t.Cleanup(func() { conn.Close() })
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)), "conn.SetDeadline should succeed")
+	return conn
+}
+
+// peerBackend answers "whoami" with the name in the client certificate
+type peerBackend struct {
+	*backend
+}
+
+func (b peerBackend) Fallback(ctx context.Context, cmd memdproto.Cmd) (memdproto.Reply, error) {
+	if cmd.Verb() != "whoami" {
+		return b.backend.Fallback(ctx, cmd)
+	}
+	name := "anonymous"
+	if state := server.TLSState(ctx); state != nil && len(state.PeerCertificates) > 0 {
+		name = state.PeerCertificates[0].Subject.CommonName
+	}
+	return memdproto.NewRawReply(nil).SetBytes([]byte(name + "\r\n")), nil
+}
+
+func TestTLS(t *testing.T) {
+	ca := newTestCA(t)
+	serverCert := ca.issue(t, "memd.test")
+
+	t.Run("server", func(t *testing.T) {
+		srv := server.New(peerBackend{newBackend()}).
+			SetTLSConfig(&tls.Config{Certificates: []tls.Certificate{serverCert}})
+		addr := serve(t, srv)
+
+		conn := dialTLS(t, addr, &tls.Config{RootCAs: ca.pool, ServerName: "memd.test"})
+		roundTrip(t, conn, "ms foo 3\r\nbar\r\n", "HD\r\n")
+		roundTrip(t, conn, "mg foo v\r\n", "VA 3\r\nbar\r\n")
+		roundTrip(t, conn, "whoami\r\n", "anonymous\r\n")
+		m := statsMap(readStats(t, conn, "stats settings\r\n"))
+		require.Equal(t, "yes", m["ssl_enabled"], "ssl_enabled should match")
+
+		_, err := tls.Dial("tcp", addr, &tls.Config{ServerName: "memd.test"})
+		require.Error(t, err, "tls.Dial should fail without the CA")
+
+		plain := dial(t, addr)
+		_, err = plain.Write([]byte("mg foo v\r\n"))
+		require.NoError(t, err, "conn.Write should succeed")
+		_, err = plain.Read(make([]byte, 16))
+		require.Error(t, err, "plain connections should be closed")
+	})
+
+	t.Run("handshake timeout", func(t *testing.T) {
+		srv := server.New(peerBackend{newBackend()}).
+			SetTLSConfig(&tls.Config{Certificates: []tls.Certificate{serverCert}}).
+			SetHandshakeTimeout(50 * time.Millisecond).
+			SetMaxConns(1)
+		addr := serve(t, srv)
+
+		// never start the handshake
+		stalled := dial(t, addr)
+		_, err := stalled.Read(make([]byte, 16))
+		require.ErrorIs(t, err, io.EOF, "stalled connections should be closed")
+
+		conn := dialTLS(t, addr, &tls.Config{RootCAs: ca.pool, ServerName: "memd.test"})
+		roundTrip(t, conn, "whoami\r\n", "anonymous\r\n")
+	})
+
+	t.Run("client certificates", func(t *testing.T) {
+		srv := server.New(peerBackend{newBackend()}).SetTLSConfig(&tls.Config{
+			Certificates: []tls.Certificate{serverCert},
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+			ClientCAs:    ca.pool,
+		})
+		addr := serve(t, srv)
+
+		conn := dialTLS(t, addr, &tls.Config{
+			RootCAs:      ca.pool,
+			ServerName:   "memd.test",
+			Certificates: []tls.Certificate{ca.issue(t, "alice")},
+		})
+		roundTrip(t, conn, "whoami\r\n", "alice\r\n")
+
+		// with TLS 1.3, the server only checks the client certificate
+		// after the client considers the handshake done
+		conn = dialTLS(t, addr, &tls.Config{RootCAs: ca.pool, ServerName: "memd.test"})
+		_, err := conn.Write([]byte("whoami\r\n"))
+		if err == nil {
+			_, err = conn.Read(make([]byte, 16))
+		}
+		require.Error(t, err, "clients without a certificate should be refused")
+	})
+
+	t.Run("plain", func(t *testing.T) {
+		_, addr := startServer(t, peerBackend{newBackend()})
+		conn := dial(t, addr)
+		roundTrip(t, conn, "whoami\r\n", "anonymous\r\n")
+		m := statsMap(readStats(t, conn, "stats settings\r\n"))
+		require.Equal(t, "no", m["ssl_enabled"], "ssl_enabled should match")
+	})
+}
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/lestrrat-go/memdproto"
+)
+
+// ListenAndServeUDP listens on the UDP address addr, and then calls
+// ServeUDP.
+func (s *Server) ListenAndServeUDP(addr string) error {
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return fmt.Errorf(`server.ListenAndServeUDP: failed to listen on %s: %w`, addr, err)
+	}
+	return s.ServeUDP(pc)
+}
+
+// SetMaxUDPRequests sets the maximum number of UDP requests that are
+// handled at the same time, by each call to ServeUDP. The default is
+// DefaultMaxUDPRequests, and zero means no limit.
+func (s *Server) SetMaxUDPRequests(n int) *Server {
+	s.maxUDPRequests = n
+	return s
+}
+
+// ServeUDP serves the UDP protocol on pc. Each datagram holds a request,
+// made of one or more commands, and the replies are sent back to its
+// sender, split into datagrams of at most memdproto.UDPMaxDatagramSize
+// bytes. As with memcached, requests that span several datagrams are
+// answered with a SERVER_ERROR, and malformed datagrams are dropped.
+//
+// Datagrams are handled in separate goroutines, up to the limit set using
+// SetMaxUDPRequests, so the replies to different requests may be sent in
+// any order. Once the limit is reached, no more datagrams are read until
+// a request is done, and those that do not fit in the socket's buffer in
+// the meantime are dropped. pc is closed when ServeUDP returns. After the
+// server has been closed, ServeUDP returns ErrServerClosed.
+//
+// Like memcached's, the UDP protocol answers whichever address a datagram
+// claims to come from, with replies that can be much larger than the
+// requests. This makes it a vector for amplification attacks: only serve
+// UDP when it is needed, and never on networks where the source address
+// of datagrams can be spoofed, such as the internet.
+func (s *Server) ServeUDP(pc net.PacketConn) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		pc.Close()
+		return ErrServerClosed
+	}
+	s.packetConns[pc] = struct{}{}
+	s.nextID++
+	id := s.nextID
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.packetConns, pc)
+		s.mu.Unlock()
+		pc.Close()
+	}()
+
+	var sem chan struct{}
+	if s.maxUDPRequests > 0 {
+		sem = make(chan struct{}, s.maxUDPRequests)
+	}
+	buf := make([]byte, 64*1024)
+	for {
+		if sem != nil {
+			select {
+			case sem <- struct{}{}:
+			case <-s.ctx.Done():
+				return ErrServerClosed
+			}
+		}
+
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			if s.isClosed() {
+				return ErrServerClosed
+			}
+			return fmt.Errorf(`server.ServeUDP: failed to read datagram: %w`, err)
+		}
+		datagram := append([]byte(nil), buf[:n]...)
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			return ErrServerClosed
+		}
+		s.wg.Add(1)
+		s.mu.Unlock()
+		go func() {
+			defer s.wg.Done()
+			if sem != nil {
+				defer func() { <-sem }()
+			}
+			s.serveDatagram(pc, addr, datagram, id)
+		}()
+	}
+}
+
+// serveDatagram handles the commands in a datagram received on pc, and
+// sends the replies back to addr. id identifies pc in watch events.
+func (s *Server) serveDatagram(pc net.PacketConn, addr net.Addr, datagram []byte, id uint64) {
+	s.counters.bytesRead.Add(uint64(len(datagram)))
+	h, payload, err := memdproto.ParseUDPDatagram(datagram)
+	if err != nil {
+		return
+	}
+
+	var out, buf bytes.Buffer
+	if h.Total != 1 {
+		writeReply(&out, &buf, memdproto.NewServerErrorReply(`multi-packet request not supported`))
+	} else {
+		ctx := context.WithValue(s.ctx, remoteAddrKey{}, addr)
+		ctx = context.WithValue(ctx, datagramKey{}, true)
+		rdr := s.newCmdReader(bytes.NewReader(payload))
+		for {
+			cmd, err := rdr.ReadCmd()
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				writeReply(&out, &buf, parseErrorReply(err))
+				if !rdr.InSync() {
+					break
+				}
+				continue
+			}
+			s.respond(ctx, &out, &buf, cmd, id)
+		}
+	}
+	if out.Len() == 0 {
+		return
+	}
+
+	datagrams, err := memdproto.UDPDatagrams(h.RequestID, out.Bytes(), 0)
+	if err != nil {
+		s.logf(`server: failed to frame reply to %s: %s`, addr, err)
+		return
+	}
+	for _, d := range datagrams {
+		n, err := pc.WriteTo(d, addr)
+		s.counters.bytesWritten.Add(uint64(n))
+		if err != nil {
+			return
+		}
+	}
+}
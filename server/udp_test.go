@@ -0,0 +1,155 @@
+package server_test
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/memdproto"
+	"github.com/lestrrat-go/memdproto/server"
+	"github.com/stretchr/testify/require"
+)
+
+// serveUDP serves srv on a loopback UDP port, and returns the address
+func serveUDP(t *testing.T, srv *server.Server) string {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err, "net.ListenPacket should succeed")
+
+	done := make(chan error, 1)
+	go func() { done <- srv.ServeUDP(pc) }()
+	t.Cleanup(func() {
+		require.NoError(t, srv.Close(), "srv.Close should succeed")
+		require.ErrorIs(t, <-done, server.ErrServerClosed, "srv.ServeUDP should return ErrServerClosed")
+	})
+	return pc.LocalAddr().String()
+}
+
+// udpRoundTrip sends datagram, and reassembles the reply
+func udpRoundTrip(t *testing.T, conn net.Conn, datagram []byte) (memdproto.UDPHeader, string) {
+	t.Helper()
+	_, err := conn.Write(datagram)
+	require.NoError(t, err, "conn.Write should succeed")
+
+	asm := memdproto.NewUDPAssembler()
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := conn.Read(buf)
+		require.NoError(t, err, "conn.Read should succeed")
+		require.LessOrEqual(t, n, memdproto.UDPMaxDatagramSize, "datagrams should not be too large")
+		h, message, ok, err := asm.Add(buf[:n])
+		require.NoError(t, err, "asm.Add should succeed")
+		if ok {
+			return h, string(message)
+		}
+	}
+}
+
+func request(requestID uint16, cmds string) []byte {
+	h := memdproto.UDPHeader{RequestID: requestID, Total: 1}
+	return append(h.AppendTo(nil), cmds...)
+}
+
+func TestUDP(t *testing.T) {
+	addr := serveUDP(t, server.New(newBackend()))
+	conn, err := net.Dial("udp", addr)
+	require.NoError(t, err, "net.Dial should succeed")
+	t.Cleanup(func() { conn.Close() })
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)), "conn.SetDeadline should succeed")
+
+	t.Run("commands", func(t *testing.T) {
+		h, reply := udpRoundTrip(t, conn, request(1, "ms foo 3\r\nbar\r\n"))
+		require.Equal(t, uint16(1), h.RequestID, "request ID should be echoed")
+		require.Equal(t, "HD\r\n", reply, "reply should match")
+
+		h, reply = udpRoundTrip(t, conn, request(2, "mg foo v q\r\nmg bar v q\r\nmd bar\r\nmn\r\n"))
+		require.Equal(t, uint16(2), h.RequestID, "request ID should be echoed")
+		require.Equal(t, "VA 3\r\nbar\r\nNF\r\nMN\r\n", reply, "replies should match")
+	})
+
+	t.Run("large reply", func(t *testing.T) {
+		value := strings.Repeat("x", 4000)
+		_, reply := udpRoundTrip(t, conn, request(3, "ms big 4000\r\n"+value+"\r\n"))
+		require.Equal(t, "HD\r\n", reply, "reply should match")
+
+		h, reply := udpRoundTrip(t, conn, request(4, "mg big v\r\n"))
+		require.Equal(t, uint16(3), h.Total, "reply should span 3 datagrams")
+		require.Equal(t, "VA 4000\r\n"+value+"\r\n", reply, "reply should match")
+	})
+
+	t.Run("stats", func(t *testing.T) {
+		_, reply := udpRoundTrip(t, conn, request(5, "stats\r\n"))
+		require.True(t, strings.HasPrefix(reply, "STAT pid "), "stats should be answered (got %q)", reply)
+		require.True(t, strings.HasSuffix(reply, "END\r\n"), "stats should be answered (got %q)", reply)
+	})
+
+	t.Run("multi-packet request", func(t *testing.T) {
+		h := memdproto.UDPHeader{RequestID: 6, Total: 2}
+		_, reply := udpRoundTrip(t, conn, append(h.AppendTo(nil), "mg foo v\r\n"...))
+		require.Equal(t, "SERVER_ERROR multi-packet request not supported\r\n", reply, "reply should match")
+	})
+
+	t.Run("malformed", func(t *testing.T) {
+		_, err := conn.Write([]byte("mg foo"))
+		require.NoError(t, err, "conn.Write should succeed")
+		_, reply := udpRoundTrip(t, conn, request(7, "bogus command\r\n"))
+		require.Equal(t, "ERROR\r\n", reply, "malformed datagrams should be dropped")
+	})
+}
+
+// blockingBackend answers "block" once it is released
+type blockingBackend struct {
+	*backend
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (b blockingBackend) Fallback(ctx context.Context, cmd memdproto.Cmd) (memdproto.Reply, error) {
+	if cmd.Verb() != "block" {
+		return b.backend.Fallback(ctx, cmd)
+	}
+	b.entered <- struct{}{}
+	<-b.release
+	return memdproto.NewRawReply(nil).SetBytes([]byte("DONE\r\n")), nil
+}
+
+func TestUDPMaxRequests(t *testing.T) {
+	b := blockingBackend{
+		backend: newBackend(),
+		entered: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	addr := serveUDP(t, server.New(b).SetMaxUDPRequests(1))
+	conn, err := net.Dial("udp", addr)
+	require.NoError(t, err, "net.Dial should succeed")
+	t.Cleanup(func() { conn.Close() })
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)), "conn.SetDeadline should succeed")
+
+	for i := uint16(1); i <= 2; i++ {
+		_, err := conn.Write(request(i, "block\r\n"))
+		require.NoError(t, err, "conn.Write should succeed")
+	}
+
+	<-b.entered
+	select {
+	case <-b.entered:
+		t.Fatal("second request should wait for the first one")
+	case <-time.After(100 * time.Millisecond):
+	}
+	b.release <- struct{}{}
+	<-b.entered
+	b.release <- struct{}{}
+
+	asm := memdproto.NewUDPAssembler()
+	buf := make([]byte, 64*1024)
+	for i := 0; i < 2; i++ {
+		n, err := conn.Read(buf)
+		require.NoError(t, err, "conn.Read should succeed")
+		_, message, ok, err := asm.Add(buf[:n])
+		require.NoError(t, err, "asm.Add should succeed")
+		require.True(t, ok, "reply should fit in a datagram")
+		require.Equal(t, "DONE\r\n", string(message), "reply should match")
+	}
+}
@@ -0,0 +1,54 @@
+package server_test
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/memdproto/server"
+	"github.com/stretchr/testify/require"
+)
+
+// socketPath returns a path for a unix domain socket. t.TempDir is
+// not used, as its paths may exceed the length allowed for sockets.
+func socketPath(t *testing.T) string {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "memd")
+	require.NoError(t, err, "os.MkdirTemp should succeed")
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return filepath.Join(dir, "memd.sock")
+}
+
+func TestUnix(t *testing.T) {
+	path := socketPath(t)
+	// a socket left behind by a previous server
+	stale, err := net.Listen("unix", path)
+	require.NoError(t, err, "net.Listen should succeed")
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	srv := server.New(newBackend())
+	done := make(chan error, 1)
+	go func() { done <- srv.ListenAndServe("unix://" + path) }()
+	t.Cleanup(func() {
+		require.NoError(t, srv.Close(), "srv.Close should succeed")
+		require.ErrorIs(t, <-done, server.ErrServerClosed, "srv.ListenAndServe should return ErrServerClosed")
+	})
+
+	var conn net.Conn
+	require.Eventually(t, func() bool {
+		conn, err = net.Dial("unix", path)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond, "net.Dial should succeed")
+	t.Cleanup(func() { conn.Close() })
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)), "conn.SetDeadline should succeed")
+
+	roundTrip(t, conn, "ms foo 3\r\nbar\r\n", "HD\r\n")
+	roundTrip(t, conn, "mg foo v\r\n", "VA 3\r\nbar\r\n")
+	m := statsMap(readStats(t, conn, "stats conns\r\n"))
+	require.Contains(t, m["1:addr"], "unix:", "address should match")
+
+	require.Error(t, server.New(newBackend()).ListenAndServe("unix://"+filepath.Join(path, "bogus")), "srv.ListenAndServe should fail on invalid paths")
+}
@@ -0,0 +1,274 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/lestrrat-go/memdproto"
+)
+
+// watchGroup is a set of event types that a watcher subscribes to
+type watchGroup uint
+
+const (
+	watchFetchers watchGroup = 1 << iota
+	watchMutations
+	watchRawCmds
+)
+
+// watchEventBuffer is the number of events that are queued for each
+// watcher. Events are dropped if a watcher falls further behind.
+const watchEventBuffer = 1024
+
+type watcher struct {
+	groups  watchGroup
+	events  chan string
+	skipped atomic.Uint64
+}
+
+// parseWatchGroups parses the arguments of the watch command. As in
+// memcached, watching without arguments means watching fetchers.
+func parseWatchGroups(args []string) (watchGroup, bool) {
+	if len(args) == 0 {
+		return watchFetchers, true
+	}
+	var groups watchGroup
+	for _, arg := range args {
+		switch arg {
+		case "fetchers":
+			groups |= watchFetchers
+		case "mutations":
+			groups |= watchMutations
+		case "rawcmds":
+			groups |= watchRawCmds
+		default:
+			return 0, false
+		}
+	}
+	return groups, true
+}
+
+// watchReply is the reply to a valid watch command. Once it has been
+// sent, the Server turns the connection into a watcher.
+type watchReply struct {
+	*memdproto.RawReply
+	groups watchGroup
+}
+
+// watch answers the watch command
+func watch(cmd *memdproto.RawCmd) (memdproto.Reply, error) {
+	groups, ok := parseWatchGroups(strings.Fields(string(cmd.Line()))[1:])
+	if !ok {
+		return nil, memdproto.NewClientErrorReply(`invalid watch option`)
+	}
+	return &watchReply{
+		RawReply: memdproto.NewRawReply(nil).SetBytes([]byte("OK\r\n")),
+		groups:   groups,
+	}, nil
+}
+
+// serveWatch turns conn into a watcher connection, once it has been
+// sent "OK": it is sent the events that it subscribed to, until the
+// client hangs up or the server is shut down
+func (s *Server) serveWatch(ctx context.Context, conn net.Conn, w *bufio.Writer, groups watchGroup) {
+	wt := &watcher{
+		groups: groups,
+		events: make(chan string, watchEventBuffer),
+	}
+	s.addWatcher(wt)
+	defer s.removeWatcher(wt)
+
+	if err := w.Flush(); err != nil {
+		return
+	}
+
+	// the client is not expected to send anything else, so reading only
+	// serves to notice that it has hung up. Watchers are considered idle,
+	// so that they are closed right away by Shutdown.
+	if !s.setIdle(conn, true) {
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+	gone := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, conn)
+		close(gone)
+	}()
+
+	for {
+		select {
+		case <-gone:
+			return
+		case <-ctx.Done():
+			return
+		case line := <-wt.events:
+			if n := wt.skipped.Swap(0); n > 0 {
+				fmt.Fprintf(w, "skipped=%d\n", n)
+			}
+			w.WriteString(line)
+			if len(wt.events) == 0 {
+				if err := w.Flush(); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
+
+// addWatcher and removeWatcher replace the set of watchers with a copy,
+// so that publish can read it without taking any lock
+func (s *Server) addWatcher(wt *watcher) {
+	s.watchMu.Lock()
+	defer s.watchMu.Unlock()
+	var watchers []*watcher
+	if old := s.watchers.Load(); old != nil {
+		watchers = append(watchers, *old...)
+	}
+	watchers = append(watchers, wt)
+	s.watchers.Store(&watchers)
+}
+
+func (s *Server) removeWatcher(wt *watcher) {
+	s.watchMu.Lock()
+	defer s.watchMu.Unlock()
+	var watchers []*watcher
+	for _, other := range *s.watchers.Load() {
+		if other != wt {
+			watchers = append(watchers, other)
+		}
+	}
+	s.watchers.Store(&watchers)
+}
+
+// publish sends the events for a command that was handled on the
+// connection with the given id to the watchers
+func (s *Server) publish(connID uint64, cmd memdproto.Cmd, reply memdproto.Reply) {
+	watchers := s.watchers.Load()
+	if watchers == nil || len(*watchers) == 0 {
+		return
+	}
+	events := watchEvents(cmd, reply)
+
+	for _, wt := range *watchers {
+		for _, ev := range events {
+			if wt.groups&ev.group == 0 {
+				continue
+			}
+			line := fmt.Sprintf("ts=%s gid=%d %s cfd=%d\n", watchTimestamp(), s.nextGID.Add(1), ev.fields, connID)
+			select {
+			case wt.events <- line:
+			default:
+				wt.skipped.Add(1)
+			}
+		}
+	}
+}
+
+func watchTimestamp() string {
+	now := time.Now()
+	return fmt.Sprintf("%d.%06d", now.Unix(), now.Nanosecond()/1000)
+}
+
+type watchEvent struct {
+	group  watchGroup
+	fields string
+}
+
+// watchEvents describes what cmd did, based on its reply
+func watchEvents(cmd memdproto.Cmd, reply memdproto.Reply) []watchEvent {
+	events := []watchEvent{
+		{watchRawCmds, fmt.Sprintf("type=rawcmd cmd=%q", commandLine(cmd))},
+	}
+	found := func(b bool) string {
+		if b {
+			return "found"
+		}
+		return "not_found"
+	}
+	add := func(group watchGroup, format string, args ...any) {
+		events = append(events, watchEvent{group, fmt.Sprintf(format, args...)})
+	}
+
+	// item events are about the keys of the command, which commands
+	// such as RawCmd do not have, whatever their handler replied
+	if len(cmd.Keys()) == 0 {
+		return events
+	}
+
+	switch reply := reply.(type) {
+	case *memdproto.MetaGetReply:
+		add(watchFetchers, "type=item_get key=%s status=%s cmd=%s", uriEncode(cmd.Keys()[0]), found(!reply.IsMiss()), cmd.Verb())
+	case *memdproto.GetReply:
+		hits := make(map[string]bool)
+		for _, item := range reply.Items() {
+			hits[item.Key()] = true
+		}
+		for _, key := range cmd.Keys() {
+			add(watchFetchers, "type=item_get key=%s status=%s cmd=%s", uriEncode(key), found(hits[key]), cmd.Verb())
+		}
+	case *memdproto.TouchCmdReply:
+		add(watchFetchers, "type=item_touch key=%s status=%s cmd=%s", uriEncode(cmd.Keys()[0]), found(reply.Status() == memdproto.TouchCmdReplyTouched), cmd.Verb())
+	case *memdproto.MetaSetReply, *memdproto.SetCmdReply:
+		var ttl int64
+		switch cmd := cmd.(type) {
+		case *memdproto.MetaSetCmd:
+			ttl, _ = cmd.TTL()
+		case interface{ Expires() int64 }:
+			ttl = cmd.Expires()
+		}
+		add(watchMutations, "type=item_store key=%s status=%s cmd=%s ttl=%d", uriEncode(cmd.Keys()[0]), storeStatus(reply), cmd.Verb(), ttl)
+	case *memdproto.MetaDeleteReply:
+		add(watchMutations, "type=item_delete key=%s status=%s cmd=%s", uriEncode(cmd.Keys()[0]), found(reply.Status() != memdproto.MetaDeleteCmdStatusNotFound), cmd.Verb())
+	case *memdproto.DeleteCmdReply:
+		add(watchMutations, "type=item_delete key=%s status=%s cmd=%s", uriEncode(cmd.Keys()[0]), found(reply.Status() == memdproto.DeleteCmdReplyDeleted), cmd.Verb())
+	case *memdproto.MetaArithmeticReply:
+		add(watchMutations, "type=item_arithmetic key=%s status=%s cmd=%s", uriEncode(cmd.Keys()[0]), found(reply.Status() != memdproto.MetaArithmeticCmdStatusNotFound), cmd.Verb())
+	case *memdproto.ArithmeticCmdReply:
+		add(watchMutations, "type=item_arithmetic key=%s status=%s cmd=%s", uriEncode(cmd.Keys()[0]), found(reply.Found()), cmd.Verb())
+	}
+	return events
+}
+
+// commandLine returns the command line of cmd, without its data block
+func commandLine(cmd memdproto.Cmd) string {
+	var buf bytes.Buffer
+	cmd.WriteTo(&buf)
+	line, _, _ := strings.Cut(buf.String(), "\r\n")
+	return line
+}
+
+// storeStatus names the status of the reply to a storage command
+func storeStatus(reply memdproto.Reply) string {
+	switch reply := reply.(type) {
+	case *memdproto.MetaSetReply:
+		switch reply.Status() {
+		case memdproto.MetaSetCmdStatusStored:
+			return "stored"
+		case memdproto.MetaSetCmdStatusNotStored:
+			return "not_stored"
+		case memdproto.MetaSetCmdStatusExists:
+			return "exists"
+		case memdproto.MetaSetCmdStatusNotFound:
+			return "not_found"
+		}
+	case *memdproto.SetCmdReply:
+		switch reply.Status() {
+		case memdproto.SetCmdReplyStored:
+			return "stored"
+		case memdproto.SetCmdReplyNotStored:
+			return "not_stored"
+		case memdproto.SetCmdReplyExists:
+			return "exists"
+		case memdproto.SetCmdReplyNotFound:
+			return "not_found"
+		}
+	}
+	return "invalid"
+}
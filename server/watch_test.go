@@ -0,0 +1,103 @@
+package server_test
+
+import (
+	"bufio"
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/lestrrat-go/memdproto"
+	"github.com/lestrrat-go/memdproto/server"
+	"github.com/stretchr/testify/require"
+)
+
+// watchLine matches the fields that vary from one run to the next
+var watchLine = regexp.MustCompile(`^ts=\d+\.\d{6} gid=\d+ (.*) cfd=\d+\n$`)
+
+// readEvents reads n events from rdr, without their varying fields
+func readEvents(t *testing.T, rdr *bufio.Reader, n int) []string {
+	t.Helper()
+	var events []string
+	for i := 0; i < n; i++ {
+		line, err := rdr.ReadString('\n')
+		require.NoError(t, err, "rdr.ReadString should succeed")
+		m := watchLine.FindStringSubmatch(line)
+		require.NotNil(t, m, "line should be an event (got %q)", line)
+		events = append(events, m[1])
+	}
+	return events
+}
+
+func TestWatch(t *testing.T) {
+	t.Run("fetchers", func(t *testing.T) {
+		_, addr := startServer(t, newBackend())
+		watcher := dial(t, addr)
+		roundTrip(t, watcher, "watch\r\n", "OK\r\n")
+
+		conn := dial(t, addr)
+		roundTrip(t, conn, "ms foo 3\r\nbar\r\n", "HD\r\n")
+		roundTrip(t, conn, "mg foo v\r\n", "VA 3\r\nbar\r\n")
+		roundTrip(t, conn, "get foo baz\r\n", "VALUE foo 0 3\r\nbar\r\nEND\r\n")
+
+		require.Equal(t, []string{
+			"type=item_get key=foo status=found cmd=mg",
+			"type=item_get key=foo status=found cmd=get",
+			"type=item_get key=baz status=not_found cmd=get",
+		}, readEvents(t, bufio.NewReader(watcher), 3), "events should match")
+	})
+
+	t.Run("mutations and rawcmds", func(t *testing.T) {
+		_, addr := startServer(t, newBackend())
+		watcher := dial(t, addr)
+		roundTrip(t, watcher, "watch mutations rawcmds\r\n", "OK\r\n")
+
+		conn := dial(t, addr)
+		roundTrip(t, conn, "ms a%20b 3 T60\r\nbar\r\n", "HD\r\n")
+		roundTrip(t, conn, "set foo 0 0 3\r\nbar\r\n", "STORED\r\n")
+		roundTrip(t, conn, "md foo\r\n", "HD\r\n")
+		roundTrip(t, conn, "md foo\r\n", "NF\r\n")
+
+		require.Equal(t, []string{
+			`type=rawcmd cmd="ms a%20b 3 T60"`,
+			"type=item_store key=a%2520b status=stored cmd=ms ttl=60",
+			`type=rawcmd cmd="set foo 0 0 3"`,
+			"type=item_store key=foo status=stored cmd=set ttl=0",
+			`type=rawcmd cmd="md foo"`,
+			"type=item_delete key=foo status=found cmd=md",
+			`type=rawcmd cmd="md foo"`,
+			"type=item_delete key=foo status=not_found cmd=md",
+		}, readEvents(t, bufio.NewReader(watcher), 8), "events should match")
+	})
+
+	t.Run("keyless command", func(t *testing.T) {
+		h := server.HandlerFunc(func(_ context.Context, cmd memdproto.Cmd) (memdproto.Reply, error) {
+			switch cmd.Verb() {
+			case "vendor_set":
+				return memdproto.NewSetCmdReply().SetStatus(memdproto.SetCmdReplyStored), nil
+			default:
+				return memdproto.NewMetaGetReply().SetMiss(true), nil
+			}
+		})
+		_, addr := startServer(t, h)
+		watcher := dial(t, addr)
+		roundTrip(t, watcher, "watch fetchers mutations rawcmds\r\n", "OK\r\n")
+
+		conn := dial(t, addr)
+		roundTrip(t, conn, "vendor_get foo\r\n", "EN\r\n")
+		roundTrip(t, conn, "vendor_set foo\r\n", "STORED\r\n")
+		roundTrip(t, conn, "mg foo v\r\n", "EN\r\n")
+
+		require.Equal(t, []string{
+			`type=rawcmd cmd="vendor_get foo"`,
+			`type=rawcmd cmd="vendor_set foo"`,
+			`type=rawcmd cmd="mg foo v"`,
+			"type=item_get key=foo status=not_found cmd=mg",
+		}, readEvents(t, bufio.NewReader(watcher), 4), "events should match")
+	})
+
+	t.Run("invalid option", func(t *testing.T) {
+		_, addr := startServer(t, newBackend())
+		conn := dial(t, addr)
+		roundTrip(t, conn, "watch bogus\r\n", "CLIENT_ERROR invalid watch option\r\n")
+	})
+}
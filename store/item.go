@@ -0,0 +1,78 @@
+package store
+
+import (
+	"bytes"
+	"container/list"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/lestrrat-go/memdproto"
+)
+
+// maxRelativeExptime is the largest exptime that is treated as a number
+// of seconds from now. Anything larger is a unix timestamp.
+const maxRelativeExptime = 60 * 60 * 24 * 30
+
+type item struct {
+	key        string
+	value      []byte
+	flags      uint32
+	cas        uint64
+	exptime    time.Time // zero if the item never expires
+	lastAccess time.Time
+	fetched    bool
+	active     bool
+	stale      bool
+	tokenSent  bool
+
+	lru   segment
+	class *slabClass
+	elem  *list.Element
+}
+
+// expiry converts an exptime sent by the client into a point in time.
+// As in memcached, values up to 30 days are relative to now, and larger
+// values are unix timestamps.
+func expiry(now time.Time, exptime int64) time.Time {
+	switch {
+	case exptime == 0:
+		return time.Time{}
+	case exptime < 0:
+		// negative values expire the item immediately
+		return now
+	case exptime > maxRelativeExptime:
+		return time.Unix(exptime, 0)
+	default:
+		return now.Add(time.Duration(exptime) * time.Second)
+	}
+}
+
+// remainingTTL returns the remaining TTL of it in seconds, or -1
+// if it never expires
+func (it *item) remainingTTL(now time.Time) int64 {
+	if it.exptime.IsZero() {
+		return -1
+	}
+	return int64(math.Ceil(it.exptime.Sub(now).Seconds()))
+}
+
+func (it *item) expired(now time.Time) bool {
+	return !it.exptime.IsZero() && !now.Before(it.exptime)
+}
+
+// applyDelta adds or subtracts delta from the decimal number in value.
+// Increments wrap around at 64 bits, and decrements stop at 0.
+func applyDelta(value []byte, delta uint64, mode memdproto.MetaArithmeticMode) (uint64, bool) {
+	n, err := strconv.ParseUint(string(bytes.TrimRight(value, " ")), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	if mode == memdproto.MetaArithmeticModeDecr {
+		if delta > n {
+			return 0, true
+		}
+		return n - delta, true
+	}
+	return n + delta, true
+}
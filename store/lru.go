@@ -0,0 +1,128 @@
+package store
+
+import (
+	"container/list"
+)
+
+// segment identifies one of the LRUs that items are kept in. As in
+// memcached's segmented LRU, new items enter HOT, items that are
+// accessed again while in HOT or COLD are moved to WARM, and all
+// other items flow from HOT to COLD, where they are evicted from.
+type segment int
+
+const (
+	segmentHot segment = iota
+	segmentWarm
+	segmentCold
+	numSegments
+)
+
+// Shares of the memory limit that HOT and WARM may use before items
+// are moved down to COLD, as in memcached's default settings
+const (
+	hotPercent  = 20
+	warmPercent = 40
+)
+
+// itemHeaderSize is the per-item overhead, modeled after the item
+// header of memcached on 64-bit platforms, including the CAS value
+const itemHeaderSize = 56
+
+// size returns the number of bytes that the item is accounted for.
+// As in memcached, the key is stored with a terminating NUL, and the
+// value with its trailing CRLF.
+func (it *item) size() int64 {
+	return int64(itemHeaderSize + len(it.key) + 1 + len(it.value) + 2)
+}
+
+type lru struct {
+	lists [numSegments]*list.List
+	bytes [numSegments]int64
+}
+
+func newLRU() *lru {
+	var l lru
+	for i := range l.lists {
+		l.lists[i] = list.New()
+	}
+	return &l
+}
+
+func (l *lru) len(seg segment) int {
+	return l.lists[seg].Len()
+}
+
+// link adds it at the head of seg
+func (l *lru) link(it *item, seg segment) {
+	it.lru = seg
+	it.elem = l.lists[seg].PushFront(it)
+	l.bytes[seg] += it.size()
+}
+
+func (l *lru) unlink(it *item) {
+	l.lists[it.lru].Remove(it.elem)
+	l.bytes[it.lru] -= it.size()
+	it.elem = nil
+}
+
+// move unlinks it, and adds it at the head of seg
+func (l *lru) move(it *item, seg segment) {
+	l.unlink(it)
+	l.link(it, seg)
+}
+
+// tail returns the least recently used item in seg, or nil
+func (l *lru) tail(seg segment) *item {
+	if elem := l.lists[seg].Back(); elem != nil {
+		return elem.Value.(*item)
+	}
+	return nil
+}
+
+// total returns the number of bytes used by all items
+func (l *lru) total() int64 {
+	var n int64
+	for _, b := range l.bytes {
+		n += b
+	}
+	return n
+}
+
+// balance moves items out of HOT and WARM until they fit in their share
+// of limit. Active items are rescued into WARM, and the others are moved
+// to COLD.
+func (l *lru) balance(limit int64) {
+	for l.bytes[segmentHot] > limit*hotPercent/100 {
+		it := l.tail(segmentHot)
+		if it.active {
+			it.active = false
+			l.move(it, segmentWarm)
+		} else {
+			l.move(it, segmentCold)
+		}
+	}
+
+	for l.bytes[segmentWarm] > limit*warmPercent/100 {
+		it := l.tail(segmentWarm)
+		if it.active {
+			// active items get another trip through WARM, which
+			// terminates because the flag is cleared
+			it.active = false
+			l.move(it, segmentWarm)
+		} else {
+			l.move(it, segmentCold)
+		}
+	}
+}
+
+// victim returns the next item to evict: the tail of COLD, or of
+// WARM and then HOT if COLD is empty. It returns nil if there are no
+// items at all.
+func (l *lru) victim() *item {
+	for _, seg := range []segment{segmentCold, segmentWarm, segmentHot} {
+		if it := l.tail(seg); it != nil {
+			return it
+		}
+	}
+	return nil
+}
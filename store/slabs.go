@@ -0,0 +1,220 @@
+package store
+
+import (
+	"time"
+)
+
+// Defaults of memcached's -f and -I options, which control the sizes
+// of the slab classes
+const (
+	DefaultGrowthFactor = 1.25
+	DefaultItemSizeMax  = 1 << 20
+)
+
+const (
+	// minChunkSize is the size of the smallest chunk, as in memcached:
+	// the item header without CAS, plus 48 bytes of key and value
+	minChunkSize = 48 + 48
+	chunkAlign   = 8
+	// maxSlabClasses is the number of slab classes in memcached,
+	// including the unused class 0
+	maxSlabClasses = 64
+)
+
+// slabClass holds the items whose size fits in its chunks. As in
+// memcached, memory is given to classes one page at a time, and each
+// class has its own LRU to evict items from when it is full.
+type slabClass struct {
+	id        int
+	chunkSize int64
+	perPage   int64
+	pages     int64
+	used      int64
+	lru       *lru
+
+	evicted          uint64
+	evictedUnfetched uint64
+	evictedTime      time.Duration
+	reclaimed        uint64
+	outOfMemory      uint64
+}
+
+// newSlabClasses returns the slab classes for the given growth factor
+// and page size, following memcached: chunk sizes start at minChunkSize
+// and grow by factor, up to half a page. Larger items are split across
+// chunks of the largest class.
+func newSlabClasses(factor float64, pageSize int64) []*slabClass {
+	chunkMax := pageSize / 2
+	newClass := func(chunkSize int64) *slabClass {
+		return &slabClass{
+			chunkSize: chunkSize,
+			perPage:   pageSize / chunkSize,
+			lru:       newLRU(),
+		}
+	}
+
+	var classes []*slabClass
+	size := int64(minChunkSize)
+	for len(classes) < maxSlabClasses-2 && float64(size) < float64(chunkMax)/factor {
+		if r := size % chunkAlign; r != 0 {
+			size += chunkAlign - r
+		}
+		classes = append(classes, newClass(size))
+		size = int64(float64(size) * factor)
+	}
+	classes = append(classes, newClass(chunkMax))
+
+	for i, c := range classes {
+		c.id = i + 1
+	}
+	return classes
+}
+
+// chunks returns the number of chunks that an item of size bytes uses
+func (c *slabClass) chunks(size int64) int64 {
+	return (size + c.chunkSize - 1) / c.chunkSize
+}
+
+// capacity returns the number of bytes in the pages of the class
+func (c *slabClass) capacity() int64 {
+	return c.pages * c.perPage * c.chunkSize
+}
+
+// classFor returns the smallest class whose chunks can hold an item
+// of size bytes, or the largest class if there is none. s.mu must be held.
+func (s *Store) classFor(size int64) *slabClass {
+	for _, c := range s.classes {
+		if size <= c.chunkSize {
+			return c
+		}
+	}
+	return s.classes[len(s.classes)-1]
+}
+
+// newPage gives a new page to c, unless the memory limit has been
+// reached. As in memcached, each class may always get its first page,
+// so that every size of item can be stored. s.mu must be held.
+func (s *Store) newPage(c *slabClass) bool {
+	if s.limit > 0 && c.pages > 0 && (s.pages+1)*s.pageSize > s.limit {
+		return false
+	}
+	c.pages++
+	s.pages++
+	return true
+}
+
+// alloc makes room for an item of size bytes in c, by allocating a new
+// page or by evicting items from the class. s.mu must be held.
+func (s *Store) alloc(c *slabClass, size int64, now time.Time) error {
+	n := c.chunks(size)
+	for c.used+n > c.pages*c.perPage {
+		if s.newPage(c) {
+			continue
+		}
+
+		it := c.lru.victim()
+		if it == nil {
+			c.outOfMemory++
+			return errOutOfMemory
+		}
+		s.remove(it)
+		switch {
+		case it.expired(now):
+			c.reclaimed++
+		default:
+			c.evicted++
+			c.evictedTime = now.Sub(it.lastAccess)
+			if !it.fetched {
+				c.evictedUnfetched++
+			}
+		}
+	}
+	c.used += n
+	return nil
+}
+
+// SlabStats holds the counters of a slab class, as reported by the
+// stats slabs and stats items commands
+type SlabStats struct {
+	// ID is the number of the class, starting at 1
+	ID int
+	// ChunkSize is the size of the chunks of the class, and
+	// ChunksPerPage the number of chunks that fit in a page
+	ChunkSize     int64
+	ChunksPerPage int64
+	// TotalPages is the number of pages given to the class, and
+	// UsedChunks the number of chunks used by items
+	TotalPages int64
+	UsedChunks int64
+	// Items is the number of items in the class, and HotItems,
+	// WarmItems and ColdItems the number of those in each LRU segment
+	Items     uint64
+	HotItems  uint64
+	WarmItems uint64
+	ColdItems uint64
+	// MemRequested is the number of bytes used by the items, without
+	// the space wasted at the end of their chunks
+	MemRequested int64
+	// Age is the time since the oldest item in COLD was accessed, and
+	// AgeHot and AgeWarm the same for HOT and WARM
+	Age     time.Duration
+	AgeHot  time.Duration
+	AgeWarm time.Duration
+	// Evicted is the number of items that were evicted from the class,
+	// EvictedUnfetched the number of those that were never fetched, and
+	// EvictedTime the time since the last evicted item was accessed
+	Evicted          uint64
+	EvictedUnfetched uint64
+	EvictedTime      time.Duration
+	// Reclaimed is the number of expired items whose memory was reused
+	Reclaimed uint64
+	// OutOfMemory is the number of items that could not be stored
+	// because the class was full, and had nothing to evict
+	OutOfMemory uint64
+}
+
+func (c *slabClass) stats(now time.Time) SlabStats {
+	age := func(seg segment) time.Duration {
+		if it := c.lru.tail(seg); it != nil {
+			return now.Sub(it.lastAccess)
+		}
+		return 0
+	}
+
+	return SlabStats{
+		ID:               c.id,
+		ChunkSize:        c.chunkSize,
+		ChunksPerPage:    c.perPage,
+		TotalPages:       c.pages,
+		UsedChunks:       c.used,
+		Items:            uint64(c.lru.len(segmentHot) + c.lru.len(segmentWarm) + c.lru.len(segmentCold)),
+		HotItems:         uint64(c.lru.len(segmentHot)),
+		WarmItems:        uint64(c.lru.len(segmentWarm)),
+		ColdItems:        uint64(c.lru.len(segmentCold)),
+		MemRequested:     c.lru.total(),
+		Age:              age(segmentCold),
+		AgeHot:           age(segmentHot),
+		AgeWarm:          age(segmentWarm),
+		Evicted:          c.evicted,
+		EvictedUnfetched: c.evictedUnfetched,
+		EvictedTime:      c.evictedTime,
+		Reclaimed:        c.reclaimed,
+		OutOfMemory:      c.outOfMemory,
+	}
+}
+
+// Slabs returns the counters of the slab classes that have been given
+// memory, in increasing order of chunk size
+func (s *Store) Slabs() []SlabStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clock()
+	var stats []SlabStats
+	for _, c := range s.classes {
+		if c.pages > 0 {
+			stats = append(stats, c.stats(now))
+		}
+	}
+	return stats
+}
@@ -0,0 +1,272 @@
+package store
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Snapshots are text files that start with a header line, followed by
+// one record per item, and an END line. Lines are terminated by CRLF,
+// like in the memcached protocol:
+//
+//	memdproto-snapshot 1
+//	<key> <flags> <exptime> <cas> <bytes> [b]
+//	<data block>
+//	...
+//	END
+//
+// flags is the 32-bit client flags, exptime the absolute unix time at
+// which the item expires, or 0 if it never does, and cas its CAS value.
+// bytes is the length of the data block, which is followed by CRLF.
+// Keys that contain spaces or control characters are base64 encoded,
+// which is marked by a trailing "b", as with the meta commands.
+//
+// Items are written from the least to the most recently accessed, so
+// that restoring a snapshot keeps the most recent ones away from
+// eviction. This also makes it easy to write fixtures by hand:
+//
+//	memdproto-snapshot 1
+//	user:1 0 0 1 5
+//	alice
+//	END
+const snapshotHeader = "memdproto-snapshot 1"
+
+// SaveSnapshot writes a snapshot of the store to the file at path, see
+// WriteSnapshot. The snapshot is written to a temporary file which then
+// replaces the file at path, so that it is never left half-written.
+func (s *Store) SaveSnapshot(path string) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf(`store.SaveSnapshot: failed to create temporary file: %w`, err)
+	}
+	defer os.Remove(f.Name())
+
+	if err := s.WriteSnapshot(f); err != nil {
+		f.Close()
+		return fmt.Errorf(`store.SaveSnapshot: %w`, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf(`store.SaveSnapshot: failed to close temporary file: %w`, err)
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf(`store.SaveSnapshot: failed to rename temporary file: %w`, err)
+	}
+	return nil
+}
+
+// LoadSnapshot reads the snapshot in the file at path into the store,
+// see ReadSnapshot. If the file does not exist, the returned error
+// wraps fs.ErrNotExist, so that a server can start empty on its first
+// run:
+//
+//	if err := s.LoadSnapshot(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+//	  ...
+//	}
+func (s *Store) LoadSnapshot(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf(`store.LoadSnapshot: %w`, err)
+	}
+	defer f.Close()
+
+	if err := s.ReadSnapshot(f); err != nil {
+		return fmt.Errorf(`store.LoadSnapshot: %w`, err)
+	}
+	return nil
+}
+
+// WriteSnapshot writes all live items to w, in the snapshot format.
+// Expired items are left out, as are items that were invalidated with
+// md I, since they are only kept around to be recached.
+func (s *Store) WriteSnapshot(w io.Writer) error {
+	s.mu.Lock()
+	now := s.clock()
+	items := make([]*item, 0, len(s.items))
+	for _, it := range s.items {
+		if !it.expired(now) && !it.stale {
+			// copied, as the item may be modified once the lock is released
+			cp := *it
+			items = append(items, &cp)
+		}
+	}
+	s.mu.Unlock()
+
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].lastAccess.Before(items[j].lastAccess)
+	})
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "%s\r\n", snapshotHeader)
+	for _, it := range items {
+		key, b64 := it.key, ""
+		if !plainKey(key) {
+			key, b64 = base64.StdEncoding.EncodeToString([]byte(key)), " b"
+		}
+		var exptime int64
+		if !it.exptime.IsZero() {
+			exptime = it.exptime.Unix()
+		}
+		fmt.Fprintf(bw, "%s %d %d %d %d%s\r\n", key, it.flags, exptime, it.cas, len(it.value), b64)
+		bw.Write(it.value)
+		bw.WriteString("\r\n")
+	}
+	bw.WriteString("END\r\n")
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf(`store.WriteSnapshot: failed to write snapshot: %w`, err)
+	}
+	return nil
+}
+
+// plainKey returns true if key can be written as-is in a snapshot
+func plainKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] <= ' ' || key[i] == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// ReadSnapshot reads a snapshot from r, and stores its items. Items that
+// have expired since the snapshot was written are skipped. Items keep
+// their CAS value, and new CAS values are generated after the largest
+// one in the snapshot.
+//
+// Items are stored as they are read, so if an error occurs, the items
+// read up to that point are kept.
+func (s *Store) ReadSnapshot(r io.Reader) error {
+	s.mu.Lock()
+	maxSize := s.pageSize
+	s.mu.Unlock()
+
+	br := bufio.NewReader(r)
+	line, err := readSnapshotLine(br)
+	if err != nil {
+		return fmt.Errorf(`store.ReadSnapshot: failed to read header: %w`, err)
+	}
+	if line != snapshotHeader {
+		return fmt.Errorf(`store.ReadSnapshot: invalid header %q`, line)
+	}
+
+	for n := 1; ; n++ {
+		line, err := readSnapshotLine(br)
+		if err != nil {
+			return fmt.Errorf(`store.ReadSnapshot: failed to read item %d: %w`, n, err)
+		}
+		if line == "END" {
+			return nil
+		}
+
+		rec, err := parseSnapshotRecord(line)
+		if err != nil {
+			return fmt.Errorf(`store.ReadSnapshot: invalid item %d: %w`, n, err)
+		}
+		// the length comes from the file, and is checked before
+		// allocating anything
+		if int64(rec.size) > maxSize {
+			return fmt.Errorf(`store.ReadSnapshot: data of item %d is larger than the maximum item size (%d > %d)`, n, rec.size, maxSize)
+		}
+		rec.value = make([]byte, rec.size+2)
+		if _, err := io.ReadFull(br, rec.value); err != nil {
+			return fmt.Errorf(`store.ReadSnapshot: failed to read data of item %d: %w`, n, err)
+		}
+		if !strings.HasSuffix(string(rec.value), "\r\n") {
+			return fmt.Errorf(`store.ReadSnapshot: data of item %d is not terminated by CRLF`, n)
+		}
+		rec.value = rec.value[:rec.size]
+
+		if err := s.restore(rec); err != nil {
+			return fmt.Errorf(`store.ReadSnapshot: failed to store item %d: %w`, n, err)
+		}
+	}
+}
+
+type snapshotRecord struct {
+	key     string
+	flags   uint32
+	exptime int64
+	cas     uint64
+	size    int
+	value   []byte
+}
+
+func parseSnapshotRecord(line string) (*snapshotRecord, error) {
+	fields := strings.Split(line, " ")
+	if len(fields) != 5 && !(len(fields) == 6 && fields[5] == "b") {
+		return nil, fmt.Errorf(`expected 5 fields, got %q`, line)
+	}
+
+	var rec snapshotRecord
+	rec.key = fields[0]
+	if len(fields) == 6 {
+		key, err := base64.StdEncoding.DecodeString(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf(`invalid base64 key: %w`, err)
+		}
+		rec.key = string(key)
+	}
+	flags, err := strconv.ParseUint(fields[1], 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf(`invalid flags: %w`, err)
+	}
+	rec.flags = uint32(flags)
+	if rec.exptime, err = strconv.ParseInt(fields[2], 10, 64); err != nil || rec.exptime < 0 {
+		return nil, fmt.Errorf(`invalid exptime %q`, fields[2])
+	}
+	if rec.cas, err = strconv.ParseUint(fields[3], 10, 64); err != nil {
+		return nil, fmt.Errorf(`invalid cas: %w`, err)
+	}
+	if rec.size, err = strconv.Atoi(fields[4]); err != nil || rec.size < 0 {
+		return nil, fmt.Errorf(`invalid data length %q`, fields[4])
+	}
+	return &rec, nil
+}
+
+// readSnapshotLine reads a line terminated by CRLF, and returns it
+// without the terminator
+func readSnapshotLine(br *bufio.Reader) (string, error) {
+	line, err := br.ReadString('\n')
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return "", io.ErrUnexpectedEOF
+		}
+		return "", err
+	}
+	if !strings.HasSuffix(line, "\r\n") {
+		return "", fmt.Errorf(`line %q is not terminated by CRLF`, line)
+	}
+	return strings.TrimSuffix(line, "\r\n"), nil
+}
+
+// restore stores an item read from a snapshot
+func (s *Store) restore(rec *snapshotRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.clock()
+
+	var exptime time.Time
+	if rec.exptime != 0 {
+		exptime = time.Unix(rec.exptime, 0)
+		if !now.Before(exptime) {
+			return nil
+		}
+	}
+
+	if _, err := s.store(rec.key, rec.value, rec.flags, exptime, rec.cas, now); err != nil {
+		return err
+	}
+	s.cas = max(s.cas, rec.cas)
+	return nil
+}
@@ -0,0 +1,85 @@
+package store_test
+
+import (
+	"bytes"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshot(t *testing.T) {
+	t.Run("round trip", func(t *testing.T) {
+		s, c := newStore()
+		run(t, s, []exchange{
+			{Cmd: "ms foo 3 F4294967295\r\nbar\r\n", Reply: "HD\r\n"},
+		})
+		c.now = c.now.Add(time.Second)
+		run(t, s, []exchange{
+			{Cmd: "ms Zm9vIGJhcg== 5 b T60 E100\r\nhello\r\n", Reply: "HD\r\n"},
+			{Cmd: "ms gone 1 T1\r\nx\r\n", Reply: "HD\r\n"},
+			{Cmd: "ms stale 1\r\nx\r\n", Reply: "HD\r\n"},
+			{Cmd: "md stale I\r\n", Reply: "HD\r\n"},
+		})
+		c.now = c.now.Add(time.Second)
+
+		var buf bytes.Buffer
+		require.NoError(t, s.WriteSnapshot(&buf), "s.WriteSnapshot should succeed")
+		require.Equal(t, "memdproto-snapshot 1\r\nfoo 4294967295 0 1 3\r\nbar\r\nZm9vIGJhcg== 0 1700000061 100 5 b\r\nhello\r\nEND\r\n", buf.String(), "snapshot should match")
+
+		restored, rc := newStore()
+		rc.now = c.now.Add(30 * time.Second)
+		require.NoError(t, restored.ReadSnapshot(&buf), "restored.ReadSnapshot should succeed")
+		run(t, restored, []exchange{
+			{Cmd: "mg foo c f v\r\n", Reply: "VA 3 c1 f4294967295\r\nbar\r\n"},
+			{Cmd: "mg Zm9vIGJhcg== b c t v\r\n", Reply: "VA 5 c100 t29\r\nhello\r\n"},
+			{Cmd: "mg stale v\r\n", Reply: "EN\r\n"},
+			{Cmd: "ms new 1 c\r\nx\r\n", Reply: "HD c101\r\n"},
+		})
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		s, _ := newStore()
+		require.NoError(t, s.ReadSnapshot(strings.NewReader("memdproto-snapshot 1\r\nold 0 1600000000 1 1\r\nx\r\nEND\r\n")), "s.ReadSnapshot should succeed")
+		require.Equal(t, uint64(0), s.Stats().CurrItems, "expired items should be skipped")
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, data := range []string{
+			"",
+			"memcached-snapshot 1\r\nEND\r\n",
+			"memdproto-snapshot 1\r\nfoo 0 0 1\r\nx\r\nEND\r\n",
+			"memdproto-snapshot 1\r\nfoo 0 0 1 3\r\nx\r\nEND\r\n",
+			"memdproto-snapshot 1\r\nfoo 0 0 1 1\r\nx\r\n",
+			"memdproto-snapshot 1\r\nfoo 0 0 1 2097152\r\nx\r\nEND\r\n",
+			"memdproto-snapshot 1\r\nfoo 0 0 1 9223372036854775807\r\nx\r\nEND\r\n",
+		} {
+			s, _ := newStore()
+			require.Error(t, s.ReadSnapshot(strings.NewReader(data)), "s.ReadSnapshot should fail for %q", data)
+		}
+	})
+
+	t.Run("file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "cache.snapshot")
+		s, _ := newStore()
+		require.ErrorIs(t, s.LoadSnapshot(path), fs.ErrNotExist, "s.LoadSnapshot should fail with fs.ErrNotExist")
+
+		run(t, s, []exchange{
+			{Cmd: "ms foo 3\r\nbar\r\n", Reply: "HD\r\n"},
+		})
+		require.NoError(t, s.SaveSnapshot(path), "s.SaveSnapshot should succeed")
+
+		restored, _ := newStore()
+		require.NoError(t, restored.LoadSnapshot(path), "restored.LoadSnapshot should succeed")
+		run(t, restored, []exchange{
+			{Cmd: "mg foo v\r\n", Reply: "VA 3\r\nbar\r\n"},
+		})
+
+		entries, err := filepath.Glob(filepath.Join(filepath.Dir(path), "*"))
+		require.NoError(t, err, "filepath.Glob should succeed")
+		require.Equal(t, []string{path}, entries, "no temporary files should be left")
+	})
+}
@@ -0,0 +1,197 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lestrrat-go/memdproto/server"
+)
+
+// Stats holds the counters of a Store
+type Stats struct {
+	// CurrItems is the number of items currently stored, and TotalItems
+	// the number of items stored since the Store was created
+	CurrItems  uint64
+	TotalItems uint64
+	// Bytes is the number of bytes used by the items, including their
+	// overhead, and LimitMaxbytes the memory limit, or 0 if none is set
+	Bytes         int64
+	LimitMaxbytes int64
+	// TotalMalloced is the number of bytes in the pages given to the
+	// slab classes
+	TotalMalloced int64
+	// Evictions is the number of items that were evicted to make room
+	// for new ones, and EvictedUnfetched the number of those that were
+	// never fetched
+	Evictions        uint64
+	EvictedUnfetched uint64
+	// Reclaimed is the number of expired items whose memory was reused
+	Reclaimed uint64
+	// HotItems, WarmItems and ColdItems are the number of items in
+	// each segment of the LRUs
+	HotItems  uint64
+	WarmItems uint64
+	ColdItems uint64
+}
+
+// Stats returns a snapshot of the counters of the store
+func (s *Store) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := Stats{
+		CurrItems:     uint64(len(s.items)),
+		TotalItems:    s.totalItems,
+		LimitMaxbytes: s.limit,
+		TotalMalloced: s.pages * s.pageSize,
+	}
+	for _, c := range s.classes {
+		stats.Bytes += c.lru.total()
+		stats.Evictions += c.evicted
+		stats.EvictedUnfetched += c.evictedUnfetched
+		stats.Reclaimed += c.reclaimed
+		stats.HotItems += uint64(c.lru.len(segmentHot))
+		stats.WarmItems += uint64(c.lru.len(segmentWarm))
+		stats.ColdItems += uint64(c.lru.len(segmentCold))
+	}
+	return stats
+}
+
+// statWriter accumulates the lines of a reply to a stats command
+type statWriter struct {
+	buf strings.Builder
+}
+
+func (w *statWriter) stat(name string, value any) {
+	fmt.Fprintf(&w.buf, "STAT %s %v\r\n", name, value)
+}
+
+func (w *statWriter) end() []byte {
+	w.buf.WriteString("END\r\n")
+	return []byte(w.buf.String())
+}
+
+// StatFields returns the fields that the store adds to the replies
+// to the stats and stats settings commands
+func (s *Store) StatFields(_ context.Context, group string) []server.Stat {
+	switch group {
+	case "":
+		stats := s.Stats()
+		return []server.Stat{
+			{Name: "curr_items", Value: stats.CurrItems},
+			{Name: "total_items", Value: stats.TotalItems},
+			{Name: "bytes", Value: stats.Bytes},
+			{Name: "limit_maxbytes", Value: stats.LimitMaxbytes},
+			{Name: "evictions", Value: stats.Evictions},
+			{Name: "evicted_unfetched", Value: stats.EvictedUnfetched},
+			{Name: "reclaimed", Value: stats.Reclaimed},
+		}
+	case "settings":
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return []server.Stat{
+			{Name: "maxbytes", Value: s.limit},
+			{Name: "growth_factor", Value: strconv.FormatFloat(s.factor, 'f', 2, 64)},
+			{Name: "chunk_size", Value: minChunkSize - 48},
+			{Name: "item_size_max", Value: s.pageSize},
+			{Name: "slab_chunk_max", Value: s.pageSize / 2},
+			{Name: "lru_segmented", Value: "yes"},
+			{Name: "hot_lru_pct", Value: hotPercent},
+			{Name: "warm_lru_pct", Value: warmPercent},
+		}
+	default:
+		return nil
+	}
+}
+
+// encodeStats encodes stats as a reply to a stats command
+func encodeStats(stats []server.Stat) []byte {
+	var w statWriter
+	for _, stat := range stats {
+		w.stat(stat.Name, stat.Value)
+	}
+	return w.end()
+}
+
+// encodeSlabs encodes the reply to the stats slabs command
+func encodeSlabs(stats Stats, slabs []SlabStats) []byte {
+	var w statWriter
+	for _, slab := range slabs {
+		total := slab.TotalPages * slab.ChunksPerPage
+		w.stat(fmt.Sprintf("%d:chunk_size", slab.ID), slab.ChunkSize)
+		w.stat(fmt.Sprintf("%d:chunks_per_page", slab.ID), slab.ChunksPerPage)
+		w.stat(fmt.Sprintf("%d:total_pages", slab.ID), slab.TotalPages)
+		w.stat(fmt.Sprintf("%d:total_chunks", slab.ID), total)
+		w.stat(fmt.Sprintf("%d:used_chunks", slab.ID), slab.UsedChunks)
+		w.stat(fmt.Sprintf("%d:free_chunks", slab.ID), total-slab.UsedChunks)
+		w.stat(fmt.Sprintf("%d:mem_requested", slab.ID), slab.MemRequested)
+	}
+	w.stat("active_slabs", len(slabs))
+	w.stat("total_malloced", stats.TotalMalloced)
+	return w.end()
+}
+
+// encodeItems encodes the reply to the stats items command
+func encodeItems(slabs []SlabStats) []byte {
+	var w statWriter
+	for _, slab := range slabs {
+		if slab.Items == 0 && slab.Evicted == 0 && slab.OutOfMemory == 0 {
+			continue
+		}
+		w.stat(fmt.Sprintf("items:%d:number", slab.ID), slab.Items)
+		w.stat(fmt.Sprintf("items:%d:number_hot", slab.ID), slab.HotItems)
+		w.stat(fmt.Sprintf("items:%d:number_warm", slab.ID), slab.WarmItems)
+		w.stat(fmt.Sprintf("items:%d:number_cold", slab.ID), slab.ColdItems)
+		w.stat(fmt.Sprintf("items:%d:age_hot", slab.ID), seconds(slab.AgeHot))
+		w.stat(fmt.Sprintf("items:%d:age_warm", slab.ID), seconds(slab.AgeWarm))
+		w.stat(fmt.Sprintf("items:%d:age", slab.ID), seconds(slab.Age))
+		w.stat(fmt.Sprintf("items:%d:mem_requested", slab.ID), slab.MemRequested)
+		w.stat(fmt.Sprintf("items:%d:evicted", slab.ID), slab.Evicted)
+		w.stat(fmt.Sprintf("items:%d:evicted_unfetched", slab.ID), slab.EvictedUnfetched)
+		w.stat(fmt.Sprintf("items:%d:evicted_time", slab.ID), seconds(slab.EvictedTime))
+		w.stat(fmt.Sprintf("items:%d:outofmemory", slab.ID), slab.OutOfMemory)
+		w.stat(fmt.Sprintf("items:%d:reclaimed", slab.ID), slab.Reclaimed)
+	}
+	return w.end()
+}
+
+// seconds truncates d to whole seconds, as memcached reports durations
+func seconds(d time.Duration) int64 {
+	return int64(d / time.Second)
+}
+
+// MetaDump calls fn for each live item, in no particular order, so that
+// the server can answer "lru_crawler metadump"
+func (s *Store) MetaDump(ctx context.Context, fn func(server.ItemInfo) error) error {
+	s.mu.Lock()
+	now := s.clock()
+	infos := make([]server.ItemInfo, 0, len(s.items))
+	for _, it := range s.items {
+		if it.expired(now) {
+			continue
+		}
+		infos = append(infos, server.ItemInfo{
+			Key:        it.key,
+			Exptime:    it.exptime,
+			LastAccess: it.lastAccess,
+			Cas:        it.cas,
+			Fetched:    it.fetched,
+			Class:      it.class.id,
+			Size:       it.size(),
+		})
+	}
+	s.mu.Unlock()
+
+	for _, info := range infos {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
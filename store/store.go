@@ -0,0 +1,600 @@
+// Package store implements an in-memory memcached backend, meant to
+// be plugged into a server.Server:
+//
+//	srv := server.New(store.New())
+//	err := srv.ListenAndServe("127.0.0.1:11211")
+//
+// Items follow the semantics of memcached: exptimes of up to 30 days
+// are relative to now and larger ones are unix timestamps, every
+// modification generates a new CAS value unless one is given with the
+// E flag, and items can be invalidated with md I so that a single
+// client is told to recache them (W), while the others are told that
+// the item is stale (X) or that the token was already sent (Z).
+//
+// Memory is managed like memcached's slab allocator: items are accounted
+// for their size plus a fixed overhead, and are stored in the chunks of
+// the smallest slab class that fits them. Classes are given memory one
+// page at a time, and each class keeps its items in a segmented LRU:
+// new items enter HOT, items that are fetched again are moved to WARM,
+// and everything else flows down to COLD. By default a Store grows
+// without bounds. When a memory limit is set with SetMemoryLimit and no
+// more pages can be given to a class, the least recently used items in
+// COLD are evicted to make room.
+//
+// Only the meta commands are implemented natively. The classic commands
+// are translated into meta commands using the translate package.
+package store
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lestrrat-go/memdproto"
+	"github.com/lestrrat-go/memdproto/server"
+	"github.com/lestrrat-go/memdproto/translate"
+)
+
+// Store is an in-memory memcached backend. It is safe for concurrent use.
+type Store struct {
+	mu       sync.Mutex
+	items    map[string]*item
+	classes  []*slabClass
+	factor   float64
+	pageSize int64
+	pages    int64
+	limit    int64
+	cas      uint64
+	clock    func() time.Time
+	version  string
+
+	totalItems uint64
+}
+
+var _ server.Handler = (*Store)(nil)
+var _ server.StatsProvider = (*Store)(nil)
+var _ server.MetaDumper = (*Store)(nil)
+
+var errOutOfMemory = memdproto.NewServerErrorReply(`out of memory storing object`)
+
+// New creates a new empty Store
+func New() *Store {
+	return &Store{
+		items:    make(map[string]*item),
+		classes:  newSlabClasses(DefaultGrowthFactor, DefaultItemSizeMax),
+		factor:   DefaultGrowthFactor,
+		pageSize: DefaultItemSizeMax,
+		clock:    time.Now,
+		version:  "memdproto",
+	}
+}
+
+// SetClock sets the function used to tell the current time, which
+// is time.Now by default. This allows tests to expire items without
+// having to sleep.
+func (s *Store) SetClock(clock func() time.Time) *Store {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clock = clock
+	return s
+}
+
+// SetMemoryLimit sets the number of bytes that the pages of the slab
+// classes may use, after which the least recently used items of a class
+// are evicted to make room in that class. A limit of 0, which is the
+// default, means that the store grows without bounds.
+func (s *Store) SetMemoryLimit(limit int64) *Store {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.limit = limit
+	return s
+}
+
+// SetGrowthFactor sets the factor by which the chunk size of each slab
+// class grows from one class to the next, like memcached's -f option.
+// It must be greater than 1. Changing it removes all items.
+func (s *Store) SetGrowthFactor(factor float64) *Store {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.factor = factor
+	s.resetClasses()
+	return s
+}
+
+// SetItemSizeMax sets the size of the largest item that can be stored,
+// which is also the size of the slab pages, like memcached's -I option.
+// Changing it removes all items.
+func (s *Store) SetItemSizeMax(size int64) *Store {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pageSize = size
+	s.resetClasses()
+	return s
+}
+
+// resetClasses removes all items, and recreates the slab classes.
+// s.mu must be held.
+func (s *Store) resetClasses() {
+	s.items = make(map[string]*item)
+	s.classes = newSlabClasses(s.factor, s.pageSize)
+	s.pages = 0
+}
+
+// SetVersion sets the version reported by the version command
+func (s *Store) SetVersion(version string) *Store {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version = version
+	return s
+}
+
+// Flush removes all items from the store. As in memcached, the pages
+// that were given to the slab classes are kept.
+func (s *Store) Flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flush()
+}
+
+// flush removes all items. s.mu must be held.
+func (s *Store) flush() {
+	s.items = make(map[string]*item)
+	for _, c := range s.classes {
+		c.lru = newLRU()
+		c.used = 0
+	}
+}
+
+// lookup returns the item associated with key, removing it if it
+// has expired. s.mu must be held.
+func (s *Store) lookup(key string, now time.Time) *item {
+	it, ok := s.items[key]
+	if !ok {
+		return nil
+	}
+	if it.expired(now) {
+		s.remove(it)
+		return nil
+	}
+	return it
+}
+
+// remove unlinks it from the store, and frees its chunks. s.mu must be held.
+func (s *Store) remove(it *item) {
+	delete(s.items, it.key)
+	it.class.used -= it.class.chunks(it.size())
+	it.class.lru.unlink(it)
+}
+
+// bump records an access to it. As in memcached, the first access only
+// marks the item as fetched. Further accesses mark it as active, which
+// keeps it out of COLD, or move it back to WARM if it is already there.
+// s.mu must be held.
+func (s *Store) bump(it *item, now time.Time) {
+	it.lastAccess = now
+	if !it.fetched {
+		it.fetched = true
+		return
+	}
+	if it.lru != segmentCold {
+		it.active = true
+		return
+	}
+	it.class.lru.move(it, segmentWarm)
+	it.class.lru.balance(it.class.capacity())
+}
+
+// nextCas returns the CAS value for an item that is being modified:
+// the value given by the client with the E flag if any, or a new
+// unique value otherwise. s.mu must be held.
+func (s *Store) nextCas(explicit func() (uint64, bool)) uint64 {
+	if cas, ok := explicit(); ok {
+		return cas
+	}
+	s.cas++
+	return s.cas
+}
+
+// store creates or replaces the item associated with key, evicting other
+// items from its slab class if needed. If the item can not be stored,
+// the previous item is removed nonetheless, as memcached does.
+// s.mu must be held.
+func (s *Store) store(key string, value []byte, flags uint32, exptime time.Time, cas uint64, now time.Time) (*item, error) {
+	if old, ok := s.items[key]; ok {
+		s.remove(old)
+	}
+
+	it := &item{
+		key:        key,
+		value:      append([]byte{}, value...),
+		flags:      flags,
+		cas:        cas,
+		exptime:    exptime,
+		lastAccess: now,
+	}
+	size := it.size()
+	if size > s.pageSize {
+		return nil, memdproto.NewServerErrorReply(`object too large for cache`)
+	}
+
+	c := s.classFor(size)
+	if err := s.alloc(c, size, now); err != nil {
+		return nil, err
+	}
+
+	s.items[key] = it
+	it.class = c
+	c.lru.link(it, segmentHot)
+	c.lru.balance(c.capacity())
+	s.totalItems++
+	return it, nil
+}
+
+func (s *Store) MetaGet(_ context.Context, cmd *memdproto.MetaGetCmd) (memdproto.Reply, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.clock()
+
+	reply := memdproto.NewMetaGetReply().SetOpaque(cmd.Opaque())
+	if cmd.RetrieveKey() {
+		reply.SetKey(cmd.Key(), cmd.KeyAsBase64())
+	}
+
+	var created bool
+	it := s.lookup(cmd.Key(), now)
+	if it == nil {
+		ttl, ok := cmd.VivifyOnMiss()
+		if !ok {
+			return reply.SetMiss(true), nil
+		}
+		var err error
+		if it, err = s.store(cmd.Key(), nil, 0, expiry(now, int64(ttl)), s.nextCas(cmd.NewCas), now); err != nil {
+			return nil, err
+		}
+		created = true
+	}
+
+	if ttl, ok := cmd.UpdateTTL(); ok {
+		it.exptime = expiry(now, ttl)
+	}
+
+	// the client that gets to recache the item is told that it won,
+	// and everybody else is told that somebody else has already won
+	won := created
+	if !won && !it.tokenSent {
+		if it.stale {
+			won = true
+		} else if ttl, ok := cmd.Recache(); ok && !it.exptime.IsZero() && it.remainingTTL(now) < int64(ttl) {
+			won = true
+		}
+	}
+	if won {
+		it.tokenSent = true
+		reply.SetRecacheResult(true)
+	} else if it.tokenSent {
+		reply.SetRecacheResult(false)
+	}
+	reply.SetStale(it.stale)
+
+	if cmd.RetrieveValue() {
+		reply.SetValue(append([]byte{}, it.value...))
+	}
+	if cmd.RetrieveCas() {
+		reply.SetCas(it.cas)
+	}
+	if cmd.RetrieveClientFlags() {
+		reply.SetClientFlags(it.flags)
+	}
+	if cmd.RetrievePreviousHit() {
+		reply.SetPreviousHit(it.fetched)
+	}
+	if cmd.RetrieveTimeSinceLastAccess() {
+		reply.SetTimeSinceLastAccess(uint64(now.Sub(it.lastAccess) / time.Second))
+	}
+	if cmd.RetrieveSize() {
+		reply.SetItemSize(uint64(len(it.value)))
+	}
+	if cmd.RetrieveRemainingTTL() {
+		reply.SetRemainingTTL(it.remainingTTL(now))
+	}
+
+	if !cmd.SkipLRUBump() {
+		s.bump(it, now)
+	}
+	return reply, nil
+}
+
+func (s *Store) MetaSet(_ context.Context, cmd *memdproto.MetaSetCmd) (memdproto.Reply, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.clock()
+
+	reply := memdproto.NewMetaSetReply().SetOpaque(cmd.Opaque())
+	if cmd.RetrieveKey() {
+		reply.SetKey(cmd.Key(), cmd.KeyAsBase64())
+	}
+
+	var exptime time.Time
+	if ttl, ok := cmd.TTL(); ok {
+		exptime = expiry(now, ttl)
+	}
+	flags, _ := cmd.ClientFlags()
+
+	var stale bool
+	it := s.lookup(cmd.Key(), now)
+	if cas, ok := cmd.CompareCas(); ok {
+		switch {
+		case it == nil:
+			return reply.SetStatus(memdproto.MetaSetCmdStatusNotFound), nil
+		case cas == it.cas:
+		case cmd.InvalidateOnOldCas() && cas < it.cas:
+			// the item is stored, but marked as stale
+			stale = true
+		default:
+			return reply.SetStatus(memdproto.MetaSetCmdStatusExists), nil
+		}
+	}
+
+	value := cmd.Value()
+	switch cmd.Mode() {
+	case memdproto.MetaSetModeAdd:
+		if it != nil {
+			return reply.SetStatus(memdproto.MetaSetCmdStatusNotStored), nil
+		}
+	case memdproto.MetaSetModeReplace:
+		if it == nil {
+			return reply.SetStatus(memdproto.MetaSetCmdStatusNotStored), nil
+		}
+	case memdproto.MetaSetModeAppend, memdproto.MetaSetModePrepend:
+		if it == nil {
+			return reply.SetStatus(memdproto.MetaSetCmdStatusNotStored), nil
+		}
+		// appending keeps the flags and the expiration time of the item
+		flags, exptime = it.flags, it.exptime
+		if cmd.Mode() == memdproto.MetaSetModeAppend {
+			value = append(append([]byte{}, it.value...), value...)
+		} else {
+			value = append(append([]byte{}, value...), it.value...)
+		}
+	}
+
+	it, err := s.store(cmd.Key(), value, flags, exptime, s.nextCas(cmd.NewCas), now)
+	if err != nil {
+		return nil, err
+	}
+	it.stale = stale
+	if cmd.RetrieveCas() {
+		reply.SetCas(it.cas)
+	}
+	return reply.SetStatus(memdproto.MetaSetCmdStatusStored), nil
+}
+
+func (s *Store) MetaDelete(_ context.Context, cmd *memdproto.MetaDeleteCmd) (memdproto.Reply, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.clock()
+
+	reply := memdproto.NewMetaDeleteReply().SetOpaque(cmd.Opaque())
+	if cmd.RetrieveKey() {
+		reply.SetKey(cmd.Key(), cmd.KeyAsBase64())
+	}
+
+	it := s.lookup(cmd.Key(), now)
+	if it == nil {
+		return reply.SetStatus(memdproto.MetaDeleteCmdStatusNotFound), nil
+	}
+	if cas, ok := cmd.CompareCas(); ok && cas != it.cas {
+		return reply.SetStatus(memdproto.MetaDeleteCmdStatusExists), nil
+	}
+
+	if cmd.InvalidateOnOldCas() {
+		// the item is kept around as stale, with a new CAS value, so
+		// that a single client can be told to recache it
+		it.stale = true
+		it.tokenSent = false
+		it.cas = s.nextCas(cmd.NewCas)
+		if ttl, ok := cmd.UpdateTTL(); ok {
+			it.exptime = expiry(now, int64(ttl))
+		}
+		return reply.SetStatus(memdproto.MetaDeleteCmdStatusDeleted), nil
+	}
+
+	s.remove(it)
+	return reply.SetStatus(memdproto.MetaDeleteCmdStatusDeleted), nil
+}
+
+func (s *Store) MetaArithmetic(_ context.Context, cmd *memdproto.MetaArithmeticCmd) (memdproto.Reply, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.clock()
+
+	reply := memdproto.NewMetaArithmeticReply().SetOpaque(cmd.Opaque())
+	if cmd.RetrieveKey() {
+		reply.SetKey(cmd.Key(), cmd.KeyAsBase64())
+	}
+
+	it := s.lookup(cmd.Key(), now)
+	if it == nil {
+		ttl, ok := cmd.VivifyOnMiss()
+		if !ok {
+			return reply.SetStatus(memdproto.MetaArithmeticCmdStatusNotFound), nil
+		}
+		// a new item is created with the initial value, without
+		// applying the delta
+		value := strconv.FormatUint(cmd.InitialValue(), 10)
+		var err error
+		if it, err = s.store(cmd.Key(), []byte(value), 0, expiry(now, int64(ttl)), s.nextCas(cmd.NewCas), now); err != nil {
+			return nil, err
+		}
+	} else {
+		if cas, ok := cmd.CompareCas(); ok && cas != it.cas {
+			return reply.SetStatus(memdproto.MetaArithmeticCmdStatusExists), nil
+		}
+
+		n, ok := applyDelta(it.value, cmd.Delta(), cmd.Mode())
+		if !ok {
+			return nil, memdproto.NewClientErrorReply(`cannot increment or decrement non-numeric value`)
+		}
+		// the size of the item may change, so it is stored anew
+		var err error
+		value := strconv.FormatUint(n, 10)
+		if it, err = s.store(cmd.Key(), []byte(value), it.flags, it.exptime, s.nextCas(cmd.NewCas), now); err != nil {
+			return nil, err
+		}
+	}
+
+	if ttl, ok := cmd.UpdateTTL(); ok {
+		it.exptime = expiry(now, ttl)
+	}
+
+	if cmd.RetrieveValue() {
+		reply.SetValue(append([]byte{}, it.value...))
+	}
+	if cmd.RetrieveCas() {
+		reply.SetCas(it.cas)
+	}
+	if cmd.RetrieveRemainingTTL() {
+		reply.SetRemainingTTL(it.remainingTTL(now))
+	}
+	return reply.SetStatus(memdproto.MetaArithmeticCmdStatusSuccess), nil
+}
+
+func (s *Store) MetaNoop(context.Context, *memdproto.MetaNoopCmd) (memdproto.Reply, error) {
+	return memdproto.NewMetaNoopReply(), nil
+}
+
+// classic handles a classic command by translating it into meta commands
+func (s *Store) classic(ctx context.Context, cmd memdproto.Cmd) (memdproto.Reply, error) {
+	tr, err := translate.Command(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	replies := make([]memdproto.Reply, len(tr.Meta()))
+	for i, mcmd := range tr.Meta() {
+		reply, err := server.Dispatch(ctx, s, mcmd)
+		if err != nil {
+			return nil, err
+		}
+		replies[i] = reply
+	}
+	return tr.Reply(replies...)
+}
+
+func (s *Store) Get(ctx context.Context, cmd *memdproto.GetCmd) (memdproto.Reply, error) {
+	return s.classic(ctx, cmd)
+}
+
+func (s *Store) Gat(ctx context.Context, cmd *memdproto.GatCmd) (memdproto.Reply, error) {
+	return s.classic(ctx, cmd)
+}
+
+func (s *Store) Set(ctx context.Context, cmd *memdproto.SetCmd) (memdproto.Reply, error) {
+	return s.classic(ctx, cmd)
+}
+
+func (s *Store) Add(ctx context.Context, cmd *memdproto.AddCmd) (memdproto.Reply, error) {
+	return s.classic(ctx, cmd)
+}
+
+func (s *Store) Replace(ctx context.Context, cmd *memdproto.ReplaceCmd) (memdproto.Reply, error) {
+	return s.classic(ctx, cmd)
+}
+
+func (s *Store) Append(ctx context.Context, cmd *memdproto.AppendCmd) (memdproto.Reply, error) {
+	return s.classic(ctx, cmd)
+}
+
+func (s *Store) Prepend(ctx context.Context, cmd *memdproto.PrependCmd) (memdproto.Reply, error) {
+	return s.classic(ctx, cmd)
+}
+
+func (s *Store) Cas(ctx context.Context, cmd *memdproto.CasCmd) (memdproto.Reply, error) {
+	return s.classic(ctx, cmd)
+}
+
+func (s *Store) Delete(ctx context.Context, cmd *memdproto.DeleteCmd) (memdproto.Reply, error) {
+	return s.classic(ctx, cmd)
+}
+
+func (s *Store) Incr(ctx context.Context, cmd *memdproto.IncrCmd) (memdproto.Reply, error) {
+	return s.classic(ctx, cmd)
+}
+
+func (s *Store) Decr(ctx context.Context, cmd *memdproto.DecrCmd) (memdproto.Reply, error) {
+	return s.classic(ctx, cmd)
+}
+
+func (s *Store) Touch(ctx context.Context, cmd *memdproto.TouchCmd) (memdproto.Reply, error) {
+	return s.classic(ctx, cmd)
+}
+
+// Fallback handles the stats (including stats settings, stats slabs and
+// stats items), version, verbosity and flush_all commands. When the store
+// is served by a server.Server, the latter answers stats and stats
+// settings itself, and adds the fields returned by StatFields.
+// flush_all accepts an optional delay in seconds, after which the
+// items that exist at the time of the command expire.
+func (s *Store) Fallback(ctx context.Context, cmd memdproto.Cmd) (memdproto.Reply, error) {
+	reply := memdproto.NewRawReply(nil)
+	switch cmd.Verb() {
+	case "stats":
+		var args []string
+		if raw, ok := cmd.(*memdproto.RawCmd); ok {
+			args = strings.Fields(string(raw.Line()))[1:]
+		}
+		switch {
+		case len(args) == 0:
+			return reply.SetBytes(encodeStats(s.StatFields(ctx, ""))), nil
+		case len(args) == 1 && args[0] == "settings":
+			return reply.SetBytes(encodeStats(s.StatFields(ctx, "settings"))), nil
+		case len(args) == 1 && args[0] == "slabs":
+			return reply.SetBytes(encodeSlabs(s.Stats(), s.Slabs())), nil
+		case len(args) == 1 && args[0] == "items":
+			return reply.SetBytes(encodeItems(s.Slabs())), nil
+		default:
+			return nil, memdproto.NewErrorReply()
+		}
+	case "version":
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return reply.SetBytes([]byte("VERSION " + s.version + "\r\n")), nil
+	case "verbosity":
+		return reply.SetBytes([]byte("OK\r\n")), nil
+	case "flush_all":
+		var delay int64
+		if raw, ok := cmd.(*memdproto.RawCmd); ok {
+			if fields := strings.Fields(string(raw.Line())); len(fields) > 1 && fields[1] != "noreply" {
+				v, err := strconv.ParseInt(fields[1], 10, 64)
+				if err != nil {
+					return nil, memdproto.NewClientErrorReply(`invalid exptime argument`)
+				}
+				delay = v
+			}
+		}
+		s.flushAfter(delay)
+		return reply.SetBytes([]byte("OK\r\n")), nil
+	default:
+		return nil, memdproto.NewErrorReply()
+	}
+}
+
+// flushAfter expires all current items after delay seconds, or right
+// away if delay is not positive
+func (s *Store) flushAfter(delay int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if delay <= 0 {
+		s.flush()
+		return
+	}
+	exptime := expiry(s.clock(), delay)
+	for _, it := range s.items {
+		if it.exptime.IsZero() || it.exptime.After(exptime) {
+			it.exptime = exptime
+		}
+	}
+}
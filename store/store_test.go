@@ -0,0 +1,320 @@
+package store_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/memdproto"
+	"github.com/lestrrat-go/memdproto/server"
+	"github.com/lestrrat-go/memdproto/store"
+	"github.com/stretchr/testify/require"
+)
+
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	return c.now
+}
+
+type exchange struct {
+	Cmd   string
+	Reply string
+}
+
+// run sends each command to s, and compares the encoded replies
+func run(t *testing.T, s *store.Store, exchanges []exchange) {
+	t.Helper()
+	for _, x := range exchanges {
+		cmd, err := memdproto.NewCmdReader(strings.NewReader(x.Cmd)).ReadCmd()
+		require.NoError(t, err, "ReadCmd should succeed (%q)", x.Cmd)
+
+		reply, err := server.Dispatch(context.Background(), s, cmd)
+		if err != nil {
+			var ereply *memdproto.ErrorReply
+			require.True(t, errors.As(err, &ereply), "error should be an error reply (%q, got %v)", x.Cmd, err)
+			reply = ereply
+		}
+
+		var buf bytes.Buffer
+		if reply != nil {
+			_, err = reply.WriteTo(&buf)
+			require.NoError(t, err, "reply.WriteTo should succeed (%q)", x.Cmd)
+		}
+		require.Equal(t, x.Reply, buf.String(), "reply to %q should match", x.Cmd)
+	}
+}
+
+func newStore() (*store.Store, *clock) {
+	c := &clock{now: time.Unix(1700000000, 0)}
+	return store.New().SetClock(c.Now), c
+}
+
+func TestStore(t *testing.T) {
+	t.Run("exptime", func(t *testing.T) {
+		s, c := newStore()
+		absolute := c.now.Add(100 * time.Second).Unix()
+		run(t, s, []exchange{
+			{Cmd: "ms rel 1 T10\r\nx\r\n", Reply: "HD\r\n"},
+			{Cmd: "set abs 0 " + strconv.FormatInt(absolute, 10) + " 1\r\nx\r\n", Reply: "STORED\r\n"},
+			{Cmd: "set past 0 1000 1\r\nx\r\n", Reply: "STORED\r\n"},
+			{Cmd: "ms neg 1 T-1\r\nx\r\n", Reply: "HD\r\n"},
+			{Cmd: "mg rel t\r\n", Reply: "HD t10\r\n"},
+			{Cmd: "mg abs t\r\n", Reply: "HD t100\r\n"},
+			{Cmd: "mg neg v\r\n", Reply: "EN\r\n"},
+		})
+
+		c.now = c.now.Add(10 * time.Second)
+		run(t, s, []exchange{
+			{Cmd: "mg rel v\r\n", Reply: "EN\r\n"},
+			{Cmd: "mg abs t\r\n", Reply: "HD t90\r\n"},
+			{Cmd: "mg past t\r\n", Reply: "HD t990\r\n"},
+		})
+
+		c.now = c.now.Add(90 * time.Second)
+		run(t, s, []exchange{
+			{Cmd: "get abs\r\n", Reply: "END\r\n"},
+		})
+	})
+
+	t.Run("cas", func(t *testing.T) {
+		s, _ := newStore()
+		run(t, s, []exchange{
+			{Cmd: "ms foo 1 c\r\nx\r\n", Reply: "HD c1\r\n"},
+			{Cmd: "ms foo 1 c E100\r\ny\r\n", Reply: "HD c100\r\n"},
+			{Cmd: "ms foo 1 C1\r\nz\r\n", Reply: "EX\r\n"},
+			{Cmd: "ms foo 1 C100 c\r\nz\r\n", Reply: "HD c2\r\n"},
+			{Cmd: "ma cnt N0 J5 E7 c v\r\n", Reply: "VA 1 c7\r\n5\r\n"},
+			{Cmd: "ma cnt C7 E8 c v\r\n", Reply: "VA 1 c8\r\n6\r\n"},
+			{Cmd: "mg new N0 E50 c\r\n", Reply: "HD c50 W\r\n"},
+			{Cmd: "gets foo\r\n", Reply: "VALUE foo 0 1 2\r\nz\r\nEND\r\n"},
+		})
+	})
+
+	t.Run("flags", func(t *testing.T) {
+		s, _ := newStore()
+		run(t, s, []exchange{
+			{Cmd: "set foo 4294967295 0 3\r\nbar\r\n", Reply: "STORED\r\n"},
+			{Cmd: "mg foo f\r\n", Reply: "HD f4294967295\r\n"},
+			{Cmd: "append foo 1 0 1\r\n!\r\n", Reply: "STORED\r\n"},
+			{Cmd: "prepend foo 2 0 1\r\n>\r\n", Reply: "STORED\r\n"},
+			{Cmd: "get foo\r\n", Reply: "VALUE foo 4294967295 5\r\n>bar!\r\nEND\r\n"},
+			{Cmd: "append nope 0 0 1\r\nx\r\n", Reply: "NOT_STORED\r\n"},
+		})
+	})
+
+	t.Run("recache", func(t *testing.T) {
+		s, c := newStore()
+		run(t, s, []exchange{
+			{Cmd: "mg foo N30 c v\r\n", Reply: "VA 0 c1 W\r\n\r\n"},
+			{Cmd: "mg foo N30 v\r\n", Reply: "VA 0 Z\r\n\r\n"},
+			{Cmd: "ms foo 3 T30\r\nbar\r\n", Reply: "HD\r\n"},
+			{Cmd: "mg foo R10 v\r\n", Reply: "VA 3\r\nbar\r\n"},
+		})
+
+		c.now = c.now.Add(25 * time.Second)
+		run(t, s, []exchange{
+			{Cmd: "mg foo R10 v\r\n", Reply: "VA 3 W\r\nbar\r\n"},
+			{Cmd: "mg foo R10 v\r\n", Reply: "VA 3 Z\r\nbar\r\n"},
+		})
+	})
+
+	t.Run("invalidate", func(t *testing.T) {
+		s, _ := newStore()
+		run(t, s, []exchange{
+			{Cmd: "ms foo 3 c\r\nbar\r\n", Reply: "HD c1\r\n"},
+			{Cmd: "md foo I T30 E10\r\n", Reply: "HD\r\n"},
+			{Cmd: "mg foo c t v\r\n", Reply: "VA 3 c10 t30 W X\r\nbar\r\n"},
+			{Cmd: "mg foo v\r\n", Reply: "VA 3 Z X\r\nbar\r\n"},
+			{Cmd: "ms foo 3 I C9 c\r\nold\r\n", Reply: "HD c2\r\n"},
+			{Cmd: "mg foo v\r\n", Reply: "VA 3 W X\r\nold\r\n"},
+			{Cmd: "ms foo 3 c\r\nnew\r\n", Reply: "HD c3\r\n"},
+			{Cmd: "mg foo v\r\n", Reply: "VA 3\r\nnew\r\n"},
+		})
+	})
+
+	t.Run("arithmetic", func(t *testing.T) {
+		s, _ := newStore()
+		run(t, s, []exchange{
+			{Cmd: "ma cnt\r\n", Reply: "NF\r\n"},
+			{Cmd: "ma cnt N0 J18446744073709551614 v\r\n", Reply: "VA 20\r\n18446744073709551614\r\n"},
+			{Cmd: "ma cnt D3 v\r\n", Reply: "VA 1\r\n1\r\n"},
+			{Cmd: "ma cnt MD D10 v\r\n", Reply: "VA 1\r\n0\r\n"},
+			{Cmd: "decr cnt 1\r\n", Reply: "0\r\n"},
+			{Cmd: "ms str 3\r\nabc\r\n", Reply: "HD\r\n"},
+			{Cmd: "ma str\r\n", Reply: "CLIENT_ERROR cannot increment or decrement non-numeric value\r\n"},
+			{Cmd: "incr str 1\r\n", Reply: "CLIENT_ERROR cannot increment or decrement non-numeric value\r\n"},
+		})
+	})
+
+	t.Run("raw", func(t *testing.T) {
+		s, c := newStore()
+		run(t, s, []exchange{
+			{Cmd: "version\r\n", Reply: "VERSION memdproto\r\n"},
+			{Cmd: "verbosity 1\r\n", Reply: "OK\r\n"},
+			{Cmd: "ms foo 1\r\nx\r\n", Reply: "HD\r\n"},
+			{Cmd: "flush_all\r\n", Reply: "OK\r\n"},
+			{Cmd: "mg foo v\r\n", Reply: "EN\r\n"},
+			{Cmd: "ms foo 1\r\nx\r\n", Reply: "HD\r\n"},
+			{Cmd: "flush_all 10\r\n", Reply: "OK\r\n"},
+			{Cmd: "mg foo t\r\n", Reply: "HD t10\r\n"},
+			{Cmd: "bogus\r\n", Reply: "ERROR\r\n"},
+		})
+
+		c.now = c.now.Add(10 * time.Second)
+		run(t, s, []exchange{
+			{Cmd: "mg foo v\r\n", Reply: "EN\r\n"},
+		})
+	})
+}
+
+func TestEviction(t *testing.T) {
+	// each item takes 72 bytes: 56 bytes of overhead, a 3 byte key
+	// plus NUL, and a 10 byte value plus CRLF. They go in the first
+	// slab class, whose 96 byte chunks fit 10 to a 1KB page.
+	const itemSize = 72
+	s, _ := newStore()
+	s.SetItemSizeMax(1024).SetMemoryLimit(1024)
+
+	set := func(key string) {
+		run(t, s, []exchange{{Cmd: "ms " + key + " 10 q\r\n0123456789\r\n", Reply: "HD\r\n"}})
+	}
+	for i := 0; i < 10; i++ {
+		set(fmt.Sprintf("k%02d", i))
+	}
+	require.Equal(t, uint64(0), s.Stats().Evictions, "no items should be evicted while under the limit")
+
+	// k00 has been pushed to COLD, and is rescued into WARM when
+	// it is fetched a second time. The u flag does not count.
+	run(t, s, []exchange{
+		{Cmd: "mg k00 u\r\n", Reply: "HD\r\n"},
+		{Cmd: "mg k00\r\n", Reply: "HD\r\n"},
+		{Cmd: "mg k00\r\n", Reply: "HD\r\n"},
+	})
+	for i := 10; i < 15; i++ {
+		set(fmt.Sprintf("k%02d", i))
+	}
+
+	run(t, s, []exchange{
+		{Cmd: "mg k00 v\r\n", Reply: "VA 10\r\n0123456789\r\n"},
+		{Cmd: "mg k01 v\r\n", Reply: "EN\r\n"},
+		{Cmd: "mg k05 v\r\n", Reply: "EN\r\n"},
+		{Cmd: "mg k06 v\r\n", Reply: "VA 10\r\n0123456789\r\n"},
+		{Cmd: "ms big 1000\r\n" + strings.Repeat("x", 1000) + "\r\n", Reply: "SERVER_ERROR object too large for cache\r\n"},
+	})
+
+	stats := s.Stats()
+	require.Equal(t, uint64(5), stats.Evictions, "evictions should match")
+	require.Equal(t, uint64(5), stats.EvictedUnfetched, "unfetched evictions should match")
+	require.Equal(t, uint64(10), stats.CurrItems, "current items should match")
+	require.Equal(t, uint64(15), stats.TotalItems, "total items should match")
+	require.Equal(t, int64(10*itemSize), stats.Bytes, "bytes should match")
+	require.Equal(t, uint64(10), stats.HotItems+stats.WarmItems+stats.ColdItems, "all items should be in the LRU")
+	require.Equal(t, uint64(1), stats.WarmItems, "k00 should be in WARM")
+
+	run(t, s, []exchange{
+		{Cmd: "stats\r\n", Reply: "STAT curr_items 10\r\nSTAT total_items 15\r\nSTAT bytes 720\r\nSTAT limit_maxbytes 1024\r\nSTAT evictions 5\r\nSTAT evicted_unfetched 5\r\nSTAT reclaimed 0\r\nEND\r\n"},
+	})
+}
+
+func TestAccess(t *testing.T) {
+	s, c := newStore()
+	run(t, s, []exchange{
+		{Cmd: "ms foo 1\r\nx\r\n", Reply: "HD\r\n"},
+	})
+	c.now = c.now.Add(5 * time.Second)
+	run(t, s, []exchange{
+		{Cmd: "mg foo h l u\r\n", Reply: "HD h0 l5\r\n"},
+	})
+	c.now = c.now.Add(5 * time.Second)
+	run(t, s, []exchange{
+		{Cmd: "mg foo h l\r\n", Reply: "HD h0 l10\r\n"},
+		{Cmd: "mg foo h l\r\n", Reply: "HD h1 l0\r\n"},
+	})
+}
+
+func TestSlabs(t *testing.T) {
+	t.Run("classes", func(t *testing.T) {
+		s, _ := newStore()
+		for _, size := range []int{10, 50, 100, 1000, 600000} {
+			value := strings.Repeat("x", size)
+			run(t, s, []exchange{{Cmd: fmt.Sprintf("ms k%d %d\r\n%s\r\n", size, size, value), Reply: "HD\r\n"}})
+		}
+
+		var chunkSizes []int64
+		for _, slab := range s.Slabs() {
+			chunkSizes = append(chunkSizes, slab.ChunkSize)
+			require.Equal(t, int64(1), slab.TotalPages, "class %d should have one page", slab.ID)
+			require.Equal(t, uint64(1), slab.Items, "class %d should have one item", slab.ID)
+		}
+		// the largest item is split across two chunks of the largest class
+		require.Equal(t, []int64{96, 120, 192, 1184, 524288}, chunkSizes, "items should go in the smallest class that fits them")
+		slabs := s.Slabs()
+		require.Equal(t, 39, slabs[len(slabs)-1].ID, "the largest class should match memcached")
+		require.Equal(t, int64(2), slabs[len(slabs)-1].UsedChunks, "the largest item should use two chunks")
+		require.Equal(t, int64(5*store.DefaultItemSizeMax), s.Stats().TotalMalloced, "each class should have been given a page")
+	})
+
+	t.Run("growth factor", func(t *testing.T) {
+		s, _ := newStore()
+		s.SetGrowthFactor(2).SetItemSizeMax(4096)
+		run(t, s, []exchange{
+			{Cmd: "ms foo 100\r\n" + strings.Repeat("x", 100) + "\r\n", Reply: "HD\r\n"},
+			{Cmd: "stats slabs\r\n", Reply: "STAT 2:chunk_size 192\r\nSTAT 2:chunks_per_page 21\r\nSTAT 2:total_pages 1\r\nSTAT 2:total_chunks 21\r\nSTAT 2:used_chunks 1\r\nSTAT 2:free_chunks 20\r\nSTAT 2:mem_requested 162\r\nSTAT active_slabs 1\r\nSTAT total_malloced 4096\r\nEND\r\n"},
+		})
+
+		settings := s.StatFields(context.Background(), "settings")
+		require.Contains(t, settings, server.Stat{Name: "growth_factor", Value: "2.00"}, "growth_factor should be reported")
+		require.Contains(t, settings, server.Stat{Name: "item_size_max", Value: int64(4096)}, "item_size_max should be reported")
+	})
+
+	t.Run("items", func(t *testing.T) {
+		s, c := newStore()
+		s.SetItemSizeMax(1024).SetMemoryLimit(1024)
+		for i := 0; i < 12; i++ {
+			run(t, s, []exchange{{Cmd: fmt.Sprintf("ms k%02d 10\r\n0123456789\r\n", i), Reply: "HD\r\n"}})
+			c.now = c.now.Add(time.Second)
+		}
+		run(t, s, []exchange{
+			{Cmd: "stats items\r\n", Reply: "STAT items:1:number 10\r\nSTAT items:1:number_hot 2\r\nSTAT items:1:number_warm 0\r\nSTAT items:1:number_cold 8\r\nSTAT items:1:age_hot 2\r\nSTAT items:1:age_warm 0\r\nSTAT items:1:age 10\r\nSTAT items:1:mem_requested 720\r\nSTAT items:1:evicted 2\r\nSTAT items:1:evicted_unfetched 2\r\nSTAT items:1:evicted_time 10\r\nSTAT items:1:outofmemory 0\r\nSTAT items:1:reclaimed 0\r\nEND\r\n"},
+			{Cmd: "stats bogus\r\n", Reply: "ERROR\r\n"},
+		})
+	})
+}
+
+func TestMetaDump(t *testing.T) {
+	s, c := newStore()
+	run(t, s, []exchange{
+		{Cmd: "ms foo 3\r\nbar\r\n", Reply: "HD\r\n"},
+		{Cmd: "ms bar 3 T60\r\nbaz\r\n", Reply: "HD\r\n"},
+		{Cmd: "ms gone 3 T1\r\nbaz\r\n", Reply: "HD\r\n"},
+		{Cmd: "mg foo v\r\n", Reply: "VA 3\r\nbar\r\n"},
+	})
+	c.now = c.now.Add(2 * time.Second)
+
+	var infos []server.ItemInfo
+	err := s.MetaDump(context.Background(), func(info server.ItemInfo) error {
+		infos = append(infos, info)
+		return nil
+	})
+	require.NoError(t, err, "s.MetaDump should succeed")
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Key < infos[j].Key })
+
+	la := time.Unix(1700000000, 0)
+	require.Equal(t, []server.ItemInfo{
+		{Key: "bar", Exptime: la.Add(time.Minute), LastAccess: la, Cas: 2, Class: 1, Size: 65},
+		{Key: "foo", LastAccess: la, Cas: 1, Fetched: true, Class: 1, Size: 65},
+	}, infos, "live items should be listed")
+
+	errStop := errors.New("stop")
+	err = s.MetaDump(context.Background(), func(server.ItemInfo) error { return errStop })
+	require.ErrorIs(t, err, errStop, "s.MetaDump should return the error from fn")
+}
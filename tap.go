@@ -0,0 +1,304 @@
+package memdproto
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// TapSide specifies which end of a connection a Tap is attached to.
+type TapSide uint8
+
+const (
+	// TapClientSide is used for connections created by a client: bytes
+	// written to the connection are commands, and bytes read from it
+	// are replies.
+	TapClientSide TapSide = iota
+	// TapServerSide is used for connections accepted by a server: bytes
+	// read from the connection are commands, and bytes written to it
+	// are replies.
+	TapServerSide
+)
+
+// TapTransaction is a command observed by a Tap, along with its reply.
+type TapTransaction struct {
+	// Cmd is the decoded command. It is nil if the command could not
+	// be decoded, in which case Err is set.
+	Cmd Cmd
+	// Reply is the decoded reply. It is nil if the server did not send
+	// one because of quiet or noreply mode, or if it could not be decoded.
+	Reply Reply
+	// Err is set if the command or the reply could not be decoded, or if
+	// the connection was closed before the reply was seen. Error replies
+	// from the server are reported both as Reply and as Err.
+	Err error
+	// Start is the time at which the command was seen
+	Start time.Time
+	// End is the time at which the reply was seen, or the time at which
+	// it was determined that there would be no reply
+	End time.Time
+}
+
+// Duration returns the time elapsed between the command and its reply
+func (tx *TapTransaction) Duration() time.Duration {
+	return tx.End.Sub(tx.Start)
+}
+
+// TapFunc is called by a Tap for each transaction, in order.
+type TapFunc func(*TapTransaction)
+
+// Tap decodes memcached traffic as it flows through a connection,
+// and hands each command, paired with its reply, to a callback.
+//
+// A Tap only ever observes copies of the bytes, so the traffic itself is
+// never altered, delayed or blocked: decoding happens in the background,
+// and data is buffered until the decoder catches up. Commands are decoded
+// in ParseModeLenient, so that anything memcached itself accepts can be
+// seen, and data blocks longer than DefaultMaxDataLength are rejected.
+// Once either direction fails to decode, the rest of that direction is
+// discarded, as it is not possible to find the next command or reply.
+// A command in noreply mode is only reported once the next reply has been
+// seen, or the Tap is closed, as the server may still send an error reply.
+//
+// Use WrapConn to tap a net.Conn, or write the bytes of each direction
+// to Commands and Replies, for example using io.TeeReader.
+type Tap struct {
+	fn      TapFunc
+	cmds    *tapBuffer
+	replies *tapBuffer
+	pending chan tapPending
+	wg      sync.WaitGroup
+	closed  sync.Once
+}
+
+type tapPending struct {
+	cmd   Cmd
+	err   error
+	start time.Time
+}
+
+// NewTap creates a new Tap that calls fn for each transaction. fn is
+// called from a single goroutine, so it does not need to be safe for
+// concurrent use. The Tap must be closed using Close when it is no
+// longer needed.
+func NewTap(fn TapFunc) *Tap {
+	t := &Tap{
+		fn:      fn,
+		cmds:    newTapBuffer(),
+		replies: newTapBuffer(),
+		pending: make(chan tapPending),
+	}
+	t.wg.Add(2)
+	go t.readCmds()
+	go t.readReplies()
+	return t
+}
+
+// Commands returns the writer that command bytes should be written to.
+// Writes always succeed.
+func (t *Tap) Commands() io.Writer {
+	return t.cmds
+}
+
+// Replies returns the writer that reply bytes should be written to.
+// Writes always succeed.
+func (t *Tap) Replies() io.Writer {
+	return t.replies
+}
+
+// Close stops the Tap, after all data written so far has been decoded.
+// Commands that have not received a reply by then are reported with
+// io.ErrUnexpectedEOF.
+func (t *Tap) Close() error {
+	t.closed.Do(func() {
+		t.cmds.Close()
+		t.replies.Close()
+	})
+	t.wg.Wait()
+	return nil
+}
+
+// WrapConn returns a net.Conn that behaves exactly like conn, while
+// copying the bytes that are read and written to the Tap. Closing the
+// returned connection also closes the Tap.
+func (t *Tap) WrapConn(conn net.Conn, side TapSide) net.Conn {
+	tc := &tapConn{
+		Conn: conn,
+		tap:  t,
+	}
+	if side == TapServerSide {
+		tc.rdst, tc.wdst = t.cmds, t.replies
+	} else {
+		tc.rdst, tc.wdst = t.replies, t.cmds
+	}
+	return tc
+}
+
+func (t *Tap) readCmds() {
+	defer t.wg.Done()
+	defer close(t.pending)
+
+	rdr := NewCmdReader(t.cmds).
+		SetParseMode(ParseModeLenient).
+		SetMaxDataLength(DefaultMaxDataLength)
+	for {
+		cmd, err := tapReadCmd(rdr)
+		if err != nil {
+			if err != io.EOF {
+				t.pending <- tapPending{err: err, start: time.Now()}
+			}
+			_, _ = io.Copy(io.Discard, t.cmds)
+			return
+		}
+		t.pending <- tapPending{cmd: cmd, start: time.Now()}
+	}
+}
+
+func (t *Tap) readReplies() {
+	defer t.wg.Done()
+
+	p := NewPipeline(t.replies)
+	var broken error
+	for pending := range t.pending {
+		tx := &TapTransaction{
+			Cmd:   pending.cmd,
+			Err:   pending.err,
+			Start: pending.start,
+		}
+
+		switch {
+		case tx.Err != nil:
+		case broken != nil:
+			tx.Err = broken
+		default:
+			var err error
+			p.Push(pending.cmd)
+			tx.Reply, err = tapReadReply(p)
+			tx.Err = err
+
+			var cerr *CorrelationError
+			var ereply *ErrorReply
+			if err != nil && !errors.As(err, &cerr) && !errors.As(err, &ereply) {
+				// the reply stream can not be decoded any further
+				broken = io.ErrUnexpectedEOF
+				if !errors.Is(err, io.EOF) {
+					broken = err
+				}
+				tx.Err = broken
+				t.wg.Add(1)
+				go func() {
+					defer t.wg.Done()
+					_, _ = io.Copy(io.Discard, t.replies)
+				}()
+			}
+		}
+		tx.End = time.Now()
+		t.fn(tx)
+	}
+}
+
+// tapReadCmd reads the next command, reporting a panic in the decoder
+// as an error, as the Tap must never bring down the process it observes
+func tapReadCmd(rdr *CmdReader) (cmd Cmd, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			cmd, err = nil, fmt.Errorf(`memdproto.Tap: panic while decoding command: %v`, v)
+		}
+	}()
+	return rdr.ReadCmd()
+}
+
+// tapReadReply reads the next reply, reporting a panic in the decoder
+// as an error
+func tapReadReply(p *Pipeline) (reply Reply, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			reply, err = nil, fmt.Errorf(`memdproto.Tap: panic while decoding reply: %v`, v)
+		}
+	}()
+	_, reply, err = p.ReadReply()
+	return reply, err
+}
+
+type tapConn struct {
+	net.Conn
+	tap  *Tap
+	rdst io.Writer
+	wdst io.Writer
+}
+
+func (c *tapConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		_, _ = c.rdst.Write(p[:n])
+	}
+	return n, err
+}
+
+func (c *tapConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		_, _ = c.wdst.Write(p[:n])
+	}
+	return n, err
+}
+
+func (c *tapConn) Close() error {
+	err := c.Conn.Close()
+	c.tap.Close()
+	return err
+}
+
+// tapBuffer is an unbounded buffer that never blocks writers.
+// Reads block until data is available, or the buffer is closed.
+type tapBuffer struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	data   []byte
+	closed bool
+}
+
+func newTapBuffer() *tapBuffer {
+	b := &tapBuffer{}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+func (b *tapBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.closed {
+		b.data = append(b.data, p...)
+		b.cond.Signal()
+	}
+	return len(p), nil
+}
+
+func (b *tapBuffer) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for len(b.data) == 0 {
+		if b.closed {
+			return 0, io.EOF
+		}
+		b.cond.Wait()
+	}
+
+	n := copy(p, b.data)
+	b.data = b.data[n:]
+	if len(b.data) == 0 {
+		// let the underlying array be reclaimed
+		b.data = nil
+	}
+	return n, nil
+}
+
+func (b *tapBuffer) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	b.cond.Broadcast()
+}
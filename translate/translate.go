@@ -0,0 +1,279 @@
+// Package translate maps classic memcached commands onto equivalent
+// meta commands, and the replies to those meta commands back onto
+// classic replies.
+//
+// This allows a backend to implement only the meta protocol while
+// still serving clients that speak the classic protocol:
+//
+//	tr, err := translate.Command(cmd)
+//	...
+//	replies := make([]memdproto.Reply, len(tr.Meta()))
+//	for i, mcmd := range tr.Meta() {
+//	  replies[i] = backend.Do(mcmd)
+//	}
+//	reply, err := tr.Reply(replies...)
+//
+// The following commands are supported:
+//
+//	get/gets           -> one mg per key
+//	gat/gats           -> one mg per key, with the T flag
+//	set/add/replace/
+//	append/prepend/cas -> ms
+//	delete             -> md
+//	incr/decr          -> ma
+//	touch              -> mg with the T flag
+package translate
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/lestrrat-go/memdproto"
+)
+
+// ErrUnsupported is returned by Command when the command can not
+// be translated into meta commands.
+var ErrUnsupported = errors.New("translate: unsupported command")
+
+// Translation holds a classic command, and the meta commands
+// that it was translated into.
+type Translation struct {
+	classic memdproto.Cmd
+	meta    []memdproto.Cmd
+}
+
+// Command translates a classic command into one or more meta commands.
+func Command(cmd memdproto.Cmd) (*Translation, error) {
+	var meta []memdproto.Cmd
+	switch cmd := cmd.(type) {
+	case *memdproto.GetCmd:
+		for _, key := range cmd.Keys() {
+			mcmd := memdproto.NewMetaGetCmd(key).
+				SetRetrieveClientFlags(true).
+				SetRetrieveValue(true).
+				SetRetrieveCas(cmd.Verb() == "gets")
+			meta = append(meta, mcmd)
+		}
+	case *memdproto.GatCmd:
+		for _, key := range cmd.Keys() {
+			mcmd := memdproto.NewMetaGetCmd(key).
+				SetRetrieveClientFlags(true).
+				SetRetrieveValue(true).
+				SetRetrieveCas(cmd.Verb() == "gats").
+				SetUpdateTTL(cmd.Expires())
+			meta = append(meta, mcmd)
+		}
+	case *memdproto.SetCmd:
+		meta = append(meta, metaSet(cmd.Key(), cmd.Value(), cmd.Flags(), cmd.Expires(), cmd.NoReply(), memdproto.MetaSetModeSet))
+	case *memdproto.AddCmd:
+		meta = append(meta, metaSet(cmd.Key(), cmd.Value(), cmd.Flags(), cmd.Expires(), cmd.NoReply(), memdproto.MetaSetModeAdd))
+	case *memdproto.ReplaceCmd:
+		meta = append(meta, metaSet(cmd.Key(), cmd.Value(), cmd.Flags(), cmd.Expires(), cmd.NoReply(), memdproto.MetaSetModeReplace))
+	case *memdproto.AppendCmd:
+		meta = append(meta, metaSet(cmd.Key(), cmd.Value(), cmd.Flags(), cmd.Expires(), cmd.NoReply(), memdproto.MetaSetModeAppend))
+	case *memdproto.PrependCmd:
+		meta = append(meta, metaSet(cmd.Key(), cmd.Value(), cmd.Flags(), cmd.Expires(), cmd.NoReply(), memdproto.MetaSetModePrepend))
+	case *memdproto.CasCmd:
+		mcmd := metaSet(cmd.Key(), cmd.Value(), cmd.Flags(), cmd.Expires(), cmd.NoReply(), memdproto.MetaSetModeSet)
+		meta = append(meta, mcmd.SetCompareCas(cmd.Cas()))
+	case *memdproto.DeleteCmd:
+		meta = append(meta, memdproto.NewMetaDeleteCmd(cmd.Key()).SetNoReply(cmd.NoReply()))
+	case *memdproto.IncrCmd:
+		meta = append(meta, metaArithmetic(cmd.Key(), cmd.Delta(), cmd.NoReply(), memdproto.MetaArithmeticModeIncr))
+	case *memdproto.DecrCmd:
+		meta = append(meta, metaArithmetic(cmd.Key(), cmd.Delta(), cmd.NoReply(), memdproto.MetaArithmeticModeDecr))
+	case *memdproto.TouchCmd:
+		meta = append(meta, memdproto.NewMetaGetCmd(cmd.Key()).SetUpdateTTL(cmd.Expires()).SetNoReply(cmd.NoReply()))
+	default:
+		return nil, fmt.Errorf(`%w: %s`, ErrUnsupported, cmd.Verb())
+	}
+
+	return &Translation{
+		classic: cmd,
+		meta:    meta,
+	}, nil
+}
+
+func metaSet(key string, value []byte, flags uint32, expires int64, noreply bool, mode memdproto.MetaSetMode) *memdproto.MetaSetCmd {
+	mcmd := memdproto.NewMetaSetCmd(key, value).
+		SetClientFlags(flags).
+		SetTTL(expires).
+		SetNoReply(noreply)
+	if mode != memdproto.MetaSetModeSet {
+		mcmd.SetMode(mode)
+	}
+	return mcmd
+}
+
+func metaArithmetic(key string, delta uint64, noreply bool, mode memdproto.MetaArithmeticMode) *memdproto.MetaArithmeticCmd {
+	mcmd := memdproto.NewMetaArithmeticCmd(key).SetDelta(delta)
+	if mode != memdproto.MetaArithmeticModeIncr {
+		mcmd.SetMode(mode)
+	}
+	if noreply {
+		// no value is requested, as it would be returned even in quiet mode
+		return mcmd.SetNoReply(true)
+	}
+	return mcmd.SetRetrieveValue(true)
+}
+
+// Classic returns the classic command that was translated
+func (t *Translation) Classic() memdproto.Cmd {
+	return t.classic
+}
+
+// Meta returns the meta commands that the classic command was translated into
+func (t *Translation) Meta() []memdproto.Cmd {
+	return t.meta
+}
+
+// Reply maps the replies to the meta commands returned by Meta onto the
+// reply to the classic command. replies must be given in the same order
+// as the meta commands, with nil in place of replies that were suppressed
+// because of quiet mode.
+//
+// If any of the replies is a *memdproto.ErrorReply, it is returned as-is,
+// as error replies are the same in both protocols. If the classic command
+// was sent with "noreply", Reply returns a nil Reply.
+func (t *Translation) Reply(replies ...memdproto.Reply) (memdproto.Reply, error) {
+	if len(replies) != len(t.meta) {
+		return nil, fmt.Errorf(`translate: expected %d replies for %s, got %d`, len(t.meta), t.classic.Verb(), len(replies))
+	}
+
+	for _, reply := range replies {
+		if ereply, ok := reply.(*memdproto.ErrorReply); ok {
+			return ereply, nil
+		}
+	}
+
+	if t.classic.NoReply() {
+		return nil, nil
+	}
+
+	for i, reply := range replies {
+		if reply == nil {
+			return nil, fmt.Errorf(`translate: missing reply to %s command #%d`, t.meta[i].Verb(), i)
+		}
+	}
+
+	switch t.classic.(type) {
+	case *memdproto.GetCmd, *memdproto.GatCmd:
+		return t.getReply(replies)
+	case *memdproto.SetCmd, *memdproto.AddCmd, *memdproto.ReplaceCmd, *memdproto.AppendCmd, *memdproto.PrependCmd, *memdproto.CasCmd:
+		return setReply(replies[0])
+	case *memdproto.DeleteCmd:
+		return deleteReply(replies[0])
+	case *memdproto.IncrCmd, *memdproto.DecrCmd:
+		return arithmeticReply(replies[0])
+	case *memdproto.TouchCmd:
+		return touchReply(replies[0])
+	default:
+		return nil, fmt.Errorf(`%w: %s`, ErrUnsupported, t.classic.Verb())
+	}
+}
+
+func unexpectedReply(verb string, reply memdproto.Reply) error {
+	return fmt.Errorf(`translate: unexpected reply %T to %s command`, reply, verb)
+}
+
+func (t *Translation) getReply(replies []memdproto.Reply) (memdproto.Reply, error) {
+	keys := t.classic.Keys()
+	classic := memdproto.NewGetReply()
+	for i, reply := range replies {
+		mreply, ok := reply.(*memdproto.MetaGetReply)
+		if !ok {
+			return nil, unexpectedReply(`mg`, reply)
+		}
+		if mreply.IsMiss() {
+			continue
+		}
+
+		flags, _ := mreply.ClientFlags()
+		item := memdproto.NewGetReplyItem(keys[i], mreply.Value()).SetFlags(flags)
+		if cas, ok := mreply.Cas(); ok {
+			item.SetCas(cas)
+		}
+		classic.AddItems(item)
+	}
+	return classic, nil
+}
+
+func setReply(reply memdproto.Reply) (memdproto.Reply, error) {
+	mreply, ok := reply.(*memdproto.MetaSetReply)
+	if !ok {
+		return nil, unexpectedReply(`ms`, reply)
+	}
+
+	classic := memdproto.NewSetCmdReply()
+	switch mreply.Status() {
+	case memdproto.MetaSetCmdStatusStored:
+		classic.SetStatus(memdproto.SetCmdReplyStored)
+	case memdproto.MetaSetCmdStatusNotStored:
+		classic.SetStatus(memdproto.SetCmdReplyNotStored)
+	case memdproto.MetaSetCmdStatusExists:
+		classic.SetStatus(memdproto.SetCmdReplyExists)
+	case memdproto.MetaSetCmdStatusNotFound:
+		classic.SetStatus(memdproto.SetCmdReplyNotFound)
+	default:
+		return nil, fmt.Errorf(`translate: invalid ms reply status %d`, mreply.Status())
+	}
+	return classic, nil
+}
+
+func deleteReply(reply memdproto.Reply) (memdproto.Reply, error) {
+	mreply, ok := reply.(*memdproto.MetaDeleteReply)
+	if !ok {
+		return nil, unexpectedReply(`md`, reply)
+	}
+
+	classic := memdproto.NewDeleteCmdReply()
+	switch mreply.Status() {
+	case memdproto.MetaDeleteCmdStatusDeleted:
+		classic.SetStatus(memdproto.DeleteCmdReplyDeleted)
+	case memdproto.MetaDeleteCmdStatusNotFound:
+		classic.SetStatus(memdproto.DeleteCmdReplyNotFound)
+	default:
+		return nil, fmt.Errorf(`translate: invalid md reply status %d`, mreply.Status())
+	}
+	return classic, nil
+}
+
+func arithmeticReply(reply memdproto.Reply) (memdproto.Reply, error) {
+	mreply, ok := reply.(*memdproto.MetaArithmeticReply)
+	if !ok {
+		return nil, unexpectedReply(`ma`, reply)
+	}
+
+	classic := memdproto.NewArithmeticCmdReply()
+	switch mreply.Status() {
+	case memdproto.MetaArithmeticCmdStatusSuccess:
+		v, err := mreply.Number()
+		if err != nil {
+			return nil, fmt.Errorf(`translate: invalid ma reply value: %w`, err)
+		}
+		classic.SetValue(v)
+	case memdproto.MetaArithmeticCmdStatusNotFound:
+		classic.SetNotFound()
+	case memdproto.MetaArithmeticCmdStatusNotStored:
+		// this is what memcached says when the item is not a number
+		return memdproto.NewClientErrorReply("cannot increment or decrement non-numeric value"), nil
+	default:
+		return nil, fmt.Errorf(`translate: invalid ma reply status %d`, mreply.Status())
+	}
+	return classic, nil
+}
+
+func touchReply(reply memdproto.Reply) (memdproto.Reply, error) {
+	mreply, ok := reply.(*memdproto.MetaGetReply)
+	if !ok {
+		return nil, unexpectedReply(`mg`, reply)
+	}
+
+	classic := memdproto.NewTouchCmdReply()
+	if mreply.IsMiss() {
+		classic.SetStatus(memdproto.TouchCmdReplyNotFound)
+	} else {
+		classic.SetStatus(memdproto.TouchCmdReplyTouched)
+	}
+	return classic, nil
+}
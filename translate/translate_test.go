@@ -0,0 +1,149 @@
+package translate_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/lestrrat-go/memdproto"
+	"github.com/lestrrat-go/memdproto/translate"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTranslate(t *testing.T) {
+	testcases := []struct {
+		Name    string
+		Classic string
+		Meta    []string
+		Replies []string
+		Reply   string // empty if no reply is expected
+	}{
+		{
+			Name:    "get",
+			Classic: "get foo bar\r\n",
+			Meta:    []string{"mg foo f v", "mg bar f v"},
+			Replies: []string{"VA 3 f5\r\nabc\r\n", "EN\r\n"},
+			Reply:   "VALUE foo 5 3\r\nabc\r\nEND\r\n",
+		},
+		{
+			Name:    "gets",
+			Classic: "gets foo\r\n",
+			Meta:    []string{"mg foo c f v"},
+			Replies: []string{"VA 3 c42 f0\r\nabc\r\n"},
+			Reply:   "VALUE foo 0 3 42\r\nabc\r\nEND\r\n",
+		},
+		{
+			Name:    "gat",
+			Classic: "gat 30 foo\r\n",
+			Meta:    []string{"mg foo f T30 v"},
+			Replies: []string{"EN\r\n"},
+			Reply:   "END\r\n",
+		},
+		{
+			Name:    "set",
+			Classic: "set foo 5 60 3\r\nabc\r\n",
+			Meta:    []string{`ms foo 3 F5 T60 "abc"`},
+			Replies: []string{"HD\r\n"},
+			Reply:   "STORED\r\n",
+		},
+		{
+			Name:    "add",
+			Classic: "add foo 0 0 3\r\nabc\r\n",
+			Meta:    []string{`ms foo 3 F0 ME T0 "abc"`},
+			Replies: []string{"NS\r\n"},
+			Reply:   "NOT_STORED\r\n",
+		},
+		{
+			Name:    "cas",
+			Classic: "cas foo 0 0 3 99\r\nabc\r\n",
+			Meta:    []string{`ms foo 3 C99 F0 T0 "abc"`},
+			Replies: []string{"EX\r\n"},
+			Reply:   "EXISTS\r\n",
+		},
+		{
+			Name:    "set noreply",
+			Classic: "set foo 0 0 3 noreply\r\nabc\r\n",
+			Meta:    []string{`ms foo 3 F0 q T0 "abc"`},
+			Replies: []string{""},
+		},
+		{
+			Name:    "delete",
+			Classic: "delete foo\r\n",
+			Meta:    []string{"md foo"},
+			Replies: []string{"NF\r\n"},
+			Reply:   "NOT_FOUND\r\n",
+		},
+		{
+			Name:    "incr",
+			Classic: "incr foo 5\r\n",
+			Meta:    []string{"ma foo D5 v"},
+			Replies: []string{"VA 2\r\n15\r\n"},
+			Reply:   "15\r\n",
+		},
+		{
+			Name:    "decr non-numeric",
+			Classic: "decr foo 5\r\n",
+			Meta:    []string{"ma foo D5 MD v"},
+			Replies: []string{"NS\r\n"},
+			Reply:   "CLIENT_ERROR cannot increment or decrement non-numeric value\r\n",
+		},
+		{
+			Name:    "touch",
+			Classic: "touch foo 10\r\n",
+			Meta:    []string{"mg foo T10"},
+			Replies: []string{"HD\r\n"},
+			Reply:   "TOUCHED\r\n",
+		},
+		{
+			Name:    "server error",
+			Classic: "delete foo\r\n",
+			Meta:    []string{"md foo"},
+			Replies: []string{"SERVER_ERROR out of memory\r\n"},
+			Reply:   "SERVER_ERROR out of memory\r\n",
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			cmd, err := memdproto.ParseCmd([]byte(tc.Classic), memdproto.ParseModeStrict)
+			require.NoError(t, err, "memdproto.ParseCmd should succeed")
+
+			tr, err := translate.Command(cmd)
+			require.NoError(t, err, "translate.Command should succeed")
+			require.Len(t, tr.Meta(), len(tc.Meta), "number of meta commands should match")
+
+			replies := make([]memdproto.Reply, len(tc.Replies))
+			for i, mcmd := range tr.Meta() {
+				require.Equal(t, tc.Meta[i], mcmd.(interface{ String() string }).String(), "meta command should match")
+				if tc.Replies[i] == "" {
+					continue
+				}
+
+				var reply memdproto.Reply = mcmd.NewReply()
+				if bytes.HasPrefix([]byte(tc.Replies[i]), []byte("SERVER_ERROR")) {
+					reply = memdproto.NewErrorReply()
+				}
+				require.NoError(t, reply.UnmarshalText([]byte(tc.Replies[i])), "reply.UnmarshalText should succeed")
+				replies[i] = reply
+			}
+
+			reply, err := tr.Reply(replies...)
+			require.NoError(t, err, "tr.Reply should succeed")
+			if tc.Reply == "" {
+				require.Nil(t, reply, "no reply should be sent")
+				return
+			}
+
+			var buf bytes.Buffer
+			_, err = reply.WriteTo(&buf)
+			require.NoError(t, err, "reply.WriteTo should succeed")
+			require.Equal(t, tc.Reply, buf.String(), "classic reply should match")
+		})
+	}
+
+	t.Run("unsupported", func(t *testing.T) {
+		_, err := translate.Command(memdproto.NewMetaNoopCmd())
+		require.True(t, errors.Is(err, translate.ErrUnsupported), "error should be ErrUnsupported")
+	})
+}
@@ -0,0 +1,145 @@
+package memdproto
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// UDPHeaderSize is the size of the frame header that starts each
+// datagram of the UDP protocol.
+const UDPHeaderSize = 8
+
+// UDPMaxDatagramSize is the size of the largest datagram that memcached
+// sends, header included. Larger replies are split across datagrams.
+const UDPMaxDatagramSize = 1400
+
+// UDPHeader is the frame header that starts each datagram of the UDP
+// protocol. A request or a reply (a "message") is split across one or
+// more datagrams, which all carry the same RequestID. Seq is the number
+// of the datagram within the message, starting at 0, and Total the
+// number of datagrams in the message. All fields are sent in network
+// byte order.
+//
+// Clients choose the request IDs, and servers echo them back, so that
+// clients can match replies with their requests. Requests must fit in a
+// single datagram.
+type UDPHeader struct {
+	RequestID uint16
+	Seq       uint16
+	Total     uint16
+	// Reserved must be 0
+	Reserved uint16
+}
+
+// AppendTo appends the encoded header to b, and returns the result
+func (h UDPHeader) AppendTo(b []byte) []byte {
+	b = binary.BigEndian.AppendUint16(b, h.RequestID)
+	b = binary.BigEndian.AppendUint16(b, h.Seq)
+	b = binary.BigEndian.AppendUint16(b, h.Total)
+	return binary.BigEndian.AppendUint16(b, h.Reserved)
+}
+
+// ParseUDPDatagram splits a datagram into its frame header and its
+// payload. The payload shares its memory with datagram.
+func ParseUDPDatagram(datagram []byte) (UDPHeader, []byte, error) {
+	if len(datagram) < UDPHeaderSize {
+		return UDPHeader{}, nil, fmt.Errorf(`memdproto.ParseUDPDatagram: datagram is too short for a frame header (%d bytes)`, len(datagram))
+	}
+	h := UDPHeader{
+		RequestID: binary.BigEndian.Uint16(datagram[0:]),
+		Seq:       binary.BigEndian.Uint16(datagram[2:]),
+		Total:     binary.BigEndian.Uint16(datagram[4:]),
+		Reserved:  binary.BigEndian.Uint16(datagram[6:]),
+	}
+	if h.Total == 0 || h.Seq >= h.Total {
+		return UDPHeader{}, nil, fmt.Errorf(`memdproto.ParseUDPDatagram: invalid sequence number %d of %d`, h.Seq, h.Total)
+	}
+	return h, datagram[UDPHeaderSize:], nil
+}
+
+// UDPDatagrams splits a message into datagrams of at most size bytes,
+// header included, each carrying requestID. If size is 0,
+// UDPMaxDatagramSize is used. An empty message is sent as a single
+// datagram that only holds a header.
+func UDPDatagrams(requestID uint16, message []byte, size int) ([][]byte, error) {
+	if size == 0 {
+		size = UDPMaxDatagramSize
+	}
+	if size <= UDPHeaderSize {
+		return nil, fmt.Errorf(`memdproto.UDPDatagrams: datagram size %d does not leave room for a payload`, size)
+	}
+	chunk := size - UDPHeaderSize
+	total := max(1, (len(message)+chunk-1)/chunk)
+	if total > 0xffff {
+		return nil, fmt.Errorf(`memdproto.UDPDatagrams: message of %d bytes needs more than 65535 datagrams`, len(message))
+	}
+
+	datagrams := make([][]byte, total)
+	for i := range datagrams {
+		payload := message[min(i*chunk, len(message)):min((i+1)*chunk, len(message))]
+		h := UDPHeader{RequestID: requestID, Seq: uint16(i), Total: uint16(total)}
+		datagrams[i] = append(h.AppendTo(make([]byte, 0, UDPHeaderSize+len(payload))), payload...)
+	}
+	return datagrams, nil
+}
+
+// UDPAssembler reassembles messages that were split across datagrams.
+// Datagrams may be added in any order, and duplicates are ignored.
+//
+// Messages that are never completed, because some of their datagrams
+// were lost, are kept until they are discarded with Discard.
+type UDPAssembler struct {
+	messages map[uint16]*udpMessage
+}
+
+type udpMessage struct {
+	parts    [][]byte
+	received int
+}
+
+func NewUDPAssembler() *UDPAssembler {
+	return &UDPAssembler{messages: make(map[uint16]*udpMessage)}
+}
+
+// Add adds a datagram. Once all datagrams of its message have been
+// added, Add returns the complete message and true, and forgets about
+// the message.
+func (a *UDPAssembler) Add(datagram []byte) (UDPHeader, []byte, bool, error) {
+	h, payload, err := ParseUDPDatagram(datagram)
+	if err != nil {
+		return UDPHeader{}, nil, false, fmt.Errorf(`memdproto.UDPAssembler.Add: %w`, err)
+	}
+	if h.Total == 1 {
+		delete(a.messages, h.RequestID)
+		return h, append([]byte(nil), payload...), true, nil
+	}
+
+	msg, ok := a.messages[h.RequestID]
+	if !ok {
+		msg = &udpMessage{parts: make([][]byte, h.Total)}
+		a.messages[h.RequestID] = msg
+	}
+	if len(msg.parts) != int(h.Total) {
+		return UDPHeader{}, nil, false, fmt.Errorf(`memdproto.UDPAssembler.Add: datagram of request %d claims %d datagrams, expected %d`, h.RequestID, h.Total, len(msg.parts))
+	}
+	if msg.parts[h.Seq] != nil {
+		return h, nil, false, nil
+	}
+	msg.parts[h.Seq] = append(make([]byte, 0, len(payload)), payload...)
+	msg.received++
+	if msg.received < len(msg.parts) {
+		return h, nil, false, nil
+	}
+
+	delete(a.messages, h.RequestID)
+	var message []byte
+	for _, part := range msg.parts {
+		message = append(message, part...)
+	}
+	return h, message, true, nil
+}
+
+// Discard forgets about the datagrams received for requestID
+func (a *UDPAssembler) Discard(requestID uint16) {
+	delete(a.messages, requestID)
+}